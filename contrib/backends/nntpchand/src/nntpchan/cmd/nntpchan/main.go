@@ -96,7 +96,11 @@ func Main() {
 		nserv.Hooks = hooks
 	}
 
-	var db database.Database
+	db, err := database.NewDBFromConfig(dconfig)
+	if err != nil {
+		log.Fatalf("failed to open database: %s", err)
+	}
+
 	for _, fconf := range conf.Frontends {
 		var f frontend.Frontend
 		f, err = frontend.NewHTTPFrontend(fconf, db)
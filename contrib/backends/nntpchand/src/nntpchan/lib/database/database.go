@@ -18,7 +18,11 @@ type Database interface {
 func NewDBFromConfig(c *config.DatabaseConfig) (db Database, err error) {
 	dbtype := strings.ToLower(c.Type)
 	if dbtype == "postgres" {
-		db, err = createPostgresDatabase(c.Addr, c.Username, c.Password)
+		var p *PostgresDB
+		p, err = createPostgresDatabase(c.Addr, c.Username, c.Password)
+		if err == nil {
+			db = p
+		}
 	} else {
 		err = errors.New("no such database driver: " + c.Type)
 	}
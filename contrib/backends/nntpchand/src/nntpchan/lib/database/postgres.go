@@ -1,28 +1,223 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	"mime"
+	"net"
 	"nntpchan/lib/model"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// how many of the last replies to show per thread on board pages
+const BoardPageReplies = 5
+
+// postgres driver for the tables srnd makes
 type PostgresDB struct {
+	conn *sql.DB
 }
 
-func (db *PostgresDB) ThreadByMessageID(msgid string) (thread *model.Thread, err error) {
+// load the attachments of a post
+func (db *PostgresDB) postAttachments(msgid string) (atts []model.Attachment, err error) {
+	var rows *sql.Rows
+	rows, err = db.conn.Query("SELECT filename, filepath, sha_hash FROM ArticleAttachments WHERE message_id = $1", msgid)
+	if err == nil {
+		for rows.Next() {
+			var att model.Attachment
+			err = rows.Scan(&att.Name, &att.Path, &att.Hash)
+			if err != nil {
+				break
+			}
+			att.Mime = mime.TypeByExtension(filepath.Ext(att.Name))
+			atts = append(atts, att)
+		}
+		rows.Close()
+	}
+	return
+}
 
+// load posts from rows of newsgroup, message_id, name, subject, time_posted, message
+func (db *PostgresDB) scanPosts(rows *sql.Rows) (posts []*model.Post, err error) {
+	for rows.Next() {
+		p := new(model.Post)
+		var posted int64
+		err = rows.Scan(&p.Newsgroup, &p.MessageID, &p.Name, &p.Subject, &posted, &p.Text)
+		if err != nil {
+			break
+		}
+		p.Posted = time.Unix(posted, 0)
+		p.PostedAt = uint64(posted)
+		posts = append(posts, p)
+	}
+	rows.Close()
+	// fill in the rest once we are done with the rows so we don't hold 2 connections per post
+	for _, p := range posts {
+		if err != nil {
+			break
+		}
+		p.Attachments, err = db.postAttachments(p.MessageID)
+		if err == nil {
+			var pubkey string
+			err = db.conn.QueryRow("SELECT pubkey FROM ArticleKeys WHERE message_id = $1", p.MessageID).Scan(&pubkey)
+			if err == sql.ErrNoRows {
+				// not signed
+				err = nil
+			}
+			p.Tripcode = model.Tripcode(pubkey)
+		}
+	}
 	return
 }
 
-func (db *PostgresDB) ThreadByHash(hash string) (thread *model.Thread, err error) {
+// load a thread given its root post's message-id
+// if last > 0 only load that many of the last replies
+func (db *PostgresDB) thread(msgid string, last int) (thread *model.Thread, err error) {
+	var rows *sql.Rows
+	var posts []*model.Post
+	rows, err = db.conn.Query("SELECT newsgroup, message_id, name, subject, time_posted, message FROM ArticlePosts WHERE message_id = $1", msgid)
+	if err == nil {
+		posts, err = db.scanPosts(rows)
+	}
+	if err != nil {
+		return
+	}
+	if len(posts) == 0 {
+		err = errors.New("no such thread: " + msgid)
+		return
+	}
+	thread = &model.Thread{
+		Root: posts[0],
+	}
+	if last > 0 {
+		rows, err = db.conn.Query("SELECT newsgroup, message_id, name, subject, time_posted, message FROM ( SELECT * FROM ArticlePosts WHERE ref_id = $1 ORDER BY time_posted DESC LIMIT $2 ) AS r ORDER BY time_posted ASC", msgid, last)
+	} else {
+		rows, err = db.conn.Query("SELECT newsgroup, message_id, name, subject, time_posted, message FROM ArticlePosts WHERE ref_id = $1 ORDER BY time_posted ASC", msgid)
+	}
+	if err == nil {
+		thread.Replies, err = db.scanPosts(rows)
+	}
+	if err != nil {
+		thread = nil
+	}
+	return
+}
+
+// get the thread a post is in, msgid can be the root post or a reply
+func (db *PostgresDB) ThreadByMessageID(msgid string) (thread *model.Thread, err error) {
+	var ref string
+	err = db.conn.QueryRow("SELECT ref_id FROM ArticlePosts WHERE message_id = $1", msgid).Scan(&ref)
+	if err == sql.ErrNoRows {
+		err = errors.New("no such post: " + msgid)
+	} else if err == nil {
+		if ref != "" {
+			msgid = ref
+		}
+		thread, err = db.thread(msgid, 0)
+	}
+	return
+}
 
+func (db *PostgresDB) ThreadByHash(hash string) (thread *model.Thread, err error) {
+	var msgid string
+	err = db.conn.QueryRow("SELECT message_id FROM Articles WHERE message_id_hash = $1 LIMIT 1", hash).Scan(&msgid)
+	if err == sql.ErrNoRows {
+		err = errors.New("no thread with hash " + hash)
+	} else if err == nil {
+		thread, err = db.ThreadByMessageID(msgid)
+	}
 	return
 }
 
 func (db *PostgresDB) BoardPage(newsgroup string, pageno, perpage int) (page *model.BoardPage, err error) {
-
+	if perpage <= 0 || pageno < 0 {
+		err = errors.New("invalid page")
+		return
+	}
+	var count int
+	err = db.conn.QueryRow("SELECT COUNT(*) FROM ArticleThreads WHERE newsgroup = $1", newsgroup).Scan(&count)
+	if err != nil {
+		return
+	}
+	var roots []string
+	var rows *sql.Rows
+	rows, err = db.conn.Query("SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 ORDER BY last_bump DESC LIMIT $2 OFFSET $3", newsgroup, perpage, pageno*perpage)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var root string
+		err = rows.Scan(&root)
+		if err != nil {
+			break
+		}
+		roots = append(roots, root)
+	}
+	rows.Close()
+	if err != nil {
+		return
+	}
+	page = &model.BoardPage{
+		Name:  newsgroup,
+		Page:  pageno,
+		Pages: (count + perpage - 1) / perpage,
+	}
+	if page.Pages == 0 {
+		// empty boards still have the first page
+		page.Pages = 1
+	}
+	for _, root := range roots {
+		var thread *model.Thread
+		thread, err = db.thread(root, BoardPageReplies)
+		if err != nil {
+			page = nil
+			return
+		}
+		page.Threads = append(page.Threads, *thread)
+	}
 	return
 }
 
-func createPostgresDatabase(addr, user, passwd string) (p *PostgresDB, err error) {
+// make a connection string for lib/pq
+// addr is either a postgres:// url, host:port or a host / unix socket directory
+func postgresConnString(addr, user, passwd string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	params := []string{"client_encoding='UTF8'"}
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil {
+		params = append(params, "host="+host, "port="+port)
+	} else {
+		params = append(params, "host="+addr)
+	}
+	if user != "" {
+		params = append(params, "user="+user)
+	}
+	if passwd != "" {
+		// backslashes first so we don't escape the ones we add for quotes
+		passwd = strings.Replace(passwd, "\\", "\\\\", -1)
+		params = append(params, fmt.Sprintf("password='%s'", strings.Replace(passwd, "'", "\\'", -1)))
+	}
+	return strings.Join(params, " ")
+}
 
+func createPostgresDatabase(addr, user, passwd string) (p *PostgresDB, err error) {
+	var conn *sql.DB
+	conn, err = sql.Open("postgres", postgresConnString(addr, user, passwd))
+	if err == nil {
+		// make sure we can actually talk to it
+		err = conn.Ping()
+		if err == nil {
+			p = &PostgresDB{
+				conn: conn,
+			}
+		} else {
+			conn.Close()
+		}
+	}
 	return
 }
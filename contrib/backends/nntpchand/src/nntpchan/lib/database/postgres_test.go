@@ -0,0 +1,20 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestPostgresConnString(t *testing.T) {
+	tests := map[[3]string]string{
+		{"/var/run/postgresql", "", ""}:              "client_encoding='UTF8' host=/var/run/postgresql",
+		{"127.0.0.1:5432", "srnd", "it's secret"}:    "client_encoding='UTF8' host=127.0.0.1 port=5432 user=srnd password='it\\'s secret'",
+		{"127.0.0.1:5432", "srnd", `back\'slash\`}:   `client_encoding='UTF8' host=127.0.0.1 port=5432 user=srnd password='back\\\'slash\\'`,
+		{"postgres://srnd@localhost/srnd", "x", "y"}: "postgres://srnd@localhost/srnd",
+	}
+	for args, expect := range tests {
+		str := postgresConnString(args[0], args[1], args[2])
+		if str != expect {
+			t.Errorf("connection string for %q was %q not %q", args, str, expect)
+		}
+	}
+}
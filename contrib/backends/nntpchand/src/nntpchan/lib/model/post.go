@@ -11,6 +11,7 @@ type Post struct {
 	Newsgroup   string
 	Attachments []Attachment
 	Subject     string
+	Text        string
 	Posted      time.Time
 	PostedAt    uint64
 	Name        string
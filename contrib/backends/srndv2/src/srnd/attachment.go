@@ -317,6 +317,14 @@ func readAttachmentFromMimePartAndStore(part *multipart.Part, store ArticleStore
 		if !CheckFile(store.ThumbnailFilepath(att.filepath)) {
 			store.GenerateThumbnail(att.filepath)
 		}
+		// and remember what it looks like
+		if canHashImage(att.filepath) {
+			_, err = store.ImageHash(att.filepath)
+			if err != nil {
+				log.Println("failed to hash image", att.filepath, err)
+				err = nil
+			}
+		}
 	} else {
 		// wtf?
 		log.Println("!!! failed to store attachment", err, "!!!")
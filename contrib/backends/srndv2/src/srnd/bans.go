@@ -0,0 +1,107 @@
+//
+// bans.go -- ip and encrypted ip bans on our node
+//
+package srnd
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// mod log action of a ban a mod lifted
+const ModLogUnban = "unban"
+
+// mod log action of a ban that ran out
+const ModLogBanExpired = "ban-expired"
+
+// an ip, ip range or encrypted ip banned from posting
+type IPBan struct {
+	// the address, cidr or encrypted address
+	Addr string
+	// set if Addr is an encrypted address we don't have the ip for
+	Encrypted bool
+	// newsgroup regex it's for
+	Scope  string
+	Reason string
+	// public key of the mod who banned it, empty if we don't know
+	Issuer string
+	// unix time it was made
+	Made int64
+	// unix time it runs out or -1 for never
+	Expires int64
+}
+
+// how long the ban has left, for templates
+func (self IPBan) Remaining() string {
+	if self.Expires < 0 {
+		return "forever"
+	}
+	left := self.Expires - timeNow()
+	if left <= 0 {
+		return "expired"
+	}
+	return (time.Duration(left) * time.Second).String()
+}
+
+// when it was made, for templates
+func (self IPBan) Date() string {
+	return time.Unix(self.Made, 0).UTC().Format(time.RFC3339)
+}
+
+func (self IPBan) String() string {
+	return fmt.Sprintf("ban of %s in %s until %d", self.Addr, self.Scope, self.Expires)
+}
+
+// the mod log entry of a ban that was lifted or ran out
+func (self IPBan) logEntry(action, pubkey string) ModLogEntry {
+	return ModLogEntry{
+		Pubkey:   pubkey,
+		Action:   action,
+		Target:   self.Addr,
+		Scope:    self.Scope,
+		Reason:   self.Reason,
+		Executed: true,
+		Time:     timeNow(),
+	}
+}
+
+// the sql queries for ip bans and encrypted ip bans that are in effect at time $1, newest first
+const activeIPBansQuery = "SELECT addr, scope, reason, issuer, made, expires FROM IPBans WHERE expires < 0 OR expires > $1 ORDER BY made DESC"
+const activeEncIPBansQuery = "SELECT encaddr, scope, reason, issuer, made, expires FROM EncIPBans WHERE expires < 0 OR expires > $1 ORDER BY made DESC"
+
+// the sql queries for ip bans and encrypted ip bans that ran out by time $1
+const expiredIPBansQuery = "SELECT addr, scope, reason, issuer, made, expires FROM IPBans WHERE expires >= 0 AND expires <= $1"
+const expiredEncIPBansQuery = "SELECT encaddr, scope, reason, issuer, made, expires FROM EncIPBans WHERE expires >= 0 AND expires <= $1"
+
+// load bans from rows of addr, scope, reason, issuer, made and expires
+func scanIPBans(rows *sql.Rows, encrypted bool) (bans []IPBan, err error) {
+	for rows.Next() {
+		ban := IPBan{Encrypted: encrypted}
+		err = rows.Scan(&ban.Addr, &ban.Scope, &ban.Reason, &ban.Issuer, &ban.Made, &ban.Expires)
+		if err != nil {
+			break
+		}
+		bans = append(bans, ban)
+	}
+	rows.Close()
+	return
+}
+
+// get the ip bans and then the encrypted ip bans of a pair of queries with argument now
+func queryIPBans(conn *metricsDB, ipQuery, encQuery string, now int64) (bans []IPBan, err error) {
+	var rows *sql.Rows
+	rows, err = conn.Query(ipQuery, now)
+	if err == nil {
+		bans, err = scanIPBans(rows, false)
+	}
+	if err == nil {
+		rows, err = conn.Query(encQuery, now)
+	}
+	if err == nil {
+		var enc []IPBan
+		enc, err = scanIPBans(rows, true)
+		bans = append(bans, enc...)
+	}
+	return
+}
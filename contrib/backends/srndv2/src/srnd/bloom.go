@@ -0,0 +1,143 @@
+//
+// bloom.go
+// bloom filter of known message-ids
+//
+package srnd
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+)
+
+// bloom filter of strings
+// Has never gives false negatives so a miss means we definitely never added it
+type bloomFilter struct {
+	bits   []uint64
+	m      uint64
+	k      uint64
+	access sync.RWMutex
+}
+
+// make a bloom filter sized for n entries with false positive rate p
+func newBloomFilter(n uint64, p float64) *bloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Ceil(math.Ln2 * float64(m) / float64(n)))
+	if k == 0 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// get the 2 base hashes we derive the k hashes from
+func bloomHashes(str string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(str))
+	h1 = h.Sum64()
+	h.Write([]byte{0})
+	h2 = h.Sum64() | 1
+	return
+}
+
+func (self *bloomFilter) Add(str string) {
+	h1, h2 := bloomHashes(str)
+	self.access.Lock()
+	for i := uint64(0); i < self.k; i++ {
+		bit := (h1 + i*h2) % self.m
+		self.bits[bit/64] |= 1 << (bit % 64)
+	}
+	self.access.Unlock()
+}
+
+func (self *bloomFilter) Has(str string) (has bool) {
+	h1, h2 := bloomHashes(str)
+	has = true
+	self.access.RLock()
+	for i := uint64(0); has && i < self.k; i++ {
+		bit := (h1 + i*h2) % self.m
+		has = self.bits[bit/64]&(1<<(bit%64)) != 0
+	}
+	self.access.RUnlock()
+	return
+}
+
+// a Database that skips lookups for message-ids it has definitely never seen
+// every message-id we have, banned or have in the history is put into the filter
+type bloomDatabase struct {
+	Database
+	filter *bloomFilter
+}
+
+// wrap a database with a bloom filter loaded with every message-id it knows about
+// n is how many message-ids we expect to know about
+func newBloomDatabase(db Database, n uint64) Database {
+	self := &bloomDatabase{
+		Database: db,
+		filter:   newBloomFilter(n, 0.001),
+	}
+	log.Println("loading message-id filter...")
+	chnl := make(chan string, 128)
+	var err error
+	go func() {
+		err = db.GetAllKnownMessageIDs(chnl)
+		close(chnl)
+	}()
+	count := 0
+	for msgid := range chnl {
+		self.filter.Add(msgid)
+		count++
+	}
+	if err == nil {
+		log.Println("loaded", count, "message-ids into filter")
+		return self
+	}
+	log.Println("failed to load message-id filter, not using it", err)
+	return db
+}
+
+func (self *bloomDatabase) HasArticle(msgid string) bool {
+	return self.filter.Has(msgid) && self.Database.HasArticle(msgid)
+}
+
+func (self *bloomDatabase) HasArticleLocal(msgid string) bool {
+	return self.filter.Has(msgid) && self.Database.HasArticleLocal(msgid)
+}
+
+func (self *bloomDatabase) ArticleBanned(msgid string) bool {
+	return self.filter.Has(msgid) && self.Database.ArticleBanned(msgid)
+}
+
+func (self *bloomDatabase) GetMessageHistory(msgid string) (string, error) {
+	if self.filter.Has(msgid) {
+		return self.Database.GetMessageHistory(msgid)
+	}
+	return "", nil
+}
+
+func (self *bloomDatabase) RegisterArticle(article NNTPMessage) error {
+	self.filter.Add(article.MessageID())
+	return self.Database.RegisterArticle(article)
+}
+
+// a deleted article stays in the filter, it's banned or in the history after
+func (self *bloomDatabase) DeleteArticle(msgid string) error {
+	return self.Database.DeleteArticle(msgid)
+}
+
+func (self *bloomDatabase) BanArticle(msgid, reason string) error {
+	self.filter.Add(msgid)
+	return self.Database.BanArticle(msgid, reason)
+}
+
+func (self *bloomDatabase) RecordMessageHistory(msgid, disposition string) error {
+	self.filter.Add(msgid)
+	return self.Database.RecordMessageHistory(msgid, disposition)
+}
@@ -0,0 +1,93 @@
+package srnd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// a database that takes as long as a round trip to postgres for every lookup
+type slowDatabase struct {
+	Database
+	known map[string]bool
+}
+
+func (self *slowDatabase) lookup(msgid string) bool {
+	time.Sleep(100 * time.Microsecond)
+	return self.known[msgid]
+}
+
+func (self *slowDatabase) HasArticle(msgid string) bool {
+	return self.lookup(msgid)
+}
+
+func (self *slowDatabase) ArticleBanned(msgid string) bool {
+	return false
+}
+
+func (self *slowDatabase) GetMessageHistory(msgid string) (string, error) {
+	if self.lookup(msgid) {
+		return HistoryAccepted, nil
+	}
+	return "", nil
+}
+
+func (self *slowDatabase) GetAllKnownMessageIDs(send chan string) error {
+	for msgid := range self.known {
+		send <- msgid
+	}
+	return nil
+}
+
+func newSlowDatabase(n int) *slowDatabase {
+	db := &slowDatabase{known: make(map[string]bool)}
+	for i := 0; i < n; i++ {
+		db.known[fmt.Sprintf("<%d@known.tld>", i)] = true
+	}
+	return db
+}
+
+func TestBloomFilter(t *testing.T) {
+
+	filter := newBloomFilter(1000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.Add(fmt.Sprintf("<%d@known.tld>", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !filter.Has(fmt.Sprintf("<%d@known.tld>", i)) {
+			t.Fatal("false negative")
+		}
+	}
+	fp := 0
+	for i := 0; i < 1000; i++ {
+		if filter.Has(fmt.Sprintf("<%d@unknown.tld>", i)) {
+			fp++
+		}
+	}
+	if fp > 10 {
+		t.Error("too many false positives", fp)
+	}
+
+}
+
+// CHECK of articles we don't have, what a peer sends us most of during initial sync
+func benchmarkCheck(b *testing.B, db Database) {
+	daemon := &NNTPDaemon{
+		database: db,
+		history:  createMessageHistory(db, time.Hour),
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if daemon.knowsMessageID(fmt.Sprintf("<%d@unknown.tld>", i)) {
+			b.Fatal("knows unknown message-id")
+		}
+	}
+}
+
+func BenchmarkCheckWithoutFilter(b *testing.B) {
+	benchmarkCheck(b, newSlowDatabase(10000))
+}
+
+func BenchmarkCheckWithFilter(b *testing.B) {
+	benchmarkCheck(b, newBloomDatabase(newSlowDatabase(10000), 10000))
+}
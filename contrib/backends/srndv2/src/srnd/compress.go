@@ -0,0 +1,92 @@
+//
+// compress.go -- COMPRESS DEFLATE, RFC 8054
+//
+package srnd
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+var CompressNotSupported = errors.New("COMPRESS DEFLATE not supported")
+
+// a connection that deflates everything going over it
+// wraps the buffered reader and writer of a textproto.Conn so nothing it already buffered is lost
+type deflateConn struct {
+	r  io.ReadCloser
+	w  *flate.Writer
+	bw *bufio.Writer
+	c  io.Closer
+}
+
+func (self *deflateConn) Read(d []byte) (int, error) {
+	return self.r.Read(d)
+}
+
+// every write is flushed so the other side can inflate each command or response as soon as we send it
+func (self *deflateConn) Write(d []byte) (n int, err error) {
+	n, err = self.w.Write(d)
+	if err == nil {
+		err = self.w.Flush()
+		if err == nil {
+			err = self.bw.Flush()
+		}
+	}
+	return
+}
+
+func (self *deflateConn) Close() error {
+	self.r.Close()
+	return self.c.Close()
+}
+
+// start deflating a connection after COMPRESS DEFLATE was accepted
+func deflateTextConn(conn *textproto.Conn) (econn *textproto.Conn, err error) {
+	var w *flate.Writer
+	w, err = flate.NewWriter(conn.W, flate.DefaultCompression)
+	if err == nil {
+		econn = textproto.NewConn(&deflateConn{
+			r:  flate.NewReader(conn.R),
+			w:  w,
+			bw: conn.W,
+			c:  conn,
+		})
+	}
+	return
+}
+
+// handle COMPRESS on connection
+// algo is the argument given to the COMPRESS command
+func HandleCompress(conn *textproto.Conn, algo string) (econn *textproto.Conn, err error) {
+	if strings.ToUpper(algo) != "DEFLATE" {
+		err = conn.PrintfLine("503 compression algorithm not supported")
+		if err == nil {
+			err = CompressNotSupported
+		}
+		return
+	}
+	err = conn.PrintfLine("206 Compression active")
+	if err == nil {
+		econn, err = deflateTextConn(conn)
+	}
+	return
+}
+
+// ask the remote server to COMPRESS DEFLATE
+func SendCompress(conn *textproto.Conn) (econn *textproto.Conn, err error) {
+	err = conn.PrintfLine("COMPRESS DEFLATE")
+	if err == nil {
+		var code int
+		code, _, err = conn.ReadCodeLine(-1)
+		if code == 206 {
+			econn, err = deflateTextConn(conn)
+		} else if err == nil {
+			err = CompressNotSupported
+		}
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package srnd
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+)
+
+// get what an inbound connection says its capabilities are
+func testCapabilities(t *testing.T, conn *textproto.Conn) string {
+	err := conn.PrintfLine("CAPABILITIES")
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(101)
+	}
+	var caps []string
+	if err == nil {
+		caps, err = conn.ReadDotLines()
+	}
+	if err != nil {
+		t.Fatal("CAPABILITIES failed", err)
+	}
+	return strings.Join(caps, "\n")
+}
+
+func TestCompressDeflate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	// we say we can do STARTTLS until we compress
+	daemon.tls_config = &tls.Config{}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	nntp := createNNTPConnection("")
+	nntp.name = "test-inbound-feed"
+	go nntp.runConnection(daemon, true, true, true, false, "stream", server, nil)
+	conn := textproto.NewConn(client)
+
+	caps := testCapabilities(t, conn)
+	if !strings.Contains(caps, "COMPRESS DEFLATE") || !strings.Contains(caps, "STARTTLS") {
+		t.Error("COMPRESS DEFLATE or STARTTLS not offered", caps)
+	}
+
+	// we only do deflate
+	err = conn.PrintfLine("COMPRESS LZ4")
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(503)
+	}
+	if err != nil {
+		t.Error("unknown compression algorithm not refused", err)
+	}
+
+	conn, err = SendCompress(conn)
+	if err != nil {
+		t.Fatal("COMPRESS DEFLATE failed", err)
+	}
+
+	// commands and responses make it through deflated
+	caps = testCapabilities(t, conn)
+	if !strings.Contains(caps, "READER") {
+		t.Error("bad capabilities over compressed connection", caps)
+	}
+	if strings.Contains(caps, "COMPRESS") || strings.Contains(caps, "STARTTLS") {
+		t.Error("COMPRESS or STARTTLS offered while compressed", caps)
+	}
+
+	// RFC 8054 has no STARTTLS or COMPRESS once compression is active
+	err = conn.PrintfLine("STARTTLS")
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(502)
+	}
+	if err != nil {
+		t.Error("STARTTLS not refused after COMPRESS", err)
+	}
+	err = conn.PrintfLine("COMPRESS DEFLATE")
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(502)
+	}
+	if err != nil {
+		t.Error("COMPRESS not refused after COMPRESS", err)
+	}
+
+	err = conn.PrintfLine("QUIT")
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(205)
+	}
+	if err != nil {
+		t.Error("QUIT failed", err)
+	}
+}
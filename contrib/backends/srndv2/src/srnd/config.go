@@ -28,9 +28,12 @@ type FeedConfig struct {
 	passwd           string
 	linkauth_keyfile string
 	tls_off          bool
+	compress         bool
 	Name             string
 	sync_interval    time.Duration
 	connections      int
+	// put articles we get from this feed in the quarantine queue
+	quarantine bool
 }
 
 type APIConfig struct {
@@ -166,6 +169,7 @@ func GenSRNdConfig() *configparser.Configuration {
 
 	sect.Add("store_dir", "articles")
 	sect.Add("incoming_dir", "/tmp/articles")
+	sect.Add("spool_dir", "spool")
 	sect.Add("attachments_dir", "webroot/img")
 	sect.Add("thumbs_dir", "webroot/thm")
 	sect.Add("convert_bin", "/usr/bin/convert")
@@ -255,6 +259,12 @@ func SaveFeeds(feeds []FeedConfig, inboundPolicy *FeedPolicy) (err error) {
 		sect.Add("username", feed.username)
 		sect.Add("password", feed.passwd)
 		sect.Add("connections", fmt.Sprintf("%d", feed.connections))
+		if feed.compress {
+			sect.Add("compress", "1")
+		}
+		if feed.quarantine {
+			sect.Add("quarantine", "1")
+		}
 		sect = conf.NewSection(feed.Name)
 		for k, v := range feed.policy.rules {
 			sect.Add(k, v)
@@ -476,6 +486,10 @@ func feedParse(fname string) (confs []FeedConfig, inboundPolicy *FeedPolicy, err
 			fconf.username = sect.ValueOf("username")
 			fconf.passwd = sect.ValueOf("password")
 			fconf.tls_off = sect.ValueOf("disabletls") == "1"
+			// COMPRESS DEFLATE if they support it
+			fconf.compress = sect.ValueOf("compress") == "1"
+			// new feeds on probation
+			fconf.quarantine = sect.ValueOf("quarantine") == "1"
 
 			// load feed polcies
 			sect_name := sect.Name()[5:]
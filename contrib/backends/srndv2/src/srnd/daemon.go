@@ -36,6 +36,8 @@ type feedStatus struct {
 	Conns []*nntpConnection
 	// the state of this feed if it exists
 	State *feedState
+	// how many articles are in this feed's outbound spool
+	SpoolDepth int64
 }
 
 // an event for querying if a feed's status
@@ -73,6 +75,7 @@ type NNTPDaemon struct {
 	conf          *SRNdConfig
 	store         ArticleStore
 	database      Database
+	history       MessageHistory
 	mod           ModEngine
 	expire        ExpirationCore
 	listener      net.Listener
@@ -126,7 +129,14 @@ type NNTPDaemon struct {
 	ask_articles_mtx  sync.RWMutex
 	ask_articles      []ArticleEntry
 
+	// feed name -> outbound spool
+	spools_mtx sync.Mutex
+	spools     map[string]*feedSpool
+
 	pump_ticker       *time.Ticker
+	rejection_ticker  *time.Ticker
+	history_ticker    *time.Ticker
+	ban_ticker        *time.Ticker
 	expiration_ticker *time.Ticker
 	article_lifetime  time.Duration
 }
@@ -362,6 +372,77 @@ func (self *NNTPDaemon) activeFeeds() (feeds []*feedStatus) {
 	return
 }
 
+// check if we have, banned or had an article before given its message-id
+func (self *NNTPDaemon) knowsMessageID(msgid string) bool {
+	return self.database.HasArticle(msgid) || self.database.ArticleBanned(msgid) || self.history.Disposition(msgid) != ""
+}
+
+// check if a feed refused an article before
+func (self *NNTPDaemon) feedRejected(feedname, msgid string) bool {
+	if feedname == "" {
+		return false
+	}
+	rejected, err := self.database.FeedRejectedArticle(feedname, msgid)
+	if err != nil {
+		log.Println("failed to check if", feedname, "rejected", msgid, err)
+	}
+	return rejected
+}
+
+// get every feed that refused an article before in one query
+func (self *NNTPDaemon) feedsRejected(msgid string) (rejected map[string]bool) {
+	rejected = make(map[string]bool)
+	feeds, err := self.database.GetArticleRejections(msgid)
+	if err != nil {
+		log.Println("failed to check which feeds rejected", msgid, err)
+	}
+	for _, feed := range feeds {
+		rejected[feed] = true
+	}
+	return
+}
+
+// forget rejected articles that were rejected long enough ago so we try them again
+func (self *NNTPDaemon) expireFeedRejections() {
+	hours := mapGetInt(self.conf.daemon, "rejection_expire_hours", 168)
+	err := self.database.ExpireFeedRejections(timeNow() - int64(hours)*3600)
+	if err != nil {
+		log.Println("failed to expire feed rejections", err)
+	}
+}
+
+// forget bans that ran out, they don't count once they expire anyways
+// they go in the mod log so we know who was unbanned when
+func (self *NNTPDaemon) purgeExpiredBans() {
+	bans, err := self.database.PurgeExpiredBans(timeNow())
+	if err != nil {
+		log.Println("failed to purge expired bans", err)
+	}
+	for _, ban := range bans {
+		log.Println(ban, "expired")
+		err = self.database.RecordModLog(ban.logEntry(ModLogBanExpired, ban.Issuer))
+		if err != nil {
+			log.Println("failed to record expired ban in mod log", err)
+		}
+	}
+}
+
+// get the outbound spool for a feed, opens it if it's not open yet
+func (self *NNTPDaemon) feedSpool(feedname string) (spool *feedSpool) {
+	self.spools_mtx.Lock()
+	spool = self.spools[feedname]
+	if spool == nil {
+		dir, ok := self.conf.store["spool_dir"]
+		if !ok {
+			dir = "spool"
+		}
+		spool = openFeedSpool(dir, feedname)
+		self.spools[feedname] = spool
+	}
+	self.spools_mtx.Unlock()
+	return
+}
+
 func (self *NNTPDaemon) messageSizeLimitFor(newsgroup string) int64 {
 	// TODO: per newsgroup
 	return mapGetInt64(self.conf.store, "max_message_size", DefaultMaxMessageSize)
@@ -392,7 +473,7 @@ func (self *NNTPDaemon) persistFeed(conf *FeedConfig, mode string, n int) {
 
 			if mode == "sync" {
 				// yeh, do it
-				self.syncPull(conf.proxy_type, conf.proxy_addr, conf.Addr)
+				self.syncPull(conf.Name, conf.proxy_type, conf.proxy_addr, conf.Addr, conf.quarantine)
 				// sleep for the sleep interval and continue
 				log.Println(conf.Name, "waiting for", conf.sync_interval, "before next sync")
 				time.Sleep(conf.sync_interval)
@@ -412,7 +493,11 @@ func (self *NNTPDaemon) persistFeed(conf *FeedConfig, mode string, n int) {
 			nntp := createNNTPConnection(conf.Addr)
 			nntp.policy = &conf.policy
 			nntp.feedname = conf.Name
+			nntp.probation = conf.quarantine
 			nntp.name = fmt.Sprintf("%s-%d-%s", conf.Name, n, mode)
+			if mode == "stream" {
+				nntp.spool = self.feedSpool(conf.Name)
+			}
 			stream, reader, use_tls, err := nntp.outboundHandshake(textproto.NewConn(conn), conf)
 			if err == nil {
 				if mode == "reader" && !reader {
@@ -441,13 +526,15 @@ func (self *NNTPDaemon) persistFeed(conf *FeedConfig, mode string, n int) {
 }
 
 // do a oneshot pull based sync with another server
-func (self *NNTPDaemon) syncPull(proxy_type, proxy_addr, remote_addr string) {
+// only pulls what's new since the last sync with this feed
+func (self *NNTPDaemon) syncPull(feedname, proxy_type, proxy_addr, remote_addr string, quarantine bool) {
 	c, err := self.dialOut(proxy_type, proxy_addr, remote_addr)
 	if err == nil {
 		conn := textproto.NewConn(c)
 		// we connected
 		nntp := createNNTPConnection(remote_addr)
 		nntp.name = remote_addr + "-sync"
+		nntp.probation = quarantine
 		// do handshake
 		_, reader, _, err := nntp.outboundHandshake(conn, nil)
 
@@ -456,7 +543,7 @@ func (self *NNTPDaemon) syncPull(proxy_type, proxy_addr, remote_addr string) {
 		}
 		if reader {
 			// we can do it
-			err = nntp.scrapeServer(self, conn)
+			err = nntp.pullNewArticles(self, conn, feedname)
 			if err == nil {
 				// we succeeded
 				log.Println(nntp.name, "Scrape successful")
@@ -493,6 +580,7 @@ func (self *NNTPDaemon) Run() {
 	if self.conf.pprof != nil && self.conf.pprof.enable {
 		addr := self.conf.pprof.bind
 		log.Println("pprof enabled, binding to", addr)
+		http.HandleFunc("/metrics", self.ServeMetrics)
 		go func() {
 			err := http.ListenAndServe(addr, nil)
 			if err != nil {
@@ -520,6 +608,7 @@ func (self *NNTPDaemon) Run() {
 	self.send_all_feeds = make(chan ArticleEntry)
 	self.activeConnections = make(map[string]*nntpConnection)
 	self.loadedFeeds = make(map[string]*feedState)
+	self.spools = make(map[string]*feedSpool)
 	self.register_feed = make(chan FeedConfig)
 	self.deregister_feed = make(chan string)
 	self.get_feeds = make(chan chan []*feedStatus)
@@ -528,11 +617,14 @@ func (self *NNTPDaemon) Run() {
 	self.ask_for_article = make(chan ArticleEntry)
 
 	self.pump_ticker = time.NewTicker(time.Millisecond * 100)
+	self.rejection_ticker = time.NewTicker(time.Hour)
+	self.history_ticker = time.NewTicker(time.Hour)
+	self.ban_ticker = time.NewTicker(time.Minute)
 	if self.conf.daemon["archive"] == "1" {
 		log.Println("running in archive mode")
 		self.expire = nil
 	} else {
-		self.expire = createExpirationCore(self.database, self.store, self.informHooks)
+		self.expire = createExpirationCore(self.database, self.store, self.history, self.informHooks)
 	}
 	self.sync_on_start = self.conf.daemon["sync_on_start"] == "1"
 	self.instance_name = self.conf.daemon["instance_name"]
@@ -644,8 +736,29 @@ func (self *NNTPDaemon) Run() {
 
 func (self *NNTPDaemon) syncAllMessages() {
 	log.Println("syncing all messages to all feeds")
+	// feed name -> message-ids the feed refused
+	rejected := make(map[string]map[string]bool)
+	for _, f := range self.activeFeeds() {
+		name := f.State.Config.Name
+		rejected[name] = make(map[string]bool)
+		msgids, err := self.database.GetFeedRejections(name)
+		if err != nil {
+			log.Println("failed to get rejections for", name, err)
+		}
+		for _, msgid := range msgids {
+			rejected[name][msgid] = true
+		}
+	}
 	for _, article := range self.database.GetAllArticles() {
-		if self.store.HasArticle(article.MessageID()) {
+		// don't bother if every feed refused it
+		wanted := len(rejected) == 0
+		for _, msgids := range rejected {
+			if !msgids[article.MessageID()] {
+				wanted = true
+				break
+			}
+		}
+		if wanted && self.store.HasArticle(article.MessageID()) && !self.database.ArticleHasFlag(article.MessageID(), ArticleFlagQuarantined) {
 			self.sendAllFeeds(article)
 		}
 	}
@@ -692,8 +805,9 @@ func (self *NNTPDaemon) pollfeeds() {
 					// caller wants to be informed
 					// create the reply
 					status := &feedStatus{
-						Exists: true,
-						State:  feedstate,
+						Exists:     true,
+						State:      feedstate,
+						SpoolDepth: self.feedSpool(name).Depth(),
 					}
 					// get the connections for this feed
 					for _, conn := range self.activeConnections {
@@ -752,9 +866,10 @@ func (self *NNTPDaemon) pollfeeds() {
 				}
 				// add feedStatus
 				feeds = append(feeds, &feedStatus{
-					Exists: true,
-					Conns:  conns,
-					State:  feedstate,
+					Exists:     true,
+					Conns:      conns,
+					State:      feedstate,
+					SpoolDepth: self.feedSpool(feedname).Depth(),
 				})
 			}
 			// send response
@@ -788,6 +903,12 @@ func (self *NNTPDaemon) pollfeeds() {
 			delete(self.activeConnections, outfeed.name)
 		case <-self.pump_ticker.C:
 			go self.pump_article_requests()
+		case <-self.rejection_ticker.C:
+			go self.expireFeedRejections()
+		case <-self.history_ticker.C:
+			go self.history.Expire()
+		case <-self.ban_ticker.C:
+			go self.purgeExpiredBans()
 		}
 	}
 }
@@ -833,6 +954,7 @@ func (self *NNTPDaemon) poll(worker int) {
 			} else {
 				msgid := getMessageIDFromArticleHeaders(hdr)
 				log.Println("worker", worker, "got", msgid)
+				self.history.Record(msgid, HistoryAccepted)
 				rollover := 100
 				group := hdr.Get("Newsgroups", "")
 				ref := hdr.Get("References", "")
@@ -866,6 +988,7 @@ func (self *NNTPDaemon) poll(worker int) {
 				sz, _ := self.store.GetMessageSize(nntp.MessageID())
 				feeds := self.activeFeeds()
 				if feeds != nil {
+					rejected := self.feedsRejected(nntp.MessageID())
 					for _, f := range feeds {
 						var send []*nntpConnection
 						for _, feed := range f.Conns {
@@ -875,9 +998,19 @@ func (self *NNTPDaemon) poll(worker int) {
 								}
 							}
 						}
+						if rejected[f.State.Config.Name] {
+							// they refused it before
+							continue
+						}
 						minconn := lowestBacklogConnection(send)
 						if minconn != nil {
-							go minconn.offerStream(nntp.MessageID(), sz)
+							go minconn.offerStream(self, nntp.MessageID(), sz)
+						} else if f.State.Config.policy.AllowsNewsgroup(group) {
+							// no connection up right now, send it when there is
+							err := self.feedSpool(f.State.Config.Name).Add(nntp.MessageID())
+							if err != nil {
+								log.Println("failed to spool", nntp.MessageID(), "for", f.State.Config.Name, err)
+							}
 						}
 					}
 				}
@@ -1054,6 +1187,16 @@ func (self *NNTPDaemon) Setup() {
 	log.Println("ensure that the database is created...")
 	self.database.CreateTables()
 
+	// skip database lookups for message-ids we never saw
+	if self.conf.daemon["message_id_filter"] != "0" {
+		n := uint64(self.database.ArticleCount())*4 + 1<<20
+		self.database = newBloomDatabase(self.database, n)
+	}
+
+	// set up message-id history
+	retention := mapGetInt(self.conf.daemon, "history_retention_days", 90)
+	self.history = createMessageHistory(self.database, time.Duration(retention)*24*time.Hour)
+
 	// ensure tls stuff
 	if self.conf.crypto != nil {
 		self.tls_config, err = GenTLS(self.conf.crypto)
@@ -1090,6 +1233,7 @@ func (self *NNTPDaemon) Setup() {
 	self.mod = &modEngine{
 		store:    self.store,
 		database: self.database,
+		history:  self.history,
 		regen:    self.frontend.RegenOnModEvent,
 	}
 	// inject DB into template engine
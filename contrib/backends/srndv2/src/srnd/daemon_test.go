@@ -0,0 +1,927 @@
+package srnd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// make a daemon with an in memory database and a store in dir
+// a worker is started to load what the store takes in
+func testDaemon(t *testing.T, dir string) *NNTPDaemon {
+	// the store only checks the thumbnailers exist, plaintext posts don't use them
+	tool := filepath.Join(dir, "tool")
+	err := ioutil.WriteFile(tool, nil, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewDatabase("memory", "srnd", "", "", "", "")
+	db.CreateTables()
+	daemon := &NNTPDaemon{
+		instance_name: "test.tld",
+		conf:          &SRNdConfig{},
+		database:      db,
+		infeed_load:   make(chan string),
+	}
+	daemon.store = createArticleStore(map[string]string{
+		"store_dir":             filepath.Join(dir, "articles"),
+		"incoming_dir":          filepath.Join(dir, "incoming"),
+		"attachments_dir":       filepath.Join(dir, "attachments"),
+		"thumbs_dir":            filepath.Join(dir, "thumbs"),
+		"convert_bin":           tool,
+		"identify_path":         tool,
+		"ffmpegthumbnailer_bin": tool,
+		"sox_bin":               tool,
+		"placeholder_thumbnail": tool,
+	}, db)
+	daemon.history = createMessageHistory(db, time.Hour)
+	daemon.expire = createExpirationCore(db, daemon.store, daemon.history, func(group, msgid, ref string) {})
+	daemon.mod = &modEngine{
+		database: db,
+		store:    daemon.store,
+		history:  daemon.history,
+		regen:    func(group, msgid, root string, page int) {},
+	}
+	go daemon.poll(0)
+	return daemon
+}
+
+// send a post posted at unix time posted into a daemon like a feed would
+// waits until the daemon is done loading it
+func testIngest(t *testing.T, daemon *NNTPDaemon, msgid, ref, message string, posted int64) {
+	nntp := testArticle(msgid, ref, "overchan.test", message)
+	nntp.Headers().Set("Date", time.Unix(posted, 0).UTC().Format(time.RFC1123Z))
+	var buff bytes.Buffer
+	err := nntp.WriteTo(&buff, MaxMessageSize)
+	if err != nil {
+		t.Fatal("failed to write article", err)
+	}
+	msg, err := readMIMEHeader(bufio.NewReader(&buff))
+	if err != nil {
+		t.Fatal("failed to read article", err)
+	}
+	conn := createNNTPConnection("")
+	conn.name = "test-inbound-feed"
+	err = conn.storeMessage(daemon, textproto.MIMEHeader(msg.Header), &io.LimitedReader{R: msg.Body, N: MaxMessageSize})
+	if err != nil {
+		t.Fatal("failed to store", msgid, err)
+	}
+	if !testLoaded(daemon, msgid) {
+		t.Fatal("daemon did not load", msgid)
+	}
+}
+
+// check if the daemon's worker queued an article for federation
+func testQueued(daemon *NNTPDaemon, msgid string) bool {
+	daemon.send_articles_mtx.RLock()
+	defer daemon.send_articles_mtx.RUnlock()
+	for _, e := range daemon.send_articles {
+		if e.MessageID() == msgid {
+			return true
+		}
+	}
+	return false
+}
+
+// wait until the daemon's worker is done loading an article
+func testLoaded(daemon *NNTPDaemon, msgid string) bool {
+	// the worker queues it for federation when it's done
+	for tries := 0; tries < 100; tries++ {
+		if testQueued(daemon, msgid) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestMemoryDatabase(t *testing.T) {
+	testDatabase(t, NewMemoryDatabase())
+}
+
+func TestDaemonIngest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	now := timeNow()
+	testIngest(t, daemon, "<root@test.tld>", "", "hello world", now-60)
+	testIngest(t, daemon, "<reply@test.tld>", "<root@test.tld>", "a reply to you", now-30)
+
+	if !daemon.store.HasArticle("<root@test.tld>") {
+		t.Error("article not in store")
+	}
+	if !db.HasArticleLocal("<root@test.tld>") || !db.HasArticleLocal("<reply@test.tld>") {
+		t.Error("article not registered")
+	}
+	if daemon.history.Disposition("<reply@test.tld>") != HistoryAccepted {
+		t.Error("article not recorded in history as accepted")
+	}
+	repls := db.GetThreadReplies("<root@test.tld>", 0, 0)
+	if len(repls) != 1 || repls[0] != "<reply@test.tld>" {
+		t.Error("wrong thread replies", repls)
+	}
+	// we prepend ourself to the path
+	p := db.GetPostModel("/", "<reply@test.tld>")
+	if p == nil || !strings.HasPrefix(p.(*post).MessagePath, "test.tld!") {
+		t.Error("path not updated", p)
+	}
+	// the same article again is dropped
+	conn := createNNTPConnection("")
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Message-Id", "<root@test.tld>")
+	conn.storeMessage(daemon, hdr, &io.LimitedReader{R: strings.NewReader("again"), N: MaxMessageSize})
+	if db.ArticleCount() != 2 {
+		t.Error("duplicate article registered")
+	}
+}
+
+func TestDaemonExpiration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	now := timeNow()
+	testIngest(t, daemon, "<old@test.tld>", "", "old thread", now-300)
+	testIngest(t, daemon, "<middle@test.tld>", "", "middle thread", now-200)
+	testIngest(t, daemon, "<new@test.tld>", "", "new thread", now-100)
+	// bumps the old thread but it's still the last bumped
+	testIngest(t, daemon, "<oldreply@test.tld>", "<old@test.tld>", "bump", now-250)
+
+	daemon.expire.ExpireGroup("overchan.test", 2)
+	threads := db.GetLastBumpedThreads("overchan.test", 10)
+	if len(threads) != 2 || threads[0].MessageID() != "<new@test.tld>" || threads[1].MessageID() != "<middle@test.tld>" {
+		t.Error("wrong threads after expiration", threads)
+	}
+	if db.HasArticleLocal("<oldreply@test.tld>") || daemon.store.HasArticle("<oldreply@test.tld>") {
+		t.Error("reply to expired thread not deleted")
+	}
+	if daemon.history.Disposition("<oldreply@test.tld>") != HistoryExpired {
+		t.Error("expired reply not recorded in history")
+	}
+
+	daemon.expire.ExpirePost("<middle@test.tld>")
+	if db.HasArticleLocal("<middle@test.tld>") || daemon.store.HasArticle("<middle@test.tld>") {
+		t.Error("expired post not deleted")
+	}
+	if !db.IsExpired("<middle@test.tld>") || db.IsExpired("<new@test.tld>") {
+		t.Error("wrong expired state")
+	}
+	if len(db.GetLastBumpedThreads("overchan.test", 10)) != 1 {
+		t.Error("thread of expired root post not deleted")
+	}
+	// expired articles and replies to them are refused after we forget their history
+	db.ExpireMessageHistory(now + 1)
+	conn := createNNTPConnection("")
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Newsgroups", "overchan.test")
+	hdr.Set("Message-Id", "<middle@test.tld>")
+	hdr.Set("X-Encrypted-Ip", "encaddr")
+	if reason, _, _ := conn.checkMIMEHeaderNoAuth(daemon, hdr); reason == "" {
+		t.Error("expired article accepted again")
+	}
+	hdr.Set("Message-Id", "<late@test.tld>")
+	hdr.Set("References", "<middle@test.tld>")
+	if reason, _, _ := conn.checkMIMEHeaderNoAuth(daemon, hdr); reason == "" {
+		t.Error("reply to expired thread accepted")
+	}
+
+	// bans that ran out go away and leave a mod log entry
+	db.BanAddr("10.0.0.1", ModScopeGlobal, "flood", "key1", now-1)
+	db.BanAddr("10.0.0.2", ModScopeGlobal, "", "", -1)
+	daemon.purgeExpiredBans()
+	bans, _ := db.GetIPBans()
+	if len(bans) != 1 || bans[0].Addr != "10.0.0.2" {
+		t.Error("wrong bans after purge", bans)
+	}
+	logs, _ := db.GetModLogs(ModLogQuery{Action: ModLogBanExpired})
+	if len(logs) != 1 || logs[0].Target != "10.0.0.1" || logs[0].Pubkey != "key1" || logs[0].Reason != "flood" {
+		t.Error("wrong mod log of expired ban", logs)
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	now := timeNow()
+	testIngest(t, daemon, "<root@test.tld>", "", "hello world", now-60)
+	testIngest(t, daemon, "<reply@test.tld>", "<root@test.tld>", "a reply to you", now-30)
+
+	// posts are rendered with the global template engine
+	engine := template
+	templates := filepath.Join("..", "..", "..", "..", "..", "contrib", "templates")
+	engine.changeTemplateDir(filepath.Join(templates, "default"))
+	engine.DB = db
+
+	var buff bytes.Buffer
+	engine.genThread(true, false, ArticleEntry{"<root@test.tld>", "overchan.test"}, "/", "test", &buff, db, false)
+	page := buff.String()
+	if !strings.Contains(page, "hello world") || !strings.Contains(page, "a reply to you") {
+		t.Error("thread page does not have posts", page)
+	}
+
+	buff.Reset()
+	engine.genBoardPage(true, false, "/", "test", "overchan.test", 0, &buff, db, false)
+	page = buff.String()
+	if !strings.Contains(page, "hello world") || !strings.Contains(page, "overchan.test") {
+		t.Error("board page does not have thread", page)
+	}
+
+	buff.Reset()
+	engine.genThread(true, false, ArticleEntry{"<root@test.tld>", "overchan.test"}, "/", "test", &buff, db, true)
+	if !strings.Contains(buff.String(), "reply@test.tld") {
+		t.Error("thread json does not have reply", buff.String())
+	}
+
+	// every theme has the pages the frontend renders
+	posts, _, _ := db.SearchPosts("/", SearchRequest{Text: "hello"})
+	for _, theme := range []string{"chen6", "chen7", "default", "neochan", "placebo"} {
+		engine.changeTemplateDir(filepath.Join(templates, theme))
+		page = engine.renderTemplate("search.mustache", map[string]interface{}{"prefix": "/", "searched": true, "posts": posts})
+		if !strings.Contains(page, "hello world") {
+			t.Error(theme, "search page does not have results", page)
+		}
+		page = engine.renderTemplate("report.mustache", map[string]interface{}{"prefix": "/", "msgid": "<report@test.tld>", "reasons": []string{"spam"}})
+		if !strings.Contains(page, `name="reason"`) || !strings.Contains(page, "report@test.tld") {
+			t.Error(theme, "report page does not render", page)
+		}
+	}
+}
+
+// a tripcode key for signing test articles
+var testSeed = bytes.Repeat([]byte{1}, 32)
+
+// sign an article with testSeed like a tripcoded post or a mod message
+func testSign(t *testing.T, nntp NNTPMessage) (signed NNTPMessage, pubkey string) {
+	s, err := signArticle(nntp, testSeed)
+	if err != nil {
+		t.Fatal("failed to sign", nntp.MessageID(), err)
+	}
+	return s, s.Pubkey()
+}
+
+func TestModFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	mod := daemon.mod
+	now := timeNow()
+	testIngest(t, daemon, "<root@test.tld>", "", "hello world", now-60)
+	testIngest(t, daemon, "<reply@test.tld>", "<root@test.tld>", "a reply to you", now-30)
+
+	// locking a reply locks its thread
+	mod.Do(ParseModEvent("overchan-lock <reply@test.tld>"), "")
+	if !db.ArticleHasFlag("<root@test.tld>", ArticleFlagLocked) {
+		t.Fatal("thread not locked")
+	}
+	conn := createNNTPConnection("")
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Newsgroups", "overchan.test")
+	hdr.Set("Message-Id", "<late@test.tld>")
+	hdr.Set("References", "<root@test.tld>")
+	hdr.Set("X-Encrypted-Ip", "encaddr")
+	reason, ban, _ := conn.checkMIMEHeaderNoAuth(daemon, hdr)
+	if reason != "thread locked" || ban {
+		t.Error("reply to locked thread not rejected without a ban", reason, ban)
+	}
+	mod.Do(ParseModEvent("overchan-unlock <root@test.tld>"), "")
+	reason, _, _ = conn.checkMIMEHeaderNoAuth(daemon, hdr)
+	if reason != "" {
+		t.Error("reply to unlocked thread rejected", reason)
+	}
+
+	// hidden posts are not rendered
+	mod.Do(ParseModEvent("overchan-hide <reply@test.tld>"), "")
+	var buff bytes.Buffer
+	template.genThread(true, false, ArticleEntry{"<root@test.tld>", "overchan.test"}, "/", "test", &buff, db, true)
+	if strings.Contains(buff.String(), "reply@test.tld") || !strings.Contains(buff.String(), "root@test.tld") {
+		t.Error("hidden reply rendered", buff.String())
+	}
+	mod.Do(ParseModEvent("overchan-unhide <reply@test.tld>"), "")
+	buff.Reset()
+	template.genThread(true, false, ArticleEntry{"<root@test.tld>", "overchan.test"}, "/", "test", &buff, db, true)
+	if !strings.Contains(buff.String(), "reply@test.tld") {
+		t.Error("unhidden reply not rendered", buff.String())
+	}
+
+	// only mods can flag
+	mod.Execute(ParseModEvent("overchan-stick <root@test.tld>"), "0000000000000000000000000000000000000000000000000000000000000000", "<ctl@test.tld>")
+	if db.ArticleHasFlag("<root@test.tld>", ArticleFlagSticky) {
+		t.Error("thread stickied by someone who isn't a mod")
+	}
+
+	// mod messages from other nodes are signed
+	ctl, pubkey := testSign(t, testArticle("<notmod@test.tld>", "", "ctl", "overchan-stick <root@test.tld>"))
+	err = testStore(daemon, ctl)
+	if err != nil || !testLoaded(daemon, "<notmod@test.tld>") {
+		t.Fatal("daemon did not load signed mod message", err)
+	}
+	if db.ArticleHasFlag("<root@test.tld>", ArticleFlagSticky) {
+		t.Error("thread stickied by a signed message from someone who isn't a mod")
+	}
+	db.MarkModPubkeyGlobal(pubkey)
+	ctl, _ = testSign(t, testArticle("<signedctl@test.tld>", "", "ctl", "overchan-stick <root@test.tld>"))
+	err = testStore(daemon, ctl)
+	if err != nil || !testLoaded(daemon, "<signedctl@test.tld>") {
+		t.Fatal("daemon did not load signed mod message", err)
+	}
+	nntp := daemon.store.GetMessage("<signedctl@test.tld>")
+	if nntp == nil || nntp.Pubkey() != pubkey {
+		t.Fatal("signed mod message not loaded", nntp)
+	}
+	if !db.ArticleHasFlag("<root@test.tld>", ArticleFlagSticky) {
+		t.Error("signed mod message not executed")
+	}
+}
+
+func TestModScope(t *testing.T) {
+	ev := ParseModEvent("overchan-inet-ban 10.9.0.0/16 scope=overchan.test expires=1234 reason=flood of spam")
+	if ev.Action() != ModInetBan || ev.Target() != "10.9.0.0/16" || ev.Scope() != "overchan.test" || ev.Expires() != 1234 || ev.Reason() != "flood of spam" {
+		t.Error("bad extended mod event", ev.Target(), ev.Scope(), ev.Expires(), ev.Reason())
+	}
+	ev = ParseModEvent("delete <root@test.tld>")
+	if ev.Target() != "<root@test.tld>" || ev.Scope() != ModScopeGlobal || ev.Expires() != -1 || ev.Reason() != "" {
+		t.Error("bad old style mod event", ev.Scope(), ev.Expires(), ev.Reason())
+	}
+	if overchanInetBan("encaddr", "key", 5678).Expires() != 5678 {
+		t.Error("inet ban expire field ignored")
+	}
+	ev = scopedModEvent(overchanDelete("<root@test.tld>"), "overchan.test", "off  topic", -1)
+	if ev.String() != "delete <root@test.tld> scope=overchan.test reason=off topic" {
+		t.Error("bad scoped mod event", ev)
+	}
+
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	mod := daemon.mod
+	testIngest(t, daemon, "<root@test.tld>", "", "hello world", timeNow()-60)
+
+	// events only act on posts in their scope
+	err = mod.Do(ParseModEvent("overchan-sage <root@test.tld> scope=overchan.other"), "")
+	if err != ErrNotInScope || db.ArticleHasFlag("<root@test.tld>", ArticleFlagSage) {
+		t.Error("post flagged out of scope")
+	}
+	// a newsgroup scope is not a regex
+	err = mod.Do(ParseModEvent("overchan-sage <root@test.tld> scope=overchan.te.t"), "")
+	if err != ErrNotInScope || db.ArticleHasFlag("<root@test.tld>", ArticleFlagSage) {
+		t.Error("newsgroup scope matched as a regex")
+	}
+	mod.Do(ParseModEvent(`overchan-sage <root@test.tld> scope=overchan\.te.t`), "")
+	if !db.ArticleHasFlag("<root@test.tld>", ArticleFlagSage) {
+		t.Error("post not flagged in scope")
+	}
+	err = mod.Do(ParseModEvent("overchan-unsage <root@test.tld> expires=1"), "")
+	if err != ErrModEventExpired || !db.ArticleHasFlag("<root@test.tld>", ArticleFlagSage) {
+		t.Error("expired mod event done")
+	}
+
+	// board mods can only ban from their board
+	pubkey := "1111111111111111111111111111111111111111111111111111111111111111"
+	db.MarkModPubkeyCanModGroup(pubkey, "overchan.test")
+	mod.Execute(ParseModEvent("overchan-inet-ban 10.6.0.0/16 scope=overchan.test expires=1"), pubkey, "<ctl@test.tld>")
+	mod.Execute(ParseModEvent("overchan-inet-ban 10.9.0.0/16 scope=overchan.test reason=spam"), pubkey, "<ctl@test.tld>")
+	mod.Execute(ParseModEvent("overchan-inet-ban 10.8.0.0/16"), pubkey, "<ctl@test.tld>")
+	mod.Execute(ParseModEvent("overchan-inet-ban 10.7.0.0/16 scope=overchan.other"), pubkey, "<ctl@test.tld>")
+	for addr, expect := range map[string]bool{
+		"10.9.1.1": true,
+		"10.8.1.1": false,
+		"10.7.1.1": false,
+	} {
+		banned, _ := db.CheckIPBanned(addr, "overchan.test")
+		if banned != expect {
+			t.Error("wrong ban for", addr, banned)
+		}
+	}
+	banned, _ := db.CheckIPBanned("10.9.1.1", "overchan.other")
+	if banned {
+		t.Error("board ban applied to other board")
+	}
+	banned, _ = db.CheckIPBanned("10.9.1.1", "overchanxtest")
+	if banned {
+		t.Error("board ban applied to board that differs where the dots are")
+	}
+	bans, _ := db.GetIPBans()
+	if len(bans) != 1 || bans[0].Issuer != pubkey || bans[0].Reason != "spam" {
+		t.Error("wrong bans in effect", bans)
+	}
+
+	// the mod log has what they did and didn't get to do
+	logs, _ := db.GetModLogs(ModLogQuery{Pubkey: pubkey})
+	if len(logs) != 4 {
+		t.Fatal("wrong number of mod log entries", logs)
+	}
+	// allowed but expired so not done
+	if logs[3].Executed || logs[3].Target != "10.6.0.0/16" {
+		t.Error("expired ban logged as executed", logs[3])
+	}
+	if logs[0].Executed || logs[0].Scope != "overchan.other" || logs[1].Executed || logs[1].Scope != ModScopeGlobal {
+		t.Error("denied bans not in mod log", logs)
+	}
+	if !logs[2].Executed || logs[2].Target != "10.9.0.0/16" || logs[2].Reason != "spam" || logs[2].MessageID != "<ctl@test.tld>" {
+		t.Error("ban not in mod log", logs[2])
+	}
+	template.changeTemplateDir(filepath.Join("..", "..", "..", "..", "..", "contrib", "templates", "default"))
+	page := template.renderTemplate("modlog.mustache", map[string]interface{}{"entries": logs})
+	if !strings.Contains(page, "10.9.0.0/16") || !strings.Contains(page, "denied") || !strings.Contains(page, time.Unix(logs[2].Time, 0).UTC().Format(time.RFC3339)) {
+		t.Error("mod log page does not have entries", page)
+	}
+}
+
+func TestModDelegation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	mod := daemon.mod
+	testIngest(t, daemon, "<root@test.tld>", "", "hello world", timeNow()-60)
+	admin := "0000000000000000000000000000000000000000000000000000000000000000"
+	remote := "1111111111111111111111111111111111111111111111111111111111111111"
+	janitor := "2222222222222222222222222222222222222222222222222222222222222222"
+	db.MarkPubkeyAdmin(admin)
+
+	// only admins and keys they let can delegate
+	mod.Execute(overchanDelegate(janitor, "", nil, -1), remote, "<ctl1@test.tld>")
+	if mod.AllowDelete(janitor, "<root@test.tld>") {
+		t.Error("key that can't delegate gave rights")
+	}
+	mod.Execute(overchanDelegate(remote, "overchan.test", []ModAction{ModDelegate, ModInetBan, ModSage}, -1), admin, "<ctl2@test.tld>")
+	if !mod.AllowBan(remote, "overchan.test") || mod.AllowBan(remote, "overchan.other") || mod.AllowBan(remote, ModScopeGlobal) {
+		t.Error("delegated ban rights not in scope")
+	}
+	if mod.AllowDelete(remote, "<root@test.tld>") {
+		t.Error("delegated action not given")
+	}
+
+	// rights passed on can't be more than what was given
+	mod.Execute(ParseModEvent("overchan-delegate "+janitor+" scope=overchan.test actions=overchan-sage,delete"), remote, "<ctl3@test.tld>")
+	mod.Execute(ParseModEvent("overchan-sage <root@test.tld>"), janitor, "<ctl4@test.tld>")
+	if !db.ArticleHasFlag("<root@test.tld>", ArticleFlagSage) {
+		t.Error("delegated sage not done")
+	}
+	if mod.AllowDelete(janitor, "<root@test.tld>") || mod.AllowDelegate(janitor, "overchan.test") {
+		t.Error("delegated more than granter has")
+	}
+
+	// taking away rights breaks the chain after it
+	mod.Execute(overchanRevoke(remote, "overchan.test"), admin, "<ctl5@test.tld>")
+	if mod.Delegated(remote, "overchan.test", ModInetBan) || mod.Delegated(janitor, "overchan.test", ModSage) {
+		t.Error("revoked rights still there")
+	}
+	delegations, _ := db.GetModDelegations(janitor)
+	if len(delegations) != 1 || delegations[0].Granter != remote || len(delegations[0].Actions) != 2 {
+		t.Error("revoke took away the wrong rights", delegations)
+	}
+
+	// expired rights don't count
+	mod.Execute(overchanDelegate(remote, "", nil, timeNow()-1), admin, "<ctl6@test.tld>")
+	if mod.AllowDelete(remote, "<root@test.tld>") {
+		t.Error("expired rights count")
+	}
+	mod.Execute(overchanDelegate(remote, "", nil, timeNow()+60), admin, "<ctl7@test.tld>")
+	if !mod.AllowDelete(remote, "<root@test.tld>") || mod.AllowDelegate(remote, "overchan.test") {
+		t.Error("wrong default rights")
+	}
+	logs, _ := db.GetModLogs(ModLogQuery{Action: string(ModDelegate)})
+	if len(logs) != 5 || logs[4].Executed {
+		t.Error("delegations not in mod log", logs)
+	}
+}
+
+// draw a test picture, shapes picks which one
+func testImage(w, h, shapes int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v uint8
+			if shapes == 0 {
+				// a bright disc on a dark gradient
+				dx, dy := x-w/3, y-h/2
+				if dx*dx+dy*dy < h*h/9 {
+					v = 230
+				} else {
+					v = uint8(40 + 120*x/w)
+				}
+			} else {
+				// stripes
+				v = uint8(255 * ((x * 5 / w) % 2))
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+// store an article with an attachment like a feed would
+func testStoreWithImage(daemon *NNTPDaemon, msgid, fname string, data []byte) error {
+	nntp := testArticle(msgid, "", "overchan.test", "look at this")
+	nntp.Attach(createAttachment("image/png", fname, strings.NewReader(base64.StdEncoding.EncodeToString(data))))
+	nntp.Pack()
+	return testStore(daemon, nntp)
+}
+
+// store an article like a feed would without waiting for the daemon to load it
+func testStore(daemon *NNTPDaemon, nntp NNTPMessage) error {
+	var buff bytes.Buffer
+	err := nntp.WriteTo(&buff, MaxMessageSize)
+	if err == nil {
+		var msg *mail.Message
+		msg, err = readMIMEHeader(bufio.NewReader(&buff))
+		if err == nil {
+			conn := createNNTPConnection("")
+			err = conn.storeMessage(daemon, textproto.MIMEHeader(msg.Header), &io.LimitedReader{R: msg.Body, N: MaxMessageSize})
+		}
+	}
+	return err
+}
+
+func TestImageBan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	mod := daemon.mod
+
+	// the same picture made bigger and saved as a jpeg looks the same
+	var orig, reencoded, other bytes.Buffer
+	png.Encode(&orig, testImage(120, 90, 0))
+	jpeg.Encode(&reencoded, testImage(300, 225, 0), &jpeg.Options{Quality: 40})
+	png.Encode(&other, testImage(120, 90, 1))
+	img, _, _ := image.Decode(bytes.NewReader(orig.Bytes()))
+	origHash := imageDHash(img)
+	img, _, _ = image.Decode(bytes.NewReader(reencoded.Bytes()))
+	reencodedHash := imageDHash(img)
+	img, _, _ = image.Decode(bytes.NewReader(other.Bytes()))
+	otherHash := imageDHash(img)
+	if origHash.Distance(reencodedHash) > DefaultImageBanDistance {
+		t.Error("re-encoded image hash too far", origHash, reencodedHash)
+	}
+	if origHash.Distance(otherHash) <= DefaultImageBanDistance {
+		t.Error("different images hash too close", origHash, otherHash)
+	}
+	h, err := ParseImageHash(origHash.String())
+	if err != nil || h != origHash {
+		t.Error("image hash does not parse", origHash, h, err)
+	}
+
+	// images that say they are huge are not decoded
+	var huge bytes.Buffer
+	gif.Encode(&huge, testImage(4, 4, 0), nil)
+	bomb := huge.Bytes()
+	// logical screen width and height
+	copy(bomb[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+	fpath := filepath.Join(dir, "bomb.gif")
+	ioutil.WriteFile(fpath, bomb, 0600)
+	_, err = hashImageFile(fpath)
+	if err != ErrImageTooBig {
+		t.Error("huge image hashed", err)
+	}
+
+	// only global mods ban images
+	admin := "0000000000000000000000000000000000000000000000000000000000000000"
+	boardmod := "1111111111111111111111111111111111111111111111111111111111111111"
+	db.MarkPubkeyAdmin(admin)
+	db.MarkModPubkeyCanModGroup(boardmod, "overchan.test")
+	mod.Execute(overchanImageBan(otherHash, "stripes"), boardmod, "<ctl1@test.tld>")
+	mod.Execute(overchanImageBan(origHash, "spam"), admin, "<ctl2@test.tld>")
+	banned, _ := db.GetBannedImageHashes()
+	if len(banned) != 1 || banned[0].Hash != origHash || banned[0].Reason != "spam" {
+		t.Fatal("wrong banned images", banned)
+	}
+
+	// posts with the banned image however it's saved are rejected
+	err = testStoreWithImage(daemon, "<spam@test.tld>", "cat.jpg", reencoded.Bytes())
+	if err != ErrBannedImage {
+		t.Error("post with banned image not rejected", err)
+	}
+	if !db.ArticleBanned("<spam@test.tld>") || daemon.store.HasArticle("<spam@test.tld>") {
+		t.Error("post with banned image kept")
+	}
+	err = testStoreWithImage(daemon, "<fine@test.tld>", "stripes.png", other.Bytes())
+	if err != nil {
+		t.Error("post with fine image rejected", err)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	for _, rule := range []FilterRule{
+		{Field: "message", Pattern: "viagra", Action: FilterReject},
+		{Newsgroup: "overchan.other", Field: "message", Pattern: "hello", Action: FilterReject},
+		{Field: "message", Pattern: "d[a4]rn", Regex: true, Action: FilterReplace, Replacement: "****"},
+		{Field: "subject", Pattern: "ANNOUNCEMENT", Action: FilterSage},
+		{Newsgroup: "overchan.test", Field: "X-Encrypted-Ip", Pattern: "^suspicious$", Regex: true, Action: FilterHold},
+		{Field: "message", Pattern: "[", Regex: true, Action: FilterReject},
+	} {
+		if rule.check() != nil {
+			continue
+		}
+		db.AddFilterRule(rule)
+	}
+	rules, _ := db.GetFilterRules()
+	if len(rules) != 5 {
+		t.Fatal("wrong filter rules", rules)
+	}
+
+	err = testStore(daemon, testArticle("<spam@test.tld>", "", "overchan.test", "buy Viagra now"))
+	if err != ErrFilteredArticle || !db.ArticleBanned("<spam@test.tld>") {
+		t.Error("filtered post not rejected", err)
+	}
+	// signed posts are rejected too and none of their files are kept
+	var pic bytes.Buffer
+	png.Encode(&pic, testImage(120, 90, 0))
+	nntp := testArticle("<signedspam@test.tld>", "", "overchan.test", "buy viagra")
+	nntp.Attach(createAttachment("image/png", "viagra.png", strings.NewReader(base64.StdEncoding.EncodeToString(pic.Bytes()))))
+	nntp.Pack()
+	nntp, _ = testSign(t, nntp)
+	err = testStore(daemon, nntp)
+	if err != ErrFilteredArticle || !db.ArticleBanned("<signedspam@test.tld>") || daemon.store.HasArticle("<signedspam@test.tld>") {
+		t.Error("filtered signed post not rejected", err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "attachments"))
+	if len(files) != 0 {
+		t.Error("attachments of rejected post kept", files[0].Name())
+	}
+
+	// words only match on their own, rules for other boards don't count
+	testIngest(t, daemon, "<root@test.tld>", "", "hello, viagrafalls is a d4rn nice place", timeNow()-60)
+	p := db.GetPostModel("/", "<root@test.tld>")
+	if p == nil || !strings.Contains(p.RenderBody(), "a **** nice") {
+		t.Error("filter did not replace text", p)
+	}
+	// we pass on what was posted
+	if orig := daemon.store.GetMessage("<root@test.tld>"); orig == nil || !strings.Contains(orig.Message(), "d4rn") {
+		t.Error("filter changed the article we pass on", orig)
+	}
+	local := testArticle("<local@test.tld>", "", "overchan.test", "what a d4rn shame").(*nntpArticle)
+	local.Headers().Set("Subject", "announcement")
+	shown, _ := filterArticleCopy(rules, local)
+	if shown.Message() != "what a **** shame" || !shown.Sage() || local.Message() != "what a d4rn shame" || local.Sage() {
+		t.Error("bad filtered copy", shown.Message(), local.Message())
+	}
+
+	testIngest(t, daemon, "<newer@test.tld>", "", "another thread", timeNow()-30)
+	nntp = testArticle("<reply@test.tld>", "<root@test.tld>", "overchan.test", "reply")
+	nntp.Headers().Set("Subject", "an announcement")
+	nntp.Pack()
+	err = testStore(daemon, nntp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads := db.GetLastBumpedThreads("overchan.test", 10)
+	if len(threads) != 2 || threads[0].MessageID() != "<newer@test.tld>" {
+		t.Error("filter did not sage", threads)
+	}
+
+	nntp = testArticle("<held@test.tld>", "<root@test.tld>", "overchan.test", "held")
+	nntp.Headers().Set("X-Encrypted-Ip", "suspicious")
+	err = testStore(daemon, nntp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, _ := db.GetArticleReports()
+	if !db.ArticleHasFlag("<held@test.tld>", ArticleFlagHidden) || len(reports) != 1 || reports[0].MessageID != "<held@test.tld>" || reports[0].Reasons[0].Reason != FilterHeldReason {
+		t.Error("filter did not hold post", reports)
+	}
+	if !db.ArticleHasFlag("<held@test.tld>", ArticleFlagQuarantined) {
+		t.Error("held post not quarantined")
+	}
+
+	db.DelFilterRule(rules[0].ID)
+	err = testStore(daemon, testArticle("<spam2@test.tld>", "", "overchan.test", "buy Viagra now"))
+	if err != nil {
+		t.Error("removed filter still rejects", err)
+	}
+}
+
+func TestSpamClassifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	db := daemon.database
+	mod := daemon.mod
+	for i := 0; i < MinSpamExamples; i++ {
+		msgid := fmt.Sprintf("<spam%d@test.tld>", i)
+		err = testStore(daemon, testArticle(msgid, "", "overchan.test", fmt.Sprintf("cheap pills at http://pills%d.example/ buy now", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// not enough examples yet so nothing is spam
+		mod.Do(overchanDelete(msgid), "")
+		msgid = fmt.Sprintf("<ham%d@test.tld>", i)
+		testIngest(t, daemon, msgid, "", fmt.Sprintf("what do you think about thread number %d", i), timeNow())
+		trainSpamArticle(db, daemon.store.GetMessage(msgid), false)
+	}
+	_, totals, _ := db.GetSpamCounts(nil)
+	if totals != (SpamCounts{Spam: MinSpamExamples, Ham: MinSpamExamples}) {
+		t.Fatal("classifier not trained", totals)
+	}
+
+	err = testStore(daemon, testArticle("<flood@test.tld>", "", "overchan.test", "cheap pills, buy now"))
+	if err != ErrSpamArticle || !db.ArticleBanned("<flood@test.tld>") {
+		t.Error("spam not rejected", err)
+	}
+	err = testStore(daemon, testArticle("<post@test.tld>", "", "overchan.test", "what do you think about this"))
+	if err != nil {
+		t.Error("ham rejected", err)
+	}
+
+	daemon.store.(*articleStore).spamAction = SpamHold
+	err = testStore(daemon, testArticle("<held@test.tld>", "", "overchan.test", "cheap pills"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, _ := db.GetArticleReports()
+	if !db.ArticleHasFlag("<held@test.tld>", ArticleFlagHidden) || len(reports) != 1 || reports[0].Reasons[0].Reason != FilterHeldReason {
+		t.Error("spam not held", reports)
+	}
+	// held spam is not sent on until a mod says it's not spam
+	if !db.ArticleHasFlag("<held@test.tld>", ArticleFlagQuarantined) {
+		t.Error("held spam not quarantined")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon := testDaemon(t, dir)
+	daemon.conf.daemon = map[string]string{"quarantine_newsgroups": "overchan.test"}
+	db := daemon.database
+	err = testStore(daemon, testArticle("<wait@test.tld>", "", "overchan.test", "approve me"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testStore(daemon, testArticle("<bad@test.tld>", "", "overchan.test", "reject me"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tripcoded posts wait too
+	signed, _ := testSign(t, testArticle("<tripcode@test.tld>", "", "overchan.test", "signed"))
+	err = testStore(daemon, signed)
+	if err != nil || !db.ArticleHasFlag("<tripcode@test.tld>", ArticleFlagQuarantined) {
+		t.Fatal("signed article not quarantined", err)
+	}
+	// feeds on probation
+	conn := createNNTPConnection("")
+	conn.probation = true
+	storeProbation := func(nntp NNTPMessage) error {
+		var buff bytes.Buffer
+		nntp.WriteTo(&buff, MaxMessageSize)
+		msg, _ := readMIMEHeader(bufio.NewReader(&buff))
+		return conn.storeMessage(daemon, textproto.MIMEHeader(msg.Header), &io.LimitedReader{R: msg.Body, N: MaxMessageSize})
+	}
+	err = storeProbation(testArticle("<probation@test.tld>", "", "overchan.other", "from a new feed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	articles, err := db.GetQuarantinedArticles()
+	if err != nil || len(articles) != 4 {
+		t.Fatal("bad quarantine queue", articles, err)
+	}
+	for _, a := range articles {
+		if a.MessageID == "<wait@test.tld>" && (a.Message != "approve me" || a.Newsgroup != "overchan.test" || a.Time == 0) {
+			t.Error("bad quarantined article", a)
+		}
+	}
+	if len(db.GetLastBumpedThreads("overchan.test", 10)) != 0 {
+		t.Error("quarantined thread shown")
+	}
+	if _, _, failure := conn.resolveArticle(daemon, "<wait@test.tld>"); failure == "" {
+		t.Error("quarantined article served")
+	}
+	err = testStore(daemon, testArticle("<other@test.tld>", "", "overchan.other", "no quarantine here"))
+	if err != nil || !testLoaded(daemon, "<other@test.tld>") || db.ArticleHasFlag("<other@test.tld>", ArticleFlagQuarantined) {
+		t.Error("other board quarantined", err)
+	}
+
+	// a quarantined reply does not bump its thread or show up over nntp until it's approved
+	now := timeNow()
+	older := testArticle("<older@test.tld>", "", "overchan.other", "older thread")
+	older.Headers().Set("Date", time.Unix(now-100, 0).UTC().Format(time.RFC1123Z))
+	testStore(daemon, older)
+	reply := testArticle("<reply@test.tld>", "<older@test.tld>", "overchan.other", "bump")
+	reply.Headers().Set("Date", time.Unix(now+100, 0).UTC().Format(time.RFC1123Z))
+	err = storeProbation(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads := db.GetLastBumpedThreads("overchan.other", 10)
+	if len(threads) != 2 || threads[0].MessageID() != "<other@test.tld>" {
+		t.Error("quarantined reply bumped its thread", threads)
+	}
+	entries, _ := db.GetArticlesObtainedSince(0)
+	for _, e := range entries {
+		if e.MessageID() == "<reply@test.tld>" || e.MessageID() == "<wait@test.tld>" {
+			t.Error("quarantined article in NEWNEWS", e)
+		}
+	}
+	overviews, _ := db.GetNNTPHeadersInRange("overchan.other", 0, -1, []string{"Subject"})
+	if len(overviews) != 2 {
+		t.Error("quarantined articles in OVER", overviews)
+	}
+	err = daemon.approveArticle("<reply@test.tld>")
+	threads = db.GetLastBumpedThreads("overchan.other", 10)
+	if err != nil || len(threads) != 2 || threads[0].MessageID() != "<older@test.tld>" {
+		t.Error("approved reply did not bump its thread", threads, err)
+	}
+	overviews, _ = db.GetNNTPHeadersInRange("overchan.other", 0, -1, []string{"Subject"})
+	if len(overviews) != 3 {
+		t.Error("approved article not in OVER", overviews)
+	}
+
+	err = daemon.approveArticle("<wait@test.tld>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testLoaded(daemon, "<wait@test.tld>") || db.ArticleHasFlag("<wait@test.tld>", ArticleFlagHidden) {
+		t.Error("approved article not loaded")
+	}
+	// the worker loads in order so it would have got to it by now
+	if testQueued(daemon, "<tripcode@test.tld>") {
+		t.Error("quarantined signed article federated")
+	}
+	if daemon.approveArticle("<wait@test.tld>") != ErrNotQuarantined {
+		t.Error("approved twice")
+	}
+	err = daemon.rejectArticle("<bad@test.tld>")
+	if err != nil || db.HasArticleLocal("<bad@test.tld>") || !db.ArticleBanned("<bad@test.tld>") {
+		t.Error("rejected article not removed", err)
+	}
+	articles, _ = db.GetQuarantinedArticles()
+	if len(articles) != 2 || articles[0].MessageID == articles[1].MessageID || (articles[0].MessageID != "<probation@test.tld>" && articles[0].MessageID != "<tripcode@test.tld>") || (articles[1].MessageID != "<probation@test.tld>" && articles[1].MessageID != "<tripcode@test.tld>") {
+		t.Error("quarantine queue not updated", articles)
+	}
+}
+
+func TestFeedSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := openFeedSpool(dir, "feed")
+	spool.Add("<a@test.tld>")
+	spool.Add("<b@test.tld>")
+	spool.Claim("<b@test.tld>")
+	// an entry sent and removed after we list the spool is skipped
+	err = os.Symlink(filepath.Join(dir, "gone"), spool.entryPath("<gone@test.tld>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgids, err := spool.Unclaimed()
+	if err != nil || len(msgids) != 1 || msgids[0] != "<a@test.tld>" {
+		t.Error("wrong unclaimed articles", msgids, err)
+	}
+}
@@ -4,6 +4,9 @@
 package srnd
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"strings"
@@ -65,14 +68,103 @@ type PostingStats struct {
 	History []PostingStatsEntry
 }
 
+// most time slices we will make posting stats for
+const maxPostingStatsSlices = 1000
+
+// make posting stats for each slice of gran seconds between begin and end
+// count gets how many posts each newsgroup had in a slice
+func makePostingStats(gran, begin, end int64, count func(lo, hi int64) (map[string]int64, error)) (st PostingStats, err error) {
+	if gran <= 0 || end < begin {
+		err = errors.New("invalid time range")
+		return
+	}
+	if (end-begin)/gran >= maxPostingStatsSlices {
+		err = errors.New("too many time slices")
+		return
+	}
+	for lo := begin; lo < end; lo += gran {
+		hi := lo + gran
+		if hi > end {
+			hi = end
+		}
+		var counts map[string]int64
+		counts, err = count(lo, hi)
+		if err != nil {
+			st.History = nil
+			return
+		}
+		var entry PostingStatsEntry
+		for group, posted := range counts {
+			entry.Groups = append(entry.Groups, NewsgroupStats{
+				Name:   group,
+				Start:  time.Unix(lo, 0),
+				End:    time.Unix(hi, 0),
+				Posted: []PostEntry{{lo, posted}},
+			})
+		}
+		st.History = append(st.History, entry)
+	}
+	return
+}
+
+// header values for an article in a newsgroup, used for OVER and HDR
+type NNTPOverview struct {
+	// the article number in the newsgroup
+	Number int64
+	// the article's message-id
+	MessageID string
+	// lowercased header name -> values
+	Headers ArticleHeaders
+}
+
+// moderation flags an article can have, set and cleared by mod events
+const ArticleFlagHidden = "hidden"
+const ArticleFlagLocked = "locked"
+const ArticleFlagSage = "sage"
+const ArticleFlagSticky = "sticky"
+
+// sql condition that is true when the message-id in column col has a moderation flag
+func articleFlagSQL(col, flag string) string {
+	return fmt.Sprintf("%s IN ( SELECT message_id FROM ArticleFlags WHERE flag = '%s' )", col, flag)
+}
+
+// check if a ban with scope keeps someone from posting in newsgroup
+// an empty newsgroup means anywhere
+func banCovers(scope, newsgroup string) bool {
+	return newsgroup == "" || modScopeMatches(scope, newsgroup)
+}
+
+// check if any of the ban scopes in rows covers newsgroup, closes rows
+func scanBansCover(rows *sql.Rows, newsgroup string) (banned bool) {
+	for rows.Next() {
+		var scope string
+		rows.Scan(&scope)
+		if banCovers(scope, newsgroup) {
+			banned = true
+			break
+		}
+	}
+	rows.Close()
+	return
+}
+
 type Database interface {
 	Close()
 	CreateTables()
+
+	// get every schema migration we know about and when it was applied
+	GetSchemaMigrations() ([]SchemaMigration, error)
+	// apply all pending schema migrations
+	MigrateSchema() error
+
 	HasNewsgroup(group string) bool
 	HasArticle(message_id string) bool
 	HasArticleLocal(message_id string) bool
 	RegisterNewsgroup(group string)
 	RegisterArticle(article NNTPMessage) error
+	// bump the thread of a reply and update when it was last posted in
+	// done by RegisterArticle unless the reply is quarantined
+	BumpThread(reply NNTPMessage) error
 	GetAllArticlesInGroup(group string, send chan ArticleEntry)
 	CountAllArticlesInGroup(group string) (int64, error)
 	GetAllArticles() []ArticleEntry
@@ -142,6 +234,7 @@ type Database interface {
 
 	// get every message id for root posts that need to be expired in a newsgroup
 	// threadcount is the upperbound limit to how many root posts we keep
+	// sticky threads are kept before the others
 	GetRootPostsForExpiration(newsgroup string, threadcount int) []string
 
 	// get the number of pages a board has
@@ -150,15 +243,17 @@ type Database interface {
 	// get board page number N
 	// prefix and frontend are injected
 	// does not load replies for thread, only gets root posts
+	// sticky threads come first and hidden threads are left out
 	GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel
 
 	// get the root posts of the last N bumped threads in a given newsgroup or "" for ukko
+	// sticky threads come first and hidden threads are left out
 	GetLastBumpedThreads(newsgroup string, threadcount int) []ArticleEntry
 
 	// get root posts of last N bumped threads with pagination offset
 	GetLastBumpedThreadsPaginated(newsgroup string, threadcount, offset int) []ArticleEntry
 
-	// get the PostModels for replies to a thread
+	// get the PostModels for replies to a thread that aren't hidden
 	// prefix is injected into the post models
 	GetThreadReplyPostModels(prefix, rootMessageID string, start, limit int) []PostModel
 
@@ -209,20 +304,97 @@ type Database interface {
 	// return emtpy string if we don't have it
 	GetIPAddress(encAddr string) (string, error)
 
-	// check if an ip is banned from our local
-	CheckIPBanned(addr string) (bool, error)
+	// check if an ip is banned from posting in a newsgroup on our local
+	// if newsgroup is empty check if it's banned anywhere
+	// expired bans don't count
+	CheckIPBanned(addr, newsgroup string) (bool, error)
 
-	// check if an encrypted ip is banned from our local
-	CheckEncIPBanned(encAddr string) (bool, error)
+	// check if an encrypted ip is banned from posting in a newsgroup on our local
+	// if newsgroup is empty check if it's banned anywhere
+	// expired bans don't count
+	CheckEncIPBanned(encAddr, newsgroup string) (bool, error)
 
-	// ban an ip address from the local
-	BanAddr(addr string) error
+	// ban an ip address from the local for newsgroups matching scope
+	// issuer is the public key of the mod who banned it or empty
+	// expires is unix time or -1 for never
+	BanAddr(addr, scope, reason, issuer string, expires int64) error
 
-	// unban an ip address from the local
+	// unban an ip address from the local in every scope
 	UnbanAddr(addr string) error
 
-	// ban an encrypted ip address from the remote
-	BanEncAddr(encAddr string) error
+	// ban an encrypted ip address from the remote for newsgroups matching scope
+	// issuer is the public key of the mod who banned it or empty
+	// expires is unix time or -1 for never
+	BanEncAddr(encAddr, scope, reason, issuer string, expires int64) error
+
+	// unban an encrypted ip address in every scope
+	UnbanEncAddr(encAddr string) error
+
+	// get the ip and encrypted ip bans that are in effect
+	GetIPBans() ([]IPBan, error)
+
+	// delete ip and encrypted ip bans that expired before now
+	// returns the bans it deleted
+	PurgeExpiredBans(now int64) ([]IPBan, error)
+
+	// record a mod event we got and if we did it
+	RecordModLog(entry ModLogEntry) error
+
+	// get recorded mod events, newest first
+	GetModLogs(q ModLogQuery) ([]ModLogEntry, error)
+
+	// record a report of a post, reporting a post again does nothing
+	ReportArticle(msgid, reason, reporter string) error
+
+	// count the reports reporter made at or after a unix time
+	CountReportsBy(reporter string, since int64) (int64, error)
+
+	// get the open reports of posts we have, most reported first
+	GetArticleReports() ([]ArticleReports, error)
+
+	// close every report of a post
+	DismissReports(msgid string) error
+
+	// store moderation rights given to a key
+	// replaces rights the same granter gave the key for the same scope
+	AddModDelegation(d ModDelegation) error
+
+	// take away the rights granter gave a key for a scope
+	DelModDelegation(pubkey, granter, scope string) error
+
+	// get the moderation rights given to a key, or to every key if pubkey is empty
+	GetModDelegations(pubkey string) ([]ModDelegation, error)
+
+	// remember the perceptual hash of an image attachment
+	RegisterImageHash(fname string, hash ImageHash) error
+
+	// get the perceptual hash of an image attachment we remembered
+	GetImageHash(fname string) (ImageHash, error)
+
+	// ban images close to a perceptual hash
+	BanImageHash(hash ImageHash, reason string) error
+
+	// unban a perceptual hash
+	UnbanImageHash(hash ImageHash) error
+
+	// get every banned perceptual hash
+	GetBannedImageHashes() ([]BannedImage, error)
+
+	// add a filter rule, returns its id
+	AddFilterRule(rule FilterRule) (int64, error)
+
+	// remove a filter rule by id
+	DelFilterRule(id int64) error
+
+	// get every filter rule in the order they were added
+	GetFilterRules() ([]FilterRule, error)
+
+	// learn that the article msgid with tokens is spam or ham
+	// each article counts once, learning it the other way moves its counts over
+	TrainSpam(msgid string, tokens []string, spam bool) error
+
+	// get how many times tokens were in spam and ham and how many articles we learned from
+	GetSpamCounts(tokens []string) (map[string]SpamCounts, SpamCounts, error)
 
 	// return the encrypted version of an IPAddress
 	// if it's not already there insert it into the database
@@ -269,7 +441,7 @@ type Database interface {
 	// get post history per month since beginning of time
 	GetMonthlyPostHistory() []PostEntry
 
-	// get the last N posts that were made globally
+	// get the last N posts that were made globally and aren't hidden
 	GetLastPostedPostModels(prefix string, n int64) []PostModel
 
 	// check if an nntp login cred is correct
@@ -308,13 +480,14 @@ type Database interface {
 	// get statistics about posting in a time slice
 	GetPostingStats(granularity, begin, end int64) (PostingStats, error)
 
-	// peform search query
-	SearchQuery(prefix, group, text string, chnl chan PostModel) error
+	// full text search of posts, more is true if there is another page of results
+	SearchPosts(prefix string, req SearchRequest) (posts []PostModel, more bool, err error)
 
 	// find posts with similar hash
 	SearchByHash(prefix, group, posthash string, chnl chan PostModel) error
 
-	// get full thread model
+	// get full thread model without hidden posts
+	// errors if the root post is hidden
 	GetThreadModel(prefix, root_msgid string) (ThreadModel, error)
 
 	// get post models with nntp id in a newsgroup
@@ -322,6 +495,77 @@ type Database interface {
 
 	// get post message-id where hash is similar to string
 	GetCitesByPostHashLike(like string) ([]MessageIDTuple, error)
+
+	// get the given headers for every article numbered lo through hi in a newsgroup
+	// if hi < 0 then there is no upper bound
+	// ordered by article number
+	GetNNTPHeadersInRange(newsgroup string, lo, hi int64, names []string) ([]NNTPOverview, error)
+
+	// get every article we obtained at or after unix time t
+	// ordered by time obtained
+	GetArticlesObtainedSince(t int64) ([]ArticleEntry, error)
+
+	// get every newsgroup we first saw at or after unix time t
+	GetNewsgroupsCreatedSince(t int64) ([]string, error)
+
+	// get the unix time of the last successful pull sync with a feed
+	// returns 0 if we never synced with it
+	GetFeedSyncTime(feed string) (int64, error)
+
+	// set the unix time of the last successful pull sync with a feed
+	SetFeedSyncTime(feed string, t int64) error
+
+	// get the article number and message-id of the first article after article number n in a newsgroup
+	// returns empty message-id if there is none
+	GetNextNNTPID(group string, n int64) (int64, string, error)
+
+	// get the article number and message-id of the last article before article number n in a newsgroup
+	// returns empty message-id if there is none
+	GetPrevNNTPID(group string, n int64) (int64, string, error)
+
+	// remember that a feed refused an article
+	RecordFeedRejection(feed, msgid, reason string) error
+
+	// check if a feed refused an article
+	FeedRejectedArticle(feed, msgid string) (bool, error)
+
+	// get every feed that refused an article
+	GetArticleRejections(msgid string) ([]string, error)
+
+	// get every message-id a feed refused
+	GetFeedRejections(feed string) ([]string, error)
+
+	// forget every article a feed refused
+	ClearFeedRejections(feed string) error
+
+	// forget every refused article that was refused before unix time t
+	ExpireFeedRejections(t int64) error
+
+	// remember what we did with a message-id
+	RecordMessageHistory(msgid, disposition string) error
+
+	// get what we did with a message-id
+	// returns empty string if it's not in the history
+	GetMessageHistory(msgid string) (string, error)
+
+	// forget every message-id in the history we saw before unix time t
+	ExpireMessageHistory(t int64) error
+
+	// get every message-id we have, banned or have in the history
+	// send each down a channel
+	GetAllKnownMessageIDs(send chan string) error
+
+	// give an article a moderation flag
+	MarkArticleFlag(msgid, flag string) error
+
+	// take a moderation flag away from an article
+	UnmarkArticleFlag(msgid, flag string) error
+
+	// check if an article has a moderation flag
+	ArticleHasFlag(msgid, flag string) bool
+
+	// get the articles waiting in the quarantine queue, oldest first
+	GetQuarantinedArticles() ([]QuarantinedArticle, error)
 }
 
 func NewDatabase(db_type, schema, host, port, user, password string) Database {
@@ -329,6 +573,15 @@ func NewDatabase(db_type, schema, host, port, user, password string) Database {
 		if schema == "srnd" {
 			return NewPostgresDatabase(host, port, user, password)
 		}
+	} else if db_type == "sqlite" {
+		if schema == "srnd" {
+			// host is the path to the database file
+			return NewSQLiteDatabase(host)
+		}
+	} else if db_type == "memory" {
+		if schema == "srnd" {
+			return NewMemoryDatabase()
+		}
 	}
 	log.Fatalf("invalid database type: %s/%s", db_type, schema)
 	return nil
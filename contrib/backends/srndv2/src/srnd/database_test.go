@@ -0,0 +1,536 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// make a post for the database tests, a reply if ref is not empty
+func testArticle(msgid, ref, group, message string) NNTPMessage {
+	nntp := newPlaintextArticle(message, "poster@test.tld", "test", "poster", "test.tld", msgid, group)
+	if ref != "" {
+		nntp.Headers().Set("References", ref)
+	}
+	nntp.Headers().Set("X-Encrypted-Ip", "encaddr")
+	return nntp
+}
+
+// things every database backend must do the same
+func testDatabase(t *testing.T, db Database) {
+	db.CreateTables()
+
+	migrations, err := db.GetSchemaMigrations()
+	if err != nil || len(migrations) == 0 {
+		t.Fatal("no schema migrations", err)
+	}
+	for _, m := range migrations {
+		if m.Applied == 0 {
+			t.Error("migration not applied", m.Version, m.Name)
+		}
+	}
+	// nothing left to do
+	err = db.MigrateSchema()
+	if err != nil {
+		t.Error("failed to migrate migrated database", err)
+	}
+
+	group := "overchan.test"
+	root := "<root@test.tld>"
+	reply := "<reply@test.tld>"
+	for _, nntp := range []NNTPMessage{
+		testArticle(root, "", group, "hello world"),
+		testArticle(reply, root, group, "a reply to you"),
+	} {
+		err := db.RegisterArticle(nntp)
+		if err != nil {
+			t.Fatal("failed to register article", err)
+		}
+	}
+
+	if !db.HasNewsgroup(group) {
+		t.Error("newsgroup not registered")
+	}
+	if !db.HasArticle(root) || !db.HasArticleLocal(reply) {
+		t.Error("article not registered")
+	}
+	if db.HasArticle("<nope@test.tld>") {
+		t.Error("has article we never registered")
+	}
+	if db.CountThreadReplies(root) != 1 {
+		t.Error("wrong reply count", db.CountThreadReplies(root))
+	}
+	repls := db.GetThreadReplies(root, 0, 0)
+	if len(repls) != 1 || repls[0] != reply {
+		t.Error("wrong thread replies", repls)
+	}
+	threads := db.GetLastBumpedThreads(group, 10)
+	if len(threads) != 1 || threads[0].MessageID() != root {
+		t.Error("wrong last bumped threads", threads)
+	}
+	th, err := db.GetThreadModel("/", root)
+	if err != nil || len(th.Replies()) != 1 {
+		t.Error("bad thread model", err)
+	}
+
+	// nntp article numbers
+	last, first, err := db.GetLastAndFirstForGroup(group)
+	if err != nil || first != 1 || last != 2 {
+		t.Error("bad article numbers", first, last, err)
+	}
+	msgid, err := db.GetMessageIDForNNTPID(group, 2)
+	if err != nil || msgid != reply {
+		t.Error("bad message-id for article number", msgid, err)
+	}
+	ovs, err := db.GetNNTPHeadersInRange(group, 1, -1, []string{"Subject", "From"})
+	if err != nil || len(ovs) != 2 || ovs[0].Headers.Get("subject", "") != "test" {
+		t.Error("bad overview", ovs, err)
+	}
+
+	// search
+	for text, expect := range map[string]string{
+		"reply":              reply,
+		"\"a reply\"":        reply,
+		"\"reply a\"":        "",
+		"hello -reply":       root,
+		"reply -hello":       reply,
+		"world -\"a reply\"": root,
+	} {
+		posts, more, err := db.SearchPosts("/", SearchRequest{Text: text})
+		if err != nil || more {
+			t.Error("search failed", text, err)
+		} else if expect == "" && len(posts) != 0 {
+			t.Error("search for", text, "found", len(posts), "posts")
+		} else if expect != "" && (len(posts) != 1 || posts[0].MessageID() != expect) {
+			t.Error("bad search result for", text, posts)
+		}
+	}
+	posts, _, _ := db.SearchPosts("/", SearchRequest{Text: "reply", Newsgroup: "overchan.other"})
+	if len(posts) != 0 {
+		t.Error("search not limited to newsgroup")
+	}
+	posts, _, _ = db.SearchPosts("/", SearchRequest{Text: "reply", HasAttachment: true})
+	if len(posts) != 0 {
+		t.Error("search not limited to posts with attachments")
+	}
+	posts, more, _ := db.SearchPosts("/", SearchRequest{Text: "-nothing", PerPage: 1})
+	if len(posts) != 1 || !more {
+		t.Error("bad search pagination", len(posts), more)
+	}
+	// pages past the end are clamped before they overflow the offset
+	req := searchRequestFromQuery(url.Values{"text": {"reply"}, "page": {"9223372036854775807"}})
+	posts, more, err = db.SearchPosts("/", req)
+	if req.Page != MaxSearchPage || err != nil || len(posts) != 0 || more {
+		t.Error("bad search for huge page", req, err)
+	}
+
+	// posting stats
+	now := timeNow()
+	stats, err := db.GetPostingStats(3600, now-3600, now+60)
+	if err != nil || len(stats.History) != 2 {
+		t.Fatal("bad posting stats", stats, err)
+	}
+	var posted int64
+	for _, entry := range stats.History {
+		for _, g := range entry.Groups {
+			if g.Name == group {
+				posted += g.Posted[0].Count()
+			}
+		}
+	}
+	if posted != 2 {
+		t.Error("wrong number of posts in stats", posted)
+	}
+	_, err = db.GetPostingStats(0, now, now)
+	if err == nil {
+		t.Error("made posting stats with no granularity")
+	}
+
+	// bans
+	db.BanArticle("<bad@test.tld>", "spam")
+	if !db.ArticleBanned("<bad@test.tld>") || db.ArticleBanned(root) {
+		t.Error("article ban not applied")
+	}
+	db.BanAddr("10.0.0.0/8", ModScopeGlobal, "", "", -1)
+	banned, err := db.CheckIPBanned("10.1.2.3", group)
+	if err != nil || !banned {
+		t.Error("address in banned range not banned", err)
+	}
+	banned, _ = db.CheckIPBanned("192.168.1.1", group)
+	if banned {
+		t.Error("address outside banned range is banned")
+	}
+	db.UnbanAddr("10.1.2.3")
+	banned, _ = db.CheckIPBanned("10.1.2.3", "")
+	if banned {
+		t.Error("address still banned after unban")
+	}
+	db.BanAddr("10.0.0.1", "overchan.test|overchan.other", "spam", "", -1)
+	db.BanAddr("10.0.0.2", ModScopeGlobal, "", "", now-60)
+	db.BanAddr("10.0.0.3", ModScopeGlobal, "", "key1", now+3600)
+	for addr, expect := range map[string][]bool{
+		// banned in group, overchan.random, anywhere
+		"10.0.0.1": {true, false, true},
+		"10.0.0.2": {false, false, false},
+		"10.0.0.3": {true, true, true},
+	} {
+		for idx, newsgroup := range []string{group, "overchan.random", ""} {
+			banned, err = db.CheckIPBanned(addr, newsgroup)
+			if err != nil || banned != expect[idx] {
+				t.Error("wrong ban for", addr, "in", newsgroup, banned, err)
+			}
+		}
+	}
+	db.BanEncAddr("badposter", "overchan.other", "", "", -1)
+	db.BanEncAddr("expired", ModScopeGlobal, "", "", now-60)
+	banned, _ = db.CheckEncIPBanned("badposter", "overchan.other")
+	if !banned {
+		t.Error("encrypted address not banned in scope")
+	}
+	banned, _ = db.CheckEncIPBanned("badposter", group)
+	if banned {
+		t.Error("encrypted address banned out of scope")
+	}
+	banned, _ = db.CheckEncIPBanned("expired", "")
+	if banned {
+		t.Error("expired encrypted address ban still in effect")
+	}
+	bans, err := db.GetIPBans()
+	if err != nil || len(bans) != 3 {
+		t.Fatal("failed to get bans in effect", bans, err)
+	}
+	for _, ban := range bans {
+		if ban.Addr == "10.0.0.2" || ban.Addr == "expired" {
+			t.Error("expired ban listed", ban)
+		}
+		if ban.Addr == "10.0.0.3" && (ban.Issuer != "key1" || ban.Expires != now+3600 || ban.Encrypted) {
+			t.Error("wrong ban", ban)
+		}
+		if ban.Addr == "badposter" && (!ban.Encrypted || ban.Scope != "overchan.other" || ban.Remaining() != "forever") {
+			t.Error("wrong encrypted ban", ban)
+		}
+	}
+	bans, err = db.PurgeExpiredBans(now + 7200)
+	if err != nil {
+		t.Error("failed to purge expired bans", err)
+	}
+	if len(bans) != 3 {
+		t.Error("wrong purged bans", bans)
+	}
+	for _, ban := range bans {
+		if ban.Addr == "10.0.0.1" || ban.Addr == "badposter" {
+			t.Error("ban that never expires was purged", ban)
+		}
+	}
+	banned, _ = db.CheckIPBanned("10.0.0.1", group)
+	if !banned {
+		t.Error("ban that never expires was purged")
+	}
+	db.UnbanAddr("10.0.0.1")
+	db.UnbanEncAddr("badposter")
+	banned, _ = db.CheckEncIPBanned("badposter", "overchan.other")
+	if banned {
+		t.Error("encrypted address still banned after unban")
+	}
+	encaddr, err := db.GetEncAddress("172.16.0.1")
+	if err != nil || encaddr == "" {
+		t.Fatal("failed to make encrypted address", err)
+	}
+	again, _ := db.GetEncAddress("172.16.0.1")
+	if again != encaddr {
+		t.Error("encrypted address changed")
+	}
+	_, cidr, _ := net.ParseCIDR("172.16.0.0/16")
+	_, err = db.GetMessageIDByCIDR(cidr)
+	if err != nil {
+		t.Error("failed to look up by cidr", err)
+	}
+
+	// mod keys
+	pubkey := "0000000000000000000000000000000000000000000000000000000000000000"
+	db.MarkModPubkeyGlobal(pubkey)
+	if !db.CheckModPubkeyGlobal(pubkey) || !db.CheckModPubkey(pubkey) {
+		t.Error("mod pubkey not marked global")
+	}
+	db.UnMarkModPubkeyGlobal(pubkey)
+	if db.CheckModPubkeyGlobal(pubkey) {
+		t.Error("mod pubkey still global")
+	}
+
+	// mod log
+	for idx, entry := range []ModLogEntry{
+		{Pubkey: pubkey, Action: "delete", Target: reply, Scope: ModScopeGlobal, MessageID: "<ctl1@test.tld>", Executed: true},
+		{Pubkey: "badkey", Action: "delete", Target: root, Scope: group, Reason: "i don't like it", MessageID: "<ctl2@test.tld>"},
+		{Pubkey: pubkey, Action: "overchan-sage", Target: root, Scope: ModScopeGlobal, MessageID: "<ctl3@test.tld>", Executed: true},
+	} {
+		entry.Time = now - 100 + int64(idx)
+		err = db.RecordModLog(entry)
+		if err != nil {
+			t.Fatal("failed to record mod log", err)
+		}
+	}
+	logs, err := db.GetModLogs(ModLogQuery{})
+	if err != nil || len(logs) != 3 || logs[0].Action != "overchan-sage" {
+		t.Error("bad mod log", logs, err)
+	}
+	logs, _ = db.GetModLogs(ModLogQuery{Target: root, Action: "delete"})
+	if len(logs) != 1 || logs[0].Executed || logs[0].Pubkey != "badkey" || logs[0].Reason != "i don't like it" || logs[0].Scope != group {
+		t.Error("bad mod log for target", logs)
+	}
+	logs, _ = db.GetModLogs(ModLogQuery{Pubkey: pubkey, Limit: 1, Offset: 1})
+	if len(logs) != 1 || logs[0].MessageID != "<ctl1@test.tld>" || !logs[0].Executed {
+		t.Error("bad mod log page", logs)
+	}
+	logs, _ = db.GetModLogs(ModLogQuery{Since: now - 99, Before: now - 98})
+	if len(logs) != 1 || logs[0].Pubkey != "badkey" {
+		t.Error("bad mod log time range", logs)
+	}
+
+	// nntp logins
+	db.AddNNTPLogin("user", "secret")
+	valid, err := db.CheckNNTPLogin("user", "secret")
+	if err != nil || !valid {
+		t.Error("valid login rejected", err)
+	}
+	valid, _ = db.CheckNNTPLogin("user", "wrong")
+	if valid {
+		t.Error("invalid login accepted")
+	}
+
+	// feeds and history
+	db.RecordFeedRejection("feed", root, "no")
+	rejected, _ := db.FeedRejectedArticle("feed", root)
+	if !rejected {
+		t.Error("feed rejection not recorded")
+	}
+	db.RecordFeedRejection("other", root, "no")
+	db.RecordFeedRejection("other", reply, "no")
+	feeds, _ := db.GetArticleRejections(root)
+	sort.Strings(feeds)
+	if len(feeds) != 2 || feeds[0] != "feed" || feeds[1] != "other" {
+		t.Error("wrong feeds rejected article", feeds)
+	}
+	db.SetFeedSyncTime("feed", 1234)
+	synced, _ := db.GetFeedSyncTime("feed")
+	if synced != 1234 {
+		t.Error("bad feed sync time", synced)
+	}
+	db.RecordMessageHistory(root, HistoryAccepted)
+	disposition, _ := db.GetMessageHistory(root)
+	if disposition != HistoryAccepted {
+		t.Error("bad history disposition", disposition)
+	}
+	err = db.RecordMessageHistory(root, HistoryRejected)
+	disposition, _ = db.GetMessageHistory(root)
+	if err != nil || disposition != HistoryRejected {
+		t.Error("history disposition not updated", disposition, err)
+	}
+
+	// deleting
+	err = db.DeleteArticle(root)
+	if err != nil || db.HasArticleLocal(root) {
+		t.Error("failed to delete article", err)
+	}
+	if len(db.GetLastBumpedThreads(group, 10)) != 0 {
+		t.Error("thread still present after deleting root post")
+	}
+
+	// moderation flags
+	now = timeNow()
+	older := "<older@test.tld>"
+	newer := "<newer@test.tld>"
+	hidden := "<hidden@test.tld>"
+	register := func(msgid, ref, message string, posted int64) {
+		nntp := testArticle(msgid, ref, group, message)
+		nntp.Headers().Set("Date", time.Unix(posted, 0).UTC().Format(time.RFC1123Z))
+		err := db.RegisterArticle(nntp)
+		if err != nil {
+			t.Fatal("failed to register article", err)
+		}
+	}
+	register(older, "", "older thread", now-300)
+	register(newer, "", "newer thread", now-200)
+	register(hidden, older, "hidden reply", now-250)
+	db.MarkArticleFlag(older, ArticleFlagSage)
+	register("<saged@test.tld>", older, "reply in saged thread", now)
+	threads = db.GetLastBumpedThreads(group, 10)
+	if len(threads) != 2 || threads[0].MessageID() != newer {
+		t.Error("saged thread was bumped", threads)
+	}
+	db.MarkArticleFlag(older, ArticleFlagSticky)
+	threads = db.GetLastBumpedThreads(group, 10)
+	if len(threads) != 2 || threads[0].MessageID() != older {
+		t.Error("sticky thread not first", threads)
+	}
+	board := db.GetGroupForPage("/", "test", group, 0, 10).Threads()
+	if len(board) != 2 || board[0].OP().MessageID() != older {
+		t.Error("sticky thread not first on board page", board)
+	}
+	if expire := db.GetRootPostsForExpiration(group, 1); len(expire) != 1 || expire[0] != newer {
+		t.Error("sticky thread expired", expire)
+	}
+	db.UnmarkArticleFlag(older, ArticleFlagSticky)
+	if db.ArticleHasFlag(older, ArticleFlagSticky) || !db.ArticleHasFlag(older, ArticleFlagSage) {
+		t.Error("wrong flags after unsticking")
+	}
+	db.MarkArticleFlag(hidden, ArticleFlagHidden)
+	th, err = db.GetThreadModel("/", older)
+	if err != nil || len(th.Replies()) != 1 || len(db.GetThreadReplyPostModels("/", older, 0, 0)) != 1 {
+		t.Error("hidden reply in thread", err)
+	}
+	if len(db.GetThreadReplies(older, 0, 0)) != 2 {
+		t.Error("hidden reply not in thread replies")
+	}
+	db.MarkArticleFlag(newer, ArticleFlagHidden)
+	_, err = db.GetThreadModel("/", newer)
+	if err == nil || len(db.GetLastBumpedThreads(group, 10)) != 1 {
+		t.Error("hidden thread still shown")
+	}
+	db.UnmarkArticleFlag(newer, ArticleFlagHidden)
+	db.UnmarkArticleFlag(hidden, ArticleFlagHidden)
+	th, err = db.GetThreadModel("/", older)
+	if err != nil || len(th.Replies()) != 2 || len(db.GetLastBumpedThreads(group, 10)) != 2 {
+		t.Error("unhidden posts not shown", err)
+	}
+	db.MarkArticleFlag(hidden, ArticleFlagQuarantined)
+	quarantined, err := db.GetQuarantinedArticles()
+	if err != nil || len(quarantined) != 1 || quarantined[0].MessageID != hidden || quarantined[0].Newsgroup != group || quarantined[0].Message != "hidden reply" || quarantined[0].Time == 0 {
+		t.Error("bad quarantined articles", quarantined, err)
+	}
+	overviews, _ := db.GetNNTPHeadersInRange(group, 0, -1, nil)
+	entries, _ := db.GetArticlesObtainedSince(0)
+	if len(overviews) == 0 || len(entries) == 0 {
+		t.Error("no overview or new articles", overviews, entries)
+	}
+	for _, ov := range overviews {
+		if ov.MessageID == hidden {
+			t.Error("quarantined article in overview")
+		}
+	}
+	for _, e := range entries {
+		if e.MessageID() == hidden {
+			t.Error("quarantined article in new articles")
+		}
+	}
+	db.UnmarkArticleFlag(hidden, ArticleFlagQuarantined)
+	if quarantined, _ = db.GetQuarantinedArticles(); len(quarantined) != 0 {
+		t.Error("article still quarantined", quarantined)
+	}
+
+	// reports
+	db.ReportArticle(older, "spam", "reporter1")
+	db.ReportArticle(older, "spam", "reporter2")
+	db.ReportArticle(older, "offtopic", "reporter3")
+	db.ReportArticle(newer, "illegal", "reporter1")
+	// again does nothing
+	db.ReportArticle(newer, "spam", "reporter1")
+	// we don't have it
+	db.ReportArticle("<gone@test.tld>", "spam", "reporter1")
+	count, err := db.CountReportsBy("reporter1", now-60)
+	if err != nil || count != 3 {
+		t.Error("wrong report count", count, err)
+	}
+	count, _ = db.CountReportsBy("reporter1", now+60)
+	if count != 0 {
+		t.Error("old reports counted", count)
+	}
+	reports, err := db.GetArticleReports()
+	if err != nil || len(reports) != 2 {
+		t.Fatal("bad reports", reports, err)
+	}
+	if reports[0].MessageID != older || reports[0].Count != 3 || reports[0].Newsgroup != group || reports[0].Reasons[0] != (ReportCount{"spam", 2}) {
+		t.Error("bad most reported post", reports[0])
+	}
+	if reports[1].MessageID != newer || reports[1].Count != 1 || reports[1].Reasons[0].Reason != "illegal" {
+		t.Error("bad reported post", reports[1])
+	}
+	db.DismissReports(older)
+	reports, _ = db.GetArticleReports()
+	if len(reports) != 1 || reports[0].MessageID != newer {
+		t.Error("reports not dismissed", reports)
+	}
+
+	// mod delegations
+	db.AddModDelegation(ModDelegation{"key1", "admin", "overchan.test", []ModAction{ModDelete, ModSage}, -1, now})
+	db.AddModDelegation(ModDelegation{"key1", "admin", ModScopeGlobal, nil, now + 60, now})
+	db.AddModDelegation(ModDelegation{"key2", "key1", ModScopeGlobal, nil, -1, now})
+	// replaces the first one
+	db.AddModDelegation(ModDelegation{"key1", "admin", "overchan.test", []ModAction{ModInetBan}, -1, now + 1})
+	delegations, err := db.GetModDelegations("key1")
+	if err != nil || len(delegations) != 2 {
+		t.Fatal("bad delegations", delegations, err)
+	}
+	if delegations[1].Scope != "overchan.test" || len(delegations[1].Actions) != 1 || delegations[1].Actions[0] != ModInetBan || delegations[1].Expires != -1 {
+		t.Error("delegation not replaced", delegations[1])
+	}
+	if delegations[0].Scope != ModScopeGlobal || len(delegations[0].Actions) != 0 || delegations[0].Expires != now+60 {
+		t.Error("bad delegation", delegations[0])
+	}
+	db.DelModDelegation("key1", "admin", ModScopeGlobal)
+	delegations, _ = db.GetModDelegations("")
+	if len(delegations) != 2 {
+		t.Error("delegation not removed", delegations)
+	}
+
+	// filter rules
+	id1, err := db.AddFilterRule(FilterRule{Field: "message", Pattern: "spam", Action: FilterReject})
+	if err != nil {
+		t.Fatal("failed to add filter rule", err)
+	}
+	id2, _ := db.AddFilterRule(FilterRule{Newsgroup: group, Field: "X-Foo", Pattern: "^b.r$", Regex: true, Action: FilterReplace, Replacement: "baz"})
+	rules, err := db.GetFilterRules()
+	if err != nil || len(rules) != 2 || rules[0].ID != id1 || rules[1].ID != id2 || id1 == id2 {
+		t.Fatal("bad filter rules", rules, err)
+	}
+	if rules[1].Newsgroup != group || !rules[1].Regex || rules[1].Action != FilterReplace || rules[1].Replacement != "baz" || rules[1].Time == 0 {
+		t.Error("bad filter rule", rules[1])
+	}
+	db.DelFilterRule(id1)
+	rules, _ = db.GetFilterRules()
+	if len(rules) != 1 || rules[0].ID != id2 {
+		t.Error("filter rule not removed", rules)
+	}
+
+	// spam classifier
+	db.TrainSpam("<spam1@test.tld>", []string{"cheap", "pills"}, true)
+	db.TrainSpam("<ham@test.tld>", []string{"cheap", "thread"}, false)
+	db.TrainSpam("<spam2@test.tld>", []string{"cheap"}, true)
+	// the same article again counts once and a change of mind moves it
+	db.TrainSpam("<spam1@test.tld>", []string{"cheap", "pills"}, true)
+	db.TrainSpam("<spam2@test.tld>", []string{"cheap"}, false)
+	db.TrainSpam("<spam2@test.tld>", []string{"cheap"}, true)
+	counts, totals, err := db.GetSpamCounts([]string{"cheap", "pills", "unseen"})
+	if err != nil || totals != (SpamCounts{Spam: 2, Ham: 1}) {
+		t.Fatal("bad spam totals", totals, err)
+	}
+	if len(counts) != 2 || counts["cheap"] != (SpamCounts{Spam: 2, Ham: 1}) || counts["pills"] != (SpamCounts{Spam: 1}) {
+		t.Error("bad spam counts", counts)
+	}
+}
+
+func TestSQLiteDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := NewSQLiteDatabase(filepath.Join(dir, "srnd.db"))
+	defer db.Close()
+	testDatabase(t, db)
+}
+
+// set SRND_TEST_POSTGRES to the host of an empty database to run this
+func TestPostgresDatabase(t *testing.T) {
+	host := os.Getenv("SRND_TEST_POSTGRES")
+	if host == "" {
+		t.Skip("SRND_TEST_POSTGRES not set")
+	}
+	db := NewPostgresDatabase(host, "5432", os.Getenv("SRND_TEST_POSTGRES_USER"), os.Getenv("SRND_TEST_POSTGRES_PASSWORD"))
+	defer db.Close()
+	testDatabase(t, db)
+}
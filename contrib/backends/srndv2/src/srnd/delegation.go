@@ -0,0 +1,110 @@
+//
+// delegation.go -- moderation rights given to remote keys in ctl messages
+//
+package srnd
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// give a key moderation rights
+// overchan-delegate <pubkey> scope=<regex> actions=<action,...> expires=<unixtime>
+const ModDelegate = ModAction("overchan-delegate")
+
+// take away rights given with overchan-delegate
+// overchan-revoke <pubkey> scope=<regex>
+const ModRevoke = ModAction("overchan-revoke")
+
+// how many keys can be between an admin key and a delegated key
+const MaxModDelegationDepth = 4
+
+// moderation rights a key got from another key
+type ModDelegation struct {
+	// who got the rights
+	Pubkey string
+	// who gave them, an admin or a key with overchan-delegate rights
+	Granter string
+	// newsgroup regex the rights are for
+	Scope string
+	// mod actions allowed, every action but delegating if empty
+	Actions []ModAction
+	// unix time the rights end or -1 for never
+	Expires int64
+	// unix time they were given
+	Time int64
+}
+
+// check if the rights are still in effect at time now
+func (self ModDelegation) active(now int64) bool {
+	return self.Expires < 0 || self.Expires > now
+}
+
+// check if the rights include a mod action
+func (self ModDelegation) allows(action ModAction) bool {
+	if len(self.Actions) == 0 {
+		return action != ModDelegate && action != ModRevoke
+	}
+	for _, a := range self.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// the actions as we store them
+func (self ModDelegation) actionsString() string {
+	var actions []string
+	for _, a := range self.Actions {
+		actions = append(actions, string(a))
+	}
+	return strings.Join(actions, ",")
+}
+
+// parse actions as we store them
+func parseModActions(str string) (actions []ModAction) {
+	for _, a := range strings.Split(str, ",") {
+		if a != "" {
+			actions = append(actions, ModAction(a))
+		}
+	}
+	return
+}
+
+// check if every newsgroup in scope inner is also in scope outer
+// we can't compare regexes so inner has to be the same scope or a single newsgroup
+func scopeCovers(outer, inner string) bool {
+	if outer == ModScopeGlobal || outer == inner {
+		return true
+	}
+	return newsgroupValidFormat(inner) && modScopeMatches(outer, inner)
+}
+
+// make the delegation an overchan-delegate mod event gives
+func modEventDelegation(ev ModEvent, granter string) ModDelegation {
+	return ModDelegation{
+		Pubkey:  ev.Target(),
+		Granter: granter,
+		Scope:   ev.Scope(),
+		Actions: parseModActions(simpleModEvent(ev.String()).options()["actions"]),
+		Expires: ev.Expires(),
+		Time:    timeNow(),
+	}
+}
+
+// load delegations from rows of pubkey, granter, scope, actions, expires and time_granted
+func scanModDelegations(rows *sql.Rows) (delegations []ModDelegation, err error) {
+	for rows.Next() {
+		var d ModDelegation
+		var actions string
+		err = rows.Scan(&d.Pubkey, &d.Granter, &d.Scope, &actions, &d.Expires, &d.Time)
+		if err != nil {
+			break
+		}
+		d.Actions = parseModActions(actions)
+		delegations = append(delegations, d)
+	}
+	rows.Close()
+	return
+}
@@ -25,8 +25,8 @@ type ExpirationCore interface {
 
 type ExpireCacheFunc func(string, string, string)
 
-func createExpirationCore(database Database, store ArticleStore, ex ExpireCacheFunc) ExpirationCore {
-	return expire{database, store, ex}
+func createExpirationCore(database Database, store ArticleStore, history MessageHistory, ex ExpireCacheFunc) ExpirationCore {
+	return expire{database, store, history, ex}
 }
 
 type deleteEvent string
@@ -42,6 +42,7 @@ func (self deleteEvent) MessageID() string {
 type expire struct {
 	database    Database
 	store       ArticleStore
+	history     MessageHistory
 	expireCache ExpireCacheFunc
 }
 
@@ -136,10 +137,13 @@ func (self expire) handleEvent(ev deleteEvent) {
 			os.Remove(thm)
 		}
 	}
+	// so we don't take it again, the ban outlives history retention
+	// and it's how we know to refuse replies to expired threads
 	err := self.database.BanArticle(ev.MessageID(), "expired")
 	if err != nil {
 		log.Println("failed to ban for expiration", err)
 	}
+	self.history.Record(ev.MessageID(), HistoryExpired)
 	err = self.database.DeleteArticle(ev.MessageID())
 	if err != nil {
 		log.Println("failed to delete article", err)
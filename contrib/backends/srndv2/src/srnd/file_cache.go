@@ -171,6 +171,20 @@ func (self *FileCache) pollRegen() {
 	}
 }
 
+// get how many threads, board pages and catalogs are waiting to be regenerated
+func (self *FileCache) RegenQueueSizes() (threads, boards, catalogs int) {
+	self.regenThreadLock.RLock()
+	threads = len(self.regenThreadMap)
+	self.regenThreadLock.RUnlock()
+	self.regenBoardLock.RLock()
+	boards = len(self.regenBoardMap)
+	self.regenBoardLock.RUnlock()
+	self.regenCatalogLock.RLock()
+	catalogs = len(self.regenCatalogMap)
+	self.regenCatalogLock.RUnlock()
+	return
+}
+
 // regen every page of the board
 func (self *FileCache) RegenerateBoard(group string) {
 	pages, _ := self.database.GetPagesPerBoard(group)
@@ -180,10 +194,17 @@ func (self *FileCache) RegenerateBoard(group string) {
 	}
 }
 
+// return true if we have the root post of a thread and mods didn't hide it
+func (self *FileCache) threadVisible(root string) bool {
+	return self.database.HasArticleLocal(root) && !self.database.ArticleHasFlag(root, ArticleFlagHidden)
+}
+
 // regenerate just a thread page
 func (self *FileCache) regenerateThread(root ArticleEntry, json bool) {
 	msgid := root.MessageID()
-	if self.store.HasArticle(msgid) {
+	if !self.threadVisible(msgid) {
+		log.Println("thread", msgid, "is hidden or gone, not regenerating")
+	} else if self.store.HasArticle(msgid) {
 		fname := self.getFilenameForThread(msgid, json)
 		wr, err := os.Create(fname)
 		defer wr.Close()
@@ -292,7 +313,7 @@ func (self *FileCache) regenUkko() {
 
 // regenerate pages after a mod event
 func (self *FileCache) RegenOnModEvent(newsgroup, msgid, root string, page int) {
-	if root == msgid {
+	if root == msgid && !self.threadVisible(root) {
 		fname := self.getFilenameForThread(root, false)
 		os.Remove(fname)
 		fname = self.getFilenameForThread(root, true)
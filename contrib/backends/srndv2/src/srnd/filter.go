@@ -0,0 +1,198 @@
+//
+// filter.go -- admin word and regex filters on posts
+//
+package srnd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+var ErrFilteredArticle = errors.New("rejected by filter")
+
+// what a filter rule does to posts it matches
+type FilterAction string
+
+// reject the post
+const FilterReject = FilterAction("reject")
+
+// replace what matched with the rule's replacement
+const FilterReplace = FilterAction("replace")
+
+// don't let the post bump its thread
+const FilterSage = FilterAction("sage")
+
+// hide the post here until a mod dismisses it from the report queue
+const FilterHold = FilterAction("hold")
+
+// the report reason of posts held by a filter
+const FilterHeldReason = "filter"
+
+// fields of a post filter rules look at, anything else is a header
+const FilterFieldSubject = "subject"
+const FilterFieldName = "name"
+const FilterFieldMessage = "message"
+
+// a filter rule an admin made
+type FilterRule struct {
+	ID int64
+	// the board it's for, empty for every board
+	Newsgroup string
+	// subject, name, message or a header name
+	Field string
+	// a word matched on its own ignoring case or a regex if Regex is set
+	Pattern string
+	Regex   bool
+	Action  FilterAction
+	// what replace rules put in place of what matched
+	Replacement string
+	// unix time it was made
+	Time int64
+}
+
+// check if a filter action is one we know
+func validFilterAction(action FilterAction) bool {
+	switch action {
+	case FilterReject, FilterReplace, FilterSage, FilterHold:
+		return true
+	}
+	return false
+}
+
+// get the regex for the rule's pattern
+func (self FilterRule) compile() (*regexp.Regexp, error) {
+	if self.Regex {
+		return regexp.Compile(self.Pattern)
+	}
+	return regexp.Compile(`(?i)\b` + regexp.QuoteMeta(self.Pattern) + `\b`)
+}
+
+// check that a rule an admin gave us makes sense
+func (self FilterRule) check() error {
+	if self.Newsgroup != "" && !newsgroupValidFormat(self.Newsgroup) {
+		return errors.New("invalid newsgroup: " + self.Newsgroup)
+	}
+	if self.Field == "" {
+		return errors.New("no field")
+	}
+	if self.Pattern == "" {
+		return errors.New("no pattern")
+	}
+	if !validFilterAction(self.Action) {
+		return errors.New("invalid filter action: " + string(self.Action))
+	}
+	_, err := self.compile()
+	return err
+}
+
+func (self FilterRule) String() string {
+	return fmt.Sprintf("filter %d %s on %s", self.ID, self.Action, self.Field)
+}
+
+// what filter rules did to a post
+type FilterResult struct {
+	// the rule that rejected or held it or nil
+	Rejected *FilterRule
+	Held     *FilterRule
+}
+
+// run filter rules on an article in order, replacing and saging as we go
+// stops at the first rule that rejects it
+// articles in ctl are never filtered
+func filterArticle(rules []FilterRule, nntp *nntpArticle) (result FilterResult) {
+	group := nntp.Newsgroup()
+	if group == "ctl" {
+		return
+	}
+	for idx := range rules {
+		rule := &rules[idx]
+		if rule.Newsgroup != "" && rule.Newsgroup != group {
+			continue
+		}
+		re, err := rule.compile()
+		if err != nil {
+			log.Println("bad filter rule", rule, err)
+			continue
+		}
+		var text string
+		switch strings.ToLower(rule.Field) {
+		case FilterFieldSubject:
+			text = nntp.Subject()
+		case FilterFieldName:
+			text = nntp.Name()
+		case FilterFieldMessage:
+			text = nntp.message
+		default:
+			text = nntp.headers.Get(rule.Field, "")
+		}
+		if !re.MatchString(text) {
+			continue
+		}
+		switch rule.Action {
+		case FilterReject:
+			result.Rejected = rule
+			return
+		case FilterReplace:
+			switch strings.ToLower(rule.Field) {
+			case FilterFieldMessage:
+				nntp.message = re.ReplaceAllString(nntp.message, rule.Replacement)
+			case FilterFieldName:
+				nntp.headers.Set("From", re.ReplaceAllString(nntp.headers.Get("From", ""), rule.Replacement))
+			case FilterFieldSubject:
+				nntp.headers.Set("Subject", re.ReplaceAllString(text, rule.Replacement))
+			default:
+				nntp.headers.Set(rule.Field, re.ReplaceAllString(text, rule.Replacement))
+			}
+		case FilterSage:
+			nntp.headers.Set("X-Sage", "1")
+		case FilterHold:
+			if result.Held == nil {
+				result.Held = rule
+			}
+		}
+	}
+	return
+}
+
+// run filter rules on a copy of an article
+// replacing and saging only change the copy we register and show, we pass on the article as it was posted
+func filterArticleCopy(rules []FilterRule, nntp *nntpArticle) (shown *nntpArticle, result FilterResult) {
+	c := *nntp
+	c.headers = make(ArticleHeaders)
+	for k, v := range nntp.headers {
+		c.headers[k] = append([]string(nil), v...)
+	}
+	shown = &c
+	result = filterArticle(rules, shown)
+	return
+}
+
+// put a post a filter held in the quarantine queue and the report queue
+// done before it is registered like quarantineArticle
+func holdFilteredArticle(db Database, msgid string, rule *FilterRule) {
+	quarantineArticle(db, msgid)
+	err := db.ReportArticle(msgid, FilterHeldReason, fmt.Sprintf("filter-%d", rule.ID))
+	if err != nil {
+		log.Println("failed to hold", msgid, "for", rule, err)
+	} else {
+		log.Println("held", msgid, "for review by", rule)
+	}
+}
+
+// load filter rules from rows of id, newsgroup, field, pattern, regex, action, replacement and time_added
+func scanFilterRules(rows *sql.Rows) (rules []FilterRule, err error) {
+	for rows.Next() {
+		var rule FilterRule
+		err = rows.Scan(&rule.ID, &rule.Newsgroup, &rule.Field, &rule.Pattern, &rule.Regex, &rule.Action, &rule.Replacement, &rule.Time)
+		if err != nil {
+			break
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+	return
+}
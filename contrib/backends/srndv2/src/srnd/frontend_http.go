@@ -23,6 +23,8 @@ import (
 	"net/http"
 	"net/mail"
 	"net/textproto"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -216,6 +218,9 @@ type httpFrontend struct {
 
 	// this is a very important thing by the way
 	requireCaptcha bool
+
+	// how many posts one address can report per hour
+	reportLimit int64
 }
 
 // do we allow this newsgroup?
@@ -393,7 +398,7 @@ func (self *httpFrontend) poll() {
 								R: msg.Body,
 								N: self.daemon.messageSizeLimitFor(nntp.Newsgroup()),
 							}
-							err = self.daemon.store.ProcessMessageBody(f, textproto.MIMEHeader(msg.Header), body)
+							err = self.daemon.store.ProcessMessageBody(f, textproto.MIMEHeader(msg.Header), body, false)
 						}
 					}
 				}
@@ -719,7 +724,7 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	address := pr.IpAddress
 	// check for banned
 	if len(address) > 0 {
-		banned, err = self.daemon.database.CheckIPBanned(address)
+		banned, err = self.daemon.database.CheckIPBanned(address, strings.ToLower(pr.Group))
 		if err == nil {
 			if banned {
 				b()
@@ -777,7 +782,10 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	ref := pr.Reference
 	if len(ref) > 0 {
 		if ValidMessageID(ref) {
-			if self.daemon.database.HasArticleLocal(ref) {
+			if self.daemon.database.ArticleHasFlag(ref, ArticleFlagLocked) {
+				e(errors.New("thread is locked"))
+				return
+			} else if self.daemon.database.HasArticleLocal(ref) {
 				nntp.headers.Set("References", ref)
 			} else {
 				e(errors.New("article referenced not locally available"))
@@ -875,6 +883,19 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 			}
 		}
 	}
+	// apply filter rules
+	rules, err := self.daemon.database.GetFilterRules()
+	if err != nil {
+		e(err)
+		return
+	}
+	_, filtered := filterArticleCopy(rules, nntp)
+	if filtered.Rejected != nil {
+		log.Println(msgid, "rejected by", filtered.Rejected)
+		e(ErrFilteredArticle)
+		return
+	}
+
 	if self.attachments {
 		var delfiles []string
 		for _, att := range pr.Attachments {
@@ -891,6 +912,9 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 					}
 					if err == nil {
 						delfiles = append(delfiles, self.daemon.store.ThumbnailFilepath(a.Filepath()))
+						if self.daemon.store.ImageBanned(a.Filepath()) {
+							err = ErrBannedImage
+						}
 					}
 				}
 				if err != nil {
@@ -914,6 +938,12 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 			return
 		}
 	}
+	// quarantine it before we register it so it does not bump its thread
+	if filtered.Held != nil {
+		holdFilteredArticle(self.daemon.database, nntp.MessageID(), filtered.Held)
+	} else if self.daemon.quarantines(nntp.Newsgroup(), false) {
+		quarantineArticle(self.daemon.database, nntp.MessageID())
+	}
 	// pack it before sending so that the article is well formed
 	// sign if needed
 	if len(tripcode_privkey) == nacl.CryptoSignSeedLen() {
@@ -925,7 +955,9 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 		defer kp.Free()
 		nntp.headers.Set("X-PubKey-Ed25519", hexify(kp.Public()))
 		nntp.Pack()
-		err = self.daemon.store.RegisterPost(nntp)
+		// we register what filters made of it and send on what was posted
+		shown, _ := filterArticleCopy(rules, nntp)
+		err = self.daemon.store.RegisterPost(shown)
 		if err != nil {
 			e(err)
 			return
@@ -941,7 +973,9 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 		}
 	} else {
 		nntp.Pack()
-		err = self.daemon.store.RegisterPost(nntp)
+		// we register what filters made of it and send on what was posted
+		shown, _ := filterArticleCopy(rules, nntp)
+		err = self.daemon.store.RegisterPost(shown)
 	}
 	if err != nil {
 		e(err)
@@ -958,7 +992,9 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 		err = nntp.WriteTo(f, self.daemon.messageSizeLimitFor(nntp.Newsgroup()))
 		f.Close()
 		if err == nil {
-			go self.daemon.loadFromInfeed(nntp.MessageID())
+			if !self.daemon.database.ArticleHasFlag(nntp.MessageID(), ArticleFlagQuarantined) {
+				go self.daemon.loadFromInfeed(nntp.MessageID())
+			}
 			s(nntp)
 			return
 		}
@@ -1072,65 +1108,166 @@ func (self httpFrontend) handle_authed_api(wr http.ResponseWriter, r *http.Reque
 	}
 }
 
+// parse a unix time or a yyyy-mm-dd date from a search query
+func parseSearchTime(str string) int64 {
+	t, err := time.Parse("2006-01-02", str)
+	if err == nil {
+		return t.Unix()
+	}
+	i, _ := strconv.ParseInt(str, 10, 64)
+	return i
+}
+
+// get a search request from url query parameters
+func searchRequestFromQuery(q url.Values) SearchRequest {
+	page := queryGetInt64(q, "page", 0)
+	if page < 0 {
+		page = 0
+	} else if page > MaxSearchPage {
+		page = MaxSearchPage
+	}
+	return SearchRequest{
+		Text:          q.Get("text"),
+		Newsgroup:     q.Get("group"),
+		After:         parseSearchTime(q.Get("after")),
+		Before:        parseSearchTime(q.Get("before")),
+		HasAttachment: q.Get("files") == "1",
+		Page:          int(page),
+		PerPage:       int(queryGetInt64(q, "perpage", DefaultSearchPerPage)),
+	}
+}
+
+// what /api/find gives back
+type findResult struct {
+	Posts []PostModel `json:"posts"`
+	// if there is another page of results
+	More bool `json:"more"`
+}
+
 // handle find post api command
 func (self *httpFrontend) handle_api_find(wr http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	h := q.Get("hash")
+	var posts []PostModel
+	var more bool
 	if len(h) > 0 {
-		msgid := q.Get("id")
-		if len(h) > 0 {
-			e, err := self.daemon.database.GetMessageIDByHash(h)
-			if err == nil {
-				msgid = e.MessageID()
-			}
-		}
-		if len(msgid) > 0 {
+		e, err := self.daemon.database.GetMessageIDByHash(h)
+		if err == nil {
 			// found it (probaly)
-			model := self.daemon.database.GetPostModel(self.prefix, msgid)
+			model := self.daemon.database.GetPostModel(self.prefix, e.MessageID())
 			if model == nil {
 				// no model
 				wr.WriteHeader(404)
-			} else {
-				// we found it
-				wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
-				json.NewEncoder(wr).Encode([]PostModel{model})
+				return
 			}
+			posts = append(posts, model)
+		} else {
+			chnl := make(chan PostModel)
+			go self.daemon.database.SearchByHash(self.prefix, q.Get("group"), h, chnl)
+			for p := range chnl {
+				posts = append(posts, p)
+			}
+		}
+	} else {
+		var err error
+		posts, more, err = self.daemon.database.SearchPosts(self.prefix, searchRequestFromQuery(q))
+		if err != nil {
+			api_error(wr, err)
 			return
 		}
 	}
-	s := q.Get("text")
-	g := q.Get("group")
-
+	if posts == nil {
+		posts = []PostModel{}
+	}
 	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
-	chnl := make(chan PostModel)
-	wr.WriteHeader(http.StatusOK)
-	io.WriteString(wr, "[")
-	donechnl := make(chan int)
-	go func(w io.Writer) {
-		for {
-			p, ok := <-chnl
-			if ok {
-				d, e := json.Marshal(p)
-				if e == nil {
-					io.WriteString(w, string(d))
-					io.WriteString(w, ", ")
-				} else {
-					log.Println("error marshalling post", e)
-				}
-			} else {
-				break
+	json.NewEncoder(wr).Encode(findResult{posts, more})
+}
+
+// handle search page
+func (self *httpFrontend) handle_search(wr http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := searchRequestFromQuery(q)
+	param := map[string]interface{}{
+		"prefix":   self.prefix,
+		"frontend": self.name,
+		"text":     req.Text,
+		"group":    req.Newsgroup,
+		"after":    q.Get("after"),
+		"before":   q.Get("before"),
+		"files":    req.HasAttachment,
+	}
+	if req.Text != "" {
+		posts, more, err := self.daemon.database.SearchPosts(self.prefix, req)
+		if err == nil {
+			param["posts"] = posts
+			param["searched"] = true
+			if req.Page > 0 {
+				q.Set("page", strconv.Itoa(req.Page-1))
+				param["prev_url"] = self.prefix + "search?" + q.Encode()
+			}
+			if more {
+				q.Set("page", strconv.Itoa(req.Page+1))
+				param["next_url"] = self.prefix + "search?" + q.Encode()
 			}
+		} else {
+			param["error"] = err.Error()
 		}
-		donechnl <- 0
-	}(wr)
-	if len(h) > 0 {
-		self.daemon.database.SearchByHash(self.prefix, g, h, chnl)
-	} else {
-		self.daemon.database.SearchQuery(self.prefix, g, s, chnl)
 	}
-	<-donechnl
-	close(donechnl)
-	io.WriteString(wr, " null ]")
+	template.writeTemplate("search.mustache", param, wr)
+}
+
+// handle reporting a post to the moderators of this node
+// GET gives the report form, POST makes the report
+func (self *httpFrontend) handle_report(wr http.ResponseWriter, r *http.Request) {
+	msgid := r.FormValue("msgid")
+	param := map[string]interface{}{
+		"prefix":  self.prefix,
+		"msgid":   msgid,
+		"reasons": ReportReasons,
+	}
+	if r.Method == "POST" {
+		err := self.reportPost(r, msgid, r.FormValue("reason"))
+		if err == nil {
+			param["reported"] = true
+		} else {
+			param["error"] = err.Error()
+		}
+	}
+	if param["reported"] == nil && self.requireCaptcha {
+		captcha_id := captcha.New()
+		param["captcha_id"] = captcha_id
+		param["captcha_url"] = fmt.Sprintf("%scaptcha/%s.png", self.prefix, captcha_id)
+	}
+	template.writeTemplate("report.mustache", param, wr)
+}
+
+// check and record a report of a post from an http request
+func (self *httpFrontend) reportPost(r *http.Request, msgid, reason string) (err error) {
+	if !ValidMessageID(msgid) || !self.daemon.database.HasArticleLocal(msgid) {
+		return errors.New("no such post")
+	}
+	if !validReportReason(reason) {
+		return errors.New("invalid report reason")
+	}
+	if self.requireCaptcha && !captcha.VerifyString(r.FormValue("captcha_id"), r.FormValue("captcha")) {
+		return errors.New("bad captcha")
+	}
+	var ip, reporter string
+	ip, err = extractRealIP(r)
+	if err == nil {
+		// we don't keep their address in the clear
+		reporter, err = self.daemon.database.GetEncAddress(ip)
+	}
+	var reports int64
+	if err == nil {
+		reports, err = self.daemon.database.CountReportsBy(reporter, timeNow()-3600)
+	}
+	if err == nil && reports >= self.reportLimit {
+		err = errors.New("too many reports, try again later")
+	}
+	if err == nil {
+		err = self.daemon.database.ReportArticle(msgid, reason, reporter)
+	}
 	return
 }
 
@@ -1183,7 +1320,7 @@ func (self *httpFrontend) handle_liveapi(w http.ResponseWriter, r *http.Request)
 	ip, err := extractRealIP(r)
 	if err == nil {
 		var banned bool
-		banned, err = self.daemon.database.CheckIPBanned(ip)
+		banned, err = self.daemon.database.CheckIPBanned(ip, "")
 		if banned {
 			// TODO: ban reason
 			res["error"] = "u banned yo"
@@ -1326,7 +1463,7 @@ func (self *httpFrontend) handle_liveui(w http.ResponseWriter, r *http.Request)
 	log.Println("liveui:", IpAddress)
 	var banned bool
 	if err == nil {
-		banned, err = self.daemon.database.CheckIPBanned(IpAddress)
+		banned, err = self.daemon.database.CheckIPBanned(IpAddress, "")
 		if banned {
 			w.WriteHeader(403)
 			io.WriteString(w, "banned")
@@ -1441,11 +1578,20 @@ func (self *httpFrontend) Mainloop() {
 	// modui handlers
 	m.Path("/mod/").HandlerFunc(self.modui.ServeModPage).Methods("GET")
 	m.Path("/mod/feeds").HandlerFunc(self.modui.ServeModPage).Methods("GET")
+	m.Path("/mod/log").HandlerFunc(self.modui.ServeModPage).Methods("GET")
+	m.Path("/mod/reports").HandlerFunc(self.modui.ServeModPage).Methods("GET")
+	m.Path("/mod/quarantine").HandlerFunc(self.modui.ServeModPage).Methods("GET")
+	m.Path("/mod/bans").HandlerFunc(self.modui.ServeModPage).Methods("GET")
 	m.Path("/mod/keygen").HandlerFunc(self.modui.HandleKeyGen).Methods("GET")
 	m.Path("/mod/login").HandlerFunc(self.modui.HandleLogin).Methods("POST")
 	m.Path("/mod/del/{article_hash}").HandlerFunc(self.modui.HandleDeletePost).Methods("GET")
 	m.Path("/mod/ban/{address}").HandlerFunc(self.modui.HandleBanAddress).Methods("GET")
-	m.Path("/mod/unban/{address}").HandlerFunc(self.modui.HandleUnbanAddress).Methods("GET")
+	m.Path("/mod/unban/{address:.+}").HandlerFunc(self.modui.HandleUnbanAddress).Methods("GET")
+	m.Path("/mod/dismiss/{article_hash}").HandlerFunc(self.modui.HandleDismissReports).Methods("GET")
+	m.Path("/mod/ham/{article_hash}").HandlerFunc(self.modui.HandleMarkHam).Methods("GET")
+	m.Path("/mod/approve/{article_hash}").HandlerFunc(self.modui.HandleApproveArticle).Methods("GET")
+	m.Path("/mod/reject/{article_hash}").HandlerFunc(self.modui.HandleRejectArticle).Methods("GET")
+	m.Path("/mod/imageban/{article_hash}").HandlerFunc(self.modui.HandleBanImages).Methods("GET")
 	m.Path("/mod/addkey/{pubkey}").HandlerFunc(self.modui.HandleAddPubkey).Methods("GET")
 	m.Path("/mod/delkey/{pubkey}").HandlerFunc(self.modui.HandleDelPubkey).Methods("GET")
 	m.Path("/mod/admin/{action}").HandlerFunc(self.modui.HandleAdminCommand).Methods("GET", "POST")
@@ -1470,6 +1616,8 @@ func (self *httpFrontend) Mainloop() {
 	m.Path("/captcha/img").HandlerFunc(self.new_captcha).Methods("GET")
 	m.Path("/captcha/{f}").Handler(captcha.Server(350, 175)).Methods("GET")
 	m.Path("/new/").HandlerFunc(self.handle_newboard).Methods("GET")
+	m.Path("/search").HandlerFunc(self.handle_search).Methods("GET")
+	m.Path("/report").HandlerFunc(self.handle_report).Methods("GET", "POST")
 	m.Path("/api/{meth}").HandlerFunc(self.handle_api).Methods("POST", "GET")
 	// live ui websocket
 	m.Path("/live").HandlerFunc(self.handle_liveui).Methods("GET")
@@ -1539,6 +1687,7 @@ func NewHTTPFrontend(daemon *NNTPDaemon, cache CacheInterface, config map[string
 	front.regen_on_start = config["regen_on_start"] == "1"
 	front.enableBoardCreation = config["board_creation"] == "1"
 	front.requireCaptcha = config["rapeme"] != "omgyesplz"
+	front.reportLimit = mapGetInt64(config, "report_limit", DefaultReportLimit)
 	cache.SetRequireCaptcha(front.requireCaptcha)
 	if config["json-api"] == "1" {
 		front.jsonUsername = config["json-api-username"]
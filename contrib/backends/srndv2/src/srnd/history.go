@@ -0,0 +1,65 @@
+//
+// history.go
+// message-id history
+//
+package srnd
+
+import (
+	"log"
+	"time"
+)
+
+// what we did with a message-id we saw
+const (
+	// we took the article
+	HistoryAccepted = "accepted"
+	// we refused the article when it was offered
+	HistoryRejected = "rejected"
+	// we took the article and it expired since
+	HistoryExpired = "expired"
+	// a moderator deleted the article
+	HistoryBanned = "banned"
+)
+
+// remembers every message-id we have seen and what we did with it
+// so articles we don't have anymore don't come back when a peer offers them again
+type MessageHistory interface {
+	// remember what we did with a message-id
+	Record(msgid, disposition string)
+	// get what we did with a message-id
+	// empty string if we never saw it or we forgot about it
+	Disposition(msgid string) string
+	// forget every message-id we saw longer ago than the retention period
+	Expire()
+}
+
+func createMessageHistory(database Database, retention time.Duration) MessageHistory {
+	return history{database, retention}
+}
+
+type history struct {
+	database  Database
+	retention time.Duration
+}
+
+func (self history) Record(msgid, disposition string) {
+	err := self.database.RecordMessageHistory(msgid, disposition)
+	if err != nil {
+		log.Println("failed to record", msgid, "as", disposition, "in history", err)
+	}
+}
+
+func (self history) Disposition(msgid string) (disposition string) {
+	disposition, err := self.database.GetMessageHistory(msgid)
+	if err != nil {
+		log.Println("failed to check history for", msgid, err)
+	}
+	return
+}
+
+func (self history) Expire() {
+	err := self.database.ExpireMessageHistory(time.Now().Add(-self.retention).Unix())
+	if err != nil {
+		log.Println("failed to expire history", err)
+	}
+}
@@ -0,0 +1,190 @@
+//
+// imagehash.go -- perceptual hashes of images so banned images stay banned when re-encoded
+//
+package srnd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ban images that look like the perceptual hash that is the target on every board
+// overchan-image-ban <hash> reason=<text>
+const ModImageBan = ModAction("overchan-image-ban")
+
+var ErrBannedImage = errors.New("banned image")
+
+var ErrImageTooBig = errors.New("image too big to hash")
+
+// ban images this many bits or less away from a banned hash by default
+const DefaultImageBanDistance = 6
+
+// most pixels an image can have for us to decode and hash it
+const MaxImageHashPixels = 40000000
+
+// most pixels we look at along each side of a cell when shrinking an image
+const imageHashSamples = 8
+
+// difference hash of an image, 64 bits of which neighbouring pixels are brighter
+// resizing, re-encoding and small edits only change a few bits
+type ImageHash uint64
+
+func (self ImageHash) String() string {
+	return fmt.Sprintf("%016x", uint64(self))
+}
+
+// hashes go into json as strings
+func (self ImageHash) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+// how many bits two hashes differ by
+func (self ImageHash) Distance(other ImageHash) int {
+	return bits.OnesCount64(uint64(self ^ other))
+}
+
+// parse a hash made by ImageHash.String
+func ParseImageHash(str string) (h ImageHash, err error) {
+	if len(str) != 16 {
+		err = errors.New("invalid image hash")
+		return
+	}
+	var i uint64
+	i, err = strconv.ParseUint(str, 16, 64)
+	h = ImageHash(i)
+	return
+}
+
+// compute the difference hash of an image
+// shrink it to 9x8 gray pixels and compare each pixel to the one right of it
+// each gray pixel is the average of at most imageHashSamples squared pixels spread over its cell
+func imageDHash(img image.Image) (h ImageHash) {
+	b := img.Bounds()
+	w, ht := b.Dx(), b.Dy()
+	if w == 0 || ht == 0 {
+		return
+	}
+	var gray [8][9]float64
+	for y := 0; y < 8; y++ {
+		y0, y1 := b.Min.Y+y*ht/8, b.Min.Y+(y+1)*ht/8
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < 9; x++ {
+			x0, x1 := b.Min.X+x*w/9, b.Min.X+(x+1)*w/9
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			stepy, stepx := (y1-y0+imageHashSamples-1)/imageHashSamples, (x1-x0+imageHashSamples-1)/imageHashSamples
+			var sum float64
+			var n int
+			for py := y0; py < y1 && py < b.Max.Y; py += stepy {
+				for px := x0; px < x1 && px < b.Max.X; px += stepx {
+					r, g, bl, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			if n > 0 {
+				gray[y][x] = sum / float64(n)
+			}
+		}
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return
+}
+
+// check if an attachment is an image we can hash
+func canHashImage(fname string) bool {
+	for _, ext := range []string{".gif", ".jpeg", ".jpg", ".png"} {
+		if strings.HasSuffix(strings.ToLower(fname), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// compute the difference hash of an image file
+// only formats we can decode without external tools, gif, jpeg and png
+// images over MaxImageHashPixels are not decoded
+func hashImageFile(fpath string) (h ImageHash, err error) {
+	var f *os.File
+	f, err = os.Open(fpath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var conf image.Config
+	conf, _, err = image.DecodeConfig(f)
+	if err != nil {
+		return
+	}
+	if conf.Width <= 0 || conf.Height <= 0 || int64(conf.Width)*int64(conf.Height) > MaxImageHashPixels {
+		err = ErrImageTooBig
+		return
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	var img image.Image
+	img, _, err = image.Decode(f)
+	if err == nil {
+		h = imageDHash(img)
+	}
+	return
+}
+
+// an image hash mods banned
+type BannedImage struct {
+	Hash   ImageHash
+	Reason string
+	// unix time it was banned
+	Time int64
+}
+
+// get the ban an image hash is close enough to or nil
+func findBannedImage(banned []BannedImage, h ImageHash, distance int) *BannedImage {
+	for idx := range banned {
+		if banned[idx].Hash.Distance(h) <= distance {
+			return &banned[idx]
+		}
+	}
+	return nil
+}
+
+// load banned images from rows of phash, reason and time_banned
+func scanBannedImages(rows *sql.Rows) (banned []BannedImage, err error) {
+	for rows.Next() {
+		var b BannedImage
+		var h string
+		err = rows.Scan(&h, &b.Reason, &b.Time)
+		if err != nil {
+			break
+		}
+		b.Hash, err = ParseImageHash(h)
+		if err != nil {
+			break
+		}
+		banned = append(banned, b)
+	}
+	rows.Close()
+	return
+}
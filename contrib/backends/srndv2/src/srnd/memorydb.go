@@ -0,0 +1,1984 @@
+//
+// memorydb.go -- in memory database backend
+//
+package srnd
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// an article we know about, like the Articles table
+type memArticle struct {
+	msgid    string
+	hash     string
+	group    string
+	ref      string
+	obtained int64
+	seq      int64
+}
+
+// a post we have locally, like the ArticlePosts table
+type memPost struct {
+	group   string
+	msgid   string
+	ref     string
+	name    string
+	subject string
+	path    string
+	posted  int64
+	message string
+	addr    string
+	// the order we got it in, keeps posts made in the same second in order
+	seq int64
+}
+
+// the bump state of a thread, like the ArticleThreads table
+type memThread struct {
+	root  string
+	group string
+	bump  int64
+	last  int64
+	seq   int64
+}
+
+type memNewsgroup struct {
+	lastPost int64
+	created  int64
+}
+
+type memAttachment struct {
+	hash     string
+	filename string
+	filepath string
+}
+
+type memHeader struct {
+	name  string
+	value string
+}
+
+type memNumber struct {
+	no    int64
+	msgid string
+}
+
+type memEncAddr struct {
+	key     string
+	addr    string
+	encaddr string
+}
+
+// an ip or encrypted ip ban
+type memBan struct {
+	addr    string
+	scope   string
+	reason  string
+	issuer  string
+	made    int64
+	expires int64
+}
+
+// the ban as the other databases give it
+func (self memBan) ipBan(encrypted bool) IPBan {
+	return IPBan{self.addr, encrypted, self.scope, self.reason, self.issuer, self.made, self.expires}
+}
+
+// check if a ban is still in effect at time now
+func (self memBan) active(now int64) bool {
+	return self.expires < 0 || self.expires > now
+}
+
+type memModPriv struct {
+	pubkey     string
+	newsgroup  string
+	permission string
+}
+
+type memLogin struct {
+	hash string
+	salt string
+}
+
+// a value and when we got it
+type memTimed struct {
+	value string
+	t     int64
+}
+
+// database driver that keeps everything in memory and forgets it all when we exit
+// for tests and ephemeral nodes that don't need a database server
+type MemoryDatabase struct {
+	access sync.RWMutex
+	// when we were made, reported as when our only schema version was applied
+	created int64
+	// counter for the order things were added in
+	seq int64
+
+	newsgroups   map[string]*memNewsgroup
+	bannedGroups map[string]int64
+	articles     map[string]*memArticle
+	posts        map[string]*memPost
+	threads      map[string]*memThread
+	attachments  map[string][]memAttachment
+	pubkeys      map[string]string
+	headers      map[string][]memHeader
+	// newsgroup -> article numbers, lowest first
+	numbers        map[string][]memNumber
+	bannedArticles map[string]memTimed
+	ipBans         []memBan
+	encIPBans      []memBan
+	encAddrs       []memEncAddr
+	modPrivs       []memModPriv
+	logins         map[string]memLogin
+	syncTimes      map[string]int64
+	// feed -> message-id -> reason
+	rejections map[string]map[string]memTimed
+	// message-id -> disposition
+	history map[string]memTimed
+	// message-id -> moderation flag -> when it was set
+	flags map[string]map[string]int64
+	// oldest first
+	modLogs []ModLogEntry
+	// message-id -> reporter -> reason and when
+	reports map[string]map[string]memTimed
+	// oldest first
+	delegations []ModDelegation
+	// attachment filepath -> perceptual hash
+	imageHashes map[string]ImageHash
+	// oldest first
+	bannedImages []BannedImage
+	// oldest first
+	filterRules  []FilterRule
+	lastFilterID int64
+	// token -> times in spam and ham
+	spamTokens map[string]SpamCounts
+	spamTotals SpamCounts
+	// message-id -> if we learned it as spam
+	spamTrained map[string]bool
+}
+
+// create a database driver that keeps everything in memory
+func NewMemoryDatabase() Database {
+	log.Println("using in memory database, nothing will be kept when we exit")
+	return &MemoryDatabase{
+		created:        timeNow(),
+		newsgroups:     make(map[string]*memNewsgroup),
+		bannedGroups:   make(map[string]int64),
+		articles:       make(map[string]*memArticle),
+		posts:          make(map[string]*memPost),
+		threads:        make(map[string]*memThread),
+		attachments:    make(map[string][]memAttachment),
+		pubkeys:        make(map[string]string),
+		headers:        make(map[string][]memHeader),
+		numbers:        make(map[string][]memNumber),
+		bannedArticles: make(map[string]memTimed),
+		logins:         make(map[string]memLogin),
+		syncTimes:      make(map[string]int64),
+		rejections:     make(map[string]map[string]memTimed),
+		history:        make(map[string]memTimed),
+		flags:          make(map[string]map[string]int64),
+		reports:        make(map[string]map[string]memTimed),
+		imageHashes:    make(map[string]ImageHash),
+		spamTokens:     make(map[string]SpamCounts),
+		spamTrained:    make(map[string]bool),
+	}
+}
+
+func (self *MemoryDatabase) Close() {
+}
+
+func (self *MemoryDatabase) CreateTables() {
+}
+
+// there is nothing to migrate, we always have the latest schema
+func (self *MemoryDatabase) GetSchemaMigrations() ([]SchemaMigration, error) {
+	return []SchemaMigration{{Version: 1, Name: "in memory", Applied: self.created}}, nil
+}
+
+func (self *MemoryDatabase) MigrateSchema() error {
+	return nil
+}
+
+func (self *MemoryDatabase) SetConnectionLifetime(seconds int) {
+}
+
+func (self *MemoryDatabase) SetMaxOpenConns(n int) {
+}
+
+func (self *MemoryDatabase) SetMaxIdleConns(n int) {
+}
+
+// get posts that match filter, oldest first, must hold lock
+func (self *MemoryDatabase) findPosts(filter func(p *memPost) bool) (posts []*memPost) {
+	for _, p := range self.posts {
+		if filter(p) {
+			posts = append(posts, p)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].posted == posts[j].posted {
+			return posts[i].seq < posts[j].seq
+		}
+		return posts[i].posted < posts[j].posted
+	})
+	return
+}
+
+// check if an article has a moderation flag, must hold lock
+func (self *MemoryDatabase) hasFlag(msgid, flag string) bool {
+	_, ok := self.flags[msgid][flag]
+	return ok
+}
+
+// get threads that match filter, sticky ones then last bumped first, must hold lock
+func (self *MemoryDatabase) findThreads(filter func(th *memThread) bool) (threads []*memThread) {
+	for _, th := range self.threads {
+		if filter(th) {
+			threads = append(threads, th)
+		}
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		sticky_i, sticky_j := self.hasFlag(threads[i].root, ArticleFlagSticky), self.hasFlag(threads[j].root, ArticleFlagSticky)
+		if sticky_i != sticky_j {
+			return sticky_i
+		} else if threads[i].bump == threads[j].bump {
+			return threads[i].seq > threads[j].seq
+		}
+		return threads[i].bump > threads[j].bump
+	})
+	return
+}
+
+// count replies to a thread, must hold lock
+func (self *MemoryDatabase) countReplies(root_message_id string) (count int64) {
+	for _, p := range self.posts {
+		if p.ref == root_message_id {
+			count++
+		}
+	}
+	return
+}
+
+// get the attachment models of a post, must hold lock
+func (self *MemoryDatabase) attachmentModels(prefix, msgid string) (atts []AttachmentModel) {
+	for _, att := range self.attachments[msgid] {
+		atts = append(atts, &attachment{
+			prefix: prefix,
+			Path:   att.filepath,
+			Name:   att.filename,
+		})
+	}
+	return
+}
+
+// get a post model with just what ArticlePosts has
+func (p *memPost) model() *post {
+	return &post{
+		board:       p.group,
+		Message_id:  p.msgid,
+		Parent:      p.ref,
+		PostName:    p.name,
+		PostSubject: p.subject,
+		MessagePath: p.path,
+		Posted:      p.posted,
+		PostMessage: p.message,
+		addr:        p.addr,
+	}
+}
+
+// get a full post model with attachments and pubkey, must hold lock
+func (self *MemoryDatabase) postModel(prefix string, p *memPost) *post {
+	model := p.model()
+	model.prefix = prefix
+	model.op = len(model.Parent) == 0
+	if len(model.Parent) == 0 {
+		model.Parent = model.Message_id
+	}
+	model.sage = isSage(model.PostSubject)
+	model.Files = append(model.Files, self.attachmentModels(prefix, p.msgid)...)
+	model.Key = self.pubkeys[p.msgid]
+	return model
+}
+
+func (self *MemoryDatabase) HasNewsgroup(group string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, ok := self.newsgroups[group]
+	return ok
+}
+
+func (self *MemoryDatabase) HasArticle(message_id string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, ok := self.articles[message_id]
+	return ok
+}
+
+func (self *MemoryDatabase) HasArticleLocal(message_id string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, ok := self.posts[message_id]
+	return ok
+}
+
+func (self *MemoryDatabase) RegisterNewsgroup(group string) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if _, ok := self.newsgroups[group]; ok {
+		log.Println("failed to register newsgroup", group, "already exists")
+		return
+	}
+	now := timeNow()
+	self.newsgroups[group] = &memNewsgroup{lastPost: now, created: now}
+}
+
+// register a message with the database
+func (self *MemoryDatabase) BumpThread(reply NNTPMessage) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.bumpThread(reply)
+	return
+}
+
+// bump the thread of a reply, the lock must be held
+func (self *MemoryDatabase) bumpThread(reply NNTPMessage) {
+	ref := reply.Reference()
+	if th, ok := self.threads[ref]; ok {
+		if !reply.Sage() && !self.hasFlag(ref, ArticleFlagSage) && self.countReplies(ref) <= BumpLimit {
+			th.bump = reply.Posted()
+		}
+		th.last = reply.Posted()
+	}
+}
+
+func (self *MemoryDatabase) RegisterArticle(message NNTPMessage) (err error) {
+	msgid := message.MessageID()
+	group := message.Newsgroup()
+	ref := message.Reference()
+	self.access.Lock()
+	defer self.access.Unlock()
+	now := timeNow()
+	g, ok := self.newsgroups[group]
+	if !ok {
+		g = &memNewsgroup{created: now}
+		self.newsgroups[group] = g
+	}
+	if _, ok = self.articles[msgid]; ok {
+		return
+	}
+	self.seq++
+	// article metadata
+	self.articles[msgid] = &memArticle{
+		msgid:    msgid,
+		hash:     HashMessageID(msgid),
+		group:    group,
+		ref:      ref,
+		obtained: now,
+		seq:      self.seq,
+	}
+	g.lastPost = now
+	// article post
+	posted := message.Posted()
+	self.posts[msgid] = &memPost{
+		group:   group,
+		msgid:   msgid,
+		ref:     ref,
+		name:    message.Name(),
+		subject: message.Subject(),
+		path:    message.Path(),
+		posted:  posted,
+		message: message.Message(),
+		addr:    message.Addr(),
+		seq:     self.seq,
+	}
+	// set / update thread state
+	if message.OP() {
+		self.threads[msgid] = &memThread{
+			root:  msgid,
+			group: group,
+			bump:  posted,
+			last:  posted,
+			seq:   self.seq,
+		}
+	} else if !self.hasFlag(msgid, ArticleFlagQuarantined) {
+		self.bumpThread(message)
+	}
+	// header key value pairs
+	var hdrs []memHeader
+	for k, val := range message.Headers() {
+		k = strings.ToLower(k)
+		for _, v := range val {
+			hdrs = append(hdrs, memHeader{k, v})
+		}
+	}
+	self.headers[msgid] = hdrs
+	// nntp number
+	nums := self.numbers[group]
+	no := int64(1)
+	if len(nums) > 0 {
+		no = nums[len(nums)-1].no + 1
+	}
+	self.numbers[group] = append(nums, memNumber{no, msgid})
+	// attachments
+	for _, att := range message.Attachments() {
+		self.attachments[msgid] = append(self.attachments[msgid], memAttachment{
+			hash:     hex.EncodeToString(att.Hash()),
+			filename: att.Filename(),
+			filepath: att.Filepath(),
+		})
+	}
+	return
+}
+
+// get all articles in a newsgroup
+// send result down a channel
+func (self *MemoryDatabase) GetAllArticlesInGroup(group string, recv chan ArticleEntry) {
+	self.access.RLock()
+	posts := self.findPosts(func(p *memPost) bool {
+		return p.group == group
+	})
+	self.access.RUnlock()
+	// don't hold the lock while the reader does things with them
+	for _, p := range posts {
+		recv <- ArticleEntry{p.msgid, group}
+	}
+}
+
+func (self *MemoryDatabase) CountAllArticlesInGroup(group string) (count int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.posts {
+		if p.group == group {
+			count++
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetAllArticles() (articles []ArticleEntry) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.findPosts(func(p *memPost) bool { return true }) {
+		articles = append(articles, ArticleEntry{p.msgid, p.group})
+	}
+	return
+}
+
+func (self *MemoryDatabase) NewsgroupBanned(group string) (banned bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, banned = self.bannedGroups[group]
+	return
+}
+
+func (self *MemoryDatabase) BanNewsgroup(group string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.bannedGroups[group] = timeNow()
+	return
+}
+
+func (self *MemoryDatabase) UnbanNewsgroup(group string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.bannedGroups, group)
+	return
+}
+
+func (self *MemoryDatabase) NukeNewsgroup(group string, store ArticleStore) {
+	var msgids []string
+	self.access.Lock()
+	// first delete all thread presences
+	for root, th := range self.threads {
+		if th.group == group {
+			delete(self.threads, root)
+		}
+	}
+	for _, p := range self.findPosts(func(p *memPost) bool { return p.group == group }) {
+		msgids = append(msgids, p.msgid)
+	}
+	self.access.Unlock()
+	// for each article delete it fully
+	for _, msgid := range msgids {
+		log.Println("delete", msgid)
+		// remove article from store
+		fname := store.GetFilename(msgid)
+		os.Remove(fname)
+		// get all attachments
+		for _, att := range self.GetPostAttachments(msgid) {
+			// remove attachment
+			log.Println("delete attachment", att)
+			os.Remove(store.ThumbnailFilepath(att))
+			os.Remove(store.AttachmentFilepath(att))
+		}
+		// delete from database
+		self.DeleteArticle(msgid)
+	}
+	log.Println("nuke of", group, "done")
+}
+
+func (self *MemoryDatabase) IsExpired(root_message_id string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, known := self.articles[root_message_id]
+	_, local := self.posts[root_message_id]
+	return !(known && local)
+}
+
+func (self *MemoryDatabase) GetMessageIDByHash(hash string) (article ArticleEntry, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, a := range self.articles {
+		if a.hash == hash {
+			article = ArticleEntry{a.msgid, a.group}
+			return
+		}
+	}
+	err = sql.ErrNoRows
+	return
+}
+
+// count how many threads in a newsgroup were bumped at or after the given thread, must hold lock
+func (self *MemoryDatabase) countThreadsBumpedSince(root_message_id, group string) (count int64) {
+	root, ok := self.threads[root_message_id]
+	if ok {
+		for _, th := range self.threads {
+			if th.group == group && th.bump >= root.bump {
+				count++
+			}
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetInfoForMessage(msgid string) (root string, newsgroup string, page int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	p, ok := self.posts[msgid]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+	newsgroup = p.group
+	root = p.ref
+	if root == "" {
+		root = msgid
+	}
+	perpage, _ := self.GetPagesPerBoard(newsgroup)
+	page = self.countThreadsBumpedSince(root, newsgroup) / int64(perpage)
+	return
+}
+
+func (self *MemoryDatabase) GetPageForRootMessage(root_message_id string) (group string, page int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	th, ok := self.threads[root_message_id]
+	if !ok {
+		err = sql.ErrNoRows
+		return
+	}
+	group = th.group
+	perpage, _ := self.GetPagesPerBoard(group)
+	page = self.countThreadsBumpedSince(root_message_id, group) / int64(perpage)
+	return
+}
+
+func (self *MemoryDatabase) RegisterSigned(message_id, pubkey string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.pubkeys[message_id] = pubkey
+	return
+}
+
+func (self *MemoryDatabase) ArticleCount() int64 {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return int64(len(self.posts))
+}
+
+func (self *MemoryDatabase) ThreadHasReplies(root_message_id string) bool {
+	return self.CountThreadReplies(root_message_id) > 0
+}
+
+func (self *MemoryDatabase) CountPostsInGroup(group string, time_frame int64) (count int64) {
+	if time_frame > 0 {
+		time_frame = timeNow() - time_frame
+	} else if time_frame < 0 {
+		time_frame = 0
+	}
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.posts {
+		if p.group == group && p.posted > time_frame {
+			count++
+		}
+	}
+	return
+}
+
+// get the replies to a thread oldest first, the last limit of them if limit > 0, must hold lock
+// leaves out hidden replies if visible is true
+func (self *MemoryDatabase) threadReplies(root_message_id string, start, limit int, visible bool) []*memPost {
+	repls := self.findPosts(func(p *memPost) bool {
+		return p.ref == root_message_id && !(visible && self.hasFlag(p.msgid, ArticleFlagHidden))
+	})
+	if limit > 0 && len(repls) > limit {
+		repls = repls[len(repls)-limit:]
+	}
+	if start > len(repls) {
+		start = len(repls)
+	}
+	return repls[start:]
+}
+
+func (self *MemoryDatabase) GetThreadReplies(root_message_id string, start, last int) (repls []string) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.threadReplies(root_message_id, start, last, false) {
+		repls = append(repls, p.msgid)
+	}
+	return
+}
+
+func (self *MemoryDatabase) CountThreadReplies(root_message_id string) int64 {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.countReplies(root_message_id)
+}
+
+func (self *MemoryDatabase) GetPostAttachments(message_id string) (atts []string) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, att := range self.attachments[message_id] {
+		atts = append(atts, att.filepath)
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetPostAttachmentModels(prefix, message_id string) []AttachmentModel {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.attachmentModels(prefix, message_id)
+}
+
+func (self *MemoryDatabase) GroupHasPosts(newsgroup string) bool {
+	count, _ := self.CountAllArticlesInGroup(newsgroup)
+	return count > 0
+}
+
+func (self *MemoryDatabase) GetGroupThreads(newsgroup string, recv chan ArticleEntry) {
+	self.access.RLock()
+	posts := self.findPosts(func(p *memPost) bool {
+		return p.group == newsgroup && p.ref == ""
+	})
+	self.access.RUnlock()
+	for _, p := range posts {
+		recv <- ArticleEntry{p.msgid, newsgroup}
+	}
+}
+
+func (self *MemoryDatabase) GetRootPostsForExpiration(newsgroup string, threadcount int) (roots []string) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	threads := self.findThreads(func(th *memThread) bool {
+		return th.group == newsgroup
+	})
+	if threadcount >= 0 && threadcount < len(threads) {
+		for _, th := range threads[threadcount:] {
+			roots = append(roots, th.root)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetGroupPageCount(newsgroup string) int64 {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	var count int64
+	for _, th := range self.threads {
+		if th.group == newsgroup {
+			count++
+		}
+	}
+	// divide by threads per page
+	return int64(math.Ceil(float64(count/10)) + 1)
+}
+
+// only fetches root posts
+// does not update the thread contents
+func (self *MemoryDatabase) GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel {
+	var threads []ThreadModel
+	pages := self.GetGroupPageCount(newsgroup)
+	self.access.RLock()
+	roots := self.findThreads(func(th *memThread) bool {
+		return th.group == newsgroup && !self.hasFlag(th.root, ArticleFlagHidden)
+	})
+	for idx, th := range roots {
+		if idx < pageno*perpage {
+			continue
+		} else if idx >= (pageno+1)*perpage {
+			break
+		}
+		p, ok := self.posts[th.root]
+		if ok {
+			threads = append(threads, createThreadModel(self.postModel(prefix, p)))
+		}
+	}
+	self.access.RUnlock()
+	return &boardModel{
+		prefix:   prefix,
+		frontend: frontend,
+		board:    newsgroup,
+		page:     pageno,
+		pages:    int(pages),
+		threads:  threads,
+	}
+}
+
+func (self *MemoryDatabase) GetLastBumpedThreads(newsgroup string, threadcount int) []ArticleEntry {
+	return self.GetLastBumpedThreadsPaginated(newsgroup, threadcount, 0)
+}
+
+func (self *MemoryDatabase) GetLastBumpedThreadsPaginated(newsgroup string, threadcount, offset int) (roots []ArticleEntry) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	threads := self.findThreads(func(th *memThread) bool {
+		if self.hasFlag(th.root, ArticleFlagHidden) {
+			return false
+		} else if len(newsgroup) > 0 {
+			return th.group == newsgroup
+		}
+		return th.group != "ctl"
+	})
+	for idx, th := range threads {
+		if idx < offset {
+			continue
+		} else if len(roots) == threadcount {
+			break
+		}
+		roots = append(roots, ArticleEntry{th.root, th.group})
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetThreadReplyPostModels(prefix, rootMessageID string, start, limit int) (repls []PostModel) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.threadReplies(rootMessageID, start, limit, true) {
+		repls = append(repls, self.postModel(prefix, p))
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetPostModel(prefix, messageID string) PostModel {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	p, ok := self.posts[messageID]
+	if ok {
+		return self.postModel(prefix, p)
+	}
+	log.Println("no post model for", messageID)
+	return nil
+}
+
+// check if a mod privilege is given, an empty newsgroup or permission matches any, must hold lock
+func (self *MemoryDatabase) hasModPriv(pubkey, newsgroup, permission string) bool {
+	for _, priv := range self.modPrivs {
+		if priv.pubkey == pubkey && (newsgroup == "" || priv.newsgroup == newsgroup) && (permission == "" || priv.permission == permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove mod privileges, an empty newsgroup or permission matches any, must hold lock
+func (self *MemoryDatabase) removeModPrivs(pubkey, newsgroup, permission string) {
+	var privs []memModPriv
+	for _, priv := range self.modPrivs {
+		if priv.pubkey == pubkey && (newsgroup == "" || priv.newsgroup == newsgroup) && (permission == "" || priv.permission == permission) {
+			continue
+		}
+		privs = append(privs, priv)
+	}
+	self.modPrivs = privs
+}
+
+func (self *MemoryDatabase) AddModPubkey(pubkey string) error {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.hasModPriv(pubkey, "", "") {
+		log.Println("did not add pubkey", pubkey, "already exists")
+		return nil
+	}
+	self.modPrivs = append(self.modPrivs, memModPriv{pubkey, "ctl", "login"})
+	return nil
+}
+
+func (self *MemoryDatabase) MarkModPubkeyGlobal(pubkey string) (err error) {
+	if len(pubkey) != 64 {
+		err = errors.New("invalid pubkey length")
+		return
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.hasModPriv(pubkey, "overchan", "all") {
+		// already marked
+		log.Println("pubkey already marked as global", pubkey)
+	} else {
+		self.modPrivs = append(self.modPrivs, memModPriv{pubkey, "overchan", "all"})
+	}
+	return
+}
+
+func (self *MemoryDatabase) UnMarkModPubkeyGlobal(pubkey string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.hasModPriv(pubkey, "overchan", "all") {
+		self.removeModPrivs(pubkey, "overchan", "all")
+	} else {
+		err = errors.New("public key not marked as global")
+	}
+	return
+}
+
+func (self *MemoryDatabase) CheckModPubkeyGlobal(pubkey string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.hasModPriv(pubkey, "overchan", "all")
+}
+
+func (self *MemoryDatabase) CheckModPubkey(pubkey string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.hasModPriv(pubkey, "", "")
+}
+
+func (self *MemoryDatabase) CheckAdminPubkey(pubkey string) (admin bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	admin = self.hasModPriv(pubkey, "", "admin")
+	return
+}
+
+func (self *MemoryDatabase) MarkPubkeyAdmin(pubkey string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if !self.hasModPriv(pubkey, "", "admin") {
+		// add as admin since it's not already there
+		self.modPrivs = append(self.modPrivs, memModPriv{pubkey, "overchan", "admin"})
+	}
+	return
+}
+
+func (self *MemoryDatabase) UnmarkPubkeyAdmin(pubkey string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.removeModPrivs(pubkey, "", "admin")
+	return
+}
+
+func (self *MemoryDatabase) CheckModPubkeyCanModGroup(pubkey, newsgroup string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.hasModPriv(pubkey, newsgroup, "")
+}
+
+func (self *MemoryDatabase) MarkModPubkeyCanModGroup(pubkey, newsgroup string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.modPrivs = append(self.modPrivs, memModPriv{pubkey, newsgroup, "all"})
+	return
+}
+
+func (self *MemoryDatabase) UnMarkModPubkeyCanModGroup(pubkey, newsgroup string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.removeModPrivs(pubkey, newsgroup, "")
+	return
+}
+
+func (self *MemoryDatabase) BanArticle(messageID, reason string) error {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if _, ok := self.bannedArticles[messageID]; ok {
+		log.Println(messageID, "already banned")
+		return nil
+	}
+	self.bannedArticles[messageID] = memTimed{reason, timeNow()}
+	return nil
+}
+
+func (self *MemoryDatabase) ArticleBanned(messageID string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, ok := self.bannedArticles[messageID]
+	return ok
+}
+
+func (self *MemoryDatabase) GetIPAddress(encAddr string) (addr string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, enc := range self.encAddrs {
+		if enc.encaddr == encAddr {
+			addr = enc.addr
+			break
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) CheckIPBanned(addr, newsgroup string) (banned bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	now := timeNow()
+	for _, ban := range self.ipBans {
+		if ban.active(now) && cidrContains(ban.addr, addr) && banCovers(ban.scope, newsgroup) {
+			banned = true
+			break
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) CheckEncIPBanned(encAddr, newsgroup string) (banned bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	now := timeNow()
+	for _, ban := range self.encIPBans {
+		if ban.active(now) && ban.addr == encAddr && banCovers(ban.scope, newsgroup) {
+			banned = true
+			break
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) BanAddr(addr, scope, reason, issuer string, expires int64) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.ipBans = append(self.ipBans, memBan{addr, scope, reason, issuer, timeNow(), expires})
+	return
+}
+
+// remove every ban that covers addr
+func (self *MemoryDatabase) UnbanAddr(addr string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var bans []memBan
+	for _, ban := range self.ipBans {
+		if !cidrContains(ban.addr, addr) {
+			bans = append(bans, ban)
+		}
+	}
+	self.ipBans = bans
+	return
+}
+
+func (self *MemoryDatabase) BanEncAddr(encAddr, scope, reason, issuer string, expires int64) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.encIPBans = append(self.encIPBans, memBan{encAddr, scope, reason, issuer, timeNow(), expires})
+	return
+}
+
+func (self *MemoryDatabase) UnbanEncAddr(encAddr string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var bans []memBan
+	for _, ban := range self.encIPBans {
+		if ban.addr != encAddr {
+			bans = append(bans, ban)
+		}
+	}
+	self.encIPBans = bans
+	return
+}
+
+func (self *MemoryDatabase) GetIPBans() (bans []IPBan, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	now := timeNow()
+	// newest first
+	for idx := len(self.ipBans) - 1; idx >= 0; idx-- {
+		if self.ipBans[idx].active(now) {
+			bans = append(bans, self.ipBans[idx].ipBan(false))
+		}
+	}
+	for idx := len(self.encIPBans) - 1; idx >= 0; idx-- {
+		if self.encIPBans[idx].active(now) {
+			bans = append(bans, self.encIPBans[idx].ipBan(true))
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) RecordModLog(entry ModLogEntry) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.modLogs = append(self.modLogs, entry)
+	return
+}
+
+func (self *MemoryDatabase) GetModLogs(q ModLogQuery) (entries []ModLogEntry, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	offset, limit := q.limits()
+	for idx := len(self.modLogs) - 1; idx >= 0 && len(entries) < limit; idx-- {
+		if q.matches(self.modLogs[idx]) {
+			if offset > 0 {
+				offset--
+			} else {
+				entries = append(entries, self.modLogs[idx])
+			}
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) ReportArticle(msgid, reason, reporter string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.reports[msgid] == nil {
+		self.reports[msgid] = make(map[string]memTimed)
+	}
+	if _, ok := self.reports[msgid][reporter]; !ok {
+		self.reports[msgid][reporter] = memTimed{reason, timeNow()}
+	}
+	return
+}
+
+func (self *MemoryDatabase) CountReportsBy(reporter string, since int64) (count int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, reporters := range self.reports {
+		if r, ok := reporters[reporter]; ok && r.t >= since {
+			count++
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetArticleReports() (reports []ArticleReports, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for msgid, reporters := range self.reports {
+		p, ok := self.posts[msgid]
+		if !ok {
+			continue
+		}
+		report := ArticleReports{MessageID: msgid, Newsgroup: p.group}
+		// reason -> count, first, last
+		reasons := make(map[string][3]int64)
+		for _, r := range reporters {
+			c := reasons[r.value]
+			c[0]++
+			if c[1] == 0 || r.t < c[1] {
+				c[1] = r.t
+			}
+			if r.t > c[2] {
+				c[2] = r.t
+			}
+			reasons[r.value] = c
+		}
+		for reason, c := range reasons {
+			report.add(reason, c[0], c[1], c[2])
+		}
+		reports = append(reports, report)
+	}
+	sortArticleReports(reports)
+	return
+}
+
+func (self *MemoryDatabase) DismissReports(msgid string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.reports, msgid)
+	return
+}
+
+func (self *MemoryDatabase) AddModDelegation(d ModDelegation) (err error) {
+	self.DelModDelegation(d.Pubkey, d.Granter, d.Scope)
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.delegations = append(self.delegations, d)
+	return
+}
+
+func (self *MemoryDatabase) DelModDelegation(pubkey, granter, scope string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var delegations []ModDelegation
+	for _, d := range self.delegations {
+		if d.Pubkey != pubkey || d.Granter != granter || d.Scope != scope {
+			delegations = append(delegations, d)
+		}
+	}
+	self.delegations = delegations
+	return
+}
+
+func (self *MemoryDatabase) GetModDelegations(pubkey string) (delegations []ModDelegation, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, d := range self.delegations {
+		if pubkey == "" || d.Pubkey == pubkey {
+			delegations = append(delegations, d)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) RegisterImageHash(fname string, hash ImageHash) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if _, ok := self.imageHashes[fname]; !ok {
+		self.imageHashes[fname] = hash
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetImageHash(fname string) (hash ImageHash, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	hash, ok := self.imageHashes[fname]
+	if !ok {
+		err = sql.ErrNoRows
+	}
+	return
+}
+
+func (self *MemoryDatabase) BanImageHash(hash ImageHash, reason string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, b := range self.bannedImages {
+		if b.Hash == hash {
+			return
+		}
+	}
+	self.bannedImages = append(self.bannedImages, BannedImage{hash, reason, timeNow()})
+	return
+}
+
+func (self *MemoryDatabase) UnbanImageHash(hash ImageHash) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var banned []BannedImage
+	for _, b := range self.bannedImages {
+		if b.Hash != hash {
+			banned = append(banned, b)
+		}
+	}
+	self.bannedImages = banned
+	return
+}
+
+func (self *MemoryDatabase) GetBannedImageHashes() (banned []BannedImage, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	banned = append(banned, self.bannedImages...)
+	return
+}
+
+func (self *MemoryDatabase) AddFilterRule(rule FilterRule) (id int64, err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.lastFilterID++
+	rule.ID = self.lastFilterID
+	rule.Time = timeNow()
+	self.filterRules = append(self.filterRules, rule)
+	id = rule.ID
+	return
+}
+
+func (self *MemoryDatabase) DelFilterRule(id int64) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var rules []FilterRule
+	for _, rule := range self.filterRules {
+		if rule.ID != id {
+			rules = append(rules, rule)
+		}
+	}
+	self.filterRules = rules
+	return
+}
+
+func (self *MemoryDatabase) GetFilterRules() (rules []FilterRule, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	rules = append(rules, self.filterRules...)
+	return
+}
+
+func (self *MemoryDatabase) TrainSpam(msgid string, tokens []string, spam bool) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var d SpamCounts
+	was, trained := self.spamTrained[msgid]
+	if trained && was == spam {
+		return
+	}
+	if spam {
+		d.Spam++
+	} else {
+		d.Ham++
+	}
+	if trained {
+		// a mod changed their mind, take back what we learned before
+		d.Spam, d.Ham = d.Spam-d.Ham, d.Ham-d.Spam
+	}
+	self.spamTrained[msgid] = spam
+	self.spamTotals.Spam += d.Spam
+	self.spamTotals.Ham += d.Ham
+	for _, token := range tokens {
+		c := self.spamTokens[token]
+		c.Spam += d.Spam
+		c.Ham += d.Ham
+		self.spamTokens[token] = c
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetSpamCounts(tokens []string) (counts map[string]SpamCounts, totals SpamCounts, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	counts = make(map[string]SpamCounts)
+	for _, token := range tokens {
+		if c, ok := self.spamTokens[token]; ok {
+			counts[token] = c
+		}
+	}
+	totals = self.spamTotals
+	return
+}
+
+func (self *MemoryDatabase) PurgeExpiredBans(now int64) (expired []IPBan, err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var bans []memBan
+	for _, ban := range self.ipBans {
+		if ban.active(now) {
+			bans = append(bans, ban)
+		} else {
+			expired = append(expired, ban.ipBan(false))
+		}
+	}
+	self.ipBans = bans
+	bans = nil
+	for _, ban := range self.encIPBans {
+		if ban.active(now) {
+			bans = append(bans, ban)
+		} else {
+			expired = append(expired, ban.ipBan(true))
+		}
+	}
+	self.encIPBans = bans
+	return
+}
+
+func (self *MemoryDatabase) GetEncAddress(addr string) (encaddr string, err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, enc := range self.encAddrs {
+		if enc.addr == addr {
+			encaddr = enc.encaddr
+			return
+		}
+	}
+	// needs to be made
+	var key string
+	key, encaddr = newAddrEnc(addr)
+	if len(encaddr) == 0 {
+		err = errors.New("failed to generate new encryption key")
+	} else {
+		self.encAddrs = append(self.encAddrs, memEncAddr{key, addr, encaddr})
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetEncKey(encAddr string) (enckey string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, enc := range self.encAddrs {
+		if enc.encaddr == encAddr {
+			enckey = enc.key
+			return
+		}
+	}
+	err = sql.ErrNoRows
+	return
+}
+
+// delete an article, must hold lock
+func (self *MemoryDatabase) deleteArticle(msgid string) {
+	delete(self.headers, msgid)
+	for group, nums := range self.numbers {
+		for idx, num := range nums {
+			if num.msgid == msgid {
+				self.numbers[group] = append(nums[:idx:idx], nums[idx+1:]...)
+				break
+			}
+		}
+	}
+	delete(self.posts, msgid)
+	delete(self.pubkeys, msgid)
+	delete(self.attachments, msgid)
+	delete(self.threads, msgid)
+}
+
+func (self *MemoryDatabase) DeleteArticle(msg_id string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.deleteArticle(msg_id)
+	return
+}
+
+func (self *MemoryDatabase) DeleteThread(root_msg_id string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.threads, root_msg_id)
+	return
+}
+
+func (self *MemoryDatabase) GetThreadsPerPage(group string) (int, error) {
+	//XXX: hardcoded
+	return 10, nil
+}
+
+func (self *MemoryDatabase) GetPagesPerBoard(group string) (int, error) {
+	//XXX: hardcoded
+	return 10, nil
+}
+
+func (self *MemoryDatabase) GetAllNewsgroups() (groups []string) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for group := range self.newsgroups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return
+}
+
+func (self *MemoryDatabase) GetPostsInGroup(group string) (models []PostModel, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.findPosts(func(p *memPost) bool { return p.group == group }) {
+		models = append(models, p.model())
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetLastAndFirstForGroup(group string) (last, first int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	nums := self.numbers[group]
+	if len(nums) == 0 {
+		last = 1
+	} else {
+		last, first = nums[len(nums)-1].no, nums[0].no
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetMessageIDForNNTPID(group string, id int64) (msgid string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, num := range self.numbers[group] {
+		if num.no == id {
+			msgid = num.msgid
+			return
+		}
+	}
+	err = sql.ErrNoRows
+	return
+}
+
+func (self *MemoryDatabase) GetNNTPIDForMessageID(group, msgid string) (id int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, num := range self.numbers[group] {
+		if num.msgid == msgid {
+			id = num.no
+			return
+		}
+	}
+	err = sql.ErrNoRows
+	return
+}
+
+func (self *MemoryDatabase) GetNextNNTPID(group string, n int64) (id int64, msgid string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, num := range self.numbers[group] {
+		if num.no > n {
+			id, msgid = num.no, num.msgid
+			break
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetPrevNNTPID(group string, n int64) (id int64, msgid string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, num := range self.numbers[group] {
+		if num.no >= n {
+			break
+		}
+		id, msgid = num.no, num.msgid
+	}
+	return
+}
+
+// count posts made in each of the last n days, optionally only in one newsgroup
+func (self *MemoryDatabase) getLastDaysPosts(newsgroup string, n int64) (posts []PostEntry) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	day := time.Hour * 24
+	now := time.Now().UTC()
+	now = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for n > 0 {
+		lo, hi := now.Unix(), now.Add(day).Unix()
+		var num int64
+		for _, p := range self.posts {
+			if p.posted > lo && p.posted < hi && (newsgroup == "" || p.group == newsgroup) {
+				num++
+			}
+		}
+		posts = append(posts, PostEntry{lo, num})
+		now = now.Add(-day)
+		n--
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetLastDaysPosts(n int64) []PostEntry {
+	return self.getLastDaysPosts("", n)
+}
+
+func (self *MemoryDatabase) GetLastDaysPostsForGroup(newsgroup string, n int64) []PostEntry {
+	return self.getLastDaysPosts(newsgroup, n)
+}
+
+func (self *MemoryDatabase) GetMonthlyPostHistory() (posts []PostEntry) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	var oldest int64
+	for _, p := range self.posts {
+		if p.posted > 0 && (oldest == 0 || p.posted < oldest) {
+			oldest = p.posted
+		}
+	}
+	if oldest == 0 {
+		// no posts
+		return
+	}
+	now := time.Now().UTC()
+	old := time.Unix(oldest, 0).UTC()
+	old = time.Date(old.Year(), old.Month(), 1, 0, 0, 0, 0, time.UTC)
+	// count up from oldest month to this one
+	for !old.After(now) {
+		next_month := old.AddDate(0, 1, 0)
+		var count int64
+		for _, p := range self.posts {
+			if p.posted >= old.Unix() && p.posted < next_month.Unix() {
+				count++
+			}
+		}
+		posts = append(posts, PostEntry{old.Unix(), count})
+		old = next_month
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetLastPostedPostModels(prefix string, n int64) (posts []PostModel) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	found := self.findPosts(func(p *memPost) bool {
+		return p.group != "ctl" && !self.hasFlag(p.msgid, ArticleFlagHidden)
+	})
+	// newest first
+	for idx := len(found) - 1; idx >= 0 && int64(len(posts)) < n; idx-- {
+		posts = append(posts, self.postModel(prefix, found[idx]))
+	}
+	return
+}
+
+func (self *MemoryDatabase) CheckNNTPLogin(username, passwd string) (valid bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	login, ok := self.logins[username]
+	if !ok {
+		err = sql.ErrNoRows
+	} else if len(login.hash) > 0 && len(login.salt) > 0 {
+		valid = nntpLoginCredHash(passwd, login.salt) == login.hash
+	}
+	return
+}
+
+func (self *MemoryDatabase) AddNNTPLogin(username, passwd string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if _, ok := self.logins[username]; ok {
+		err = errors.New("nntp user already exists")
+		return
+	}
+	login_salt := genLoginCredSalt()
+	self.logins[username] = memLogin{nntpLoginCredHash(passwd, login_salt), login_salt}
+	return
+}
+
+func (self *MemoryDatabase) RemoveNNTPLogin(username string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.logins, username)
+	return
+}
+
+func (self *MemoryDatabase) CheckNNTPUserExists(username string) (exists bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, exists = self.logins[username]
+	return
+}
+
+func (self *MemoryDatabase) GetMessageIDByHeader(name, value string) (msgids []string, err error) {
+	name = strings.ToLower(name)
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for msgid, hdrs := range self.headers {
+		for _, h := range hdrs {
+			if h.name == name && h.value == value {
+				msgids = append(msgids, msgid)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetHeadersForMessage(msgid string) (hdr ArticleHeaders, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	hdr = make(ArticleHeaders)
+	for _, h := range self.headers[msgid] {
+		hdr.Add(h.name, h.value)
+	}
+	return
+}
+
+// get message-ids of posts made with an encrypted address, must hold lock
+func (self *MemoryDatabase) postsByEncAddr(encaddr string) (msgids []string) {
+	for _, p := range self.findPosts(func(p *memPost) bool { return p.addr == encaddr }) {
+		msgids = append(msgids, p.msgid)
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetMessageIDByCIDR(cidr *net.IPNet) (msgids []string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, enc := range self.encAddrs {
+		ip := net.ParseIP(enc.addr)
+		if ip != nil && cidr.Contains(ip) {
+			msgids = append(msgids, self.postsByEncAddr(enc.encaddr)...)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetMessageIDByEncryptedIP(encaddr string) (msgids []string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	msgids = self.postsByEncAddr(encaddr)
+	return
+}
+
+func (self *MemoryDatabase) PubkeyIsBanned(pubkey string) (bool, error) {
+	// TODO: implement
+	return false, nil
+}
+
+func (self *MemoryDatabase) BanPubkey(pubkey string) (err error) {
+	// TODO: implement
+	err = errors.New("ban pubkey not implemented")
+	return
+}
+
+func (self *MemoryDatabase) GetPostsBefore(t time.Time) (msgids []string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, p := range self.findPosts(func(p *memPost) bool { return p.posted < t.Unix() }) {
+		msgids = append(msgids, p.msgid)
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetPostingStats(gran, begin, end int64) (st PostingStats, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return makePostingStats(gran, begin, end, func(lo, hi int64) (counts map[string]int64, err error) {
+		counts = make(map[string]int64)
+		for _, p := range self.posts {
+			if p.posted >= lo && p.posted < hi {
+				counts[p.group]++
+			}
+		}
+		return
+	})
+}
+
+// there is no index so we look at every post, terms match whole words and results are newest first
+func (self *MemoryDatabase) SearchPosts(prefix string, req SearchRequest) (posts []PostModel, more bool, err error) {
+	terms := parseSearchText(req.Text)
+	if len(terms) == 0 {
+		err = errors.New("nothing to search for")
+		return
+	}
+	offset, limit := req.limits()
+	self.access.RLock()
+	defer self.access.RUnlock()
+	found := self.findPosts(func(p *memPost) bool {
+		if req.Newsgroup != "" && p.group != req.Newsgroup {
+			return false
+		} else if req.After > 0 && p.posted < req.After {
+			return false
+		} else if req.Before > 0 && p.posted >= req.Before {
+			return false
+		} else if req.HasAttachment && len(self.attachments[p.msgid]) == 0 {
+			return false
+		} else if self.hasFlag(p.msgid, ArticleFlagHidden) {
+			return false
+		}
+		// pad with spaces so we only match whole words
+		text := " " + strings.Join(searchWords(p.subject+" "+p.name+" "+p.message), " ") + " "
+		for _, term := range terms {
+			if strings.Contains(text, " "+strings.Join(term.words, " ")+" ") == term.negate {
+				return false
+			}
+		}
+		return true
+	})
+	for idx := len(found) - 1 - offset; idx >= 0; idx-- {
+		if len(posts) == limit {
+			more = true
+			break
+		}
+		posts = append(posts, self.postModel(prefix, found[idx]))
+	}
+	return
+}
+
+func (self *MemoryDatabase) SearchByHash(prefix, group, posthash string, chnl chan PostModel) (err error) {
+	var found []*memArticle
+	if posthash != "" {
+		self.access.RLock()
+		for _, a := range self.articles {
+			if strings.Contains(a.hash, posthash) && (group == "" || a.group == group) {
+				found = append(found, a)
+			}
+		}
+		self.access.RUnlock()
+	}
+	// newest first
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].seq > found[j].seq
+	})
+	for _, a := range found {
+		chnl <- &post{
+			board:      a.group,
+			Message_id: a.msgid,
+			Parent:     a.ref,
+		}
+	}
+	close(chnl)
+	return
+}
+
+func (self *MemoryDatabase) GetThreadModel(prefix, root_msgid string) (th ThreadModel, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	var posts []PostModel
+	if self.hasFlag(root_msgid, ArticleFlagHidden) {
+		err = errors.New("no such thread " + root_msgid)
+		return
+	}
+	for _, p := range self.findPosts(func(p *memPost) bool {
+		return (p.msgid == root_msgid || p.ref == root_msgid) && !self.hasFlag(p.msgid, ArticleFlagHidden)
+	}) {
+		model := self.postModel(prefix, p)
+		model.Parent = root_msgid
+		model.op = p.msgid == root_msgid
+		posts = append(posts, model)
+	}
+	if len(posts) == 0 {
+		err = errors.New("no such thread " + root_msgid)
+		return
+	}
+	th = createThreadModel(posts...)
+	return
+}
+
+func (self *MemoryDatabase) GetNNTPPostsInGroup(newsgroup string) (models []PostModel, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, num := range self.numbers[newsgroup] {
+		p, ok := self.posts[num.msgid]
+		if ok && !self.hasFlag(num.msgid, ArticleFlagQuarantined) {
+			model := p.model()
+			model.Newsgroup = newsgroup
+			model.nntp_id = int(num.no)
+			models = append(models, model)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetCitesByPostHashLike(like string) (cites []MessageIDTuple, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, a := range self.articles {
+		if strings.HasPrefix(a.hash, like) {
+			cites = append(cites, MessageIDTuple{a.msgid, a.ref})
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetNNTPHeadersInRange(newsgroup string, lo, hi int64, names []string) (overviews []NNTPOverview, err error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for _, num := range self.numbers[newsgroup] {
+		if num.no < lo || (hi >= 0 && num.no > hi) || self.hasFlag(num.msgid, ArticleFlagQuarantined) {
+			continue
+		}
+		ov := NNTPOverview{
+			Number:    num.no,
+			MessageID: num.msgid,
+			Headers:   make(ArticleHeaders),
+		}
+		for _, h := range self.headers[num.msgid] {
+			if wanted[h.name] {
+				ov.Headers.Add(h.name, h.value)
+			}
+		}
+		overviews = append(overviews, ov)
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetArticlesObtainedSince(t int64) (articles []ArticleEntry, err error) {
+	self.access.RLock()
+	var found []*memArticle
+	for _, a := range self.articles {
+		if a.obtained >= t && !self.hasFlag(a.msgid, ArticleFlagQuarantined) {
+			found = append(found, a)
+		}
+	}
+	self.access.RUnlock()
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].seq < found[j].seq
+	})
+	for _, a := range found {
+		articles = append(articles, ArticleEntry{a.msgid, a.group})
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetNewsgroupsCreatedSince(t int64) (groups []string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for group, g := range self.newsgroups {
+		if g.created >= t {
+			groups = append(groups, group)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetFeedSyncTime(feed string) (t int64, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	t = self.syncTimes[feed]
+	return
+}
+
+func (self *MemoryDatabase) SetFeedSyncTime(feed string, t int64) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.syncTimes[feed] = t
+	return
+}
+
+func (self *MemoryDatabase) RecordFeedRejection(feed, msgid, reason string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	rejected, ok := self.rejections[feed]
+	if !ok {
+		rejected = make(map[string]memTimed)
+		self.rejections[feed] = rejected
+	}
+	rejected[msgid] = memTimed{reason, timeNow()}
+	return
+}
+
+func (self *MemoryDatabase) FeedRejectedArticle(feed, msgid string) (rejected bool, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	_, rejected = self.rejections[feed][msgid]
+	return
+}
+
+func (self *MemoryDatabase) GetArticleRejections(msgid string) (feeds []string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for feed, rejected := range self.rejections {
+		if _, ok := rejected[msgid]; ok {
+			feeds = append(feeds, feed)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetFeedRejections(feed string) (msgids []string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for msgid := range self.rejections[feed] {
+		msgids = append(msgids, msgid)
+	}
+	return
+}
+
+func (self *MemoryDatabase) ClearFeedRejections(feed string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.rejections, feed)
+	return
+}
+
+func (self *MemoryDatabase) ExpireFeedRejections(t int64) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, rejected := range self.rejections {
+		for msgid, r := range rejected {
+			if r.t < t {
+				delete(rejected, msgid)
+			}
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) RecordMessageHistory(msgid, disposition string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.history[msgid] = memTimed{disposition, timeNow()}
+	return
+}
+
+func (self *MemoryDatabase) GetMessageHistory(msgid string) (disposition string, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	disposition = self.history[msgid].value
+	return
+}
+
+func (self *MemoryDatabase) ExpireMessageHistory(t int64) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for msgid, h := range self.history {
+		if h.t < t {
+			delete(self.history, msgid)
+		}
+	}
+	return
+}
+
+func (self *MemoryDatabase) GetAllKnownMessageIDs(send chan string) (err error) {
+	known := make(map[string]bool)
+	self.access.RLock()
+	for msgid := range self.articles {
+		known[msgid] = true
+	}
+	for msgid := range self.bannedArticles {
+		known[msgid] = true
+	}
+	for msgid := range self.history {
+		known[msgid] = true
+	}
+	self.access.RUnlock()
+	for msgid := range known {
+		send <- msgid
+	}
+	return
+}
+
+func (self *MemoryDatabase) MarkArticleFlag(msgid, flag string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.flags[msgid] == nil {
+		self.flags[msgid] = make(map[string]int64)
+	}
+	if !self.hasFlag(msgid, flag) {
+		self.flags[msgid][flag] = timeNow()
+	}
+	return
+}
+
+func (self *MemoryDatabase) UnmarkArticleFlag(msgid, flag string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.flags[msgid], flag)
+	if len(self.flags[msgid]) == 0 {
+		delete(self.flags, msgid)
+	}
+	return
+}
+
+func (self *MemoryDatabase) ArticleHasFlag(msgid, flag string) bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.hasFlag(msgid, flag)
+}
+
+func (self *MemoryDatabase) GetQuarantinedArticles() (articles []QuarantinedArticle, err error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	for msgid, flags := range self.flags {
+		t, ok := flags[ArticleFlagQuarantined]
+		p, has := self.posts[msgid]
+		if ok && has {
+			articles = append(articles, QuarantinedArticle{
+				MessageID: msgid,
+				Newsgroup: p.group,
+				Name:      p.name,
+				Subject:   p.subject,
+				Message:   p.message,
+				Posted:    p.posted,
+				Time:      t,
+			})
+		}
+	}
+	sort.Slice(articles, func(i, j int) bool {
+		if articles[i].Time == articles[j].Time {
+			return articles[i].MessageID < articles[j].MessageID
+		}
+		return articles[i].Time < articles[j].Time
+	})
+	return
+}
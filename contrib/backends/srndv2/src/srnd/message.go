@@ -440,6 +440,7 @@ func (self *nntpArticle) WriteBody(wr io.Writer, limit int64) (err error) {
 
 // verify a signed message's body
 // innerHandler must close reader when done
+// innerHandler is done before we return
 // returns error if one happens while verifying article
 func verifyMessage(pk, sig string, body *io.LimitedReader, innerHandler func(map[string][]string, io.Reader)) (err error) {
 	log.Println("unwrapping signed message from", pk)
@@ -447,6 +448,7 @@ func verifyMessage(pk, sig string, body *io.LimitedReader, innerHandler func(map
 	sig_bytes := unhex(sig)
 	h := sha512.New()
 	pr, pw := io.Pipe()
+	done := make(chan struct{})
 	// read header
 	// handle inner body
 	go func(hdr_reader *io.PipeReader) {
@@ -456,6 +458,7 @@ func verifyMessage(pk, sig string, body *io.LimitedReader, innerHandler func(map
 			innerHandler(msg.Header, msg.Body)
 		}
 		hdr_reader.Close()
+		close(done)
 	}(pr)
 	body = &io.LimitedReader{
 		R: io.TeeReader(body, pw),
@@ -476,5 +479,7 @@ func verifyMessage(pk, sig string, body *io.LimitedReader, innerHandler func(map
 	}
 	// flush pipe
 	pw.Close()
+	// callers rely on what innerHandler did
+	<-done
 	return
 }
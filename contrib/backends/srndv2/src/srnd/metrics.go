@@ -0,0 +1,270 @@
+//
+// metrics.go -- prometheus metrics
+//
+package srnd
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buckets in seconds for database queries
+var dbQueryBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// buckets in seconds for making thumbnails
+var thumbnailBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// a named metric with labels in prometheus text exposition format
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+	access  sync.Mutex
+}
+
+// one set of label values of a metric
+type metricSeries struct {
+	labels []string
+	// value of counter or gauge, sum of histogram
+	value   float64
+	count   uint64
+	buckets []uint64
+}
+
+func newMetricFamily(kind, name, help string, labels ...string) *metricFamily {
+	return &metricFamily{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+}
+
+func newCounter(name, help string, labels ...string) *metricFamily {
+	return newMetricFamily("counter", name, help, labels...)
+}
+
+func newGauge(name, help string, labels ...string) *metricFamily {
+	return newMetricFamily("gauge", name, help, labels...)
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricFamily {
+	m := newMetricFamily("histogram", name, help, labels...)
+	m.buckets = buckets
+	return m
+}
+
+// get series for label values, must hold lock
+func (self *metricFamily) get(values []string) (s *metricSeries) {
+	k := strings.Join(values, "\x00")
+	s = self.series[k]
+	if s == nil {
+		s = &metricSeries{
+			labels:  values,
+			buckets: make([]uint64, len(self.buckets)),
+		}
+		self.series[k] = s
+	}
+	return
+}
+
+// add to a counter or gauge
+func (self *metricFamily) Add(v float64, values ...string) {
+	self.access.Lock()
+	self.get(values).value += v
+	self.access.Unlock()
+}
+
+// set a gauge
+func (self *metricFamily) Set(v float64, values ...string) {
+	self.access.Lock()
+	self.get(values).value = v
+	self.access.Unlock()
+}
+
+// put a value into a histogram
+func (self *metricFamily) Observe(v float64, values ...string) {
+	self.access.Lock()
+	s := self.get(values)
+	s.value += v
+	s.count++
+	for idx, b := range self.buckets {
+		if v <= b {
+			s.buckets[idx]++
+		}
+	}
+	self.access.Unlock()
+}
+
+// put the time since start in seconds into a histogram
+func (self *metricFamily) ObserveSince(start time.Time, values ...string) {
+	self.Observe(time.Since(start).Seconds(), values...)
+}
+
+func escapeMetricLabel(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	str = strings.Replace(str, `"`, `\"`, -1)
+	return strings.Replace(str, "\n", `\n`, -1)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// render label values as {name="value",...} with optional extra label
+func (self *metricFamily) formatLabels(values []string, extra ...string) string {
+	var pairs []string
+	for idx, name := range self.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeMetricLabel(values[idx])))
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[0], extra[1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// write metric in text exposition format
+func (self *metricFamily) Write(w io.Writer) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", self.name, self.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", self.name, self.kind)
+	var keys []string
+	for k := range self.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := self.series[k]
+		if self.kind == "histogram" {
+			for idx, b := range self.buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", self.name, self.formatLabels(s.labels, "le", formatMetricValue(b)), s.buckets[idx])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", self.name, self.formatLabels(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", self.name, self.formatLabels(s.labels), formatMetricValue(s.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", self.name, self.formatLabels(s.labels), s.count)
+		} else {
+			fmt.Fprintf(w, "%s%s %s\n", self.name, self.formatLabels(s.labels), formatMetricValue(s.value))
+		}
+	}
+}
+
+// all metrics we collect while running
+type metricsRegistry struct {
+	articlesAccepted *metricFamily
+	articlesRejected *metricFamily
+	connections      *metricFamily
+	dbQuerySeconds   *metricFamily
+	thumbnailSeconds *metricFamily
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		articlesAccepted: newCounter("srnd_articles_accepted_total", "articles we stored from feeds and posters"),
+		articlesRejected: newCounter("srnd_articles_rejected_total", "articles we refused by reason", "reason"),
+		connections:      newGauge("srnd_nntp_connections", "open nntp connections", "direction"),
+		dbQuerySeconds:   newHistogram("srnd_database_query_seconds", "database query latency", dbQueryBuckets, "query"),
+		thumbnailSeconds: newHistogram("srnd_thumbnail_seconds", "time taken to make thumbnails", thumbnailBuckets, "kind"),
+	}
+}
+
+var metrics = newMetricsRegistry()
+
+// get the part of a rejection reason that doesn't vary per article
+// so we don't make a new series for every message-id or newsgroup
+func metricReason(reason string) string {
+	idx := strings.IndexAny(reason, ":'")
+	if idx > 0 {
+		reason = reason[:idx]
+	}
+	return strings.TrimSpace(reason)
+}
+
+// record an article we refused
+func (self *metricsRegistry) Rejected(reason string) {
+	self.articlesRejected.Add(1, metricReason(reason))
+}
+
+// record an nntp connection opening or closing
+func (self *metricsRegistry) Connection(inbound bool, delta float64) {
+	if inbound {
+		self.connections.Add(delta, "inbound")
+	} else {
+		self.connections.Add(delta, "outbound")
+	}
+}
+
+// sql connection that records how long each query took
+// queries are labeled by the name of the prepared statement
+type metricsDB struct {
+	*sql.DB
+	// statement name by statement
+	names map[string]string
+}
+
+func (self *metricsDB) queryName(query string) string {
+	name, ok := self.names[query]
+	if ok {
+		return name
+	}
+	return "other"
+}
+
+func (self *metricsDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer metrics.dbQuerySeconds.ObserveSince(time.Now(), self.queryName(query))
+	return self.DB.Exec(query, args...)
+}
+
+func (self *metricsDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer metrics.dbQuerySeconds.ObserveSince(time.Now(), self.queryName(query))
+	return self.DB.Query(query, args...)
+}
+
+func (self *metricsDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer metrics.dbQuerySeconds.ObserveSince(time.Now(), self.queryName(query))
+	return self.DB.QueryRow(query, args...)
+}
+
+// serve /metrics
+func (self *NNTPDaemon) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// things we look at when we're scraped
+	backlog := newGauge("srnd_feed_backlog_bytes", "bytes queued to send per feed connection", "feed", "connection")
+	feedConns := newGauge("srnd_feed_connections", "open outbound connections per feed", "feed")
+	spool := newGauge("srnd_feed_spool_depth", "articles in the outbound spool per feed", "feed")
+	for _, status := range self.activeFeeds() {
+		feedConns.Set(float64(len(status.Conns)), status.State.Config.Name)
+		spool.Set(float64(status.SpoolDepth), status.State.Config.Name)
+		for _, conn := range status.Conns {
+			backlog.Set(float64(conn.GetBacklog()), status.State.Config.Name, conn.name)
+		}
+	}
+	regen := newGauge("srnd_cache_regen_queue", "pages waiting to be regenerated", "kind")
+	if cache, ok := self.cache.(*FileCache); ok {
+		threads, boards, catalogs := cache.RegenQueueSizes()
+		regen.Set(float64(threads), "thread")
+		regen.Set(float64(boards), "board")
+		regen.Set(float64(catalogs), "catalog")
+	}
+
+	for _, m := range []*metricFamily{backlog, feedConns, spool, regen,
+		metrics.articlesAccepted, metrics.articlesRejected, metrics.connections,
+		metrics.dbQuerySeconds, metrics.thumbnailSeconds} {
+		m.Write(w)
+	}
+}
@@ -0,0 +1,146 @@
+//
+// migrations.go -- versioned database schema upgrades
+//
+package srnd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// a schema migration that was applied or is waiting to be
+type SchemaMigration struct {
+	Version int
+	Name    string
+	// unix time when it was applied, 0 if pending
+	Applied int64
+}
+
+// one step that brings the schema to version
+type dbMigration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// applies migrations in order and records each one in the SchemaMigrations table
+type schemaMigrator struct {
+	conn       *metricsDB
+	migrations []dbMigration
+	// the version databases from before migrations kept in the Settings table, -1 for none
+	// everything up to it is recorded as applied the first time we run
+	legacyVersion func() int
+}
+
+// make sure the SchemaMigrations table exists and has the legacy version in it
+func (self *schemaMigrator) init() (err error) {
+	_, err = self.conn.Exec(`CREATE TABLE IF NOT EXISTS SchemaMigrations (
+                             version INTEGER PRIMARY KEY,
+                             name VARCHAR(255) NOT NULL,
+                             time_applied INTEGER NOT NULL
+                           )`)
+	if err != nil {
+		return
+	}
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM SchemaMigrations").Scan(&count)
+	if err != nil || count > 0 {
+		return
+	}
+	legacy := self.legacyVersion()
+	if legacy < 0 {
+		// new database
+		return
+	}
+	log.Println("recording schema version", legacy, "from settings as applied")
+	now := timeNow()
+	for _, m := range self.migrations {
+		if m.version <= legacy {
+			_, err = self.conn.Exec("INSERT INTO SchemaMigrations(version, name, time_applied) VALUES($1, $2, $3)", m.version, m.name, now)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// get every migration we know about, pending ones have Applied set to 0
+func (self *schemaMigrator) Status() (migrations []SchemaMigration, err error) {
+	err = self.init()
+	if err != nil {
+		return
+	}
+	applied := make(map[int]int64)
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT version, time_applied FROM SchemaMigrations")
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var version int
+		var t int64
+		rows.Scan(&version, &t)
+		applied[version] = t
+	}
+	rows.Close()
+	for _, m := range self.migrations {
+		migrations = append(migrations, SchemaMigration{
+			Version: m.version,
+			Name:    m.name,
+			Applied: applied[m.version],
+		})
+	}
+	return
+}
+
+// apply all pending migrations in order, each in its own transaction
+func (self *schemaMigrator) Migrate() (err error) {
+	var status []SchemaMigration
+	status, err = self.Status()
+	if err != nil {
+		return
+	}
+	for idx, st := range status {
+		if st.Applied > 0 {
+			continue
+		}
+		m := self.migrations[idx]
+		log.Printf("migrating... to version %d, %s", m.version, m.name)
+		var tx *sql.Tx
+		tx, err = self.conn.Begin()
+		if err != nil {
+			return
+		}
+		err = m.up(tx)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO SchemaMigrations(version, name, time_applied) VALUES($1, $2, $3)", m.version, m.name, timeNow())
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			err = fmt.Errorf("migration to version %d failed: %s", m.version, err)
+			return
+		}
+	}
+	log.Println("we are up to date at version", self.migrations[len(self.migrations)-1].version)
+	return
+}
+
+// make a migration step that runs each statement in order
+func execMigration(cmds ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) (err error) {
+		for _, cmd := range cmds {
+			_, err = tx.Exec(cmd)
+			if err != nil {
+				err = fmt.Errorf("`%s`: %s", cmd, err)
+				break
+			}
+		}
+		return
+	}
+}
@@ -6,11 +6,14 @@ package srnd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -36,6 +39,16 @@ type ModUI interface {
 	HandleBanAddress(wr http.ResponseWriter, r *http.Request)
 	// handle an unban address request
 	HandleUnbanAddress(wr http.ResponseWriter, r *http.Request)
+	// handle dismissing the reports of a post
+	HandleDismissReports(wr http.ResponseWriter, r *http.Request)
+	// handle telling the spam classifier a post is not spam
+	HandleMarkHam(wr http.ResponseWriter, r *http.Request)
+	// handle letting a quarantined article through
+	HandleApproveArticle(wr http.ResponseWriter, r *http.Request)
+	// handle throwing away a quarantined article
+	HandleRejectArticle(wr http.ResponseWriter, r *http.Request)
+	// handle banning the images of a post
+	HandleBanImages(wr http.ResponseWriter, r *http.Request)
 	// handle add a pubkey
 	HandleAddPubkey(wr http.ResponseWriter, r *http.Request)
 	// handle removing a pubkey
@@ -50,6 +63,11 @@ type ModUI interface {
 
 type ModAction string
 
+// why a mod event was not done
+var ErrModEventExpired = errors.New("mod event expired")
+var ErrNotInScope = errors.New("target not in scope")
+var ErrInvalidModAction = errors.New("invalid mod action")
+
 const ModInetBan = ModAction("overchan-inet-ban")
 const ModDelete = ModAction("delete")
 const ModRemoveAttachment = ModAction("overchan-del-attachment")
@@ -57,8 +75,50 @@ const ModStick = ModAction("overchan-stick")
 const ModLock = ModAction("overchan-lock")
 const ModHide = ModAction("overchan-hide")
 const ModSage = ModAction("overchan-sage")
+const ModUnstick = ModAction("overchan-unstick")
+const ModUnlock = ModAction("overchan-unlock")
+const ModUnhide = ModAction("overchan-unhide")
+const ModUnsage = ModAction("overchan-unsage")
 const ModDeleteAlt = ModAction("delete")
 
+// the article flag each mod action gives
+var modActionFlags = map[ModAction]string{
+	ModStick: ArticleFlagSticky,
+	ModLock:  ArticleFlagLocked,
+	ModHide:  ArticleFlagHidden,
+	ModSage:  ArticleFlagSage,
+}
+
+// the article flag each mod action takes away
+var modActionUnflags = map[ModAction]string{
+	ModUnstick: ArticleFlagSticky,
+	ModUnlock:  ArticleFlagLocked,
+	ModUnhide:  ArticleFlagHidden,
+	ModUnsage:  ArticleFlagSage,
+}
+
+// the scope of mod events that don't give one, every board
+const ModScopeGlobal = "overchan.*"
+
+// check if a newsgroup is in the scope of a mod event or ban
+// the scope is a newsgroup or a regex that has to match the whole newsgroup
+func modScopeMatches(scope, newsgroup string) bool {
+	if scope == ModScopeGlobal {
+		// what everything was before we had scopes
+		return true
+	}
+	if newsgroupValidFormat(scope) {
+		// a newsgroup is only itself, the dots in it are not wildcards
+		return scope == newsgroup
+	}
+	re, err := regexp.Compile("^(" + scope + ")$")
+	if err != nil {
+		log.Println("invalid mod scope", scope, err)
+		return false
+	}
+	return re.MatchString(newsgroup)
+}
+
 type ModEvent interface {
 	// turn it into a string for putting into an article
 	String() string
@@ -70,28 +130,54 @@ type ModEvent interface {
 	Target() string
 	// scope of the event, regex of newsgroup
 	Scope() string
-	// when this mod event expires, unix nano
+	// when this mod event expires, unix seconds or -1 for never
 	Expires() int64
 }
 
+// a mod event line
+// "action target" optionally followed by "scope=regex", "expires=unixtime" and "reason=text"
+// reason goes last and takes the rest of the line
+// old nodes only look at the action and target so they still understand it
 type simpleModEvent string
 
 func (self simpleModEvent) String() string {
 	return string(self)
 }
 
+// get the key=value options after the target
+func (self simpleModEvent) options() map[string]string {
+	opts := make(map[string]string)
+	parts := strings.Split(string(self), " ")
+	for idx := 2; idx < len(parts); idx++ {
+		kv := strings.SplitN(parts[idx], "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == "reason" {
+			opts[kv[0]] = strings.Join(append([]string{kv[1]}, parts[idx+1:]...), " ")
+			break
+		}
+		opts[kv[0]] = kv[1]
+	}
+	return opts
+}
+
 func (self simpleModEvent) Action() ModAction {
-	switch strings.Split(string(self), " ")[0] {
-	case "delete":
-		return ModDelete
-	case "overchan-inet-ban":
-		return ModInetBan
+	action := ModAction(strings.Split(string(self), " ")[0])
+	switch action {
+	case ModDelete, ModInetBan, ModDelegate, ModRevoke, ModImageBan:
+		return action
+	}
+	if _, ok := modActionFlags[action]; ok {
+		return action
+	} else if _, ok = modActionUnflags[action]; ok {
+		return action
 	}
 	return ""
 }
 
 func (self simpleModEvent) Reason() string {
-	return ""
+	return self.options()["reason"]
 }
 
 func (self simpleModEvent) Target() string {
@@ -103,13 +189,44 @@ func (self simpleModEvent) Target() string {
 }
 
 func (self simpleModEvent) Scope() string {
-	// TODO: hard coded
-	return "overchan.*"
+	scope, ok := self.options()["scope"]
+	if ok && scope != "" {
+		return scope
+	}
+	return ModScopeGlobal
 }
 
 func (self simpleModEvent) Expires() int64 {
-	// no expiration
-	return -1
+	expires, ok := self.options()["expires"]
+	if !ok && self.Action() == ModInetBan {
+		// encaddr:key:expires
+		parts := strings.Split(self.Target(), ":")
+		if len(parts) == 3 {
+			expires = parts[2]
+		}
+	}
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || t < 0 {
+		// no expiration
+		return -1
+	}
+	return t
+}
+
+// add scope, reason and expiration to a mod event
+func scopedModEvent(ev ModEvent, scope, reason string, expires int64) ModEvent {
+	line := string(ev.Action()) + " " + ev.Target()
+	if scope != "" && scope != ModScopeGlobal {
+		line += " scope=" + scope
+	}
+	if expires >= 0 {
+		line += fmt.Sprintf(" expires=%d", expires)
+	}
+	reason = strings.Join(strings.Fields(reason), " ")
+	if reason != "" {
+		line += " reason=" + reason
+	}
+	return simpleModEvent(line)
 }
 
 // create an overchan-delete mod event
@@ -122,6 +239,32 @@ func overchanInetBan(encAddr, key string, expire int64) ModEvent {
 	return simpleModEvent(fmt.Sprintf("overchan-inet-ban %s:%s:%d", encAddr, key, expire))
 }
 
+// create an overchan-image-ban mod event
+func overchanImageBan(hash ImageHash, reason string) ModEvent {
+	return scopedModEvent(simpleModEvent(string(ModImageBan)+" "+hash.String()), "", reason, -1)
+}
+
+// create an overchan-delegate mod event giving pubkey actions in scope until expires
+// no actions gives every action but delegating
+func overchanDelegate(pubkey, scope string, actions []ModAction, expires int64) ModEvent {
+	line := string(ModDelegate) + " " + pubkey
+	if scope != "" && scope != ModScopeGlobal {
+		line += " scope=" + scope
+	}
+	if len(actions) > 0 {
+		line += " actions=" + ModDelegation{Actions: actions}.actionsString()
+	}
+	if expires >= 0 {
+		line += fmt.Sprintf(" expires=%d", expires)
+	}
+	return simpleModEvent(line)
+}
+
+// create an overchan-revoke mod event taking away what we gave pubkey in scope
+func overchanRevoke(pubkey, scope string) ModEvent {
+	return scopedModEvent(simpleModEvent(string(ModRevoke)+" "+pubkey), scope, "", -1)
+}
+
 // moderation message
 // wraps multiple mod events
 // is turned into an NNTPMessage later
@@ -171,25 +314,41 @@ type ModEngine interface {
 	HandleMessage(msgid string)
 	// delete post of a poster
 	DeletePost(msgid string) error
-	// ban a cidr
-	BanAddress(cidr string) error
+	// ban a cidr from newsgroups in scope until expires for the mod with pubkey issuer
+	BanAddress(cidr, scope, reason, issuer string, expires int64) error
+	// give or take away a moderation flag of an article
+	// thread flags go on the root post of the thread the article is in
+	FlagArticle(msgid, flag string, set bool) error
 	// do we allow this public key to delete this message-id ?
 	AllowDelete(pubkey, msgid string) bool
-	// do we allow this public key to do inet-ban?
-	AllowBan(pubkey string) bool
+	// do we allow this public key to do inet-ban in scope?
+	AllowBan(pubkey, scope string) bool
+	// do we allow this public key to ban images everywhere?
+	AllowImageBan(pubkey string) bool
+	// do we allow this public key to give and take away mod rights in scope?
+	AllowDelegate(pubkey, scope string) bool
+	// did an admin give this public key the right to do action in scope, directly or through other keys?
+	Delegated(pubkey, scope string, action ModAction) bool
+	// store the mod rights an overchan-delegate mod event from granter gives
+	Delegate(ev ModEvent, granter string) error
+	// take away the mod rights an overchan-revoke mod event from revoker covers
+	Revoke(ev ModEvent, revoker string) error
 	// allow janitor
 	AllowJanitor(pubkey string) bool
 	// load a mod message
 	LoadMessage(msgid string) NNTPMessage
-	// execute 1 mod action line by a mod with pubkey
-	Execute(ev ModEvent, pubkey string)
-	// do a mod event unconditionally
-	Do(ev ModEvent)
+	// execute 1 mod action line by a mod with pubkey from ctl message msgid
+	// records it in the mod log if it's a valid action
+	Execute(ev ModEvent, pubkey, msgid string)
+	// do a mod event from the mod with pubkey unconditionally
+	// returns why it was not done
+	Do(ev ModEvent, pubkey string) error
 }
 
 type modEngine struct {
 	database Database
 	store    ArticleStore
+	history  MessageHistory
 	regen    RegenFunc
 }
 
@@ -197,8 +356,21 @@ func (self *modEngine) LoadMessage(msgid string) NNTPMessage {
 	return self.store.GetMessage(msgid)
 }
 
-func (self *modEngine) BanAddress(cidr string) (err error) {
-	return self.database.BanAddr(cidr)
+func (self *modEngine) BanAddress(cidr, scope, reason, issuer string, expires int64) (err error) {
+	return self.database.BanAddr(cidr, scope, reason, issuer, expires)
+}
+
+// check if the newsgroup of a post is in the scope of a mod event
+func (self *modEngine) inScope(msgid, scope string) bool {
+	if scope == ModScopeGlobal {
+		return true
+	}
+	_, group, _, err := self.database.GetInfoForMessage(msgid)
+	if err != nil {
+		log.Println("cannot tell if", msgid, "is in scope", scope, err)
+		return false
+	}
+	return modScopeMatches(scope, group)
 }
 
 func (self *modEngine) DeletePost(msgid string) (err error) {
@@ -257,8 +429,11 @@ func (self *modEngine) DeletePost(msgid string) (err error) {
 		if err != nil {
 			log.Println(err)
 		}
+		// nothing left to report
+		self.database.DismissReports(delmsg)
 		// ban article
 		self.database.BanArticle(delmsg, "deleted by moderator")
+		self.history.Record(delmsg, HistoryBanned)
 		self.store.Remove(delmsg)
 	}
 
@@ -269,13 +444,125 @@ func (self *modEngine) DeletePost(msgid string) (err error) {
 	return nil
 }
 
-func (self *modEngine) AllowBan(pubkey string) bool {
+func (self *modEngine) FlagArticle(msgid, flag string, set bool) (err error) {
+	if !ValidMessageID(msgid) {
+		err = errors.New("invalid message-id " + msgid)
+		return
+	}
+	root, group, page, infoErr := self.database.GetInfoForMessage(msgid)
+	if infoErr == nil && flag != ArticleFlagHidden {
+		// everything but hiding is done to the whole thread
+		msgid = root
+	} else if infoErr != nil {
+		// we don't have it (yet), flag it anyways for when we do
+		log.Println("flagging", msgid, "as", flag, "but we don't have it")
+	}
+	if set {
+		err = self.database.MarkArticleFlag(msgid, flag)
+	} else {
+		err = self.database.UnmarkArticleFlag(msgid, flag)
+	}
+	if err == nil && infoErr == nil {
+		self.regen(group, msgid, root, int(page))
+		if flag == ArticleFlagSticky && page != 0 {
+			// moves to or from the first page
+			self.regen(group, msgid, root, 0)
+		}
+	}
+	return
+}
+
+func (self *modEngine) AllowBan(pubkey, scope string) bool {
 	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
 	if is_admin {
 		// admins can do whatever
 		return true
 	}
-	return self.database.CheckModPubkeyGlobal(pubkey)
+	if self.database.CheckModPubkeyGlobal(pubkey) {
+		return true
+	}
+	// board mods can only ban from their board
+	if newsgroupValidFormat(scope) && self.database.CheckModPubkeyCanModGroup(pubkey, scope) {
+		return true
+	}
+	return self.Delegated(pubkey, scope, ModInetBan)
+}
+
+func (self *modEngine) AllowImageBan(pubkey string) bool {
+	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
+	if is_admin {
+		return true
+	}
+	if self.database.CheckModPubkeyGlobal(pubkey) {
+		return true
+	}
+	// image bans are for every board
+	return self.Delegated(pubkey, ModScopeGlobal, ModImageBan)
+}
+
+func (self *modEngine) AllowDelegate(pubkey, scope string) bool {
+	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
+	if is_admin {
+		// admins are where every delegation starts
+		return true
+	}
+	return self.Delegated(pubkey, scope, ModDelegate)
+}
+
+func (self *modEngine) Delegated(pubkey, scope string, action ModAction) bool {
+	return self.delegated(pubkey, scope, action, false, 0)
+}
+
+// check if pubkey got action in scope from an admin or from a key that can delegate it
+// keys in the middle of the chain need overchan-delegate as well as action
+// expired or revoked rights anywhere in the chain break it
+func (self *modEngine) delegated(pubkey, scope string, action ModAction, delegate bool, depth int) bool {
+	if depth > MaxModDelegationDepth {
+		return false
+	}
+	delegations, err := self.database.GetModDelegations(pubkey)
+	if err != nil {
+		log.Println("failed to get mod delegations for", pubkey, err)
+		return false
+	}
+	now := timeNow()
+	for _, d := range delegations {
+		if !d.active(now) || !d.allows(action) || (delegate && !d.allows(ModDelegate)) || !scopeCovers(d.Scope, scope) {
+			continue
+		}
+		is_admin, _ := self.database.CheckAdminPubkey(d.Granter)
+		if is_admin || self.delegated(d.Granter, scope, action, true, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *modEngine) Delegate(ev ModEvent, granter string) (err error) {
+	d := modEventDelegation(ev, granter)
+	if !serverPubkeyIsValid(d.Pubkey) {
+		return errors.New("invalid public key")
+	}
+	return self.database.AddModDelegation(d)
+}
+
+func (self *modEngine) Revoke(ev ModEvent, revoker string) (err error) {
+	var delegations []ModDelegation
+	delegations, err = self.database.GetModDelegations(ev.Target())
+	if err != nil {
+		return
+	}
+	// admins can take away what anyone gave, everyone else only what they gave
+	is_admin, _ := self.database.CheckAdminPubkey(revoker)
+	for _, d := range delegations {
+		if (is_admin || d.Granter == revoker) && scopeCovers(ev.Scope(), d.Scope) {
+			err = self.database.DelModDelegation(d.Pubkey, d.Granter, d.Scope)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
 }
 
 func (self *modEngine) AllowJanitor(pubkey string) bool {
@@ -291,6 +578,11 @@ func (self *modEngine) AllowJanitor(pubkey string) bool {
 }
 
 func (self *modEngine) AllowDelete(pubkey, msgid string) (allow bool) {
+	return self.allowArticle(pubkey, msgid, ModDelete)
+}
+
+// check if pubkey can do a mod action to a post
+func (self *modEngine) allowArticle(pubkey, msgid string, action ModAction) (allow bool) {
 	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
 	if is_admin {
 		// admins can do whatever
@@ -303,7 +595,7 @@ func (self *modEngine) AllowDelete(pubkey, msgid string) (allow bool) {
 	// check for scoped permissions
 	_, group, _, err := self.database.GetInfoForMessage(msgid)
 	if err == nil && newsgroupValidFormat(group) {
-		allow = self.database.CheckModPubkeyCanModGroup(pubkey, group)
+		allow = self.database.CheckModPubkeyCanModGroup(pubkey, group) || self.Delegated(pubkey, group, action)
 	} else if err != nil {
 		log.Println("db error in mod engine while checking permissions", err)
 	}
@@ -323,23 +615,41 @@ func (mod *modEngine) HandleMessage(msgid string) {
 			line = strings.Trim(line, "\r\t\n ")
 			if len(line) > 0 {
 				ev := ParseModEvent(line)
-				mod.Execute(ev, pubkey)
+				mod.Execute(ev, pubkey, msgid)
 			}
 		}
 	}
 }
 
-func (mod *modEngine) Do(ev ModEvent) {
+func (mod *modEngine) Do(ev ModEvent, pubkey string) (err error) {
 	action := ev.Action()
 	target := ev.Target()
+	scope := ev.Scope()
+	expires := ev.Expires()
+	if expires >= 0 && expires <= timeNow() {
+		log.Println("mod event expired", ev)
+		err = ErrModEventExpired
+		return
+	}
+	if action != ModInetBan && action != ModImageBan && !mod.inScope(target, scope) {
+		log.Println(target, "not in scope", scope)
+		err = ErrNotInScope
+		return
+	}
 	if action == ModDelete || action == ModDeleteAlt {
 		msgid := target
 		if !ValidMessageID(msgid) {
 			// invalid message-id
 			log.Println("invalid message-id", msgid)
+			err = errors.New("invalid message-id: " + msgid)
 			return
 		}
-		err := mod.DeletePost(msgid)
+		// mods deleting it is how the spam classifier learns what spam is
+		nntp := mod.store.GetMessage(msgid)
+		if nntp != nil {
+			trainSpamArticle(mod.database, nntp, true)
+		}
+		err = mod.DeletePost(msgid)
 		if err != nil {
 			log.Println(msgid, err)
 		} else {
@@ -349,11 +659,11 @@ func (mod *modEngine) Do(ev ModEvent) {
 	} else if action == ModInetBan {
 		// ban action
 		if target[0] == '[' {
-			err := mod.BanAddress(target)
+			err = mod.BanAddress(target, scope, ev.Reason(), pubkey, expires)
 			if err != nil {
 				log.Println("failed to do literal ipv6 range ban on", target, err)
 			} else {
-				log.Println("banned", target)
+				log.Println("banned", target, "in", scope)
 			}
 			return
 		}
@@ -364,44 +674,63 @@ func (mod *modEngine) Do(ev ModEvent) {
 			cidr := decAddr(encaddr, key)
 			if cidr == "" {
 				log.Println("failed to decrypt inet ban")
+				err = errors.New("failed to decrypt inet ban")
 			} else {
-				err := mod.BanAddress(cidr)
+				err = mod.BanAddress(cidr, scope, ev.Reason(), pubkey, expires)
 				if err != nil {
 					log.Println("failed to do range ban on", cidr, err)
 				} else {
-					log.Println("banned", cidr)
+					log.Println("banned", cidr, "in", scope)
 				}
 			}
 		} else if len(parts) == 2 {
 			// x-encrypted-ip ban without pad
-			err := mod.database.BanEncAddr(parts[0])
+			err = mod.database.BanEncAddr(parts[0], scope, ev.Reason(), pubkey, expires)
 			if err != nil {
 				log.Println("failed to ban encrypted ip", err)
 			} else {
-				log.Println("banned poster", parts[0])
+				log.Println("banned poster", parts[0], "in", scope)
 			}
 
 		} else if len(parts) == 1 {
 			// literal cidr
 			cidr := parts[0]
-			err := mod.BanAddress(cidr)
+			err = mod.BanAddress(cidr, scope, ev.Reason(), pubkey, expires)
 			if err != nil {
 				log.Println("failed to do literal range ban on", cidr, err)
 			} else {
-				log.Println("banned cidr", cidr)
+				log.Println("banned cidr", cidr, "in", scope)
 			}
 
 		} else {
 			log.Printf("invalid overchan-inet-ban: target=%s", target)
+			err = errors.New("invalid overchan-inet-ban target: " + target)
+		}
+	} else if action == ModImageBan {
+		var hash ImageHash
+		hash, err = ParseImageHash(target)
+		if err == nil {
+			err = mod.database.BanImageHash(hash, ev.Reason())
+		}
+		if err != nil {
+			log.Println("failed to ban image", target, err)
+		} else {
+			log.Println("banned image", target)
+		}
+	} else if flag, ok := modActionFlags[action]; ok {
+		err = mod.FlagArticle(target, flag, true)
+		if err != nil {
+			log.Println("failed to flag", target, "as", flag, err)
+		} else {
+			log.Println("flagged", target, "as", flag)
+		}
+	} else if flag, ok := modActionUnflags[action]; ok {
+		err = mod.FlagArticle(target, flag, false)
+		if err != nil {
+			log.Println("failed to unflag", target, "as", flag, err)
+		} else {
+			log.Println("unflagged", target, "as", flag)
 		}
-	} else if action == ModHide {
-		// TODO: implement
-	} else if action == ModLock {
-		// TODO: implement
-	} else if action == ModSage {
-		// TODO: implement
-	} else if action == ModStick {
-		// TODO: implement
 	} else if action == ModRemoveAttachment {
 		var delfiles []string
 		atts := mod.database.GetPostAttachments(target)
@@ -417,32 +746,67 @@ func (mod *modEngine) Do(ev ModEvent) {
 		}
 	} else {
 		log.Println("invalid mod action", action)
+		err = ErrInvalidModAction
 	}
+	return
 }
 
-func (mod *modEngine) Execute(ev ModEvent, pubkey string) {
+func (mod *modEngine) Execute(ev ModEvent, pubkey, msgid string) {
 	action := ev.Action()
 	target := ev.Target()
+	var allow bool
 	switch action {
 	case ModDelete:
-		if mod.AllowDelete(pubkey, target) {
-			mod.Do(ev)
-		}
-		return
+		allow = mod.AllowDelete(pubkey, target)
 	case ModInetBan:
-		if mod.AllowBan(pubkey) {
-			mod.Do(ev)
-		}
-		return
-	case ModHide:
-	case ModLock:
-	case ModSage:
-	case ModStick:
+		allow = mod.AllowBan(pubkey, ev.Scope())
+	case ModHide, ModLock, ModSage, ModStick, ModUnhide, ModUnlock, ModUnsage, ModUnstick:
+		allow = mod.allowArticle(pubkey, target, action)
 	case ModRemoveAttachment:
-		if mod.AllowJanitor(pubkey) {
-			mod.Do(ev)
-		}
+		allow = mod.AllowJanitor(pubkey)
+	case ModImageBan:
+		allow = mod.AllowImageBan(pubkey)
+	case ModDelegate, ModRevoke:
+		allow = mod.AllowDelegate(pubkey, ev.Scope())
 	default:
 		// invalid action
+		return
+	}
+	// the mod log says if it was done, not just allowed
+	executed := allow
+	if allow && action == ModDelegate {
+		err := mod.Delegate(ev, pubkey)
+		if err != nil {
+			log.Println("failed to delegate", ev, err)
+			executed = false
+		} else {
+			log.Println(pubkey, "delegated", ev)
+		}
+	} else if allow && action == ModRevoke {
+		err := mod.Revoke(ev, pubkey)
+		if err != nil {
+			log.Println("failed to revoke", ev, err)
+			executed = false
+		} else {
+			log.Println(pubkey, "revoked", ev)
+		}
+	} else if allow {
+		// Do logs why it failed
+		executed = mod.Do(ev, pubkey) == nil
+	} else {
+		log.Println(pubkey, "not allowed to", ev)
+	}
+	err := mod.database.RecordModLog(ModLogEntry{
+		Pubkey:    pubkey,
+		Action:    string(action),
+		Target:    target,
+		Scope:     ev.Scope(),
+		Reason:    ev.Reason(),
+		MessageID: msgid,
+		Executed:  executed,
+		Time:      timeNow(),
+	})
+	if err != nil {
+		log.Println("failed to record mod event in mod log", err)
 	}
 }
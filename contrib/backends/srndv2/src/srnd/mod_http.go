@@ -18,6 +18,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -44,7 +45,7 @@ func extractGroup(param map[string]interface{}) string {
 	return extractParam(param, "newsgroup")
 }
 
-func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
+func (self httpModUI) getAdminFunc(funcname string, r *http.Request) AdminFunc {
 	if funcname == "template.reload" {
 		return func(param map[string]interface{}) (interface{}, error) {
 			tname, ok := param["template"]
@@ -298,6 +299,8 @@ func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
 				Addr:   host + ":" + port,
 				Name:   name,
 				quarks: make(map[string]string),
+				// put new feeds on probation
+				quarantine: extractParam(param, "quarantine") == "1",
 			}
 			err := self.daemon.addFeed(conf)
 			if err == nil {
@@ -316,6 +319,18 @@ func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
 			go self.daemon.syncAllMessages()
 			return "sync started", nil
 		}
+	} else if funcname == "feed.unreject" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			name := extractParam(param, "name")
+			if len(name) == 0 {
+				return "", errors.New("please specify feed name")
+			}
+			err := self.daemon.database.ClearFeedRejections(name)
+			if err == nil {
+				return "rejections cleared", nil
+			}
+			return "", err
+		}
 	} else if funcname == "feed.del" {
 		return func(param map[string]interface{}) (interface{}, error) {
 			name := extractParam(param, "name")
@@ -353,15 +368,132 @@ func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
 			}
 			return post_msgids, err
 		}
+	} else if funcname == "modlog.list" {
+		// get what mods did, newest first
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetModLogs(ModLogQuery{
+				Pubkey:    extractParam(param, "pubkey"),
+				Action:    extractParam(param, "action"),
+				Target:    extractParam(param, "target"),
+				MessageID: extractParam(param, "message-id"),
+				Since:     extractIntParam(param, "since"),
+				Before:    extractIntParam(param, "before"),
+				Offset:    int(extractIntParam(param, "offset")),
+				Limit:     int(extractIntParam(param, "limit")),
+			})
+		}
+	} else if funcname == "filter.list" {
+		// get every filter rule
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetFilterRules()
+		}
+	} else if funcname == "filter.add" {
+		// add a filter rule
+		return func(param map[string]interface{}) (interface{}, error) {
+			regex, _ := param["regex"].(bool)
+			rule := FilterRule{
+				Newsgroup:   extractGroup(param),
+				Field:       extractParam(param, "field"),
+				Pattern:     extractParam(param, "pattern"),
+				Regex:       regex,
+				Action:      FilterAction(extractParam(param, "action")),
+				Replacement: extractParam(param, "replacement"),
+			}
+			err := rule.check()
+			if err != nil {
+				return "bad filter rule", err
+			}
+			log.Println("filter.add", rule.Action, rule.Field, rule.Pattern)
+			return self.daemon.database.AddFilterRule(rule)
+		}
+	} else if funcname == "filter.del" {
+		// remove a filter rule
+		return func(param map[string]interface{}) (interface{}, error) {
+			id := extractIntParam(param, "id")
+			log.Println("filter.del", id)
+			err := self.daemon.database.DelFilterRule(id)
+			if err == nil {
+				return fmt.Sprintf("removed filter %d", id), nil
+			}
+			return "error", err
+		}
+	} else if funcname == "imageban.list" {
+		// get banned image hashes
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetBannedImageHashes()
+		}
+	} else if funcname == "imageban.del" {
+		// unban an image hash here only
+		return func(param map[string]interface{}) (interface{}, error) {
+			hash, err := ParseImageHash(extractParam(param, "hash"))
+			if err == nil {
+				err = self.daemon.database.UnbanImageHash(hash)
+			}
+			if err == nil {
+				return "unbanned " + hash.String(), nil
+			}
+			return "error", err
+		}
+	} else if funcname == "delegation.list" {
+		// get the mod rights given to a key or every key
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetModDelegations(extractParam(param, "pubkey"))
+		}
+	} else if funcname == "delegation.grant" {
+		// give a remote key mod rights, signed with our key so every node knows
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			if !serverPubkeyIsValid(pubkey) {
+				return "bad pubkey: " + pubkey, nil
+			}
+			expires := extractIntParam(param, "expires")
+			if expires <= 0 {
+				expires = -1
+			}
+			ev := overchanDelegate(pubkey, extractParam(param, "scope"), parseModActions(extractParam(param, "actions")), expires)
+			err := self.sendModMessage(ModMessage{ev}, r)
+			if err == nil {
+				return ev.String(), nil
+			}
+			return "error", err
+		}
+	} else if funcname == "delegation.revoke" {
+		// take away mod rights given to a remote key
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			if !serverPubkeyIsValid(pubkey) {
+				return "bad pubkey: " + pubkey, nil
+			}
+			ev := overchanRevoke(pubkey, extractParam(param, "scope"))
+			err := self.sendModMessage(ModMessage{ev}, r)
+			if err == nil {
+				return ev.String(), nil
+			}
+			return "error", err
+		}
 	}
 	return nil
 }
 
+// sign a mod message with the session's key and send it off to federate
+func (self httpModUI) sendModMessage(mm ModMessage, r *http.Request) (err error) {
+	privkey_bytes := self.getSessionPrivkeyBytes(r)
+	if privkey_bytes == nil {
+		return errors.New("failed to get private key from session")
+	}
+	var nntp NNTPMessage
+	nntp, err = signArticle(wrapModMessage(mm), privkey_bytes)
+	if err == nil {
+		self.modMessageChan <- nntp
+	}
+	return
+}
+
 // handle an admin action
 func (self httpModUI) HandleAdminCommand(wr http.ResponseWriter, r *http.Request) {
 	self.asAuthed("admin", func(url string) {
 		action := strings.Split(url, "/admin/")[1]
-		f := self.getAdminFunc(action)
+		f := self.getAdminFunc(action, r)
 		if f == nil {
 			wr.WriteHeader(404)
 		} else {
@@ -406,13 +538,36 @@ func (self httpModUI) CheckPubkey(pubkey, scope string) (bool, error) {
 		if self.daemon.database.CheckModPubkeyCanModGroup(pubkey, group) {
 			return true, nil
 		}
+		// or mods an admin gave the board to
+		if self.daemon.mod != nil && self.daemon.mod.Delegated(pubkey, group, ModDelete) {
+			return true, nil
+		}
 	} else if scope == "login" {
 		// check if a user can log in
-		return self.daemon.database.CheckModPubkey(pubkey), nil
+		if self.daemon.database.CheckModPubkey(pubkey) {
+			return true, nil
+		}
+		return self.hasDelegation(pubkey), nil
 	}
 	return false, err
 }
 
+// check if a key was given mod rights that are still in effect
+func (self httpModUI) hasDelegation(pubkey string) bool {
+	delegations, err := self.daemon.database.GetModDelegations(pubkey)
+	if err != nil {
+		log.Println("failed to get mod delegations for", pubkey, err)
+		return false
+	}
+	now := timeNow()
+	for _, d := range delegations {
+		if d.active(now) {
+			return true
+		}
+	}
+	return false
+}
+
 func (self httpModUI) CheckKey(privkey, scope string) (bool, error) {
 	privkey_bytes, err := hex.DecodeString(privkey)
 	if err == nil {
@@ -452,6 +607,15 @@ func (self httpModUI) getSessionPrivkeyBytes(r *http.Request) []byte {
 	return nil
 }
 
+// get the public key of the session's private key or empty string if we don't have it
+func (self httpModUI) getSessionPubkey(r *http.Request) string {
+	privkey_bytes := self.getSessionPrivkeyBytes(r)
+	if privkey_bytes == nil {
+		return ""
+	}
+	return getSignPubkey(privkey_bytes)
+}
+
 // returns true if the session is okay for a scope
 // otherwise redirect to login page
 func (self httpModUI) checkSession(r *http.Request, scope string) bool {
@@ -533,19 +697,35 @@ func (self httpModUI) HandleDelPubkey(wr http.ResponseWriter, r *http.Request) {
 
 func (self httpModUI) HandleUnbanAddress(wr http.ResponseWriter, r *http.Request) {
 	self.asAuthed("ban", func(path string) {
-		// extract the ip address
-		// TODO: ip ranges and prefix detection
+		// extract the ip address, cidr or encrypted ip
+		// TODO: prefix detection
 		if strings.Count(path, "/") > 2 {
-			addr := strings.Split(path, "/")[3]
+			addr := strings.SplitN(path, "/", 4)[3]
+			// anything that is not an ip or a range is an encrypted ip
+			encrypted := parseAddrOrCIDR(addr) == nil
 			resp := make(map[string]interface{})
-			banned, err := self.daemon.database.CheckIPBanned(addr)
+			var banned bool
+			var err error
+			if encrypted {
+				banned, err = self.daemon.database.CheckEncIPBanned(addr, "")
+			} else {
+				banned, err = self.daemon.database.CheckIPBanned(addr, "")
+			}
 			if err != nil {
 				resp["error"] = fmt.Sprintf("cannot tell if %s is banned: %s", addr, err.Error())
 			} else if banned {
-				// TODO: rangebans
-				err = self.daemon.database.UnbanAddr(addr)
+				if encrypted {
+					err = self.daemon.database.UnbanEncAddr(addr)
+				} else {
+					err = self.daemon.database.UnbanAddr(addr)
+				}
 				if err == nil {
 					resp["result"] = fmt.Sprintf("%s was unbanned", addr)
+					ban := IPBan{Addr: addr, Encrypted: encrypted}
+					err = self.daemon.database.RecordModLog(ban.logEntry(ModLogUnban, self.getSessionPubkey(r)))
+					if err != nil {
+						log.Println("failed to record unban in mod log", err)
+					}
 				} else {
 					resp["error"] = err.Error()
 				}
@@ -583,7 +763,7 @@ func (self httpModUI) handleBanAddress(msg ArticleEntry, r *http.Request) map[st
 			if len(ip) > 0 {
 				// we have it
 				// ban the address
-				err = self.daemon.database.BanAddr(ip)
+				err = self.daemon.database.BanAddr(ip, ModScopeGlobal, "", self.getSessionPubkey(r), -1)
 				// then we tell everyone about it
 				var key string
 				// TODO: we SHOULD have the key, but what if we do not?
@@ -608,9 +788,11 @@ func (self httpModUI) handleBanAddress(msg ArticleEntry, r *http.Request) map[st
 			} else {
 				// we don't have it
 				// ban the encrypted version
-				err = self.daemon.database.BanEncAddr(encip)
+				err = self.daemon.database.BanEncAddr(encip, ModScopeGlobal, "", self.getSessionPubkey(r), -1)
 			}
 			if err == nil {
+				// they were dealt with
+				self.daemon.database.DismissReports(msgid)
 				result_msg := fmt.Sprintf("We banned %s", encip)
 				if len(ip) > 0 {
 					result_msg += fmt.Sprintf(" (%s)", ip)
@@ -704,6 +886,227 @@ func (self httpModUI) HandleLogin(wr http.ResponseWriter, r *http.Request) {
 	self.writeTemplateParam(wr, r, "modlogin_result.mustache", map[string]interface{}{"message": msg, csrf.TemplateTag: csrf.TemplateField(r)})
 }
 
+// serve the mod log page filtered by the query string
+func (self httpModUI) serveModLog(wr http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 0 {
+		page = 0
+	}
+	q := ModLogQuery{
+		Pubkey:    query.Get("pubkey"),
+		Action:    query.Get("action"),
+		Target:    query.Get("target"),
+		MessageID: query.Get("msgid"),
+		Offset:    page * DefaultModLogLimit,
+		// get one more than we need to know if there is another page
+		Limit: DefaultModLogLimit + 1,
+	}
+	param := map[string]interface{}{
+		"pubkey": q.Pubkey,
+		"action": q.Action,
+		"target": q.Target,
+		"msgid":  q.MessageID,
+	}
+	entries, err := self.daemon.database.GetModLogs(q)
+	if err != nil {
+		param["error"] = err.Error()
+	}
+	if len(entries) > DefaultModLogLimit {
+		entries = entries[:DefaultModLogLimit]
+		query.Set("page", strconv.Itoa(page+1))
+		param["next_url"] = self.mod_prefix + "log?" + query.Encode()
+	}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page-1))
+		param["prev_url"] = self.mod_prefix + "log?" + query.Encode()
+	}
+	param["entries"] = entries
+	self.writeTemplateParam(wr, r, "modlog.mustache", param)
+}
+
+// serve the queue of reported posts this mod can moderate
+func (self httpModUI) serveReports(wr http.ResponseWriter, r *http.Request) {
+	param := make(map[string]interface{})
+	reports, err := self.daemon.database.GetArticleReports()
+	if err == nil {
+		var visible []ArticleReports
+		for _, report := range reports {
+			if self.checkSession(r, "mod-"+report.Newsgroup) {
+				visible = append(visible, report)
+			}
+		}
+		param["reports"] = visible
+	} else {
+		param["error"] = err.Error()
+	}
+	self.writeTemplateParam(wr, r, "modreports.mustache", param)
+}
+
+func (self httpModUI) handleDismissReports(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	if self.heldByFilter(msg.MessageID()) {
+		// dismissing is approving it
+		var err error
+		if self.daemon.database.ArticleHasFlag(msg.MessageID(), ArticleFlagQuarantined) {
+			err = self.daemon.approveArticle(msg.MessageID())
+		} else {
+			err = self.daemon.mod.FlagArticle(msg.MessageID(), ArticleFlagHidden, false)
+		}
+		if err != nil {
+			log.Println("failed to show held post", msg.MessageID(), err)
+		}
+	}
+	err := self.daemon.database.DismissReports(msg.MessageID())
+	if err == nil {
+		resp["dismissed"] = msg.MessageID()
+	} else {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
+// check if a post is in the report queue because a filter held it
+func (self httpModUI) heldByFilter(msgid string) bool {
+	reports, err := self.daemon.database.GetArticleReports()
+	if err != nil {
+		log.Println("failed to get reports", err)
+		return false
+	}
+	for _, report := range reports {
+		if report.MessageID != msgid {
+			continue
+		}
+		for _, reason := range report.Reasons {
+			if reason.Reason == FilterHeldReason {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dismiss the reports of a post
+func (self httpModUI) HandleDismissReports(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("login", self.handleDismissReports, wr, r)
+}
+
+func (self httpModUI) handleMarkHam(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	nntp := self.daemon.store.GetMessage(msg.MessageID())
+	if nntp == nil {
+		return map[string]interface{}{"error": "failed to load " + msg.MessageID()}
+	}
+	trainSpamArticle(self.daemon.database, nntp, false)
+	// it's not spam so it shouldn't be held or reported as spam either
+	resp := self.handleDismissReports(msg, r)
+	if _, ok := resp["error"]; !ok {
+		resp["ham"] = msg.MessageID()
+	}
+	return resp
+}
+
+// tell the spam classifier a post is not spam
+func (self httpModUI) HandleMarkHam(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("login", self.handleMarkHam, wr, r)
+}
+
+// serve the bans in effect
+func (self httpModUI) serveBans(wr http.ResponseWriter, r *http.Request) {
+	param := make(map[string]interface{})
+	bans, err := self.daemon.database.GetIPBans()
+	if err == nil {
+		param["bans"] = bans
+	} else {
+		param["error"] = err.Error()
+	}
+	self.writeTemplateParam(wr, r, "modbans.mustache", param)
+}
+
+// serve the quarantine queue for the boards this mod can moderate
+func (self httpModUI) serveQuarantine(wr http.ResponseWriter, r *http.Request) {
+	param := make(map[string]interface{})
+	articles, err := self.daemon.database.GetQuarantinedArticles()
+	if err == nil {
+		var visible []QuarantinedArticle
+		for _, article := range articles {
+			if self.checkSession(r, "mod-"+article.Newsgroup) {
+				visible = append(visible, article)
+			}
+		}
+		param["articles"] = visible
+	} else {
+		param["error"] = err.Error()
+	}
+	self.writeTemplateParam(wr, r, "modquarantine.mustache", param)
+}
+
+func (self httpModUI) handleApproveArticle(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	err := self.daemon.approveArticle(msg.MessageID())
+	if err == nil {
+		resp["approved"] = msg.MessageID()
+	} else {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
+// let a quarantined article through
+func (self httpModUI) HandleApproveArticle(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("login", self.handleApproveArticle, wr, r)
+}
+
+func (self httpModUI) handleRejectArticle(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	err := self.daemon.rejectArticle(msg.MessageID())
+	if err == nil {
+		resp["rejected"] = msg.MessageID()
+	} else {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
+// throw away a quarantined article
+func (self httpModUI) HandleRejectArticle(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("login", self.handleRejectArticle, wr, r)
+}
+
+func (self httpModUI) handleBanImages(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	var mm ModMessage
+	var hashes []string
+	for _, att := range self.daemon.database.GetPostAttachments(msg.MessageID()) {
+		if !canHashImage(att) {
+			continue
+		}
+		hash, err := self.daemon.store.ImageHash(att)
+		if err == nil {
+			mm = append(mm, overchanImageBan(hash, r.URL.Query().Get("reason")))
+			hashes = append(hashes, hash.String())
+		} else {
+			log.Println("failed to hash image", att, err)
+		}
+	}
+	if len(mm) == 0 {
+		resp["error"] = fmt.Sprintf("%s has no images we can ban", msg.MessageID())
+		return resp
+	}
+	// we ban them when it comes back to us
+	err := self.sendModMessage(mm, r)
+	if err == nil {
+		resp["banned"] = hashes
+	} else {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
+// ban the images of a post on every board
+func (self httpModUI) HandleBanImages(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("ban", self.handleBanImages, wr, r)
+}
+
 func (self httpModUI) HandleKeyGen(wr http.ResponseWriter, r *http.Request) {
 	pk, sk := newSignKeypair()
 	tripcode := makeTripcode(pk)
@@ -718,6 +1121,14 @@ func (self httpModUI) ServeModPage(wr http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(url, "/mod/feeds") {
 			// serve feeds page
 			self.writeTemplate(wr, r, "modfeed.mustache")
+		} else if strings.HasSuffix(r.URL.Path, "/mod/log") {
+			self.serveModLog(wr, r)
+		} else if strings.HasSuffix(r.URL.Path, "/mod/reports") {
+			self.serveReports(wr, r)
+		} else if strings.HasSuffix(r.URL.Path, "/mod/quarantine") {
+			self.serveQuarantine(wr, r)
+		} else if strings.HasSuffix(r.URL.Path, "/mod/bans") {
+			self.serveBans(wr, r)
 		} else {
 			// serve mod page
 			self.writeTemplate(wr, r, "modpage.mustache")
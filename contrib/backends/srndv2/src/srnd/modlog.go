@@ -0,0 +1,134 @@
+//
+// modlog.go -- record of what moderators asked us to do
+//
+package srnd
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// how many mod log entries we give by default
+const DefaultModLogLimit = 50
+
+// most mod log entries we give at once
+const MaxModLogLimit = 500
+
+// a mod event from a ctl message and what we did with it
+type ModLogEntry struct {
+	// public key of the mod that signed it
+	Pubkey string
+	Action string
+	Target string
+	Scope  string
+	Reason string
+	// message-id of the ctl message it came in
+	MessageID string
+	// false if the mod was not allowed to do it
+	Executed bool
+	// unix time we got it
+	Time int64
+}
+
+// when we got it, for templates
+func (self ModLogEntry) Date() string {
+	return time.Unix(self.Time, 0).UTC().Format(time.RFC3339)
+}
+
+// which mod log entries to get, newest first
+// empty fields match everything
+type ModLogQuery struct {
+	Pubkey    string
+	Action    string
+	Target    string
+	MessageID string
+	// only entries at or after this unix time if not 0
+	Since int64
+	// only entries before this unix time if not 0
+	Before int64
+	// how many entries to skip
+	Offset int
+	// how many entries to give at most
+	Limit int
+}
+
+// get how many entries to skip and how many to give
+func (self ModLogQuery) limits() (offset, limit int) {
+	limit = self.Limit
+	if limit <= 0 {
+		limit = DefaultModLogLimit
+	} else if limit > MaxModLogLimit {
+		limit = MaxModLogLimit
+	}
+	if self.Offset > 0 {
+		offset = self.Offset
+	}
+	return
+}
+
+// check if an entry is one the query wants, not counting limits
+func (self ModLogQuery) matches(entry ModLogEntry) bool {
+	if self.Pubkey != "" && entry.Pubkey != self.Pubkey {
+		return false
+	}
+	if self.Action != "" && entry.Action != self.Action {
+		return false
+	}
+	if self.Target != "" && entry.Target != self.Target {
+		return false
+	}
+	if self.MessageID != "" && entry.MessageID != self.MessageID {
+		return false
+	}
+	if self.Since > 0 && entry.Time < self.Since {
+		return false
+	}
+	if self.Before > 0 && entry.Time >= self.Before {
+		return false
+	}
+	return true
+}
+
+// get the sql query for the entries the query wants
+func (self ModLogQuery) sql() (q string, args []interface{}) {
+	conds := []string{"1 = 1"}
+	for _, col := range [][2]string{
+		{"pubkey", self.Pubkey},
+		{"action", self.Action},
+		{"target", self.Target},
+		{"message_id", self.MessageID},
+	} {
+		if col[1] != "" {
+			args = append(args, col[1])
+			conds = append(conds, fmt.Sprintf("%s = $%d", col[0], len(args)))
+		}
+	}
+	if self.Since > 0 {
+		args = append(args, self.Since)
+		conds = append(conds, fmt.Sprintf("time >= $%d", len(args)))
+	}
+	if self.Before > 0 {
+		args = append(args, self.Before)
+		conds = append(conds, fmt.Sprintf("time < $%d", len(args)))
+	}
+	offset, limit := self.limits()
+	args = append(args, limit, offset)
+	q = fmt.Sprintf("SELECT pubkey, action, target, scope, reason, message_id, executed, time FROM ModLogs WHERE %s ORDER BY time DESC LIMIT $%d OFFSET $%d", strings.Join(conds, " AND "), len(args)-1, len(args))
+	return
+}
+
+// load mod log entries from rows of the query made by ModLogQuery.sql
+func scanModLogs(rows *sql.Rows) (entries []ModLogEntry, err error) {
+	for rows.Next() {
+		var entry ModLogEntry
+		err = rows.Scan(&entry.Pubkey, &entry.Action, &entry.Target, &entry.Scope, &entry.Reason, &entry.MessageID, &entry.Executed, &entry.Time)
+		if err != nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	return
+}
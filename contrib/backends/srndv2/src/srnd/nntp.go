@@ -60,6 +60,8 @@ type nntpConnection struct {
 	group string
 	// what article is currently selected
 	selected_article string
+	// the article number of the selected article in the current newsgroup
+	selected_number int64
 	// the policy for federation
 	policy *FeedPolicy
 	// lock help when expecting non pipelined activity
@@ -80,6 +82,11 @@ type nntpConnection struct {
 
 	tls_state tls.ConnectionState
 
+	// do we want to COMPRESS DEFLATE after STARTTLS, outbound only
+	compress bool
+	// is COMPRESS DEFLATE active on this connection
+	compressed bool
+
 	// have we authenticated with a login?
 	authenticated bool
 	// the username that is authenticated
@@ -95,6 +102,12 @@ type nntpConnection struct {
 
 	// streaming keepalive timer
 	keepalive *time.Ticker
+
+	// outbound spool of the feed, nil if we don't stream out
+	spool *feedSpool
+
+	// put articles we get on this connection in the quarantine queue
+	probation bool
 }
 
 // get message backlog in bytes
@@ -116,6 +129,7 @@ func (self *nntpConnection) MarshalJSON() (data []byte, err error) {
 	jmap["authed"] = self.authenticated
 	jmap["group"] = self.group
 	jmap["backlog"] = self.backlog
+	jmap["compressed"] = self.compressed
 	data, err = json.Marshal(jmap)
 	return
 }
@@ -191,6 +205,7 @@ func (self *nntpConnection) outboundHandshake(conn *textproto.Conn, conf *FeedCo
 	log.Println(self.name, "outbound handshake")
 	var line string
 	var code int
+	var compress bool
 	for err == nil {
 		code, line, err = conn.ReadCodeLine(-1)
 		log.Println(self.name, line)
@@ -224,6 +239,13 @@ func (self *nntpConnection) outboundHandshake(conn *textproto.Conn, conf *FeedCo
 								stream = true
 								reader = false
 								log.Println(self.name, "is SRNd")
+							} else if strings.HasPrefix(line, "COMPRESS ") {
+								for _, algo := range strings.Fields(line)[1:] {
+									if algo == "DEFLATE" {
+										log.Println(self.name, "supports COMPRESS DEFLATE")
+										compress = true
+									}
+								}
 							}
 						} else {
 							// we got an error
@@ -243,6 +265,8 @@ func (self *nntpConnection) outboundHandshake(conn *textproto.Conn, conf *FeedCo
 			}
 		}
 	}
+	// we turn on compression in runConnection after STARTTLS, RFC 8054 doesn't let us do STARTTLS after it
+	self.compress = compress && conf != nil && conf.compress
 	if conf != nil && len(conf.username) > 0 && len(conf.passwd) > 0 {
 		log.Println(self.name, "authenticating...")
 		err = conn.PrintfLine("AUTHINFO USER %s", conf.username)
@@ -271,16 +295,79 @@ func (self *nntpConnection) outboundHandshake(conn *textproto.Conn, conf *FeedCo
 }
 
 // offer up a article to sync via this connection
-func (self *nntpConnection) offerStream(msgid string, sz int64) {
+func (self *nntpConnection) offerStream(daemon *NNTPDaemon, msgid string, sz int64) {
 	if self.messageIsQueued(msgid) {
 		// already queued for send
+	} else if daemon.feedRejected(self.feedname, msgid) {
+		// they refused it before
+		self.unspool(msgid)
 	} else {
+		if self.spool != nil {
+			err := self.spool.Add(msgid)
+			if err != nil {
+				log.Println(self.name, "failed to spool", msgid, err)
+			}
+			if !self.spool.Claim(msgid) {
+				// another connection of this feed is offering it
+				return
+			}
+		}
 		self.backlog += sz
 		self.messageSetPendingState(msgid, "queued", sz)
 		self.check <- syncEvent{msgid, sz, "queued"}
 	}
 }
 
+// offer every article in our feed's spool that no other connection is offering
+func (self *nntpConnection) offerSpooled(daemon *NNTPDaemon) {
+	if self.spool == nil {
+		return
+	}
+	msgids, err := self.spool.Unclaimed()
+	if err != nil {
+		log.Println(self.name, "failed to read spool", err)
+		return
+	}
+	for _, msgid := range msgids {
+		sz, err := daemon.store.GetMessageSize(msgid)
+		if err == nil {
+			self.offerStream(daemon, msgid, sz)
+		} else {
+			// we don't have it anymore
+			log.Println(self.name, "dropping", msgid, "from spool", err)
+			self.unspool(msgid)
+		}
+	}
+}
+
+// the other side accepted or refused an article, we don't need to send it anymore
+func (self *nntpConnection) unspool(msgid string) {
+	if self.spool != nil {
+		err := self.spool.Remove(msgid)
+		if err != nil {
+			log.Println(self.name, "failed to remove", msgid, "from spool", err)
+		}
+	}
+}
+
+// the other side refused an article, don't offer it to them again until the rejection expires
+func (self *nntpConnection) rememberRejection(daemon *NNTPDaemon, msgid, reason string) {
+	if self.feedname != "" {
+		err := daemon.database.RecordFeedRejection(self.feedname, msgid, reason)
+		if err != nil {
+			log.Println(self.name, "failed to remember rejection of", msgid, err)
+		}
+	}
+	self.unspool(msgid)
+}
+
+// we didn't send an article, keep it in the spool for later
+func (self *nntpConnection) releaseSpooled(msgid string) {
+	if self.spool != nil {
+		self.spool.Release(msgid)
+	}
+}
+
 // handle sending 1 stream event
 func (self *nntpConnection) handleStreamEvent(ev nntpStreamEvent, daemon *NNTPDaemon, conn *textproto.Conn) (err error) {
 	if ValidMessageID(ev.MessageID()) {
@@ -372,6 +459,8 @@ func (self *nntpConnection) handleStreaming(daemon *NNTPDaemon, conn *textproto.
 			err = self.handleStreamEvent(nntpTAKETHIS(ev.msgid), daemon, conn)
 		case <-self.keepalive.C:
 			err = conn.PrintfLine("CHECK %s", nntpDummyArticle)
+			// retry what the other side wanted later
+			go self.offerSpooled(daemon)
 		}
 	}
 	return
@@ -390,6 +479,11 @@ func (self *nntpConnection) checkMIMEHeader(daemon *NNTPDaemon, h map[string][]s
 }
 
 func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textproto.MIMEHeader) (reason string, ban bool, err error) {
+	defer func() {
+		if reason != "" {
+			metrics.Rejected(reason)
+		}
+	}()
 	newsgroup := hdr.Get("Newsgroups")
 	reference := hdr.Get("References")
 	msgid := getMessageID(hdr)
@@ -461,11 +555,20 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 		reason = "thread banned"
 		ban = true
 		return
+	} else if reference != "" && daemon.database.ArticleHasFlag(reference, ArticleFlagLocked) {
+		reason = "thread locked"
+		// don't ban, it can come again once the thread is unlocked
+		return
 	} else if daemon.database.HasArticle(msgid) {
 		// this article is too old
 		reason = "we have this article already"
 		// don't ban
 		return
+	} else if disposition := daemon.history.Disposition(msgid); disposition != "" {
+		// we saw it before and don't have it now
+		reason = "article in history as " + disposition
+		// don't ban
+		return
 	} else if is_ctl {
 		// we always allow control messages
 		return
@@ -503,7 +606,7 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 	} else {
 		// check for banned address
 		if encaddr != "" {
-			ban, err = daemon.database.CheckEncIPBanned(encaddr)
+			ban, err = daemon.database.CheckEncIPBanned(encaddr, newsgroup)
 			if err == nil {
 				if ban {
 					// this address is banned
@@ -565,10 +668,14 @@ func (self *nntpConnection) storeMessage(daemon *NNTPDaemon, hdr textproto.MIMEH
 	// now store attachments and article
 	err = writeMIMEHeader(f, hdr)
 	if err == nil {
-		err = daemon.store.ProcessMessageBody(f, hdr, body)
+		err = daemon.store.ProcessMessageBody(f, hdr, body, daemon.quarantines(hdr.Get("Newsgroups"), self.probation))
 		if err == nil {
-			// tell daemon
-			daemon.loadFromInfeed(msgid)
+			// quarantined articles wait for a mod
+			if !daemon.database.ArticleHasFlag(msgid, ArticleFlagQuarantined) {
+				// tell daemon
+				daemon.loadFromInfeed(msgid)
+			}
+			metrics.articlesAccepted.Add(1)
 		} else {
 			log.Println("error processing message body", err)
 		}
@@ -580,6 +687,535 @@ func (self *nntpConnection) storeMessage(daemon *NNTPDaemon, hdr textproto.MIMEH
 			DelFile(daemon.store.GetFilename(msgid))
 		}
 		log.Println("error processing message", err)
+		if err == ErrBannedImage || err == ErrFilteredArticle || err == ErrSpamArticle {
+			// don't ask for it again
+			daemon.database.BanArticle(msgid, err.Error())
+			daemon.history.Record(msgid, HistoryRejected)
+		}
+	}
+	return
+}
+
+// fill in the headers a newsreader leaves for the injecting server to add
+// see RFC 5537 section 3.5
+func (self *nntpConnection) injectPostHeaders(daemon *NNTPDaemon, hdr textproto.MIMEHeader) {
+	if !ValidMessageID(getMessageID(hdr)) {
+		hdr.Del("Message-Id")
+		hdr.Set("Message-Id", genMessageID(daemon.instance_name))
+	}
+	if hdr.Get("Path") == "" {
+		// storeMessage prepends our instance name
+		hdr.Set("Path", "not-for-mail")
+	}
+	// normalize the date so that Posted() can parse it, never accept dates from the future
+	now := time.Unix(timeNow(), 0).UTC()
+	t, err := mail.ParseDate(hdr.Get("Date"))
+	if err == nil && !t.After(now) {
+		hdr.Set("Date", t.UTC().Format(time.RFC1123Z))
+	} else {
+		hdr.Set("Date", now.Format(time.RFC1123Z))
+	}
+	hdr.Set("Injection-Date", now.Format(time.RFC1123Z))
+	hdr.Set("Injection-Info", daemon.instance_name)
+	// never trust poster supplied addresses
+	hdr.Del("X-Encrypted-Ip")
+	if self.addr != nil {
+		ipaddr, _, _ := net.SplitHostPort(self.addr.String())
+		if len(ipaddr) > 0 {
+			// inject encrypted ip for poster
+			encaddr, err := daemon.database.GetEncAddress(ipaddr)
+			if err == nil {
+				hdr.Set("X-Encrypted-Ip", encaddr)
+			} else {
+				log.Println(self.name, "failed to get encrypted address for poster", err)
+			}
+		}
+	}
+}
+
+// figure out the thread root from the References header a newsreader sent
+// newsreaders send the whole reference chain but we only do 1 level of threading
+func (self *nntpConnection) postThreadRoot(daemon *NNTPDaemon, newsgroup, refs string) (root, reason string) {
+	for _, reference := range strings.Split(refs, " ") {
+		reference = strings.Trim(reference, "\t ")
+		if reference == "" {
+			continue
+		} else if !ValidMessageID(reference) {
+			reason = "cannot reply with invalid reference " + reference
+			return
+		}
+		if daemon.store.HasArticle(reference) {
+			h := daemon.store.GetMIMEHeader(reference)
+			parent := strings.Trim(h.Get("References"), " ")
+			if parent == "" {
+				// replying to a root post
+				root = reference
+			} else {
+				// replying to a reply
+				root = parent
+			}
+			return
+		} else if root == "" {
+			// remember first reference in case we don't have any of them
+			root = reference
+		}
+	}
+	if root != "" && !daemon.database.IsExpired(root) {
+		log.Println(self.name, "got reply to", root, "but we don't have it")
+		go daemon.askForArticle(ArticleEntry{root, newsgroup})
+	}
+	return
+}
+
+// handle POST command from a newsreader, RFC 3977 section 6.3.1
+func (self *nntpConnection) handlePOST(daemon *NNTPDaemon, conn *textproto.Conn) (err error) {
+	if !self.authenticated {
+		// needs login or tls to work
+		err = conn.PrintfLine("440 Posting not permitted")
+		return
+	}
+	err = conn.PrintfLine("340 Send article to be posted, end with <CR-LF>.<CR-LF>")
+	if err != nil {
+		return
+	}
+	r := bufio.NewReader(conn.DotReader())
+	var msg *mail.Message
+	var reason string
+	msg, err = readMIMEHeader(r)
+	if err == nil {
+		hdr := textproto.MIMEHeader(msg.Header)
+		self.injectPostHeaders(daemon, hdr)
+		newsgroup := hdr.Get("Newsgroups")
+		if !daemon.database.HasNewsgroup(newsgroup) {
+			reason = "no such newsgroup: " + newsgroup
+		} else {
+			var root string
+			root, reason = self.postThreadRoot(daemon, newsgroup, hdr.Get("References"))
+			if reason == "" {
+				if root == "" {
+					hdr.Del("References")
+				} else {
+					hdr.Set("References", root)
+				}
+				reason, _, err = self.checkMIMEHeaderNoAuth(daemon, hdr)
+			}
+		}
+		if reason == "" && err == nil {
+			body := &io.LimitedReader{
+				R: msg.Body,
+				N: daemon.messageSizeLimitFor(newsgroup),
+			}
+			err = self.storeMessage(daemon, hdr, body)
+			if err == nil {
+				log.Println(self.name, "got POST", getMessageID(hdr))
+				err = conn.PrintfLine("240 Article received OK")
+				return
+			}
+		}
+	}
+	// discard the rest of the article so we stay in sync with the client
+	io.Copy(ioutil.Discard, r)
+	if err != nil {
+		log.Println(self.name, "failed nntp POST", err)
+		reason = err.Error()
+	}
+	err = conn.PrintfLine("441 Posting failed %s", reason)
+	return
+}
+
+// the order of fields in our overview, RFC 3977 section 8.4
+var nntpOverviewFormat = []string{"Subject:", "From:", "Date:", "Message-ID:", "References:", ":bytes", ":lines"}
+
+// parse an RFC 3977 range argument, one of "n", "n-" or "n-m"
+// hi is -1 if the range has no upper bound
+func parseNNTPRange(str string) (lo, hi int64, err error) {
+	idx := strings.Index(str, "-")
+	if idx == -1 {
+		lo, err = strconv.ParseInt(str, 10, 64)
+		hi = lo
+		return
+	}
+	lo, err = strconv.ParseInt(str[:idx], 10, 64)
+	if err == nil {
+		if idx+1 == len(str) {
+			hi = -1
+		} else {
+			hi, err = strconv.ParseInt(str[idx+1:], 10, 64)
+		}
+	}
+	return
+}
+
+// make a header value safe to put into a tab separated overview line
+func nntpOverviewValue(val string) string {
+	return strings.NewReplacer("\t", " ", "\r", "", "\n", " ").Replace(val)
+}
+
+// get size of article in bytes for :bytes metadata item or empty string if we don't have it
+func (self *nntpConnection) articleBytes(daemon *NNTPDaemon, msgid string) string {
+	sz, err := daemon.store.GetMessageSize(msgid)
+	if err == nil {
+		return strconv.FormatInt(sz, 10)
+	}
+	return ""
+}
+
+// get overview info for 1 article given its message-id, article number is 0 as per RFC 3977
+func (self *nntpConnection) overviewForMessageID(daemon *NNTPDaemon, msgid string) (ov NNTPOverview, has bool) {
+	if daemon.store.HasArticle(msgid) {
+		hdrs, err := daemon.database.GetHeadersForMessage(msgid)
+		if err == nil {
+			ov.MessageID = msgid
+			ov.Headers = hdrs
+			has = true
+		} else {
+			log.Println(self.name, "cannot get headers for", msgid, err)
+		}
+	}
+	return
+}
+
+// get overview info for a range argument or the current article if no range is given
+// returns the failure response line if we can't
+func (self *nntpConnection) overviewForRange(daemon *NNTPDaemon, args []string, names []string) (overviews []NNTPOverview, failure string) {
+	var lo, hi int64
+	var err error
+	if self.group == "" {
+		failure = "412 No newsgroup selected"
+		return
+	} else if len(args) == 0 {
+		if self.selected_article == "" {
+			failure = "420 Current article number is invalid"
+			return
+		}
+		lo = self.selected_number
+		hi = lo
+	} else {
+		lo, hi, err = parseNNTPRange(args[0])
+		if err != nil {
+			failure = "501 invalid range " + args[0]
+			return
+		}
+	}
+	overviews, err = daemon.database.GetNNTPHeadersInRange(self.group, lo, hi, names)
+	if err != nil {
+		log.Println(self.name, "error getting overview for", self.group, err)
+		failure = "403 error getting overview: " + err.Error()
+	} else if len(overviews) == 0 {
+		failure = "423 No articles in that range"
+	}
+	return
+}
+
+// handle OVER command, RFC 3977 section 8.3
+func (self *nntpConnection) handleOVER(daemon *NNTPDaemon, args []string, conn *textproto.Conn) (err error) {
+	var overviews []NNTPOverview
+	if len(args) > 0 && ValidMessageID(args[0]) {
+		ov, has := self.overviewForMessageID(daemon, args[0])
+		if !has {
+			err = conn.PrintfLine("430 No article with that message-id")
+			return
+		}
+		overviews = append(overviews, ov)
+	} else {
+		var failure string
+		overviews, failure = self.overviewForRange(daemon, args, []string{"Subject", "From", "Date", "References"})
+		if failure != "" {
+			err = conn.PrintfLine("%s", failure)
+			return
+		}
+	}
+	err = conn.PrintfLine("224 Overview information follows")
+	if err == nil {
+		dw := conn.DotWriter()
+		for _, ov := range overviews {
+			fields := []string{strconv.FormatInt(ov.Number, 10)}
+			for _, name := range []string{"subject", "from", "date"} {
+				fields = append(fields, nntpOverviewValue(ov.Headers.Get(name, "")))
+			}
+			// we don't keep line counts so :lines is empty
+			fields = append(fields, ov.MessageID, nntpOverviewValue(ov.Headers.Get("references", "")), self.articleBytes(daemon, ov.MessageID), "")
+			_, err = io.WriteString(dw, strings.Join(fields, "\t")+"\n")
+			if err != nil {
+				break
+			}
+		}
+		dw.Close()
+	}
+	return
+}
+
+// handle HDR command, RFC 3977 section 8.5
+// code is the success response code, it's different for XHDR
+func (self *nntpConnection) handleHDR(daemon *NNTPDaemon, code int, args []string, conn *textproto.Conn) (err error) {
+	field := strings.ToLower(args[0])
+	args = args[1:]
+	var names []string
+	if strings.HasPrefix(field, ":") {
+		if field != ":bytes" {
+			err = conn.PrintfLine("503 metadata item %s not supported", field)
+			return
+		}
+	} else {
+		names = append(names, field)
+	}
+	var overviews []NNTPOverview
+	if len(args) > 0 && ValidMessageID(args[0]) {
+		ov, has := self.overviewForMessageID(daemon, args[0])
+		if !has {
+			err = conn.PrintfLine("430 No article with that message-id")
+			return
+		}
+		overviews = append(overviews, ov)
+	} else {
+		var failure string
+		overviews, failure = self.overviewForRange(daemon, args, names)
+		if failure != "" {
+			err = conn.PrintfLine("%s", failure)
+			return
+		}
+	}
+	err = conn.PrintfLine("%d Headers follow", code)
+	if err == nil {
+		dw := conn.DotWriter()
+		for _, ov := range overviews {
+			var val string
+			if field == ":bytes" {
+				val = self.articleBytes(daemon, ov.MessageID)
+			} else {
+				val = nntpOverviewValue(ov.Headers.Get(field, ""))
+			}
+			_, err = fmt.Fprintf(dw, "%d %s\n", ov.Number, val)
+			if err != nil {
+				break
+			}
+		}
+		dw.Close()
+	}
+	return
+}
+
+// select a newsgroup and set the current article to the first article in it
+func (self *nntpConnection) selectGroup(daemon *NNTPDaemon, group string) {
+	self.group = group
+	self.selected_article = ""
+	self.selected_number = 0
+	_, lo, err := daemon.database.GetLastAndFirstForGroup(group)
+	if err == nil {
+		msgid, err := daemon.database.GetMessageIDForNNTPID(group, lo)
+		if err == nil {
+			self.selected_article = msgid
+			self.selected_number = lo
+		}
+	}
+}
+
+// find the article an ARTICLE, HEAD, BODY or STAT argument refers to, RFC 3977 section 6.2
+// arg is a message-id, an article number or empty string for the current article
+// selecting by article number moves the current article pointer
+// returns the failure response line if we can't
+func (self *nntpConnection) resolveArticle(daemon *NNTPDaemon, arg string) (n int64, msgid, failure string) {
+	var err error
+	if arg == "" {
+		if self.group == "" {
+			failure = "412 No newsgroup selected"
+		} else if self.selected_article == "" {
+			failure = "420 Current article number is invalid"
+		} else if daemon.store.HasArticle(self.selected_article) {
+			n = self.selected_number
+			msgid = self.selected_article
+		} else {
+			failure = "420 Current article was removed"
+		}
+	} else if ValidMessageID(arg) {
+		if daemon.store.HasArticle(arg) {
+			msgid = arg
+			if self.group != "" {
+				// it's 0 if it's not in this group
+				n, _ = daemon.database.GetNNTPIDForMessageID(self.group, msgid)
+			}
+		} else {
+			failure = "430 No article with that message-id"
+		}
+	} else {
+		n, err = strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			failure = "501 invalid article argument " + arg
+		} else if self.group == "" {
+			failure = "412 No newsgroup selected"
+		} else {
+			msgid, err = daemon.database.GetMessageIDForNNTPID(self.group, n)
+			if err == nil && daemon.store.HasArticle(msgid) {
+				self.selected_article = msgid
+				self.selected_number = n
+			} else {
+				failure = "423 No article with that number"
+			}
+		}
+	}
+	if failure == "" && daemon.database.ArticleHasFlag(msgid, ArticleFlagQuarantined) {
+		// nobody gets it until a mod approves it
+		failure = "430 No article with that message-id"
+	}
+	return
+}
+
+// handle ARTICLE, HEAD, BODY and STAT commands, RFC 3977 section 6.2
+func (self *nntpConnection) handleArticleCommand(daemon *NNTPDaemon, cmd, arg string, conn *textproto.Conn) (err error) {
+	n, msgid, failure := self.resolveArticle(daemon, arg)
+	if failure != "" {
+		err = conn.PrintfLine("%s", failure)
+		return
+	}
+	if cmd == "STAT" {
+		err = conn.PrintfLine("223 %d %s", n, msgid)
+	} else if cmd == "HEAD" {
+		hdrs := daemon.store.GetHeaders(msgid)
+		if hdrs == nil {
+			err = conn.PrintfLine("403 cannot load headers for %s", msgid)
+		} else {
+			err = conn.PrintfLine("221 %d %s", n, msgid)
+			if err == nil {
+				dw := conn.DotWriter()
+				err = writeMIMEHeader(dw, hdrs)
+				dw.Close()
+			}
+		}
+	} else {
+		var f io.ReadCloser
+		f, err = daemon.store.OpenMessage(msgid)
+		if err != nil {
+			log.Println(self.name, "cannot open", msgid, err)
+			err = conn.PrintfLine("403 cannot open %s", msgid)
+			return
+		}
+		r := bufio.NewReader(f)
+		if cmd == "BODY" {
+			err = conn.PrintfLine("222 %d %s", n, msgid)
+			// skip over the header
+			var line string
+			for err == nil {
+				line, err = r.ReadString(10)
+				if strings.TrimRight(line, "\r\n") == "" {
+					break
+				}
+			}
+		} else {
+			err = conn.PrintfLine("220 %d %s", n, msgid)
+		}
+		if err == nil {
+			dw := conn.DotWriter()
+			_, err = io.Copy(dw, r)
+			dw.Close()
+		}
+		f.Close()
+	}
+	return
+}
+
+// handle NEXT and LAST commands, RFC 3977 section 6.1.3 and 6.1.4
+func (self *nntpConnection) handleNEXTorLAST(daemon *NNTPDaemon, next bool, conn *textproto.Conn) (err error) {
+	if self.group == "" {
+		err = conn.PrintfLine("412 No newsgroup selected")
+		return
+	} else if self.selected_article == "" {
+		err = conn.PrintfLine("420 Current article number is invalid")
+		return
+	}
+	var n int64
+	var msgid string
+	if next {
+		n, msgid, err = daemon.database.GetNextNNTPID(self.group, self.selected_number)
+	} else {
+		n, msgid, err = daemon.database.GetPrevNNTPID(self.group, self.selected_number)
+	}
+	if err == nil && msgid == "" {
+		if next {
+			err = conn.PrintfLine("421 No next article in this group")
+		} else {
+			err = conn.PrintfLine("422 No previous article in this group")
+		}
+	} else if err == nil {
+		self.selected_article = msgid
+		self.selected_number = n
+		err = conn.PrintfLine("223 %d %s", n, msgid)
+	} else {
+		log.Println(self.name, "failed to move article pointer in", self.group, err)
+		err = conn.PrintfLine("403 %s", err.Error())
+	}
+	return
+}
+
+// handle NEWNEWS command, RFC 3977 section 7.4
+// lists every article we obtained since the given time in groups matching the wildmat
+func (self *nntpConnection) handleNEWNEWS(daemon *NNTPDaemon, args []string, conn *textproto.Conn) (err error) {
+	if len(args) < 3 {
+		err = conn.PrintfLine("501 syntax is NEWNEWS wildmat date time [GMT]")
+		return
+	}
+	var t time.Time
+	t, err = parseNNTPDate(args[1], args[2], len(args) > 3 && strings.ToUpper(args[3]) == "GMT")
+	if err != nil {
+		err = conn.PrintfLine("501 invalid date: %s", err.Error())
+		return
+	}
+	var articles []ArticleEntry
+	articles, err = daemon.database.GetArticlesObtainedSince(t.Unix())
+	if err != nil {
+		log.Println(self.name, "failed to get new articles", err)
+		err = conn.PrintfLine("403 %s", err.Error())
+		return
+	}
+	err = conn.PrintfLine("230 list of new articles by message-id follows")
+	if err == nil {
+		dw := conn.DotWriter()
+		for _, article := range articles {
+			if wildmatMatch(args[0], article.Newsgroup()) {
+				_, err = io.WriteString(dw, article.MessageID()+"\n")
+				if err != nil {
+					break
+				}
+			}
+		}
+		dw.Close()
+	}
+	return
+}
+
+// handle NEWGROUPS command, RFC 3977 section 7.3
+func (self *nntpConnection) handleNEWGROUPS(daemon *NNTPDaemon, args []string, conn *textproto.Conn) (err error) {
+	if len(args) < 2 {
+		err = conn.PrintfLine("501 syntax is NEWGROUPS date time [GMT]")
+		return
+	}
+	var t time.Time
+	t, err = parseNNTPDate(args[0], args[1], len(args) > 2 && strings.ToUpper(args[2]) == "GMT")
+	if err != nil {
+		err = conn.PrintfLine("501 invalid date: %s", err.Error())
+		return
+	}
+	var groups []string
+	groups, err = daemon.database.GetNewsgroupsCreatedSince(t.Unix())
+	if err != nil {
+		log.Println(self.name, "failed to get new newsgroups", err)
+		err = conn.PrintfLine("403 %s", err.Error())
+		return
+	}
+	err = conn.PrintfLine("231 list of new newsgroups follows")
+	if err == nil {
+		dw := conn.DotWriter()
+		for _, group := range groups {
+			last, first, e := daemon.database.GetLastAndFirstForGroup(group)
+			if e == nil {
+				_, err = fmt.Fprintf(dw, "%s %d %d y\n", group, last, first)
+				if err != nil {
+					break
+				}
+			} else {
+				log.Println(self.name, "could not get low/high water mark for", group, e)
+			}
+		}
+		dw.Close()
 	}
 	return
 }
@@ -605,6 +1241,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 		// successful TAKETHIS
 		log.Println(msgid, "sent via", self.name)
 		self.messageSetProcessed(msgid)
+		self.unspool(msgid)
 		return
 		// TODO: remember success
 	} else if code == 431 {
@@ -613,6 +1250,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 		}
 		// CHECK said we would like this article later
 		self.messageSetProcessed(msgid)
+		self.releaseSpooled(msgid)
 	} else if code == 439 {
 		if msgid == nntpDummyArticle {
 			return
@@ -620,14 +1258,14 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 		// TAKETHIS failed
 		log.Println(msgid, "was not sent to", self.name, "denied:", line)
 		self.messageSetProcessed(msgid)
-		// TODO: remember denial
+		self.rememberRejection(daemon, msgid, line)
 	} else if code == 438 {
 		if msgid == nntpDummyArticle {
 			return
 		}
 		// they don't want the article
-		// TODO: remeber rejection
 		self.messageSetProcessed(msgid)
+		self.rememberRejection(daemon, msgid, line)
 	} else {
 		// handle command
 		parts := strings.Split(line, " ")
@@ -706,12 +1344,9 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 					return
 				}
 				// have we seen this article?
-				if daemon.store.HasArticle(msgid) {
+				if daemon.store.HasArticle(msgid) || daemon.knowsMessageID(msgid) {
 					// yeh don't want it
 					conn.PrintfLine("438 %s", msgid)
-				} else if daemon.database.ArticleBanned(msgid) {
-					// it's banned we don't want it
-					conn.PrintfLine("438 %s", msgid)
 				} else {
 					// yes we do want it and we don't have it
 					conn.PrintfLine("238 %s", msgid)
@@ -735,6 +1370,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 						_, err = io.Copy(ioutil.Discard, msg.Body)
 						if ban {
 							err = daemon.database.BanArticle(msgid, reason)
+							daemon.history.Record(msgid, HistoryRejected)
 						}
 					} else if err == nil {
 						// check if we don't have the rootpost
@@ -765,6 +1401,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 						_, err = io.Copy(ioutil.Discard, msg.Body)
 						if ban {
 							err = daemon.database.BanArticle(msgid, reason)
+							daemon.history.Record(msgid, HistoryRejected)
 						}
 					}
 				} else {
@@ -773,39 +1410,15 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 					reason = "error reading mime header"
 				}
 				conn.PrintfLine("%d %s %s", code, msgid, reason)
-			} else if cmd == "ARTICLE" {
-				if !ValidMessageID(msgid) {
-					if len(self.group) > 0 {
-						n, err := strconv.Atoi(msgid)
-						if err == nil {
-							msgid, err = daemon.database.GetMessageIDForNNTPID(self.group, int64(n))
-						}
-					}
-				}
-				if ValidMessageID(msgid) && daemon.store.HasArticle(msgid) {
-					// we have it yeh
-					f, err := daemon.store.OpenMessage(msgid)
-					if err == nil {
-						conn.PrintfLine("220 %s", msgid)
-						dw := conn.DotWriter()
-						_, err = io.Copy(dw, f)
-						dw.Close()
-						f.Close()
-					} else {
-						// wtf?!
-						conn.PrintfLine("503 idkwtf happened: %s", err.Error())
-					}
-				} else {
-					// we dont got it
-					conn.PrintfLine("430 %s", msgid)
-				}
+			} else if cmd == "ARTICLE" || cmd == "HEAD" || cmd == "BODY" || cmd == "STAT" {
+				err = self.handleArticleCommand(daemon, cmd, parts[1], conn)
 			} else if cmd == "IHAVE" {
 				if !self.authenticated {
 					conn.PrintfLine("483 You have not authenticated")
 				} else {
 					// handle IHAVE command
 					msgid := parts[1]
-					if daemon.database.HasArticleLocal(msgid) || daemon.database.HasArticle(msgid) || daemon.database.ArticleBanned(msgid) {
+					if daemon.database.HasArticleLocal(msgid) || daemon.knowsMessageID(msgid) {
 						// we don't want it
 						conn.PrintfLine("435 Article Not Wanted")
 					} else {
@@ -825,6 +1438,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 								_, err = io.Copy(ioutil.Discard, r)
 								if ban {
 									_ = daemon.database.BanArticle(msgid, reason)
+									daemon.history.Record(msgid, HistoryRejected)
 								}
 								conn.PrintfLine("437 Rejected do not send again bro")
 							} else {
@@ -864,17 +1478,21 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 				if len(group) > 0 && newsgroupValidFormat(group) {
 					if daemon.database.HasNewsgroup(group) {
 						// we has newsgroup
+						self.selectGroup(daemon, group)
 						var hi, lo int64
 						count, err := daemon.database.CountAllArticlesInGroup(group)
 						if err == nil {
 							hi, lo, err = daemon.database.GetLastAndFirstForGroup(group)
+						}
+						var overviews []NNTPOverview
+						if err == nil {
+							// only the articles we serve, not ones waiting in quarantine
+							overviews, err = daemon.database.GetNNTPHeadersInRange(group, lo, hi, nil)
 							if err == nil {
 								conn.PrintfLine("211 %d %d %d %s list follows", count, lo, hi, group)
 								dw := conn.DotWriter()
-								idx := lo
-								for idx <= hi {
-									fmt.Fprintf(dw, "%d\r\n", idx)
-									idx++
+								for _, ov := range overviews {
+									fmt.Fprintf(dw, "%d\r\n", ov.Number)
 								}
 								dw.Close()
 							}
@@ -890,6 +1508,10 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 				} else {
 					conn.PrintfLine("412 no newsgroup selected")
 				}
+			} else if cmd == "NEWNEWS" {
+				err = self.handleNEWNEWS(daemon, parts[1:], conn)
+			} else if cmd == "NEWGROUPS" {
+				err = self.handleNEWGROUPS(daemon, parts[1:], conn)
 			} else if cmd == "NEWSGROUPS" {
 				// handle NEWSGROUPS
 				conn.PrintfLine("231 List of newsgroups follow")
@@ -910,105 +1532,24 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 				// flush dotwriter
 				dw.Close()
 
-			} else if cmd == "XOVER" {
-				// handle XOVER
-				if self.group == "" {
-					conn.PrintfLine("412 No newsgroup selected")
-				} else {
-					// handle xover command
-					// right now it's every article in group
-					models, err := daemon.database.GetNNTPPostsInGroup(self.group)
-					if err == nil {
-						conn.PrintfLine("224 Overview information follows")
-						dw := conn.DotWriter()
-						for _, model := range models {
-							if model != nil {
-								if err == nil {
-									io.WriteString(dw, fmt.Sprintf("%.6d\t%s\t\"%s\" <%s@%s>\t%s\t%s\t%s\r\n", model.NNTPID(), model.Subject(), model.Name(), model.Name(), model.Frontend(), model.Date(), model.MessageID(), model.Reference()))
-								}
-							}
-						}
-						dw.Close()
-					} else {
-						log.Println(self.name, "error when getting posts in", self.group, err)
-						conn.PrintfLine("500 error, %s", err.Error())
-					}
-				}
-			} else if cmd == "HEAD" {
-				if len(self.group) == 0 {
-					// no group selected
-					conn.PrintfLine("412 No newsgroup slected")
-				} else {
-					// newsgroup is selected
-					// handle HEAD command
-					if len(parts) == 0 {
-						// we have no parameters
-						if len(self.selected_article) > 0 {
-							// we have a selected article
-						} else {
-							// no selected article
-							conn.PrintfLine("420 current article number is invalid")
-						}
-					} else {
-						// head command has 1 or more paramters
-						var n int64
-						var msgid string
-						var has bool
-						var code int
-						n, err = strconv.ParseInt(parts[1], 10, 64)
-						if err == nil {
-							// is a number
-							msgid, err = daemon.database.GetMessageIDForNNTPID(self.group, n)
-							if err == nil && len(msgid) > 0 {
-								has = daemon.store.HasArticle(msgid)
-							}
-							if !has {
-								code = 423
-							}
-						} else if ValidMessageID(parts[1]) {
-							msgid = parts[1]
-							has = daemon.store.HasArticle(msgid)
-							if has {
-								n, err = daemon.database.GetNNTPIDForMessageID(self.group, parts[1])
-							} else {
-								code = 430
-							}
-						}
-						if err == nil {
-							if has {
-								// we has
-								hdrs := daemon.store.GetHeaders(msgid)
-								if hdrs == nil {
-									// wtf can't load?
-									conn.PrintfLine("500 cannot load headers")
-								} else {
-									// headers loaded, send them
-									conn.PrintfLine("221 %d %s", n, msgid)
-									dw := conn.DotWriter()
-									err = writeMIMEHeader(dw, hdrs)
-									dw.Close()
-									hdrs = nil
-								}
-							} else if code > 0 {
-								// don't has
-								conn.PrintfLine("%d don't have article", code)
-							} else {
-								// invalid state
-								conn.PrintfLine("500 invalid state in HEAD, should have article but we don't")
-							}
-						} else {
-							// error occured
-							conn.PrintfLine("500 error in HEAD: %s", err.Error())
-						}
-					}
+			} else if cmd == "OVER" || cmd == "XOVER" {
+				args := parts[1:]
+				if cmd == "XOVER" && args[0] == "0" {
+					// older srnd asks for the whole group with XOVER 0
+					args = []string{"1-"}
 				}
+				err = self.handleOVER(daemon, args, conn)
+			} else if cmd == "HDR" {
+				err = self.handleHDR(daemon, 225, parts[1:], conn)
+			} else if cmd == "XHDR" {
+				err = self.handleHDR(daemon, 221, parts[1:], conn)
 			} else if cmd == "GROUP" {
 				// handle GROUP command
 				group := parts[1]
 				// check for newsgroup
 				if daemon.database.HasNewsgroup(group) {
 					// we have the group
-					self.group = group
+					self.selectGroup(daemon, group)
 					// count posts
 					number := daemon.database.CountPostsInGroup(group, 0)
 					// get hi/low water marks
@@ -1026,106 +1567,56 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 					// no such group
 					conn.PrintfLine("411 No Such Newsgroup")
 				}
-			} else if cmd == "LIST" && parts[1] == "NEWSGROUPS" {
-				conn.PrintfLine("215 list of newsgroups follows")
-				// handle list command
-				groups := daemon.database.GetAllNewsgroups()
-				dw := conn.DotWriter()
-				for _, group := range groups {
-					last, first, err := daemon.database.GetLastAndFirstForGroup(group)
-					if err == nil {
-						io.WriteString(dw, fmt.Sprintf("%s %d %d y\r\n", group, first, last))
-					} else {
-						log.Println("cannot get last/first ids for group", group, err)
-					}
+			} else if cmd == "LIST" {
+				// LIST on its own is LIST ACTIVE
+				keyword := "ACTIVE"
+				if len(parts) > 1 {
+					keyword = strings.ToUpper(parts[1])
 				}
-				dw.Close()
-			} else if cmd == "STAT" {
-				if len(self.group) == 0 {
-					if len(parts) == 2 {
-						// parameter given
-						msgid := parts[1]
-						// check for article
-						if ValidMessageID(msgid) && daemon.store.HasArticle(msgid) {
-							// valid message id
-							var n int64
-							n, err = daemon.database.GetNNTPIDForMessageID(self.group, msgid)
-							// exists
-							conn.PrintfLine("223 %d %s", n, msgid)
-							err = nil
+				if keyword == "ACTIVE" {
+					conn.PrintfLine("215 list of newsgroups follows")
+					groups := daemon.database.GetAllNewsgroups()
+					dw := conn.DotWriter()
+					for _, group := range groups {
+						last, first, err := daemon.database.GetLastAndFirstForGroup(group)
+						if err == nil {
+							// group high low status
+							io.WriteString(dw, fmt.Sprintf("%s %d %d y\r\n", group, last, first))
 						} else {
-							conn.PrintfLine("430 No article with that message-id")
+							log.Println("cannot get last/first ids for group", group, err)
 						}
-					} else {
-						conn.PrintfLine("412 No newsgroup selected")
 					}
-				} else if daemon.database.HasNewsgroup(self.group) {
-					// group specified
-					if len(parts) == 2 {
-						// parameter specified
-						var msgid string
-						var n int64
-						n, err = strconv.ParseInt(parts[1], 10, 64)
+					dw.Close()
+				} else if keyword == "NEWSGROUPS" {
+					conn.PrintfLine("215 list of newsgroups follows")
+					// handle list command
+					groups := daemon.database.GetAllNewsgroups()
+					dw := conn.DotWriter()
+					for _, group := range groups {
+						last, first, err := daemon.database.GetLastAndFirstForGroup(group)
 						if err == nil {
-							msgid, err = daemon.database.GetMessageIDForNNTPID(self.group, n)
-							if err != nil {
-								// error getting id
-								conn.PrintfLine("500 error getting nntp article id: %s", err.Error())
-								return
-							}
-						} else {
-							// message id
-							msgid = parts[1]
-						}
-						if ValidMessageID(msgid) && daemon.store.HasArticle(msgid) {
-							conn.PrintfLine("223 %d %s", n, msgid)
-						} else if n == 0 {
-							// was a message id
-							conn.PrintfLine("430 no such article")
+							io.WriteString(dw, fmt.Sprintf("%s %d %d y\r\n", group, first, last))
 						} else {
-							// was an article number
-							conn.PrintfLine("423 no article with that number")
+							log.Println("cannot get last/first ids for group", group, err)
 						}
-					} else {
-						conn.PrintfLine("420 Current article number is invalid")
-					}
-				} else {
-					conn.PrintfLine("500 invalid daemon state, got STAT with group set but we don't have that group now?")
-				}
-			} else if cmd == "XHDR" {
-				if len(self.group) > 0 {
-					var msgid string
-					var hdr string
-					if len(parts) == 2 {
-						// XHDR headername
-
-					} else if len(parts) == 3 {
-						// message id
-						msgid = parts[2]
-						hdr = parts[1]
-					} else {
-						// wtf?
-						conn.PrintfLine("502 no permission")
-						return
 					}
-					if ValidMessageID(msgid) {
-						hdrs := daemon.store.GetHeaders(msgid)
-						if hdrs != nil {
-							v := hdrs.Get(hdr, "")
-							conn.PrintfLine("221 header follows")
-							conn.PrintfLine(v)
-							conn.PrintfLine(".")
-						} else {
-							conn.PrintfLine("500 could not fetch headers for %s", msgid)
-						}
-					} else {
-						conn.PrintfLine("430 no such article")
+					dw.Close()
+				} else if keyword == "OVERVIEW.FMT" {
+					conn.PrintfLine("215 Order of fields in overview database")
+					dw := conn.DotWriter()
+					for _, field := range nntpOverviewFormat {
+						io.WriteString(dw, field+"\n")
 					}
+					dw.Close()
+				} else if keyword == "HEADERS" {
+					// we keep every header in the database so we can do any of them
+					conn.PrintfLine("215 headers and metadata items supported")
+					dw := conn.DotWriter()
+					io.WriteString(dw, ":\n:bytes\n")
+					dw.Close()
 				} else {
-					// no newsgroup
-					conn.PrintfLine("412 no newsgroup selected")
+					conn.PrintfLine("501 unknown LIST keyword")
 				}
-
 			} else {
 				log.Println(self.name, "invalid command recv'd", cmd)
 				conn.PrintfLine("500 Invalid command: %s", cmd)
@@ -1146,74 +1637,13 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 				}
 				dw.Close()
 			} else if line == "POST" {
-				if !self.authenticated {
-					// needs tls to work if not logged in
-					conn.PrintfLine("440 Posting Not Allowed")
-				} else {
-					// handle POST command
-					conn.PrintfLine("340 Yeeeh postit yo; end with <CR-LF>.<CR-LF>")
-					var msg *mail.Message
-					msg, err = readMIMEHeader(bufio.NewReader(conn.DotReader()))
-					var success bool
-					var reason string
-					if err == nil {
-						hdr := textproto.MIMEHeader(msg.Header)
-						if getMessageID(hdr) == "" {
-							hdr.Set("Message-ID", genMessageID(daemon.instance_name))
-						}
-						msgid = getMessageID(hdr)
-						hdr.Set("Date", timeNowStr())
-						ipaddr, _, _ := net.SplitHostPort(self.addr.String())
-						if len(ipaddr) > 0 {
-							// inject encrypted ip for poster
-							encaddr, err := daemon.database.GetEncAddress(ipaddr)
-							if err == nil {
-								hdr.Set("X-Encrypted-Ip", encaddr)
-							}
-						}
-						reason, _, err = self.checkMIMEHeader(daemon, hdr)
-						success = reason == "" && err == nil
-						if success {
-							refs := strings.Split(hdr.Get("References"), " ")
-							newsgroup := hdr.Get("Newsgroups")
-							for _, reference := range refs {
-								if reference != "" && ValidMessageID(reference) {
-									if !daemon.store.HasArticle(reference) && !daemon.database.IsExpired(reference) {
-										log.Println(self.name, "got reply to", reference, "but we don't have it")
-										go daemon.askForArticle(ArticleEntry{reference, newsgroup})
-									} else {
-										h := daemon.store.GetMIMEHeader(reference)
-										if strings.Trim(h.Get("References"), " ") == "" {
-											hdr.Set("References", getMessageID(h))
-										}
-									}
-								} else if reference != "" {
-									// bad message id
-									reason = "cannot reply with invalid reference, maybe you are replying to a reply?"
-									success = false
-								}
-							}
-							if success && daemon.database.HasNewsgroup(newsgroup) {
-								body := &io.LimitedReader{
-									R: msg.Body,
-									N: daemon.messageSizeLimitFor(newsgroup),
-								}
-								err = self.storeMessage(daemon, hdr, body)
-							}
-						}
-					}
-					if success {
-						// all gud
-						conn.PrintfLine("240 We got it, thnkxbai")
-					} else {
-						// failed posting
-						if err != nil {
-							log.Println(self.name, "failed nntp POST", err)
-							reason = err.Error()
-						}
-						conn.PrintfLine("441 Posting Failed %s", reason)
-					}
-				}
+				err = self.handlePOST(daemon, conn)
+			} else if cmd := strings.ToUpper(line); cmd == "OVER" || cmd == "XOVER" {
+				err = self.handleOVER(daemon, nil, conn)
+			} else if cmd := strings.ToUpper(line); cmd == "ARTICLE" || cmd == "HEAD" || cmd == "BODY" || cmd == "STAT" {
+				err = self.handleArticleCommand(daemon, cmd, "", conn)
+			} else if cmd := strings.ToUpper(line); cmd == "NEXT" || cmd == "LAST" {
+				err = self.handleNEXTorLAST(daemon, cmd == "NEXT", conn)
 			} else {
 				conn.PrintfLine("500 wut?")
 			}
@@ -1225,7 +1655,17 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 func (self *nntpConnection) startStreaming(daemon *NNTPDaemon, reader bool, conn *textproto.Conn) {
 	self.keepalive = time.NewTicker(time.Minute)
 	defer self.keepalive.Stop()
+	// send what we didn't get to send before
+	go self.offerSpooled(daemon)
 	err := self.handleStreaming(daemon, conn)
+	if self.spool != nil {
+		// let the other connections of this feed send what we didn't
+		self.pending_access.Lock()
+		for msgid := range self.pending {
+			self.spool.Release(msgid)
+		}
+		self.pending_access.Unlock()
+	}
 	if err == nil {
 		log.Println(self.name, "done with streaming")
 	} else {
@@ -1419,6 +1859,80 @@ func (self *nntpConnection) scrapeServer(daemon *NNTPDaemon, conn *textproto.Con
 	return
 }
 
+// how far back before our last sync time we ask for new articles
+// covers clock differences between us and the remote server
+const nntpSyncSlack = 10 * 60
+
+// pull every article the remote server obtained since our last sync with this feed
+// scrape everything if we never synced or they don't do NEWNEWS
+func (self *nntpConnection) pullNewArticles(daemon *NNTPDaemon, conn *textproto.Conn, feed string) (err error) {
+	self.abort = func() {
+		conn.Close()
+	}
+
+	defer func() {
+		self.abort = nil
+	}()
+	start := timeNow()
+	var since int64
+	since, err = daemon.database.GetFeedSyncTime(feed)
+	if err != nil {
+		log.Println(self.name, "cannot get last sync time", err)
+		return
+	}
+	if since > 0 {
+		since -= nntpSyncSlack
+		log.Println(self.name, "pull articles since", time.Unix(since, 0).UTC())
+		err = conn.PrintfLine("NEWNEWS * %s GMT", time.Unix(since, 0).UTC().Format("20060102 150405"))
+		if err != nil {
+			return
+		}
+		var code int
+		code, _, err = conn.ReadCodeLine(-1)
+		if code == 230 {
+			var msgids []string
+			dr := conn.DotReader()
+			sc := bufio.NewScanner(dr)
+			for sc.Scan() {
+				msgid := strings.TrimSpace(sc.Text())
+				if ValidMessageID(msgid) {
+					msgids = append(msgids, msgid)
+				}
+			}
+			err = sc.Err()
+			if err != nil {
+				log.Println(self.name, "bad multiline response from NEWNEWS command", err)
+				return
+			}
+			log.Println(self.name, "has", len(msgids), "new articles")
+			for _, msgid := range msgids {
+				if daemon.database.HasArticle(msgid) {
+					// we have it
+				} else if daemon.database.ArticleBanned(msgid) {
+					// we don't want it
+				} else {
+					err = self.requestArticle(daemon, conn, msgid)
+					if err != nil {
+						log.Println(self.name, "failed to obtain article", msgid, err)
+						return
+					}
+				}
+			}
+		} else if err == nil {
+			// probably an older srnd
+			log.Println(self.name, "does not do NEWNEWS, got", code, "so do full scrape")
+			err = self.scrapeServer(daemon, conn)
+		}
+	} else {
+		// first time
+		err = self.scrapeServer(daemon, conn)
+	}
+	if err == nil {
+		err = daemon.database.SetFeedSyncTime(feed, start)
+	}
+	return
+}
+
 // ask for an article from the remote server
 // feed it to the daemon if we get it
 func (self *nntpConnection) requestArticle(daemon *NNTPDaemon, conn *textproto.Conn, msgid string) (err error) {
@@ -1445,6 +1959,7 @@ func (self *nntpConnection) requestArticle(daemon *NNTPDaemon, conn *textproto.C
 					io.Copy(ioutil.Discard, msg.Body)
 					if ban {
 						daemon.database.BanArticle(msgid, reason)
+						daemon.history.Record(msgid, HistoryRejected)
 					}
 				} else {
 					// yeh we want it open up a file to store it in
@@ -1456,6 +1971,7 @@ func (self *nntpConnection) requestArticle(daemon *NNTPDaemon, conn *textproto.C
 					if err != nil {
 						log.Println(self.name, "failed to obtain article", err)
 						daemon.database.BanArticle(msgid, err.Error())
+						daemon.history.Record(msgid, HistoryRejected)
 					}
 				}
 			} else {
@@ -1511,6 +2027,8 @@ func (self *nntpConnection) startReader(daemon *NNTPDaemon, conn *textproto.Conn
 func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, reader, use_tls bool, preferMode string, nconn net.Conn, conf *FeedConfig) {
 	defer nconn.Close()
 	self.addr = nconn.RemoteAddr()
+	metrics.Connection(inbound, 1)
+	defer metrics.Connection(inbound, -1)
 	var err error
 	var line string
 	var success bool
@@ -1528,6 +2046,22 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 		// we are authenticated if we are don't need tls
 		conn = textproto.NewConn(nconn)
 	}
+	if !inbound && self.compress {
+		log.Println(self.name, "COMPRESS DEFLATE with", self.hostname)
+		var _conn *textproto.Conn
+		_conn, err = SendCompress(conn)
+		if err == nil {
+			conn = _conn
+			self.compressed = true
+		} else if err == CompressNotSupported {
+			// carry on without it
+			log.Println(self.name, err)
+			err = nil
+		} else {
+			log.Println(self.name, "COMPRESS failed", err)
+			return
+		}
+	}
 	if !inbound {
 		if preferMode == "stream" {
 			// try outbound streaming
@@ -1567,6 +2101,23 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 			conn.Close()
 			return
 		}
+		if err == nil && inbound && self.mode != "STREAM" && strings.HasPrefix(strings.ToUpper(line), "COMPRESS ") {
+			// we can't swap out the connection under streaming
+			if self.compressed {
+				conn.PrintfLine("502 compression already active")
+			} else {
+				var _conn *textproto.Conn
+				_conn, err = HandleCompress(conn, line[9:])
+				if err == nil {
+					conn = _conn
+					self.compressed = true
+					log.Println(self.name, "COMPRESS DEFLATE active")
+				} else if err == CompressNotSupported {
+					err = nil
+				}
+			}
+			continue
+		}
 		if self.mode == "" {
 			if inbound {
 				if len(line) == 0 {
@@ -1575,7 +2126,9 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 				}
 				parts := strings.Split(line, " ")
 				cmd := parts[0]
-				if cmd == "STARTTLS" {
+				if cmd == "STARTTLS" && self.compressed {
+					conn.PrintfLine("502 STARTTLS not allowed with active compression")
+				} else if cmd == "STARTTLS" {
 					_conn, state, err := HandleStartTLS(nconn, daemon.GetOurTLSConfig())
 					if err == nil {
 						// we are now tls
@@ -1590,9 +2143,14 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 					// write capabilities
 					conn.PrintfLine("101 i support to the following:")
 					dw := conn.DotWriter()
-					caps := []string{"VERSION 2", "READER", "STREAMING", "IMPLEMENTATION srndv2", "POST", "IHAVE", "AUTHINFO"}
-					if daemon.CanTLS() {
-						caps = append(caps, "STARTTLS")
+					caps := []string{"VERSION 2", "READER", "STREAMING", "IMPLEMENTATION srndv2", "POST", "IHAVE", "AUTHINFO", "OVER MSGID", "HDR", "LIST ACTIVE NEWSGROUPS HEADERS OVERVIEW.FMT", "NEWNEWS"}
+					if self.compressed {
+						// no STARTTLS or COMPRESS once we are compressed
+					} else {
+						caps = append(caps, "COMPRESS DEFLATE")
+						if daemon.CanTLS() {
+							caps = append(caps, "STARTTLS")
+						}
 					}
 					for _, cap := range caps {
 						io.WriteString(dw, cap)
@@ -1606,8 +2164,12 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 						if mode == "READER" {
 							// set reader mode
 							self.mode = "READER"
-							// we'll allow posting for reader
-							conn.PrintfLine("200 Posting is Permitted awee yeh")
+							// we'll allow posting for reader if they are logged in
+							if self.authenticated {
+								conn.PrintfLine("200 Posting is Permitted awee yeh")
+							} else {
+								conn.PrintfLine("201 No posting Permitted")
+							}
 						} else if mode == "STREAM" {
 							if !self.authenticated {
 								conn.PrintfLine("483 Streaming Denied")
@@ -1624,7 +2186,10 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 					// handle a it as a command, we don't have a mode set
 					parts := strings.Split(line, " ")
 					cmd := parts[0]
-					if cmd == "STARTTLS" {
+					if cmd == "STARTTLS" && self.compressed {
+						conn.PrintfLine("502 STARTTLS not allowed with active compression")
+						continue
+					} else if cmd == "STARTTLS" {
 						_conn, state, err := HandleStartTLS(nconn, daemon.GetOurTLSConfig())
 						if err == nil {
 							// we are now tls
@@ -28,7 +28,7 @@ import (
 
 // postgres database driver implementation
 type PostgresDatabase struct {
-	conn   *sql.DB
+	conn   *metricsDB
 	db_str string
 	stmt   map[string]string
 }
@@ -51,10 +51,12 @@ func NewPostgresDatabase(host, port, user, password string) Database {
 		}
 	}
 	log.Println("Connecting to postgres...")
-	db.conn, err = sql.Open("postgres", db.db_str)
+	var conn *sql.DB
+	conn, err = sql.Open("postgres", db.db_str)
 	if err != nil {
 		log.Fatalf("can`not open connection to db: %s", err)
 	}
+	db.conn = &metricsDB{DB: conn}
 	db.SetConnectionLifetime(30)
 	db.SetMaxOpenConns(30)
 	db.SetMaxIdleConns(10)
@@ -127,6 +129,8 @@ const CheckEncIPBanned = "CheckEncIPBanned"
 const GetFirstAndLastForGroup = "GetFirstAndLastForGroup"
 const GetMessageIDForNNTPID = "GetMessageIDForNNTPID"
 const GetNNTPIDForMessageID = "GetNNTPIDForMessageID"
+const GetNextNNTPID = "GetNextNNTPID"
+const GetPrevNNTPID = "GetPrevNNTPID"
 const IsExpired = "IsExpired"
 const GetLastDaysPostsForGroup = "GetLastDaysPostsForGroup"
 const GetLastDaysPosts = "GetLastDaysPosts"
@@ -139,12 +143,16 @@ const CountAllArticlesInGroup = "CountAllArticlesInGroup"
 const GetMessageIDByCIDR = "GetMessageIDByCIDR"
 const GetMessageIDByEncryptedIP = "GetMessageIDByEncryptedIP"
 const GetPostsBefore = "GetPostsBefore"
-const SearchQuery_1 = "SearchQuery_1"
-const SearchQuery_2 = "SearchQuery_2"
 const SearchByHash_1 = "SearchByHash_1"
 const SearchByHash_2 = "SearchByHash_2"
 const GetNNTPPostsInGroup = "GetNNTPPostsInGroup"
 const GetCitesByPostHashLike = "GetCitesByPostHashLike"
+const GetNNTPHeadersInRange_1 = "GetNNTPHeadersInRange_1"
+const GetNNTPHeadersInRange_2 = "GetNNTPHeadersInRange_2"
+const GetArticlesObtainedSince = "GetArticlesObtainedSince"
+const GetNewsgroupsCreatedSince = "GetNewsgroupsCreatedSince"
+const FeedRejectedArticle = "FeedRejectedArticle"
+const GetMessageHistory = "GetMessageHistory"
 
 func (self *PostgresDatabase) prepareStatements() {
 	self.stmt = map[string]string{
@@ -154,7 +162,7 @@ func (self *PostgresDatabase) prepareStatements() {
 		GetPostsInGroup:                 "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE newsgroup = $1 ORDER BY time_posted",
 		GetPostModel:                    "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id = $1 LIMIT 1",
 		GetArticlePubkey:                "SELECT pubkey FROM ArticleKeys WHERE message_id = $1",
-		GetThreadModel:                  "SELECT ArticlePosts.newsgroup, ArticlePosts.message_id, ArticlePosts.name, ArticlePosts.subject, ArticlePosts.time_posted, ArticlePosts.message, ArticlePosts.addr FROM ArticlePosts WHERE ArticlePosts.message_id = $1 OR ArticlePosts.ref_id = $1 ORDER BY ArticlePosts.time_posted",
+		GetThreadModel:                  "SELECT ArticlePosts.newsgroup, ArticlePosts.message_id, ArticlePosts.name, ArticlePosts.subject, ArticlePosts.time_posted, ArticlePosts.message, ArticlePosts.addr FROM ArticlePosts WHERE ( ArticlePosts.message_id = $1 OR ArticlePosts.ref_id = $1 ) AND NOT " + articleFlagSQL("ArticlePosts.message_id", ArticleFlagHidden) + " ORDER BY ArticlePosts.time_posted",
 		GetThreadModelPubkeys:           "SELECT pubkey, message_id from ArticleKeys WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 OR message_id = $1 )",
 		GetThreadModelAttachments:       "SELECT filename, filepath, message_id from ArticleAttachments WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 OR message_id = $1 )",
 		DeleteArticle_1:                 "DELETE FROM NNTPHeaders WHERE header_article_message_id = $1",
@@ -163,13 +171,13 @@ func (self *PostgresDatabase) prepareStatements() {
 		DeleteArticle_4:                 "DELETE FROM ArticleKeys WHERE message_id = $1",
 		DeleteArticle_5:                 "DELETE FROM ArticleAttachments WHERE message_id = $1",
 		DeleteThread:                    "DELETE FROM ArticleThreads WHERE root_message_id = $1",
-		GetThreadReplyPostModels_1:      "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 ORDER BY time_posted DESC LIMIT $2 ) ORDER BY time_posted ASC",
-		GetThreadReplyPostModels_2:      "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 ) ORDER BY time_posted ASC",
+		GetThreadReplyPostModels_1:      "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ORDER BY time_posted DESC LIMIT $2 ) ORDER BY time_posted ASC",
+		GetThreadReplyPostModels_2:      "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ) ORDER BY time_posted ASC",
 		GetThreadReplies_1:              "SELECT message_id FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 ORDER BY time_posted DESC LIMIT $2 ) ORDER BY time_posted ASC",
 		GetThreadReplies_2:              "SELECT message_id FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 ) ORDER BY time_posted ASC",
 		GetGroupThreads:                 "SELECT message_id FROM ArticlePosts WHERE newsgroup = $1 AND ref_id = '' ",
-		GetLastBumpedThreadsPaginated_1: "SELECT root_message_id, newsgroup FROM ArticleThreads WHERE newsgroup = $1 ORDER BY last_bump DESC LIMIT $2",
-		GetLastBumpedThreadsPaginated_2: "SELECT root_message_id, newsgroup FROM ArticleThreads WHERE newsgroup != 'ctl' ORDER BY last_bump DESC LIMIT $1",
+		GetLastBumpedThreadsPaginated_1: "SELECT root_message_id, newsgroup FROM ArticleThreads WHERE newsgroup = $1 AND NOT " + articleFlagSQL("root_message_id", ArticleFlagHidden) + " ORDER BY " + articleFlagSQL("root_message_id", ArticleFlagSticky) + " DESC, last_bump DESC LIMIT $2",
+		GetLastBumpedThreadsPaginated_2: "SELECT root_message_id, newsgroup FROM ArticleThreads WHERE newsgroup != 'ctl' AND NOT " + articleFlagSQL("root_message_id", ArticleFlagHidden) + " ORDER BY " + articleFlagSQL("root_message_id", ArticleFlagSticky) + " DESC, last_bump DESC LIMIT $1",
 		HasNewsgroup:                    "SELECT COUNT(name) FROM Newsgroups WHERE name = $1",
 		HasArticle:                      "SELECT COUNT(message_id) FROM Articles WHERE message_id = $1",
 		HasArticleLocal:                 "SELECT COUNT(message_id) FROM ArticlePosts WHERE message_id = $1",
@@ -177,7 +185,7 @@ func (self *PostgresDatabase) prepareStatements() {
 		GetPostAttachmentModels:         "SELECT filepath, filename FROM ArticleAttachments WHERE message_id = $1",
 		RegisterArticle_1:               "INSERT INTO Articles (message_id, message_id_hash, message_newsgroup, time_obtained, message_ref_id) VALUES($1, $2, $3, $4, $5)",
 		RegisterArticle_2:               "UPDATE Newsgroups SET last_post = $1 WHERE name = $2",
-		RegisterArticle_3:               "INSERT INTO ArticlePosts(newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		RegisterArticle_3:               "INSERT INTO ArticlePosts(newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr, search) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, " + postSearchVector("$5", "$4", "$8") + ")",
 		RegisterArticle_4:               "INSERT INTO ArticleThreads(root_message_id, last_bump, last_post, newsgroup) VALUES($1, $2, $2, $3)",
 		RegisterArticle_5:               "SELECT COUNT(*) FROM ArticlePosts WHERE ref_id = $1",
 		RegisterArticle_6:               "UPDATE ArticleThreads SET last_bump = $2 WHERE root_message_id = $1",
@@ -188,14 +196,16 @@ func (self *PostgresDatabase) prepareStatements() {
 		GetAllArticlesInGroup:           "SELECT message_id FROM ArticlePosts WHERE newsgroup = $1",
 		GetAllArticles:                  "SELECT message_id, newsgroup FROM ArticlePosts",
 		GetMessageIDByHash:              "SELECT message_id, message_newsgroup FROM Articles WHERE message_id_hash = $1 LIMIT 1",
-		CheckEncIPBanned:                "SELECT COUNT(*) FROM EncIPBans WHERE encaddr = $1",
+		CheckEncIPBanned:                "SELECT scope FROM EncIPBans WHERE encaddr = $1 AND ( expires < 0 OR expires > $2 )",
 		GetFirstAndLastForGroup:         "WITH x(min_no, max_no) AS ( SELECT MIN(message_no) AS min_no, MAX(message_no) AS max_no FROM ArticleNumbers WHERE newsgroup = $1) SELECT CASE WHEN min_no IS NULL THEN 0 ELSE min_no END AS min_no FROM x UNION SELECT CASE WHEN max_no IS NULL THEN 1 ELSE max_no END AS max_no FROM x",
 		GetMessageIDForNNTPID:           "SELECT message_id FROM ArticleNumbers WHERE newsgroup = $1 AND message_no = $2 LIMIT 1",
 		GetNNTPIDForMessageID:           "SELECT message_no FROM ArticleNumbers WHERE newsgroup = $1 AND message_id = $2 LIMIT 1",
+		GetNextNNTPID:                   "SELECT message_no, message_id FROM ArticleNumbers WHERE newsgroup = $1 AND message_no > $2 ORDER BY message_no ASC LIMIT 1",
+		GetPrevNNTPID:                   "SELECT message_no, message_id FROM ArticleNumbers WHERE newsgroup = $1 AND message_no < $2 ORDER BY message_no DESC LIMIT 1",
 		IsExpired:                       "WITH x(msgid) AS ( SELECT message_id FROM Articles WHERE message_id = $1 INTERSECT ( SELECT message_id FROM ArticlePosts WHERE message_id = $1 ) ) SELECT COUNT(*) FROM x",
 		GetLastDaysPostsForGroup:        "SELECT COUNT(*) FROM ArticlePosts WHERE time_posted < $1 AND time_posted > $2 AND newsgroup = $3",
 		GetLastDaysPosts:                "SELECT COUNT(*) FROM ArticlePosts WHERE time_posted < $1 AND time_posted > $2",
-		GetLastPostedPostModels:         "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE newsgroup != 'ctl' ORDER BY time_posted DESC LIMIT $1",
+		GetLastPostedPostModels:         "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE newsgroup != 'ctl' AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ORDER BY time_posted DESC LIMIT $1",
 		GetMonthlyPostHistory:           "SELECT time_posted FROM ArticlePosts WHERE time_posted > 0 ORDER BY time_posted ASC LIMIT 1",
 		CheckNNTPLogin:                  "SELECT login_hash, login_salt FROM NNTPUsers WHERE username = $1",
 		CheckNNTPUserExists:             "SELECT COUNT(username) FROM NNTPUsers WHERE username = $1",
@@ -204,430 +214,95 @@ func (self *PostgresDatabase) prepareStatements() {
 		GetMessageIDByCIDR:              "SELECT message_id FROM ArticlePosts WHERE addr IN ( SELECT encaddr FROM EncryptedAddrs WHERE addr_cidr <<= cidr($1) )",
 		GetMessageIDByEncryptedIP:       "SELECT message_id FROM ArticlePosts WHERE addr = $1",
 		GetPostsBefore:                  "SELECT message_id FROM ArticlePosts WHERE time_posted < $1",
-		SearchQuery_1:                   "SELECT newsgroup, message_id, ref_id FROM ArticlePosts WHERE message LIKE $1 ORDER BY time_posted DESC",
-		SearchQuery_2:                   "SELECT newsgroup, message_id, ref_id FROM ArticlePosts WHERE newsgroup = $1 AND message LIKE $2 ORDER BY time_posted DESC",
 		SearchByHash_1:                  "SELECT message_newsgroup, message_id, message_ref_id FROM Articles WHERE message_id_hash LIKE $1 ORDER BY time_obtained DESC",
 		SearchByHash_2:                  "SELECT message_newsgroup, message_id, message_ref_id FROM Articles WHERE message_newsgroup = $2 AND message_id_hash LIKE $1 ORDER BY time_obtained DESC",
-		GetNNTPPostsInGroup:             "SELECT message_no, ArticlePosts.message_id, subject, time_posted, ref_id, name, path FROM ArticleNumbers INNER JOIN ArticlePosts ON ArticleNumbers.message_id = ArticlePosts.message_id WHERE ArticlePosts.newsgroup = $1 ORDER BY message_no",
+		GetNNTPPostsInGroup:             "SELECT message_no, ArticlePosts.message_id, subject, time_posted, ref_id, name, path FROM ArticleNumbers INNER JOIN ArticlePosts ON ArticleNumbers.message_id = ArticlePosts.message_id WHERE ArticlePosts.newsgroup = $1 AND ArticlePosts.message_id " + notQuarantinedQuery + " ORDER BY message_no",
 		GetCitesByPostHashLike:          "SELECT message_id, message_ref_id FROM Articles WHERE message_id_hash LIKE $1",
+		GetNNTPHeadersInRange_1:         "SELECT message_no, message_id, header_name, header_value FROM ArticleNumbers LEFT OUTER JOIN NNTPHeaders ON ( ArticleNumbers.message_id = NNTPHeaders.header_article_message_id AND header_name = ANY($4) ) WHERE newsgroup = $1 AND message_no >= $2 AND message_no <= $3 AND message_id " + notQuarantinedQuery + " ORDER BY message_no",
+		GetNNTPHeadersInRange_2:         "SELECT message_no, message_id, header_name, header_value FROM ArticleNumbers LEFT OUTER JOIN NNTPHeaders ON ( ArticleNumbers.message_id = NNTPHeaders.header_article_message_id AND header_name = ANY($3) ) WHERE newsgroup = $1 AND message_no >= $2 AND message_id " + notQuarantinedQuery + " ORDER BY message_no",
+		GetArticlesObtainedSince:        "SELECT message_id, message_newsgroup FROM Articles WHERE time_obtained >= $1 AND message_id " + notQuarantinedQuery + " ORDER BY time_obtained",
+		GetNewsgroupsCreatedSince:       "SELECT name FROM Newsgroups WHERE time_created >= $1",
+		FeedRejectedArticle:             "SELECT COUNT(*) FROM FeedRejections WHERE feed = $1 AND message_id = $2",
+		GetMessageHistory:               "SELECT disposition FROM MessageHistory WHERE message_id = $1",
 	}
-
+	names := make(map[string]string)
+	for name, query := range self.stmt {
+		names[query] = name
+	}
+	self.conn.names = names
 }
 
 func (self *PostgresDatabase) CreateTables() {
-	for {
-		version := self.getDBVersion()
-		if version == -1 {
-			// no tables
-			self.createTablesV0()
-			self.upgrade0to1()
-		} else if version == 0 {
-			// upgrade to version 1
-			self.upgrade0to1()
-		} else if version == 1 {
-			// upgrade to version 2
-			self.upgrade1to2()
-		} else if version == 2 {
-			// upgrade to version 3
-			self.upgrade2to3()
-		} else if version == 3 {
-			// update to version 4
-			self.upgrade3to4()
-		} else if version == 4 {
-			// update to version 5
-			self.upgrade4to5()
-		} else if version == 5 {
-			// upgrade to version 6
-			self.upgrade5to6()
-		} else if version == 6 {
-			// upgrade to version 7
-			self.upgrade6to7()
-		} else if version == 7 {
-			// we are up to date
-			log.Println("we are up to date at version", version)
-			break
-		}
+	err := self.MigrateSchema()
+	if err != nil {
+		log.Fatalf("cannot migrate database schema: %s, login was '%s'", err, self.db_str)
 	}
 	self.prepareStatements()
 }
 
-func (self *PostgresDatabase) upgrade1to2() {
-	log.Println("migrating... 1 -> 2")
-
-	var err error
-
-	tables := make(map[string]string)
-
-	tables["NNTPUsers"] = `(
-                           username VARCHAR(255) PRIMARY KEY,
-                           login_hash VARCHAR(255) NOT NULL,
-                           login_salt VARCHAR(255) NOT NULL
-                         )`
-
-	table_order := []string{"NNTPUsers"}
-
-	for _, table := range table_order {
-		q := tables[table]
-		// create table
-		_, err = self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, q))
-		if err != nil {
-			log.Fatalf("cannot create table %s, %s, login was '%s'", table, err, self.db_str)
-		}
-	}
-	self.setDBVersion(2)
-}
-
-func (self *PostgresDatabase) upgrade2to3() {
-	log.Println("migrating... 2 -> 3")
-
-	var err error
-
-	tables := make(map[string]string)
-
-	tables["NNTPHeaders"] = `(
-                             header_name VARCHAR(255) NOT NULL,
-                             header_value TEXT NOT NULL,
-                             header_article_message_id VARCHAR(255) NOT NULL,
-                             FOREIGN KEY(header_article_message_id) REFERENCES ArticlePosts(message_id)
-                           )`
-
-	table_order := []string{"NNTPHeaders"}
-
-	for _, table := range table_order {
-		q := tables[table]
-		// create table
-		_, err = self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, q))
-		if err != nil {
-			log.Fatalf("cannot create table %s, %s, login was '%s'", table, err, self.db_str)
-		}
-	}
-	cmds := []string{
-		"CREATE INDEX ON NNTPHeaders(header_name)",
-	}
-	for _, cmd := range cmds {
-		_, err = self.conn.Exec(cmd)
-		checkError(err)
-	}
-	self.setDBVersion(3)
-}
-
-func (self *PostgresDatabase) upgrade5to6() {
-	log.Println("migrating... 5 -> 6")
-	tables := make(map[string]string)
-
-	// public key properties, key value pair: pubkey -> status
-	tables["PubkeyProperties"] = `(
-                                  pubkey VARCHAR(255) PRIMARY KEY,
-                                  status VARCHAR(255) NOT NULL
-                                )`
-
-	// ledger of public key property modification events
-	tables["PubkeyModifyEvents"] = `(
-                                     source_pubkey VARCHAR(255) NOT NULL,
-                                     target_pubkey VARCHAR(255) NOT NULL,
-                                     event_time BIGINT NOT NULL,
-                                     status VARCHAR(255) NOT NULL,
-                                     id BIGSERIAL PRIMARY KEY
-                                   )`
-
-	table_order := []string{"PubkeyProperties", "PubkeyModifyEvents"}
-	for _, t := range table_order {
-		q := tables[t]
-		// create table
-		_, err := self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", t, q))
-		if err != nil {
-			log.Fatalf("cannot create table %s, %s", t, err)
-		}
-	}
-
-	self.setDBVersion(6)
-}
-
-func (self *PostgresDatabase) upgrade4to5() {
-	log.Println("migrating... 4 -> 5")
-	cmds := []string{
-		"ALTER TABLE EncryptedAddrs DROP COLUMN IF EXISTS addr_cidr",
-		"ALTER TABLE EncryptedAddrs ADD COLUMN addr_cidr cidr",
-		"UPDATE EncryptedAddrs AS a SET addr_cidr = e.cidr FROM ( SELECT cidr(addr), addr FROM EncryptedAddrs) AS e WHERE e.addr = a.addr",
-	}
-	for _, cmd := range cmds {
-		_, err := self.conn.Exec(cmd)
-		if err != nil {
-			log.Fatalf("failed to execute query `%s`, %s", cmd, err.Error())
-		}
-	}
-	self.setDBVersion(5)
-}
-
-func (self *PostgresDatabase) upgrade3to4() {
-	log.Println("migrating... 3 -> 4")
-	tables := make(map[string]string)
-	tables["ArticleNumbers"] = `(
-                                newsgroup VARCHAR(255) NOT NULL,
-                                message_id VARCHAR(255) NOT NULL,
-                                message_no BIGINT NOT NULL,
-                                FOREIGN KEY (newsgroup) REFERENCES Newsgroups(name),
-                                FOREIGN KEY (message_id) REFERENCES ArticlePosts(message_id)
-                              )`
-	table_order := []string{"ArticleNumbers"}
-	cmds := []string{"CREATE INDEX ON ArticleNumbers(message_no)"}
-	for _, table := range table_order {
-		_, err := self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, tables[table]))
-		if err != nil {
-			log.Fatalf("cannot create table %s: %s", table, err.Error())
-		}
-	}
-
-	for _, cmd := range cmds {
-		_, err := self.conn.Exec(cmd)
-		if err != nil {
-			log.Fatalf("failed to execute query: %s, %s", cmd, err.Error())
-		}
-	}
-
-	log.Println("migrating post numbers, this can take a bit DO NOT INTERRUPT")
-	rows, err := self.conn.Query("SELECT message_id, newsgroup FROM ArticlePosts ORDER BY time_posted DESC")
-	if err != nil {
-		log.Fatalf("could not query ArticlePosts table: %s", err.Error())
-	}
-	counter := int64(0)
-	for rows.Next() {
-		counter++
-		var msgid, group string
-		err = rows.Scan(&msgid, &group)
-		if err != nil {
-			log.Fatalf("could not scan row: %s", err.Error())
-		}
-		err = self.registerNNTPNumber(group, msgid)
-		if err != nil {
-			log.Fatalf("could not migrate article %s in %s, %s", msgid, group, err.Error())
-		}
-		if counter%100 == 0 {
-			log.Println("migrated ", counter)
-		}
+func (self *PostgresDatabase) migrator() *schemaMigrator {
+	return &schemaMigrator{
+		conn:          self.conn,
+		migrations:    postgresMigrations,
+		legacyVersion: self.getDBVersion,
 	}
-	log.Println("total migrated posts: ", counter)
-	self.setDBVersion(4)
 }
 
-func (self *PostgresDatabase) upgrade0to1() {
-
-	// begin >:D
-	log.Println("migrating... 0 -> 1")
-
-	var err error
-
-	cmds := []string{
-		// newsgroups table
-		"CREATE INDEX ON Newsgroups(name)",
-		// article posts table
-		"ALTER TABLE ArticlePosts DROP COLUMN IF EXISTS addr",
-		"ALTER TABLE ArticlePosts ADD COLUMN addr VARCHAR(255)",
-		"ALTER TABLE ArticlePosts DROP CONSTRAINT IF EXISTS group_depend",
-		"ALTER TABLE ArticlePosts ADD CONSTRAINT group_depend FOREIGN KEY(newsgroup) REFERENCES Newsgroups(name) ON DELETE CASCADE",
-		"ALTER TABLE ArticlePosts DROP CONSTRAINT IF EXISTS msgid_pk",
-		"ALTER TABLE ArticlePosts ADD CONSTRAINT msgid_pk PRIMARY KEY(message_id)",
-		"CREATE INDEX ON ArticlePosts(ref_id)",
-		// article keys table
-		"DELETE FROM ArticleKeys WHERE message_id NOT IN ( SELECT message_id FROM ArticlePosts )",
-		"ALTER TABLE ArticleKeys DROP CONSTRAINT IF EXISTS msgid_depend",
-		"ALTER TABLE ArticleKeys ADD CONSTRAINT msgid_depend FOREIGN KEY(message_id) REFERENCES ArticlePosts(message_id) ON DELETE CASCADE",
-		// article threads table
-		"ALTER TABLE ArticleThreads DROP CONSTRAINT IF EXISTS msgid_depend",
-		"ALTER TABLE ArticleThreads DROP CONSTRAINT IF EXISTS group_depend",
-		"DELETE FROM ArticleThreads WHERE root_message_id NOT IN ( SELECT message_id FROM ArticlePosts )",
-		"ALTER TABLE ArticleThreads ADD CONSTRAINT msgid_depend FOREIGN KEY(root_message_id) REFERENCES ArticlePosts(message_id) ON DELETE CASCADE",
-		"ALTER TABLE ArticleThreads ADD CONSTRAINT group_depend FOREIGN KEY(newsgroup) REFERENCES Newsgroups(name) ON DELETE CASCADE",
-		// article attachments table
-		"ALTER TABLE ArticleAttachments DROP CONSTRAINT IF EXISTS msgid_depend",
-		"DELETE FROM ArticleAttachments WHERE message_id NOT IN ( SELECT message_id FROM ArticlePosts )",
-		"ALTER TABLE ArticleAttachments ADD CONSTRAINT msgid_depend FOREIGN KEY(message_id) REFERENCES ArticlePosts(message_id) ON DELETE CASCADE",
-	}
-
-	for _, cmd := range cmds {
-		_, err = self.conn.Exec(cmd)
-		checkError(err)
-	}
-	self.setDBVersion(1)
+func (self *PostgresDatabase) GetSchemaMigrations() ([]SchemaMigration, error) {
+	return self.migrator().Status()
 }
 
-func (self *PostgresDatabase) upgrade6to7() {
-	tables := make(map[string]string)
-	log.Println("migrating... 6 -> 7")
-	// table for thumbnail info
-	tables["Thumbnails"] = `(
-                            sha_hash VARCHAR(128) PRIMARY KEY,
-                            width INTEGER NOT NULL,
-                            height INTEGER NOT NULL
-                          )`
-
-	tables["Cites"] = `(
-                            post_msgid VARCHAR(255) NOT NULL,
-                            cite_msgid VARCHAR(255) NOT NULL
-                     )`
-
-	var err error
-
-	table_order := []string{"Thumbnails", "Cites"}
-	for _, table := range table_order {
-		q := tables[table]
-		// create table
-		_, err = self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, q))
-		if err != nil {
-			log.Fatalf("cannot create table %s, %s, login was '%s'", table, err, self.db_str)
-		}
-	}
-
-	// make indexes
-	cmds := []string{
-		"CREATE INDEX ON Thumbnails(sha_hash)",
-		"CREATE INDEX ON Cites(cite_msgid)",
-	}
-
-	for _, cmd := range cmds {
-		_, err = self.conn.Exec(cmd)
-		checkError(err)
-	}
-
-	/*
-		// rebuild ALL cites
-		log.Println("!!! Building Cites table, this will take a long time. Do NOT interrupt !!!")
-
-		post_counter := 0
-		cite_counter := 0
-		var rows *sql.Rows
-		rows, err = self.conn.Query("SELECT message, message_id FROM ArticlePosts")
-		if err != nil {
-			log.Fatalf("error migrating: %s", err)
-		}
-
-		cites := make(map[string][]string)
-
-		for rows.Next() {
-			var msg, msgid string
-			rows.Scan(&msg, &msgid)
-			c := findBacklinks(msg)
-			cite_counter += len(c)
-			cites[msgid] = c
-			post_counter++
-			if post_counter%100 == 0 {
-				log.Printf("selected %d messages %d cites", post_counter, cite_counter)
-			}
-		}
-
-		rows.Close()
-
-		log.Printf("calculating %d cites ...", cite_counter)
-
-		cites_insert := make(map[string][2]string)
-
-		citemap_counter := 0
-
-		for msgid, citelist := range cites {
-			for _, cite := range citelist {
-				cite = cite[2:]
-				citeLike := cite + "%"
-				var cite_msgid string
-				err = self.conn.QueryRow("SELECT message_id FROM Articles WHERE message_id_hash LIKE $1 LIMIT 1", citeLike).Scan(&cite_msgid)
-				if err != nil {
-					continue
-					//log.Fatalf("failed to select cite like %s: %s", citeLike, err)
-				}
-				cites_insert[msgid+cite_msgid] = [2]string{msgid, cite_msgid}
-				citemap_counter++
-				if cite_counter%100 == 0 {
-					log.Printf("calculated %d cites", cite_counter)
-				}
-			}
-		}
-
-		log.Printf("inserting %d cites ...", cite_counter)
-
-		txn, err := self.conn.Begin()
-		if err != nil {
-			log.Fatalf("failed to begin insert: %s", err)
-		}
-
-		st, err := txn.Prepare(pq.CopyIn("Cites", "post_msgid", "cite_msgid"))
-
-		if err != nil {
-			log.Fatalf("failed to prepare statement: %s", err)
-		}
-
-		for _, ct := range cites_insert {
-			_, err = st.Exec(ct[0], ct[1])
-			if err != nil {
-				log.Fatalf("failed to insert with prepared statement: %s", err)
-			}
-		}
-
-		_, err = st.Exec()
-
-		if err != nil {
-			log.Fatalf("failed to excute statement: %s", err)
-		}
-
-		err = st.Close()
-
-		if err != nil {
-			log.Fatalf("failed to close statement: %s", err)
-		}
-
-		log.Println("committing...")
-
-		err = txn.Commit()
-		if err != nil {
-			log.Fatalf("failed to commit transaction: %s", err)
-		}
-
-		log.Println("insertion done")
-	*/
-	self.setDBVersion(7)
+func (self *PostgresDatabase) MigrateSchema() error {
+	return self.migrator().Migrate()
 }
 
-// create all tables for database version 0
-func (self *PostgresDatabase) createTablesV0() {
-	tables := make(map[string]string)
-
-	// table of active newsgroups
-	tables["Newsgroups"] = `(
+// every schema version in order, append new ones to the end and never change old ones
+var postgresMigrations = []dbMigration{
+	{0, "initial tables", execMigration(
+		// table of active newsgroups
+		`CREATE TABLE IF NOT EXISTS Newsgroups (
                             name VARCHAR(255) PRIMARY KEY,
                             last_post INTEGER NOT NULL,
                             restricted BOOLEAN
-                          )`
-
-	// table for ip and their encryption key
-	tables["EncryptedAddrs"] = `(
-                                enckey VARCHAR(255) NOT NULL,
-                                addr VARCHAR(255) NOT NULL,
-                                encaddr VARCHAR(255) NOT NULL
-                              )`
-
-	// table for articles that have been banned
-	tables["BannedArticles"] = `(
+                          )`,
+		// table for banned newsgroups
+		`CREATE TABLE IF NOT EXISTS BannedGroups (
+                             newsgroup VARCHAR(255) PRIMARY KEY,
+                             time_banned INTEGER NOT NULL
+                           )`,
+		// table for articles that have been banned
+		`CREATE TABLE IF NOT EXISTS BannedArticles (
                                 message_id VARCHAR(255) PRIMARY KEY,
                                 time_banned INTEGER NOT NULL,
                                 ban_reason TEXT NOT NULL
-                              )`
-
-	// table for banned newsgroups
-	tables["BannedGroups"] = `(
-                             newsgroup VARCHAR(255) PRIMARY KEY,
-                             time_banned INTEGER NOT NULL
-                           )`
-
-	// table for storing nntp article meta data
-	tables["Articles"] = `(
+                              )`,
+		// ip range bans
+		`CREATE TABLE IF NOT EXISTS IPBans (
+                        addr cidr NOT NULL,
+                        made INTEGER NOT NULL,
+                        expires INTEGER NOT NULL
+                      )`,
+		// bans for encrypted addresses that we don't have the ip for
+		`CREATE TABLE IF NOT EXISTS EncIPBans (
+                           encaddr VARCHAR(255) NOT NULL,
+                           made INTEGER NOT NULL,
+                           expires INTEGER NOT NULL
+                         )`,
+		`CREATE TABLE IF NOT EXISTS Settings (
+                           name VARCHAR(255) NOT NULL,
+                           value VARCHAR(255) NOT NULL
+                        )`,
+		// table for storing nntp article meta data
+		`CREATE TABLE IF NOT EXISTS Articles (
                           message_id VARCHAR(255) PRIMARY KEY,
                           message_id_hash VARCHAR(40) UNIQUE NOT NULL,
                           message_newsgroup VARCHAR(255),
                           message_ref_id VARCHAR(255),
                           time_obtained INTEGER NOT NULL,
                           FOREIGN KEY(message_newsgroup) REFERENCES Newsgroups(name)
-                        )`
-
-	// table for storing nntp article post content
-	tables["ArticlePosts"] = `(
+                        )`,
+		// table for storing nntp article post content
+		`CREATE TABLE IF NOT EXISTS ArticlePosts (
                               newsgroup VARCHAR(255),
                               message_id VARCHAR(255),
                               ref_id VARCHAR(255),
@@ -636,92 +311,286 @@ func (self *PostgresDatabase) createTablesV0() {
                               path TEXT NOT NULL,
                               time_posted INTEGER NOT NULL,
                               message TEXT NOT NULL
-                            )`
-
-	// table for storing nntp article posts to pubkey mapping
-	tables["ArticleKeys"] = `(
+                            )`,
+		// table for storing nntp article posts to pubkey mapping
+		`CREATE TABLE IF NOT EXISTS ArticleKeys (
                              message_id VARCHAR(255) NOT NULL,
                              pubkey VARCHAR(255) NOT NULL
-                           )`
-
-	// table for thread state
-	tables["ArticleThreads"] = `(
+                           )`,
+		// table for thread state
+		`CREATE TABLE IF NOT EXISTS ArticleThreads (
                                 newsgroup VARCHAR(255) NOT NULL,
                                 root_message_id VARCHAR(255) NOT NULL,
                                 last_bump INTEGER NOT NULL,
                                 last_post INTEGER NOT NULL
-                              )`
-
-	// table for storing nntp article attachment info
-	tables["ArticleAttachments"] = `(
+                              )`,
+		// table for storing nntp article attachment info
+		`CREATE TABLE IF NOT EXISTS ArticleAttachments (
                                     message_id VARCHAR(255),
                                     sha_hash VARCHAR(128) NOT NULL,
                                     filename TEXT NOT NULL,
                                     filepath TEXT NOT NULL
-                                  )`
-
-	// table for storing current permissions of mod pubkeys
-	tables["ModPrivs"] = `(
+                                  )`,
+		// table for storing current permissions of mod pubkeys
+		`CREATE TABLE IF NOT EXISTS ModPrivs (
                           pubkey VARCHAR(255),
                           newsgroup VARCHAR(255),
                           permission VARCHAR(255)
-                        )`
-
-	// table for storing moderation events
-	tables["ModLogs"] = `(
+                        )`,
+		// table for storing moderation events
+		`CREATE TABLE IF NOT EXISTS ModLogs (
                          pubkey VARCHAR(255),
                          action VARCHAR(255),
                          target VARCHAR(255),
                          time INTEGER
-                       )`
-
-	// ip range bans
-	tables["IPBans"] = `(
-                        addr cidr NOT NULL,
-                        made INTEGER NOT NULL,
-                        expires INTEGER NOT NULL
-                      )`
-	// bans for encrypted addresses that we don't have the ip for
-	tables["EncIPBans"] = `(
-                           encaddr VARCHAR(255) NOT NULL,
-                           made INTEGER NOT NULL,
-                           expires INTEGER NOT NULL
-                         )`
-
-	tables["Settings"] = `(
-                           name VARCHAR(255) NOT NULL,
-                           value VARCHAR(255) NOT NULL
-                        )`
-	var err error
-
-	table_order := []string{"Newsgroups", "BannedGroups", "BannedArticles", "IPBans", "EncIPBans", "Settings", "Articles", "ArticlePosts", "ArticleKeys", "ArticleThreads", "ArticleAttachments", "ModPrivs", "ModLogs", "EncryptedAddrs"}
-	for _, table := range table_order {
-		q := tables[table]
-		// create table
-		_, err = self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, q))
-		if err != nil {
-			log.Fatalf("cannot create table %s, %s, login was '%s'", table, err, self.db_str)
-		}
-	}
-	// create indexes
-	_, err = self.conn.Exec("CREATE INDEX IF NOT EXISTS ON ArticleThreads(root_message_id)")
-	_, err = self.conn.Exec("CREATE INDEX IF NOT EXISTS ON ArticleAttachments(message_id)")
-	_, err = self.conn.Exec("CREATE INDEX IF NOT EXISTS ON ArticlePosts(message_id)")
-	_, err = self.conn.Exec("CREATE INDEX IF NOT EXISTS ON Articles(message_id)")
-	_, err = self.conn.Exec("CREATE INDEX IF NOT EXISTS ON Newsgroups(name)")
-
-	self.setDBVersion(0)
-}
-
-// set what the current database version is
-func (self *PostgresDatabase) setDBVersion(version int) (err error) {
-	log.Println("set db version to", version)
-	_, err = self.conn.Exec("DELETE FROM Settings WHERE name = $1", "version")
-	_, err = self.conn.Exec("INSERT INTO Settings(name, value) VALUES($1, $2)", "version", fmt.Sprintf("%d", version))
-	return
-}
-
-// get the current database version
+                       )`,
+		// table for ip and their encryption key
+		`CREATE TABLE IF NOT EXISTS EncryptedAddrs (
+                                enckey VARCHAR(255) NOT NULL,
+                                addr VARCHAR(255) NOT NULL,
+                                encaddr VARCHAR(255) NOT NULL
+                              )`,
+		// postgres wants a name when IF NOT EXISTS is used
+		"CREATE INDEX IF NOT EXISTS articlethreads_root_message_id_idx ON ArticleThreads(root_message_id)",
+		"CREATE INDEX IF NOT EXISTS articleattachments_message_id_idx ON ArticleAttachments(message_id)",
+		"CREATE INDEX IF NOT EXISTS articleposts_message_id_idx ON ArticlePosts(message_id)",
+	)},
+	{1, "article post constraints", execMigration(
+		// newsgroups table
+		"CREATE INDEX ON Newsgroups(name)",
+		// article posts table
+		"ALTER TABLE ArticlePosts DROP COLUMN IF EXISTS addr",
+		"ALTER TABLE ArticlePosts ADD COLUMN addr VARCHAR(255)",
+		"ALTER TABLE ArticlePosts DROP CONSTRAINT IF EXISTS group_depend",
+		"ALTER TABLE ArticlePosts ADD CONSTRAINT group_depend FOREIGN KEY(newsgroup) REFERENCES Newsgroups(name) ON DELETE CASCADE",
+		"ALTER TABLE ArticlePosts DROP CONSTRAINT IF EXISTS msgid_pk",
+		"ALTER TABLE ArticlePosts ADD CONSTRAINT msgid_pk PRIMARY KEY(message_id)",
+		"CREATE INDEX ON ArticlePosts(ref_id)",
+		// article keys table
+		"DELETE FROM ArticleKeys WHERE message_id NOT IN ( SELECT message_id FROM ArticlePosts )",
+		"ALTER TABLE ArticleKeys DROP CONSTRAINT IF EXISTS msgid_depend",
+		"ALTER TABLE ArticleKeys ADD CONSTRAINT msgid_depend FOREIGN KEY(message_id) REFERENCES ArticlePosts(message_id) ON DELETE CASCADE",
+		// article threads table
+		"ALTER TABLE ArticleThreads DROP CONSTRAINT IF EXISTS msgid_depend",
+		"ALTER TABLE ArticleThreads DROP CONSTRAINT IF EXISTS group_depend",
+		"DELETE FROM ArticleThreads WHERE root_message_id NOT IN ( SELECT message_id FROM ArticlePosts )",
+		"ALTER TABLE ArticleThreads ADD CONSTRAINT msgid_depend FOREIGN KEY(root_message_id) REFERENCES ArticlePosts(message_id) ON DELETE CASCADE",
+		"ALTER TABLE ArticleThreads ADD CONSTRAINT group_depend FOREIGN KEY(newsgroup) REFERENCES Newsgroups(name) ON DELETE CASCADE",
+		// article attachments table
+		"ALTER TABLE ArticleAttachments DROP CONSTRAINT IF EXISTS msgid_depend",
+		"DELETE FROM ArticleAttachments WHERE message_id NOT IN ( SELECT message_id FROM ArticlePosts )",
+		"ALTER TABLE ArticleAttachments ADD CONSTRAINT msgid_depend FOREIGN KEY(message_id) REFERENCES ArticlePosts(message_id) ON DELETE CASCADE",
+	)},
+	{2, "nntp users", execMigration(
+		`CREATE TABLE IF NOT EXISTS NNTPUsers (
+                           username VARCHAR(255) PRIMARY KEY,
+                           login_hash VARCHAR(255) NOT NULL,
+                           login_salt VARCHAR(255) NOT NULL
+                         )`,
+	)},
+	{3, "nntp headers", execMigration(
+		`CREATE TABLE IF NOT EXISTS NNTPHeaders (
+                             header_name VARCHAR(255) NOT NULL,
+                             header_value TEXT NOT NULL,
+                             header_article_message_id VARCHAR(255) NOT NULL,
+                             FOREIGN KEY(header_article_message_id) REFERENCES ArticlePosts(message_id)
+                           )`,
+		"CREATE INDEX ON NNTPHeaders(header_name)",
+	)},
+	{4, "nntp article numbers", execMigration(
+		`CREATE TABLE IF NOT EXISTS ArticleNumbers (
+                                newsgroup VARCHAR(255) NOT NULL,
+                                message_id VARCHAR(255) NOT NULL,
+                                message_no BIGINT NOT NULL,
+                                FOREIGN KEY (newsgroup) REFERENCES Newsgroups(name),
+                                FOREIGN KEY (message_id) REFERENCES ArticlePosts(message_id)
+                              )`,
+		"CREATE INDEX ON ArticleNumbers(message_no)",
+		// number the posts we already have
+		"INSERT INTO ArticleNumbers(newsgroup, message_id, message_no) SELECT newsgroup, message_id, ROW_NUMBER() OVER (PARTITION BY newsgroup ORDER BY time_posted DESC) FROM ArticlePosts",
+	)},
+	{5, "encrypted address cidr", execMigration(
+		"ALTER TABLE EncryptedAddrs DROP COLUMN IF EXISTS addr_cidr",
+		"ALTER TABLE EncryptedAddrs ADD COLUMN addr_cidr cidr",
+		"UPDATE EncryptedAddrs AS a SET addr_cidr = e.cidr FROM ( SELECT cidr(addr), addr FROM EncryptedAddrs) AS e WHERE e.addr = a.addr",
+	)},
+	{6, "pubkey properties", execMigration(
+		// public key properties, key value pair: pubkey -> status
+		`CREATE TABLE IF NOT EXISTS PubkeyProperties (
+                                  pubkey VARCHAR(255) PRIMARY KEY,
+                                  status VARCHAR(255) NOT NULL
+                                )`,
+		// ledger of public key property modification events
+		`CREATE TABLE IF NOT EXISTS PubkeyModifyEvents (
+                                     source_pubkey VARCHAR(255) NOT NULL,
+                                     target_pubkey VARCHAR(255) NOT NULL,
+                                     event_time BIGINT NOT NULL,
+                                     status VARCHAR(255) NOT NULL,
+                                     id BIGSERIAL PRIMARY KEY
+                                   )`,
+	)},
+	{7, "thumbnails and cites", execMigration(
+		// table for thumbnail info
+		`CREATE TABLE IF NOT EXISTS Thumbnails (
+                            sha_hash VARCHAR(128) PRIMARY KEY,
+                            width INTEGER NOT NULL,
+                            height INTEGER NOT NULL
+                          )`,
+		`CREATE TABLE IF NOT EXISTS Cites (
+                            post_msgid VARCHAR(255) NOT NULL,
+                            cite_msgid VARCHAR(255) NOT NULL
+                     )`,
+		"CREATE INDEX ON Thumbnails(sha_hash)",
+		"CREATE INDEX ON Cites(cite_msgid)",
+	)},
+	{8, "feed sync times and newsgroup creation time", execMigration(
+		// table for the high-water timestamp of each pull sync feed
+		`CREATE TABLE IF NOT EXISTS FeedSyncTimes (
+                              feed VARCHAR(255) PRIMARY KEY,
+                              last_sync INTEGER NOT NULL
+                            )`,
+		"ALTER TABLE Newsgroups ADD COLUMN time_created INTEGER",
+		// groups we had before this get the time of the first article we obtained in them
+		"UPDATE Newsgroups SET time_created = COALESCE( ( SELECT MIN(time_obtained) FROM Articles WHERE message_newsgroup = name ), last_post )",
+		"CREATE INDEX ON Articles(time_obtained)",
+		"CREATE INDEX ON Newsgroups(time_created)",
+	)},
+	{9, "feed rejections", execMigration(
+		// table for articles a feed refused
+		`CREATE TABLE IF NOT EXISTS FeedRejections (
+                              feed VARCHAR(255) NOT NULL,
+                              message_id VARCHAR(255) NOT NULL,
+                              reason TEXT NOT NULL,
+                              time_rejected INTEGER NOT NULL,
+                              PRIMARY KEY(feed, message_id)
+                            )`,
+		"CREATE INDEX ON FeedRejections(time_rejected)",
+	)},
+	{10, "message history", execMigration(
+		// table for every message-id we have seen
+		`CREATE TABLE IF NOT EXISTS MessageHistory (
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              disposition VARCHAR(16) NOT NULL,
+                              time_seen INTEGER NOT NULL
+                            )`,
+		"CREATE INDEX ON MessageHistory(time_seen)",
+		// what we have now was accepted
+		"INSERT INTO MessageHistory(message_id, disposition, time_seen) SELECT message_id, 'accepted', time_obtained FROM Articles",
+		// what we banned before we had a history
+		"INSERT INTO MessageHistory(message_id, disposition, time_seen) SELECT message_id, CASE ban_reason WHEN 'expired' THEN 'expired' WHEN 'deleted by moderator' THEN 'banned' ELSE 'rejected' END, time_banned FROM BannedArticles WHERE message_id NOT IN ( SELECT message_id FROM Articles )",
+	)},
+	{11, "full text search", execMigration(
+		"ALTER TABLE ArticlePosts ADD COLUMN search tsvector",
+		// this takes a while on big databases
+		"UPDATE ArticlePosts SET search = "+postSearchVector("subject", "name", "message"),
+		"CREATE INDEX articleposts_search_idx ON ArticlePosts USING GIN(search)",
+	)},
+	{12, "article flags", execMigration(
+		// moderation flags on articles, the article might not be here yet
+		`CREATE TABLE IF NOT EXISTS ArticleFlags (
+                            message_id VARCHAR(255) NOT NULL,
+                            flag VARCHAR(16) NOT NULL,
+                            time_set INTEGER NOT NULL,
+                            PRIMARY KEY(message_id, flag)
+                          )`,
+		"CREATE INDEX ON ArticleFlags(flag)",
+	)},
+	{13, "scoped bans", execMigration(
+		// bans from before this were for everything
+		"ALTER TABLE IPBans ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT '"+ModScopeGlobal+"'",
+		"ALTER TABLE IPBans ADD COLUMN reason TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE EncIPBans ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT '"+ModScopeGlobal+"'",
+		"ALTER TABLE EncIPBans ADD COLUMN reason TEXT NOT NULL DEFAULT ''",
+	)},
+	{14, "mod log", execMigration(
+		"ALTER TABLE ModLogs ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT ''",
+		"ALTER TABLE ModLogs ADD COLUMN reason TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE ModLogs ADD COLUMN message_id VARCHAR(255) NOT NULL DEFAULT ''",
+		"ALTER TABLE ModLogs ADD COLUMN executed BOOLEAN NOT NULL DEFAULT TRUE",
+		"CREATE INDEX ON ModLogs(time)",
+		"CREATE INDEX ON ModLogs(target)",
+		"CREATE INDEX ON ModLogs(pubkey)",
+	)},
+	{15, "article reports", execMigration(
+		// reports from users, only kept here
+		`CREATE TABLE IF NOT EXISTS ArticleReports (
+                            message_id VARCHAR(255) NOT NULL,
+                            reporter VARCHAR(255) NOT NULL,
+                            reason VARCHAR(32) NOT NULL,
+                            time_reported INTEGER NOT NULL,
+                            PRIMARY KEY(message_id, reporter)
+                          )`,
+		"CREATE INDEX ON ArticleReports(reporter, time_reported)",
+	)},
+	{16, "mod delegations", execMigration(
+		// rights given to keys in ctl messages
+		`CREATE TABLE IF NOT EXISTS ModDelegations (
+                            pubkey VARCHAR(255) NOT NULL,
+                            granter VARCHAR(255) NOT NULL,
+                            scope VARCHAR(255) NOT NULL,
+                            actions TEXT NOT NULL,
+                            expires INTEGER NOT NULL,
+                            time_granted INTEGER NOT NULL,
+                            PRIMARY KEY(pubkey, granter, scope)
+                          )`,
+	)},
+	{17, "image hashes", execMigration(
+		// perceptual hashes of image attachments
+		`CREATE TABLE IF NOT EXISTS ImageHashes (
+                            attachment_filepath VARCHAR(255) PRIMARY KEY,
+                            phash VARCHAR(16) NOT NULL
+                          )`,
+		"CREATE INDEX ON ImageHashes(phash)",
+		`CREATE TABLE IF NOT EXISTS BannedImageHashes (
+                            phash VARCHAR(16) PRIMARY KEY,
+                            reason TEXT NOT NULL,
+                            time_banned INTEGER NOT NULL
+                          )`,
+	)},
+	{18, "filter rules", execMigration(
+		`CREATE TABLE IF NOT EXISTS FilterRules (
+                            id BIGSERIAL PRIMARY KEY,
+                            newsgroup VARCHAR(255) NOT NULL,
+                            field VARCHAR(255) NOT NULL,
+                            pattern TEXT NOT NULL,
+                            regex BOOLEAN NOT NULL,
+                            action VARCHAR(16) NOT NULL,
+                            replacement TEXT NOT NULL,
+                            time_added INTEGER NOT NULL
+                          )`,
+	)},
+	{19, "spam classifier", execMigration(
+		`CREATE TABLE IF NOT EXISTS SpamTokens (
+                            token VARCHAR(64) PRIMARY KEY,
+                            spam INTEGER NOT NULL,
+                            ham INTEGER NOT NULL
+                          )`,
+	)},
+	{20, "ban issuers", execMigration(
+		// bans from before this don't say who made them
+		"ALTER TABLE IPBans ADD COLUMN issuer VARCHAR(255) NOT NULL DEFAULT ''",
+		"ALTER TABLE EncIPBans ADD COLUMN issuer VARCHAR(255) NOT NULL DEFAULT ''",
+	)},
+	{21, "trained spam", execMigration(
+		`CREATE TABLE IF NOT EXISTS SpamTrained (
+                            message_id VARCHAR(255) PRIMARY KEY,
+                            spam INTEGER NOT NULL,
+                            time_trained INTEGER NOT NULL
+                          )`,
+	)},
+	{22, "feed rejections by article", execMigration(
+		// we look up every feed that refused an article when we federate it
+		"CREATE INDEX ON FeedRejections(message_id)",
+	)},
+}
+
+// the full text search vector of a post, subject ranks over name ranks over message
+// we use the simple config because boards are in many languages
+func postSearchVector(subject, name, message string) string {
+	return fmt.Sprintf("setweight(to_tsvector('simple', %s), 'A') || setweight(to_tsvector('simple', %s), 'B') || setweight(to_tsvector('simple', %s), 'C')", subject, name, message)
+}
+
+// get the schema version from before we had migrations
 func (self *PostgresDatabase) getDBVersion() (version int) {
 	var val string
 	var vers int64
@@ -901,10 +770,12 @@ func (self *PostgresDatabase) GetEncKey(encAddr string) (enckey string, err erro
 	return
 }
 
-func (self *PostgresDatabase) CheckIPBanned(addr string) (banned bool, err error) {
-	var amount int64
-	err = self.conn.QueryRow("SELECT COUNT(*) FROM IPBans WHERE addr >>= $1 ", addr).Scan(&amount)
-	banned = amount > 0
+func (self *PostgresDatabase) CheckIPBanned(addr, newsgroup string) (banned bool, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT scope FROM IPBans WHERE addr >>= $1 AND ( expires < 0 OR expires > $2 )", addr, timeNow())
+	if err == nil {
+		banned = scanBansCover(rows, newsgroup)
+	}
 	return
 }
 
@@ -972,7 +843,7 @@ func (self *PostgresDatabase) CountThreadReplies(root_message_id string) (repls
 
 func (self *PostgresDatabase) GetRootPostsForExpiration(newsgroup string, threadcount int) (roots []string) {
 
-	rows, err := self.conn.Query("SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 AND root_message_id NOT IN ( SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 ORDER BY last_bump DESC LIMIT $2)", newsgroup, threadcount)
+	rows, err := self.conn.Query("SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 AND root_message_id NOT IN ( SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 ORDER BY "+articleFlagSQL("root_message_id", ArticleFlagSticky)+" DESC, last_bump DESC LIMIT $2)", newsgroup, threadcount)
 	if err == nil {
 		// get results
 		for rows.Next() {
@@ -982,91 +853,287 @@ func (self *PostgresDatabase) GetRootPostsForExpiration(newsgroup string, thread
 			log.Println(root)
 		}
 		rows.Close()
-	} else {
-		log.Println("failed to get root posts for expiration", err)
+	} else {
+		log.Println("failed to get root posts for expiration", err)
+	}
+	// return the list of expired roots
+	return
+}
+
+// register an article in a newsgroup with the ArticleNumbers table
+func (self *PostgresDatabase) registerNNTPNumber(group, msgid string) (err error) {
+	_, err = self.conn.Exec("WITH x(msg_no) AS ( SELECT MAX(message_no) AS msg_no FROM ArticleNumbers WHERE newsgroup = $1 ) INSERT INTO ArticleNumbers(newsgroup, message_id, message_no) VALUES($1, $2, (SELECT CASE WHEN msg_no IS NULL THEN 0 ELSE msg_no END FROM x) + 1 )", group, msgid)
+	return
+}
+
+func (self *PostgresDatabase) GetAllNewsgroups() (groups []string) {
+
+	rows, err := self.conn.Query(self.stmt[GetAllNewsgroups])
+	if err == nil {
+		for rows.Next() {
+			var group string
+			rows.Scan(&group)
+			groups = append(groups, group)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetGroupPageCount(newsgroup string) int64 {
+	var count int64
+	err := self.conn.QueryRow("SELECT COUNT(*) FROM ArticleThreads WHERE newsgroup = $1", newsgroup).Scan(&count)
+	if err != nil {
+		log.Println("failed to count pages in group", newsgroup, err)
+	}
+	// divide by threads per page
+	return int64(math.Ceil(float64(count/10)) + 1)
+}
+
+// only fetches root posts
+// does not update the thread contents
+func (self *PostgresDatabase) GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel {
+	var threads []ThreadModel
+	pages := self.GetGroupPageCount(newsgroup)
+	rows, err := self.conn.Query("WITH roots(root_message_id, sticky, last_bump) AS ( SELECT root_message_id, "+articleFlagSQL("root_message_id", ArticleFlagSticky)+", last_bump FROM ArticleThreads WHERE newsgroup = $1 AND NOT "+articleFlagSQL("root_message_id", ArticleFlagHidden)+" ORDER BY 2 DESC, last_bump DESC OFFSET $2 LIMIT $3 ) SELECT p.newsgroup, p.message_id, p.name, p.subject, p.path, p.time_posted, p.message, p.addr FROM ArticlePosts p INNER JOIN roots ON ( roots.root_message_id = p.message_id ) ORDER BY roots.sticky DESC, roots.last_bump DESC", newsgroup, pageno*perpage, perpage)
+	if err == nil {
+		for rows.Next() {
+
+			p := &post{
+				prefix: prefix,
+			}
+			rows.Scan(&p.board, &p.Message_id, &p.PostName, &p.PostSubject, &p.MessagePath, &p.Posted, &p.PostMessage, &p.addr)
+			p.Parent = p.Message_id
+			p.op = true
+			_ = self.conn.QueryRow("SELECT pubkey FROM ArticleKeys WHERE message_id = $1", p.Message_id).Scan(&p.Key)
+			p.sage = isSage(p.PostSubject)
+			atts := self.GetPostAttachmentModels(prefix, p.Message_id)
+			if atts != nil {
+				p.Files = append(p.Files, atts...)
+			}
+			threads = append(threads, createThreadModel(p))
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to fetch board model for", newsgroup, "page", pageno, err)
+	}
+	return &boardModel{
+		prefix:   prefix,
+		frontend: frontend,
+		board:    newsgroup,
+		page:     pageno,
+		pages:    int(pages),
+		threads:  threads,
+	}
+}
+
+func (self *PostgresDatabase) GetNNTPPostsInGroup(newsgroup string) (models []PostModel, err error) {
+	rows, err := self.conn.Query(self.stmt[GetNNTPPostsInGroup], newsgroup)
+	if err == nil {
+		for rows.Next() {
+			model := new(post)
+			model.Newsgroup = newsgroup
+			rows.Scan(&model.nntp_id, &model.Message_id, &model.PostSubject, &model.Posted, &model.Parent, &model.PostName, &model.MessagePath)
+			models = append(models, model)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetNNTPHeadersInRange(newsgroup string, lo, hi int64, names []string) (overviews []NNTPOverview, err error) {
+	var headers []string
+	for _, name := range names {
+		headers = append(headers, strings.ToLower(name))
+	}
+	var rows *sql.Rows
+	if hi < 0 {
+		rows, err = self.conn.Query(self.stmt[GetNNTPHeadersInRange_2], newsgroup, lo, pq.Array(headers))
+	} else {
+		rows, err = self.conn.Query(self.stmt[GetNNTPHeadersInRange_1], newsgroup, lo, hi, pq.Array(headers))
+	}
+	if err == nil {
+		for rows.Next() {
+			var ov NNTPOverview
+			var k, v sql.NullString
+			err = rows.Scan(&ov.Number, &ov.MessageID, &k, &v)
+			if err != nil {
+				break
+			}
+			last := len(overviews) - 1
+			if last < 0 || overviews[last].Number != ov.Number {
+				ov.Headers = make(ArticleHeaders)
+				overviews = append(overviews, ov)
+				last++
+			}
+			if k.Valid {
+				overviews[last].Headers.Add(k.String, v.String)
+			}
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetArticlesObtainedSince(t int64) (articles []ArticleEntry, err error) {
+	rows, err := self.conn.Query(self.stmt[GetArticlesObtainedSince], t)
+	if err == nil {
+		for rows.Next() {
+			var entry ArticleEntry
+			rows.Scan(&entry[0], &entry[1])
+			articles = append(articles, entry)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetNewsgroupsCreatedSince(t int64) (groups []string, err error) {
+	rows, err := self.conn.Query(self.stmt[GetNewsgroupsCreatedSince], t)
+	if err == nil {
+		for rows.Next() {
+			var group string
+			rows.Scan(&group)
+			groups = append(groups, group)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetFeedSyncTime(feed string) (t int64, err error) {
+	err = self.conn.QueryRow("SELECT last_sync FROM FeedSyncTimes WHERE feed = $1", feed).Scan(&t)
+	if err == sql.ErrNoRows {
+		// never synced
+		err = nil
+	}
+	return
+}
+
+func (self *PostgresDatabase) SetFeedSyncTime(feed string, t int64) (err error) {
+	var res sql.Result
+	res, err = self.conn.Exec("UPDATE FeedSyncTimes SET last_sync = $2 WHERE feed = $1", feed, t)
+	if err == nil {
+		var n int64
+		n, err = res.RowsAffected()
+		if err == nil && n == 0 {
+			// first sync with this feed
+			_, err = self.conn.Exec("INSERT INTO FeedSyncTimes(feed, last_sync) VALUES($1, $2)", feed, t)
+		}
+	}
+	return
+}
+
+func (self *PostgresDatabase) RecordFeedRejection(feed, msgid, reason string) (err error) {
+	var res sql.Result
+	res, err = self.conn.Exec("UPDATE FeedRejections SET reason = $3, time_rejected = $4 WHERE feed = $1 AND message_id = $2", feed, msgid, reason, timeNow())
+	if err == nil {
+		var n int64
+		n, err = res.RowsAffected()
+		if err == nil && n == 0 {
+			_, err = self.conn.Exec("INSERT INTO FeedRejections(feed, message_id, reason, time_rejected) VALUES($1, $2, $3, $4)", feed, msgid, reason, timeNow())
+		}
+	}
+	return
+}
+
+func (self *PostgresDatabase) FeedRejectedArticle(feed, msgid string) (rejected bool, err error) {
+	var count int64
+	err = self.conn.QueryRow(self.stmt[FeedRejectedArticle], feed, msgid).Scan(&count)
+	rejected = count > 0
+	return
+}
+
+func (self *PostgresDatabase) GetArticleRejections(msgid string) (feeds []string, err error) {
+	rows, err := self.conn.Query("SELECT feed FROM FeedRejections WHERE message_id = $1", msgid)
+	if err == nil {
+		for rows.Next() {
+			var feed string
+			err = rows.Scan(&feed)
+			if err != nil {
+				break
+			}
+			feeds = append(feeds, feed)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetFeedRejections(feed string) (msgids []string, err error) {
+	rows, err := self.conn.Query("SELECT message_id FROM FeedRejections WHERE feed = $1", feed)
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
 	}
-	// return the list of expired roots
 	return
 }
 
-// register an article in a newsgroup with the ArticleNumbers table
-func (self *PostgresDatabase) registerNNTPNumber(group, msgid string) (err error) {
-	_, err = self.conn.Exec("WITH x(msg_no) AS ( SELECT MAX(message_no) AS msg_no FROM ArticleNumbers WHERE newsgroup = $1 ) INSERT INTO ArticleNumbers(newsgroup, message_id, message_no) VALUES($1, $2, (SELECT CASE WHEN msg_no IS NULL THEN 0 ELSE msg_no END FROM x) + 1 )", group, msgid)
+func (self *PostgresDatabase) ClearFeedRejections(feed string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FeedRejections WHERE feed = $1", feed)
 	return
 }
 
-func (self *PostgresDatabase) GetAllNewsgroups() (groups []string) {
+func (self *PostgresDatabase) ExpireFeedRejections(t int64) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FeedRejections WHERE time_rejected < $1", t)
+	return
+}
 
-	rows, err := self.conn.Query(self.stmt[GetAllNewsgroups])
-	if err == nil {
-		for rows.Next() {
-			var group string
-			rows.Scan(&group)
-			groups = append(groups, group)
-		}
-		rows.Close()
-	}
+func (self *PostgresDatabase) RecordMessageHistory(msgid, disposition string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO MessageHistory(message_id, disposition, time_seen) VALUES($1, $2, $3) ON CONFLICT (message_id) DO UPDATE SET disposition = excluded.disposition, time_seen = excluded.time_seen", msgid, disposition, timeNow())
 	return
 }
 
-func (self *PostgresDatabase) GetGroupPageCount(newsgroup string) int64 {
-	var count int64
-	err := self.conn.QueryRow("SELECT COUNT(*) FROM ArticleThreads WHERE newsgroup = $1", newsgroup).Scan(&count)
-	if err != nil {
-		log.Println("failed to count pages in group", newsgroup, err)
+func (self *PostgresDatabase) GetMessageHistory(msgid string) (disposition string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetMessageHistory], msgid).Scan(&disposition)
+	if err == sql.ErrNoRows {
+		// never seen it
+		err = nil
 	}
-	// divide by threads per page
-	return int64(math.Ceil(float64(count/10)) + 1)
+	return
 }
 
-// only fetches root posts
-// does not update the thread contents
-func (self *PostgresDatabase) GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel {
-	var threads []ThreadModel
-	pages := self.GetGroupPageCount(newsgroup)
-	rows, err := self.conn.Query("WITH roots(root_message_id, last_bump) AS ( SELECT root_message_id, last_bump FROM ArticleThreads WHERE newsgroup = $1 ORDER BY last_bump DESC OFFSET $2 LIMIT $3 ) SELECT p.newsgroup, p.message_id, p.name, p.subject, p.path, p.time_posted, p.message, p.addr FROM ArticlePosts p INNER JOIN roots ON ( roots.root_message_id = p.message_id ) ORDER BY roots.last_bump DESC", newsgroup, pageno*perpage, perpage)
+func (self *PostgresDatabase) GetAllKnownMessageIDs(send chan string) (err error) {
+	rows, err := self.conn.Query("SELECT message_id FROM Articles UNION SELECT message_id FROM BannedArticles UNION SELECT message_id FROM MessageHistory")
 	if err == nil {
 		for rows.Next() {
-
-			p := &post{
-				prefix: prefix,
-			}
-			rows.Scan(&p.board, &p.Message_id, &p.PostName, &p.PostSubject, &p.MessagePath, &p.Posted, &p.PostMessage, &p.addr)
-			p.Parent = p.Message_id
-			p.op = true
-			_ = self.conn.QueryRow("SELECT pubkey FROM ArticleKeys WHERE message_id = $1", p.Message_id).Scan(&p.Key)
-			p.sage = isSage(p.PostSubject)
-			atts := self.GetPostAttachmentModels(prefix, p.Message_id)
-			if atts != nil {
-				p.Files = append(p.Files, atts...)
-			}
-			threads = append(threads, createThreadModel(p))
+			var msgid string
+			rows.Scan(&msgid)
+			send <- msgid
 		}
+		err = rows.Err()
 		rows.Close()
-	} else {
-		log.Println("failed to fetch board model for", newsgroup, "page", pageno, err)
 	}
-	return &boardModel{
-		prefix:   prefix,
-		frontend: frontend,
-		board:    newsgroup,
-		page:     pageno,
-		pages:    int(pages),
-		threads:  threads,
+	return
+}
+
+func (self *PostgresDatabase) MarkArticleFlag(msgid, flag string) (err error) {
+	if !self.ArticleHasFlag(msgid, flag) {
+		_, err = self.conn.Exec("INSERT INTO ArticleFlags(message_id, flag, time_set) VALUES($1, $2, $3)", msgid, flag, timeNow())
 	}
+	return
 }
 
-func (self *PostgresDatabase) GetNNTPPostsInGroup(newsgroup string) (models []PostModel, err error) {
-	rows, err := self.conn.Query(self.stmt[GetNNTPPostsInGroup], newsgroup)
-	if err == nil {
-		for rows.Next() {
-			model := new(post)
-			model.Newsgroup = newsgroup
-			rows.Scan(&model.nntp_id, &model.Message_id, &model.PostSubject, &model.Posted, &model.Parent, &model.PostName, &model.MessagePath)
-			models = append(models, model)
-		}
-		rows.Close()
+func (self *PostgresDatabase) UnmarkArticleFlag(msgid, flag string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ArticleFlags WHERE message_id = $1 AND flag = $2", msgid, flag)
+	return
+}
+
+func (self *PostgresDatabase) ArticleHasFlag(msgid, flag string) bool {
+	var count int64
+	err := self.conn.QueryRow("SELECT COUNT(*) FROM ArticleFlags WHERE message_id = $1 AND flag = $2", msgid, flag).Scan(&count)
+	if err != nil {
+		log.Println("failed to check article flag", flag, "for", msgid, err)
 	}
+	return count > 0
+}
+
+func (self *PostgresDatabase) ExpireMessageHistory(t int64) (err error) {
+	_, err = self.conn.Exec("DELETE FROM MessageHistory WHERE time_seen < $1", t)
 	return
 }
 
@@ -1083,21 +1150,27 @@ func (self *PostgresDatabase) GetPostsInGroup(newsgroup string) (models []PostMo
 	return
 }
 
+// fill in what a post model has besides its ArticlePosts row
+func (self *PostgresDatabase) finishPostModel(prefix string, model *post) {
+	model.prefix = prefix
+	model.op = len(model.Parent) == 0
+	if len(model.Parent) == 0 {
+		model.Parent = model.Message_id
+	}
+	model.sage = isSage(model.PostSubject)
+	atts := self.GetPostAttachmentModels(prefix, model.Message_id)
+	if atts != nil {
+		model.Files = append(model.Files, atts...)
+	}
+	// quiet fail
+	self.conn.QueryRow(self.stmt[GetArticlePubkey], model.Message_id).Scan(&model.Key)
+}
+
 func (self *PostgresDatabase) GetPostModel(prefix, messageID string) PostModel {
 	model := new(post)
 	err := self.conn.QueryRow(self.stmt[GetPostModel], messageID).Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
 	if err == nil {
-		model.op = len(model.Parent) == 0
-		if len(model.Parent) == 0 {
-			model.Parent = model.Message_id
-		}
-		model.sage = isSage(model.PostSubject)
-		atts := self.GetPostAttachmentModels(prefix, messageID)
-		if atts != nil {
-			model.Files = append(model.Files, atts...)
-		}
-		// quiet fail
-		self.conn.QueryRow(self.stmt[GetArticlePubkey], messageID).Scan(&model.Key)
+		self.finishPostModel(prefix, model)
 		return model
 	} else {
 		log.Println("failed to prepare query for geting post model for", messageID, err)
@@ -1161,6 +1234,11 @@ func (self *PostgresDatabase) GetThreadModel(prefix, msgid string) (th ThreadMod
 		}
 	}
 	rows.Close()
+	if _, ok := pmap[msgid]; !ok {
+		// root post is hidden or gone
+		err = errors.New("no such thread " + msgid)
+		return
+	}
 	th = createThreadModel(posts...)
 	return
 }
@@ -1354,7 +1432,8 @@ func (self *PostgresDatabase) ArticleCount() (count int64) {
 
 // register a new newsgroup
 func (self *PostgresDatabase) RegisterNewsgroup(group string) {
-	_, err := self.conn.Exec("INSERT INTO Newsgroups (name, last_post) VALUES($1, $2)", group, timeNow())
+	now := timeNow()
+	_, err := self.conn.Exec("INSERT INTO Newsgroups (name, last_post, time_created) VALUES($1, $2, $3)", group, now, now)
 	if err != nil {
 		log.Println("failed to register newsgroup", group, err)
 	}
@@ -1435,25 +1514,9 @@ func (self *PostgresDatabase) RegisterArticle(message NNTPMessage) (err error) {
 			log.Println("cannot register thread", msgid, err)
 			return
 		}
-	} else {
-		ref := message.Reference()
-		if !message.Sage() {
-			// TODO: this could be 1 query possibly?
-			var posts int64
-			err = self.conn.QueryRow(self.stmt[RegisterArticle_5], ref).Scan(&posts)
-			if err == nil && posts <= BumpLimit {
-				// bump it nigguh
-				_, err = self.conn.Exec(self.stmt[RegisterArticle_6], ref, message.Posted())
-			}
-			if err != nil {
-				log.Println("failed to bump thread", ref, err)
-				return
-			}
-		}
-		// update last posted
-		_, err = self.conn.Exec(self.stmt[RegisterArticle_7], ref, message.Posted())
+	} else if !self.ArticleHasFlag(msgid, ArticleFlagQuarantined) {
+		err = self.BumpThread(message)
 		if err != nil {
-			log.Println("failed to update post time for", ref, err)
 			return
 		}
 	}
@@ -1511,6 +1574,29 @@ func (self *PostgresDatabase) RegisterArticle(message NNTPMessage) (err error) {
 	return
 }
 
+func (self *PostgresDatabase) BumpThread(reply NNTPMessage) (err error) {
+	ref := reply.Reference()
+	if !reply.Sage() && !self.ArticleHasFlag(ref, ArticleFlagSage) {
+		// TODO: this could be 1 query possibly?
+		var posts int64
+		err = self.conn.QueryRow(self.stmt[RegisterArticle_5], ref).Scan(&posts)
+		if err == nil && posts <= BumpLimit {
+			// bump it nigguh
+			_, err = self.conn.Exec(self.stmt[RegisterArticle_6], ref, reply.Posted())
+		}
+		if err != nil {
+			log.Println("failed to bump thread", ref, err)
+			return
+		}
+	}
+	// update last posted
+	_, err = self.conn.Exec(self.stmt[RegisterArticle_7], ref, reply.Posted())
+	if err != nil {
+		log.Println("failed to update post time for", ref, err)
+	}
+	return
+}
+
 //
 // get message ids of articles with this header name and value
 //
@@ -1582,8 +1668,8 @@ func (self *PostgresDatabase) GetMessageIDByHash(hash string) (article ArticleEn
 	return
 }
 
-func (self *PostgresDatabase) BanAddr(addr string) (err error) {
-	_, err = self.conn.Exec("INSERT INTO IPBans(addr, made, expires) VALUES($1, $2, $3)", addr, timeNow(), -1)
+func (self *PostgresDatabase) BanAddr(addr, scope, reason, issuer string, expires int64) (err error) {
+	_, err = self.conn.Exec("INSERT INTO IPBans(addr, made, expires, scope, reason, issuer) VALUES($1, $2, $3, $4, $5, $6)", addr, timeNow(), expires, scope, reason, issuer)
 	return
 }
 
@@ -1593,15 +1679,190 @@ func (self *PostgresDatabase) UnbanAddr(addr string) (err error) {
 	return
 }
 
-func (self *PostgresDatabase) CheckEncIPBanned(encaddr string) (banned bool, err error) {
-	var result int64
-	err = self.conn.QueryRow(self.stmt[CheckEncIPBanned], encaddr).Scan(&result)
-	banned = result > 0
+func (self *PostgresDatabase) CheckEncIPBanned(encaddr, newsgroup string) (banned bool, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(self.stmt[CheckEncIPBanned], encaddr, timeNow())
+	if err == nil {
+		banned = scanBansCover(rows, newsgroup)
+	}
+	return
+}
+
+func (self *PostgresDatabase) BanEncAddr(encaddr, scope, reason, issuer string, expires int64) (err error) {
+	_, err = self.conn.Exec("INSERT INTO EncIPBans(encaddr, made, expires, scope, reason, issuer) VALUES($1, $2, $3, $4, $5, $6)", encaddr, timeNow(), expires, scope, reason, issuer)
+	return
+}
+
+func (self *PostgresDatabase) UnbanEncAddr(encaddr string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM EncIPBans WHERE encaddr = $1", encaddr)
+	return
+}
+
+func (self *PostgresDatabase) GetIPBans() ([]IPBan, error) {
+	return queryIPBans(self.conn, activeIPBansQuery, activeEncIPBansQuery, timeNow())
+}
+
+func (self *PostgresDatabase) RecordModLog(entry ModLogEntry) (err error) {
+	_, err = self.conn.Exec("INSERT INTO ModLogs(pubkey, action, target, scope, reason, message_id, executed, time) VALUES($1, $2, $3, $4, $5, $6, $7, $8)", entry.Pubkey, entry.Action, entry.Target, entry.Scope, entry.Reason, entry.MessageID, entry.Executed, entry.Time)
+	return
+}
+
+func (self *PostgresDatabase) GetModLogs(q ModLogQuery) (entries []ModLogEntry, err error) {
+	query, args := q.sql()
+	var rows *sql.Rows
+	rows, err = self.conn.Query(query, args...)
+	if err == nil {
+		entries, err = scanModLogs(rows)
+	}
+	return
+}
+
+func (self *PostgresDatabase) ReportArticle(msgid, reason, reporter string) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM ArticleReports WHERE message_id = $1 AND reporter = $2", msgid, reporter).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO ArticleReports(message_id, reporter, reason, time_reported) VALUES($1, $2, $3, $4)", msgid, reporter, reason, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) CountReportsBy(reporter string, since int64) (count int64, err error) {
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM ArticleReports WHERE reporter = $1 AND time_reported >= $2", reporter, since).Scan(&count)
+	return
+}
+
+func (self *PostgresDatabase) GetArticleReports() (reports []ArticleReports, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT r.message_id, p.newsgroup, r.reason, COUNT(*), MIN(r.time_reported), MAX(r.time_reported) FROM ArticleReports r INNER JOIN ArticlePosts p ON p.message_id = r.message_id GROUP BY r.message_id, p.newsgroup, r.reason")
+	if err == nil {
+		reports, err = scanArticleReports(rows)
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetQuarantinedArticles() (articles []QuarantinedArticle, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(quarantinedArticlesQuery)
+	if err == nil {
+		articles, err = scanQuarantinedArticles(rows)
+	}
+	return
+}
+
+func (self *PostgresDatabase) DismissReports(msgid string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ArticleReports WHERE message_id = $1", msgid)
+	return
+}
+
+func (self *PostgresDatabase) AddModDelegation(d ModDelegation) (err error) {
+	err = self.DelModDelegation(d.Pubkey, d.Granter, d.Scope)
+	if err == nil {
+		_, err = self.conn.Exec("INSERT INTO ModDelegations(pubkey, granter, scope, actions, expires, time_granted) VALUES($1, $2, $3, $4, $5, $6)", d.Pubkey, d.Granter, d.Scope, d.actionsString(), d.Expires, d.Time)
+	}
+	return
+}
+
+func (self *PostgresDatabase) DelModDelegation(pubkey, granter, scope string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ModDelegations WHERE pubkey = $1 AND granter = $2 AND scope = $3", pubkey, granter, scope)
+	return
+}
+
+func (self *PostgresDatabase) GetModDelegations(pubkey string) (delegations []ModDelegation, err error) {
+	var rows *sql.Rows
+	if pubkey == "" {
+		rows, err = self.conn.Query("SELECT pubkey, granter, scope, actions, expires, time_granted FROM ModDelegations ORDER BY time_granted")
+	} else {
+		rows, err = self.conn.Query("SELECT pubkey, granter, scope, actions, expires, time_granted FROM ModDelegations WHERE pubkey = $1 ORDER BY time_granted", pubkey)
+	}
+	if err == nil {
+		delegations, err = scanModDelegations(rows)
+	}
+	return
+}
+
+func (self *PostgresDatabase) RegisterImageHash(fname string, hash ImageHash) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM ImageHashes WHERE attachment_filepath = $1", fname).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO ImageHashes(attachment_filepath, phash) VALUES($1, $2)", fname, hash.String())
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetImageHash(fname string) (hash ImageHash, err error) {
+	var h string
+	err = self.conn.QueryRow("SELECT phash FROM ImageHashes WHERE attachment_filepath = $1", fname).Scan(&h)
+	if err == nil {
+		hash, err = ParseImageHash(h)
+	}
+	return
+}
+
+func (self *PostgresDatabase) BanImageHash(hash ImageHash, reason string) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM BannedImageHashes WHERE phash = $1", hash.String()).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO BannedImageHashes(phash, reason, time_banned) VALUES($1, $2, $3)", hash.String(), reason, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnbanImageHash(hash ImageHash) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BannedImageHashes WHERE phash = $1", hash.String())
+	return
+}
+
+func (self *PostgresDatabase) GetBannedImageHashes() (banned []BannedImage, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT phash, reason, time_banned FROM BannedImageHashes ORDER BY time_banned")
+	if err == nil {
+		banned, err = scanBannedImages(rows)
+	}
+	return
+}
+
+func (self *PostgresDatabase) AddFilterRule(rule FilterRule) (id int64, err error) {
+	err = self.conn.QueryRow("INSERT INTO FilterRules(newsgroup, field, pattern, regex, action, replacement, time_added) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id", rule.Newsgroup, rule.Field, rule.Pattern, rule.Regex, string(rule.Action), rule.Replacement, timeNow()).Scan(&id)
+	return
+}
+
+func (self *PostgresDatabase) DelFilterRule(id int64) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FilterRules WHERE id = $1", id)
+	return
+}
+
+func (self *PostgresDatabase) GetFilterRules() (rules []FilterRule, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT id, newsgroup, field, pattern, regex, action, replacement, time_added FROM FilterRules ORDER BY id")
+	if err == nil {
+		rules, err = scanFilterRules(rows)
+	}
+	return
+}
+
+func (self *PostgresDatabase) TrainSpam(msgid string, tokens []string, spam bool) (err error) {
+	err = trainSpamTx(self.conn, msgid, tokens, spam)
+	return
+}
+
+func (self *PostgresDatabase) GetSpamCounts(tokens []string) (counts map[string]SpamCounts, totals SpamCounts, err error) {
+	var rows *sql.Rows
+	q, args := spamCountsQuery(tokens)
+	rows, err = self.conn.Query(q, args...)
+	if err == nil {
+		counts, totals, err = scanSpamCounts(rows)
+	}
 	return
 }
 
-func (self *PostgresDatabase) BanEncAddr(encaddr string) (err error) {
-	_, err = self.conn.Exec("INSERT INTO EncIPBans(encaddr, made, expires) VALUES($1, $2, $3)", encaddr, timeNow(), -1)
+func (self *PostgresDatabase) PurgeExpiredBans(now int64) (bans []IPBan, err error) {
+	bans, err = queryIPBans(self.conn, expiredIPBansQuery, expiredEncIPBansQuery, now)
+	if err == nil {
+		_, err = self.conn.Exec("DELETE FROM IPBans WHERE expires >= 0 AND expires <= $1", now)
+	}
+	if err == nil {
+		_, err = self.conn.Exec("DELETE FROM EncIPBans WHERE expires >= 0 AND expires <= $1", now)
+	}
 	return
 }
 
@@ -1632,6 +1893,24 @@ func (self *PostgresDatabase) GetNNTPIDForMessageID(group, msgid string) (id int
 	return
 }
 
+func (self *PostgresDatabase) GetNextNNTPID(group string, n int64) (id int64, msgid string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetNextNNTPID], group, n).Scan(&id, &msgid)
+	if err == sql.ErrNoRows {
+		// no next article
+		err = nil
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetPrevNNTPID(group string, n int64) (id int64, msgid string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetPrevNNTPID], group, n).Scan(&id, &msgid)
+	if err == sql.ErrNoRows {
+		// no previous article
+		err = nil
+	}
+	return
+}
+
 func (self *PostgresDatabase) MarkModPubkeyCanModGroup(pubkey, group string) (err error) {
 	_, err = self.conn.Exec("INSERT INTO ModPrivs(pubkey, newsgroup, permission) VALUES($1, $2, $3)", pubkey, group, "all")
 	return
@@ -1863,30 +2142,63 @@ func (self *PostgresDatabase) GetPostsBefore(t time.Time) (msgids []string, err
 }
 
 func (self *PostgresDatabase) GetPostingStats(gran, begin, end int64) (st PostingStats, err error) {
-	return
-}
-
-func (self *PostgresDatabase) SearchQuery(prefix, group string, text string, chnl chan PostModel) (err error) {
-	if text != "" && strings.Count(text, "%") == 0 {
-		text = "%" + text + "%"
+	return makePostingStats(gran, begin, end, func(lo, hi int64) (counts map[string]int64, err error) {
 		var rows *sql.Rows
-		if group == "" {
-			rows, err = self.conn.Query(self.stmt[SearchQuery_1], text)
-		} else {
-			rows, err = self.conn.Query(self.stmt[SearchQuery_2], group, text)
-		}
+		rows, err = self.conn.Query("SELECT newsgroup, COUNT(*) FROM ArticlePosts WHERE time_posted >= $1 AND time_posted < $2 GROUP BY newsgroup", lo, hi)
 		if err == nil {
+			counts = make(map[string]int64)
 			for rows.Next() {
-				p := new(post)
-				rows.Scan(&p.board, &p.Message_id, &p.Parent)
-				chnl <- p
+				var group string
+				var posted int64
+				rows.Scan(&group, &posted)
+				counts[group] = posted
 			}
 			rows.Close()
 		}
+		return
+	})
+}
+
+func (self *PostgresDatabase) SearchPosts(prefix string, req SearchRequest) (posts []PostModel, more bool, err error) {
+	terms := parseSearchText(req.Text)
+	if len(terms) == 0 {
+		err = errors.New("nothing to search for")
+		return
+	}
+	conds, args := req.filters([]interface{}{searchTSQuery(terms)})
+	conds = append([]string{"search @@ to_tsquery('simple', $1)"}, conds...)
+	offset, limit := req.limits()
+	// get one more than we need to know if there is another page
+	args = append(args, limit+1, offset)
+	q := fmt.Sprintf("SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE %s ORDER BY ts_rank(search, to_tsquery('simple', $1)) DESC, time_posted DESC LIMIT $%d OFFSET $%d", strings.Join(conds, " AND "), len(args)-1, len(args))
+	var models []*post
+	var rows *sql.Rows
+	rows, err = self.conn.Query(q, args...)
+	if err == nil {
+		for rows.Next() {
+			model := new(post)
+			err = rows.Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
+			if err != nil {
+				break
+			}
+			models = append(models, model)
+		}
+		rows.Close()
+	}
+	if err != nil {
+		return
+	}
+	if len(models) > limit {
+		more = true
+		models = models[:limit]
+	}
+	for _, model := range models {
+		self.finishPostModel(prefix, model)
+		posts = append(posts, model)
 	}
-	close(chnl)
 	return
 }
+
 func (self *PostgresDatabase) SearchByHash(prefix, group, text string, chnl chan PostModel) (err error) {
 	if text != "" && strings.Count(text, "%") == 0 {
 		text = "%" + text + "%"
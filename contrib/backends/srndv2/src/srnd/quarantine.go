@@ -0,0 +1,121 @@
+//
+// quarantine.go -- articles that wait for a moderator before we show them or pass them on
+//
+package srnd
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// articles we stored and registered but don't show or federate until a mod approves them
+const ArticleFlagQuarantined = "quarantined"
+
+var ErrNotQuarantined = errors.New("article is not quarantined")
+
+// an article waiting in the quarantine queue
+type QuarantinedArticle struct {
+	MessageID string
+	Newsgroup string
+	Name      string
+	Subject   string
+	Message   string
+	// unix time it says it was posted
+	Posted int64
+	// unix time we quarantined it
+	Time int64
+}
+
+// the long hash of the article, for mod actions
+func (self QuarantinedArticle) Hash() string {
+	return HashMessageID(self.MessageID)
+}
+
+// sql condition on a message-id column that leaves out quarantined articles
+const notQuarantinedQuery = "NOT IN ( SELECT message_id FROM ArticleFlags WHERE flag = '" + ArticleFlagQuarantined + "' )"
+
+// quarantined articles, oldest first
+const quarantinedArticlesQuery = "SELECT p.message_id, p.newsgroup, p.name, p.subject, p.message, p.time_posted, f.time_set FROM ArticlePosts p INNER JOIN ArticleFlags f ON ( f.message_id = p.message_id ) WHERE f.flag = '" + ArticleFlagQuarantined + "' ORDER BY f.time_set, p.message_id"
+
+// load quarantined articles from rows of quarantinedArticlesQuery
+func scanQuarantinedArticles(rows *sql.Rows) (articles []QuarantinedArticle, err error) {
+	for rows.Next() {
+		var a QuarantinedArticle
+		err = rows.Scan(&a.MessageID, &a.Newsgroup, &a.Name, &a.Subject, &a.Message, &a.Posted, &a.Time)
+		if err != nil {
+			break
+		}
+		articles = append(articles, a)
+	}
+	rows.Close()
+	return
+}
+
+// check if an article we got in newsgroup goes to the quarantine queue
+// probation is set if it came from a feed on probation
+// ctl is never quarantined so moderation keeps working
+func (self *NNTPDaemon) quarantines(newsgroup string, probation bool) bool {
+	if newsgroup == "ctl" {
+		return false
+	}
+	if probation {
+		return true
+	}
+	scope := self.conf.daemon["quarantine_newsgroups"]
+	return scope != "" && modScopeMatches(scope, newsgroup)
+}
+
+// put an article in the quarantine queue instead of loading it
+// done before it is registered so it does not bump its thread or show up over nntp
+func quarantineArticle(db Database, msgid string) {
+	err := db.MarkArticleFlag(msgid, ArticleFlagHidden)
+	if err == nil {
+		err = db.MarkArticleFlag(msgid, ArticleFlagQuarantined)
+	}
+	if err != nil {
+		log.Println("failed to quarantine", msgid, err)
+	} else {
+		log.Println("quarantined", msgid)
+	}
+}
+
+// let a quarantined article through as if we just got it
+func (self *NNTPDaemon) approveArticle(msgid string) (err error) {
+	if !self.database.ArticleHasFlag(msgid, ArticleFlagQuarantined) {
+		err = ErrNotQuarantined
+		return
+	}
+	err = self.database.UnmarkArticleFlag(msgid, ArticleFlagHidden)
+	if err == nil {
+		err = self.database.UnmarkArticleFlag(msgid, ArticleFlagQuarantined)
+	}
+	if err == nil {
+		// it did not bump its thread when we got it
+		nntp := self.store.GetMessage(msgid)
+		if nntp != nil && !nntp.OP() {
+			err = self.database.BumpThread(nntp)
+		}
+	}
+	if err == nil {
+		log.Println("approved", msgid)
+		self.loadFromInfeed(msgid)
+	}
+	return
+}
+
+// throw away a quarantined article and never take it again
+func (self *NNTPDaemon) rejectArticle(msgid string) (err error) {
+	if !self.database.ArticleHasFlag(msgid, ArticleFlagQuarantined) {
+		err = ErrNotQuarantined
+		return
+	}
+	err = self.mod.DeletePost(msgid)
+	if err == nil {
+		err = self.database.UnmarkArticleFlag(msgid, ArticleFlagQuarantined)
+	}
+	if err == nil {
+		log.Println("rejected", msgid)
+	}
+	return
+}
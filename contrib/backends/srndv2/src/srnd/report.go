@@ -0,0 +1,101 @@
+//
+// report.go -- posts reported to the moderators of this node
+//
+// reports are kept local and never federated
+//
+package srnd
+
+import (
+	"database/sql"
+	"sort"
+)
+
+// what posts can be reported for
+var ReportReasons = []string{"spam", "illegal", "offtopic", "other"}
+
+// how many reports one address can make per hour by default
+const DefaultReportLimit = 10
+
+func validReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// how many times a post was reported for a reason
+type ReportCount struct {
+	Reason string
+	Count  int64
+}
+
+// the open reports of a post
+type ArticleReports struct {
+	MessageID string
+	Newsgroup string
+	// most reported reason first
+	Reasons []ReportCount
+	// how many reports in total
+	Count int64
+	// unix time of the first and last report
+	First int64
+	Last  int64
+}
+
+// the long hash of the reported post, for mod actions
+func (self ArticleReports) Hash() string {
+	return HashMessageID(self.MessageID)
+}
+
+// add reports of a post for a reason
+func (self *ArticleReports) add(reason string, count, first, last int64) {
+	self.Reasons = append(self.Reasons, ReportCount{reason, count})
+	self.Count += count
+	if self.First == 0 || first < self.First {
+		self.First = first
+	}
+	if last > self.Last {
+		self.Last = last
+	}
+}
+
+// sort reported posts most reported first then most recently reported first
+func sortArticleReports(reports []ArticleReports) {
+	for idx := range reports {
+		reasons := reports[idx].Reasons
+		sort.SliceStable(reasons, func(i, j int) bool {
+			return reasons[i].Count > reasons[j].Count
+		})
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Count == reports[j].Count {
+			return reports[i].Last > reports[j].Last
+		}
+		return reports[i].Count > reports[j].Count
+	})
+}
+
+// load reported posts from rows of message_id, newsgroup, reason, count, first and last report time
+func scanArticleReports(rows *sql.Rows) (reports []ArticleReports, err error) {
+	idx := make(map[string]int)
+	for rows.Next() {
+		var msgid, group, reason string
+		var count, first, last int64
+		err = rows.Scan(&msgid, &group, &reason, &count, &first, &last)
+		if err != nil {
+			break
+		}
+		i, ok := idx[msgid]
+		if !ok {
+			i = len(reports)
+			idx[msgid] = i
+			reports = append(reports, ArticleReports{MessageID: msgid, Newsgroup: group})
+		}
+		reports[i].add(reason, count, first, last)
+	}
+	rows.Close()
+	sortArticleReports(reports)
+	return
+}
@@ -0,0 +1,138 @@
+//
+// search.go -- full text search queries
+//
+package srnd
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// how many search results we give per page by default
+const DefaultSearchPerPage = 20
+
+// most search results we give per page
+const MaxSearchPerPage = 100
+
+// last page of search results we give, later pages would overflow the offset
+const MaxSearchPage = 10000
+
+// a full text search over subject, name and message of posts
+type SearchRequest struct {
+	// search terms, "quoted phrases" must appear in order and -words must not appear
+	Text string
+	// only posts in this newsgroup if not empty
+	Newsgroup string
+	// only posts made at or after this unix time if not 0
+	After int64
+	// only posts made before this unix time if not 0
+	Before int64
+	// only posts that have attachments
+	HasAttachment bool
+	// page of results starting at 0
+	Page int
+	// results per page
+	PerPage int
+}
+
+// get how many results to skip and how many to give
+func (self SearchRequest) limits() (offset, limit int) {
+	limit = self.PerPage
+	if limit <= 0 {
+		limit = DefaultSearchPerPage
+	} else if limit > MaxSearchPerPage {
+		limit = MaxSearchPerPage
+	}
+	if self.Page > 0 {
+		offset = self.Page * limit
+	}
+	return
+}
+
+// one part of a search, a word or a phrase
+type searchTerm struct {
+	words  []string
+	negate bool
+}
+
+// split search text into lowercase words, keeping only letters and numbers
+func searchWords(text string) (words []string) {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// parse search text into terms
+// "some phrase" is one term, a leading - negates a word or phrase
+func parseSearchText(text string) (terms []searchTerm) {
+	for len(text) > 0 {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		negate := strings.HasPrefix(text, "-")
+		if negate {
+			text = text[1:]
+		}
+		var part string
+		if strings.HasPrefix(text, "\"") {
+			end := strings.Index(text[1:], "\"")
+			if end == -1 {
+				// unterminated phrase goes to the end
+				part, text = text[1:], ""
+			} else {
+				part, text = text[1:end+1], text[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end == -1 {
+				part, text = text, ""
+			} else {
+				part, text = text[:end], text[end:]
+			}
+		}
+		words := searchWords(part)
+		if len(words) > 0 {
+			terms = append(terms, searchTerm{words: words, negate: negate})
+		}
+	}
+	return
+}
+
+// make a postgres tsquery from search terms
+// only letters and numbers are left in words so nothing needs escaping
+func searchTSQuery(terms []searchTerm) string {
+	var parts []string
+	for _, term := range terms {
+		part := strings.Join(term.words, " <-> ")
+		if len(term.words) > 1 {
+			part = "(" + part + ")"
+		}
+		if term.negate {
+			part = "!" + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " & ")
+}
+
+// get the sql conditions for the filters of a search request that aren't the text
+// arguments are numbered after the ones in args
+func (self SearchRequest) filters(args []interface{}) ([]string, []interface{}) {
+	// mods hid these
+	conds := []string{"NOT " + articleFlagSQL("ArticlePosts.message_id", ArticleFlagHidden)}
+	if self.Newsgroup != "" {
+		args = append(args, self.Newsgroup)
+		conds = append(conds, "newsgroup = "+fmt.Sprintf("$%d", len(args)))
+	}
+	if self.After > 0 {
+		args = append(args, self.After)
+		conds = append(conds, "time_posted >= "+fmt.Sprintf("$%d", len(args)))
+	}
+	if self.Before > 0 {
+		args = append(args, self.Before)
+		conds = append(conds, "time_posted < "+fmt.Sprintf("$%d", len(args)))
+	}
+	if self.HasAttachment {
+		conds = append(conds, "EXISTS ( SELECT 1 FROM ArticleAttachments WHERE ArticleAttachments.message_id = ArticlePosts.message_id )")
+	}
+	return conds, args
+}
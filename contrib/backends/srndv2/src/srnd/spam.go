@@ -0,0 +1,204 @@
+//
+// spam.go -- naive bayes spam classifier trained by moderators
+//
+package srnd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"unicode"
+)
+
+var ErrSpamArticle = errors.New("classified as spam")
+
+// reject inbound articles at least this likely to be spam by default
+const DefaultSpamThreshold = 0.99
+
+// what we do with spam, reject it or hide it until a mod looks at it
+const SpamReject = "reject"
+const SpamHold = "hold"
+
+// what we do with spam by default
+const DefaultSpamAction = SpamReject
+
+// who reports posts the classifier held
+const SpamReporter = "classifier"
+
+// we don't classify anything until mods gave us this many examples of both spam and ham
+const MinSpamExamples = 10
+
+// most distinct tokens we look at in one article
+const MaxSpamTokens = 200
+
+// how many times a token was in spam and ham
+// the totals count articles trained as spam and ham
+type SpamCounts struct {
+	Spam int64
+	Ham  int64
+}
+
+// the token totals are stored under, tokens are never empty
+const spamTotalsToken = ""
+
+// get the distinct tokens of an article the classifier looks at
+func spamTokens(subject, message string) (tokens []string) {
+	seen := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(subject+" "+message), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '$' && r != '.' && r != '/' && r != ':'
+	})
+	for _, word := range words {
+		word = strings.Trim(word, ".:/")
+		if len(word) < 2 || len(word) > 64 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+		if len(tokens) == MaxSpamTokens {
+			break
+		}
+	}
+	return
+}
+
+// get how likely an article with tokens is to be spam given what we learned
+// ok is false if we did not learn enough to tell
+func spamProbability(tokens []string, counts map[string]SpamCounts, totals SpamCounts) (p float64, ok bool) {
+	if totals.Spam < MinSpamExamples || totals.Ham < MinSpamExamples {
+		return
+	}
+	// log odds of spam with laplace smoothing, tokens we never saw count for nothing
+	spamDocs, hamDocs := float64(totals.Spam), float64(totals.Ham)
+	odds := math.Log(spamDocs / hamDocs)
+	for _, token := range tokens {
+		c, seen := counts[token]
+		if !seen || c.Spam+c.Ham == 0 {
+			continue
+		}
+		odds += math.Log((float64(c.Spam)+1)/(spamDocs+2)) - math.Log((float64(c.Ham)+1)/(hamDocs+2))
+	}
+	p = 1 / (1 + math.Exp(-odds))
+	ok = true
+	return
+}
+
+// learn from an article a mod said is spam or ham
+func trainSpamArticle(db Database, nntp NNTPMessage, spam bool) {
+	tokens := spamTokens(nntp.Subject(), nntp.Message())
+	err := db.TrainSpam(nntp.MessageID(), tokens, spam)
+	if err != nil {
+		log.Println("failed to train spam classifier on", nntp.MessageID(), err)
+	}
+}
+
+// check if an article is spam going by what mods taught us
+// articles in ctl are never spam
+func classifySpam(db Database, nntp NNTPMessage, threshold float64) (spam bool, p float64) {
+	if nntp.Newsgroup() == "ctl" {
+		return
+	}
+	tokens := spamTokens(nntp.Subject(), nntp.Message())
+	counts, totals, err := db.GetSpamCounts(tokens)
+	if err != nil {
+		log.Println("failed to get spam counts", err)
+		return
+	}
+	var ok bool
+	p, ok = spamProbability(tokens, counts, totals)
+	spam = ok && p >= threshold
+	return
+}
+
+// put a post the classifier held in the quarantine queue and the report queue
+// done before it is registered like quarantineArticle
+func holdSpamArticle(db Database, msgid string, p float64) {
+	quarantineArticle(db, msgid)
+	err := db.ReportArticle(msgid, FilterHeldReason, SpamReporter)
+	if err != nil {
+		log.Println("failed to hold", msgid, "as spam", err)
+	} else {
+		log.Printf("held %s as spam p=%.4f", msgid, p)
+	}
+}
+
+// add to the spam and ham counts of token $1, $2 and $3 are what to add
+const trainSpamQuery = "INSERT INTO SpamTokens(token, spam, ham) VALUES($1, $2, $3) ON CONFLICT (token) DO UPDATE SET spam = SpamTokens.spam + excluded.spam, ham = SpamTokens.ham + excluded.ham"
+
+// learn that the article msgid with tokens is spam or ham in one transaction
+// we remember what we learned each article as so it counts once
+func trainSpamTx(conn *metricsDB, msgid string, tokens []string, spam bool) (err error) {
+	var label int64
+	var dspam, dham int64
+	if spam {
+		label, dspam = 1, 1
+	} else {
+		dham = 1
+	}
+	var tx *sql.Tx
+	tx, err = conn.Begin()
+	if err != nil {
+		return
+	}
+	var was int64
+	err = tx.QueryRow("SELECT spam FROM SpamTrained WHERE message_id = $1", msgid).Scan(&was)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("INSERT INTO SpamTrained(message_id, spam, time_trained) VALUES($1, $2, $3)", msgid, label, timeNow())
+	} else if err == nil && was != label {
+		// a mod changed their mind, take back what we learned before
+		_, err = tx.Exec("UPDATE SpamTrained SET spam = $1, time_trained = $2 WHERE message_id = $3", label, timeNow(), msgid)
+		dspam, dham = dspam-dham, dham-dspam
+	} else {
+		// already learned
+		dspam, dham = 0, 0
+	}
+	if err == nil && (dspam != 0 || dham != 0) {
+		for _, token := range append([]string{spamTotalsToken}, tokens...) {
+			_, err = tx.Exec(trainSpamQuery, token, dspam, dham)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	return
+}
+
+// get the sql query for the counts of tokens and the totals
+func spamCountsQuery(tokens []string) (q string, args []interface{}) {
+	var params []string
+	args = append(args, spamTotalsToken)
+	params = append(params, "$1")
+	for _, token := range tokens {
+		args = append(args, token)
+		params = append(params, fmt.Sprintf("$%d", len(args)))
+	}
+	q = "SELECT token, spam, ham FROM SpamTokens WHERE token IN(" + strings.Join(params, ", ") + ")"
+	return
+}
+
+// load token counts and totals from rows of the query made by spamCountsQuery
+func scanSpamCounts(rows *sql.Rows) (counts map[string]SpamCounts, totals SpamCounts, err error) {
+	counts = make(map[string]SpamCounts)
+	for rows.Next() {
+		var token string
+		var c SpamCounts
+		err = rows.Scan(&token, &c.Spam, &c.Ham)
+		if err != nil {
+			break
+		}
+		if token == spamTotalsToken {
+			totals = c
+		} else {
+			counts[token] = c
+		}
+	}
+	rows.Close()
+	return
+}
@@ -0,0 +1,142 @@
+//
+// spool.go -- durable outbound spool for feeds
+//
+package srnd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// articles waiting to be sent to a feed, kept on disk so they survive restarts and disconnects
+// each entry is a file named by the hash of the message-id that holds the message-id
+// entries are removed once the feed accepted or refused the article
+type feedSpool struct {
+	dir string
+	// number of entries on disk
+	depth int64
+	// message-ids a connection is currently offering
+	claimed map[string]bool
+	access  sync.Mutex
+}
+
+// open the spool for a feed, creates it if it's not there
+func openFeedSpool(dir, feedname string) (spool *feedSpool) {
+	spool = &feedSpool{
+		dir:     filepath.Join(dir, feedname),
+		claimed: make(map[string]bool),
+	}
+	EnsureDir(spool.dir)
+	infos, err := ioutil.ReadDir(spool.dir)
+	if err == nil {
+		for _, info := range infos {
+			if filepath.Ext(info.Name()) != ".tmp" {
+				spool.depth++
+			}
+		}
+	} else {
+		log.Println("failed to read spool for", feedname, err)
+	}
+	return
+}
+
+func (self *feedSpool) entryPath(msgid string) string {
+	return filepath.Join(self.dir, HashMessageID(msgid))
+}
+
+// put an article into the spool
+func (self *feedSpool) Add(msgid string) (err error) {
+	fname := self.entryPath(msgid)
+	self.access.Lock()
+	defer self.access.Unlock()
+	if CheckFile(fname) {
+		// already spooled
+		return
+	}
+	tmp := fname + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(msgid), 0600)
+	if err == nil {
+		err = os.Rename(tmp, fname)
+	}
+	if err == nil {
+		self.depth++
+	}
+	return
+}
+
+// remove an article from the spool
+func (self *feedSpool) Remove(msgid string) (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	delete(self.claimed, msgid)
+	err = os.Remove(self.entryPath(msgid))
+	if err == nil {
+		self.depth--
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// mark that a connection is offering this article
+// returns false if another connection already is
+func (self *feedSpool) Claim(msgid string) (ok bool) {
+	self.access.Lock()
+	ok = !self.claimed[msgid]
+	self.claimed[msgid] = true
+	self.access.Unlock()
+	return
+}
+
+// a connection is no longer offering this article, it stays in the spool
+func (self *feedSpool) Release(msgid string) {
+	self.access.Lock()
+	delete(self.claimed, msgid)
+	self.access.Unlock()
+}
+
+// get the number of articles in the spool
+func (self *feedSpool) Depth() (depth int64) {
+	self.access.Lock()
+	depth = self.depth
+	self.access.Unlock()
+	return
+}
+
+// get every spooled message-id no connection is offering right now, oldest first
+func (self *feedSpool) Unclaimed() (msgids []string, err error) {
+	var infos []os.FileInfo
+	infos, err = ioutil.ReadDir(self.dir)
+	if err != nil {
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) == ".tmp" {
+			continue
+		}
+		var data []byte
+		data, err = ioutil.ReadFile(filepath.Join(self.dir, info.Name()))
+		if os.IsNotExist(err) {
+			// sent and removed since we listed the spool
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		msgid := string(data)
+		self.access.Lock()
+		claimed := self.claimed[msgid]
+		self.access.Unlock()
+		if !claimed {
+			msgids = append(msgids, msgid)
+		}
+	}
+	return
+}
@@ -0,0 +1,2079 @@
+//
+// sqlite db backend
+//
+package srnd
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlite database driver implementation
+// for small nodes that don't want to run a postgres server
+type SQLiteDatabase struct {
+	conn *metricsDB
+	path string
+	stmt map[string]string
+}
+
+// create sqlite database driver that keeps everything in the file at path
+func NewSQLiteDatabase(path string) Database {
+	db := new(SQLiteDatabase)
+	db.path = path
+	log.Println("Opening sqlite database", path)
+	// wait for other connections to finish writing instead of failing with database is locked
+	conn, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=10000&_journal_mode=WAL&_txlock=immediate", path))
+	if err != nil {
+		log.Fatalf("cannot open sqlite database %s: %s", path, err)
+	}
+	db.conn = &metricsDB{DB: conn}
+	db.SetConnectionLifetime(30)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(10)
+	return db
+}
+
+func (db *SQLiteDatabase) SetConnectionLifetime(seconds int) {
+	db.conn.SetConnMaxLifetime(time.Second * time.Duration(seconds))
+}
+
+func (db *SQLiteDatabase) SetMaxOpenConns(n int) {
+	db.conn.SetMaxOpenConns(n)
+}
+
+func (db *SQLiteDatabase) SetMaxIdleConns(n int) {
+	db.conn.SetMaxIdleConns(n)
+}
+
+func (self *SQLiteDatabase) Close() {
+	if self.conn != nil {
+		self.conn.Close()
+		self.conn = nil
+	}
+}
+
+func (self *SQLiteDatabase) prepareStatements() {
+	self.stmt = map[string]string{
+		NewsgroupBanned:                 "SELECT COUNT(newsgroup) FROM BannedGroups WHERE newsgroup = ?",
+		ArticleBanned:                   "SELECT COUNT(message_id) FROM BannedArticles WHERE message_id = ?",
+		GetAllNewsgroups:                "SELECT name FROM Newsgroups",
+		GetPostsInGroup:                 "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE newsgroup = ? ORDER BY time_posted",
+		GetPostModel:                    "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id = ? LIMIT 1",
+		GetArticlePubkey:                "SELECT pubkey FROM ArticleKeys WHERE message_id = ?",
+		GetThreadModel:                  "SELECT newsgroup, message_id, name, subject, time_posted, message, addr FROM ArticlePosts WHERE ( message_id = ?1 OR ref_id = ?1 ) AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ORDER BY time_posted",
+		GetThreadModelPubkeys:           "SELECT pubkey, message_id FROM ArticleKeys WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = ?1 OR message_id = ?1 )",
+		GetThreadModelAttachments:       "SELECT filename, filepath, message_id FROM ArticleAttachments WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = ?1 OR message_id = ?1 )",
+		DeleteArticle_1:                 "DELETE FROM NNTPHeaders WHERE header_article_message_id = ?",
+		DeleteArticle_2:                 "DELETE FROM ArticleNumbers WHERE message_id = ?",
+		DeleteArticle_3:                 "DELETE FROM ArticlePosts WHERE message_id = ?",
+		DeleteArticle_4:                 "DELETE FROM ArticleKeys WHERE message_id = ?",
+		DeleteArticle_5:                 "DELETE FROM ArticleAttachments WHERE message_id = ?",
+		DeleteThread:                    "DELETE FROM ArticleThreads WHERE root_message_id = ?",
+		GetThreadReplyPostModels_1:      "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = ? AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ORDER BY time_posted DESC LIMIT ? ) ORDER BY time_posted ASC",
+		GetThreadReplyPostModels_2:      "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE ref_id = ? AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ORDER BY time_posted ASC",
+		GetThreadReplies_1:              "SELECT message_id FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = ? ORDER BY time_posted DESC LIMIT ? ) ORDER BY time_posted ASC",
+		GetThreadReplies_2:              "SELECT message_id FROM ArticlePosts WHERE ref_id = ? ORDER BY time_posted ASC",
+		GetGroupThreads:                 "SELECT message_id FROM ArticlePosts WHERE newsgroup = ? AND ref_id = ''",
+		GetLastBumpedThreadsPaginated_1: "SELECT root_message_id, newsgroup FROM ArticleThreads WHERE newsgroup = ? AND NOT " + articleFlagSQL("root_message_id", ArticleFlagHidden) + " ORDER BY " + articleFlagSQL("root_message_id", ArticleFlagSticky) + " DESC, last_bump DESC LIMIT ?",
+		GetLastBumpedThreadsPaginated_2: "SELECT root_message_id, newsgroup FROM ArticleThreads WHERE newsgroup != 'ctl' AND NOT " + articleFlagSQL("root_message_id", ArticleFlagHidden) + " ORDER BY " + articleFlagSQL("root_message_id", ArticleFlagSticky) + " DESC, last_bump DESC LIMIT ?",
+		HasNewsgroup:                    "SELECT COUNT(name) FROM Newsgroups WHERE name = ?",
+		HasArticle:                      "SELECT COUNT(message_id) FROM Articles WHERE message_id = ?",
+		HasArticleLocal:                 "SELECT COUNT(message_id) FROM ArticlePosts WHERE message_id = ?",
+		GetPostAttachments:              "SELECT filepath FROM ArticleAttachments WHERE message_id = ?",
+		GetPostAttachmentModels:         "SELECT filepath, filename FROM ArticleAttachments WHERE message_id = ?",
+		RegisterArticle_1:               "INSERT INTO Articles (message_id, message_id_hash, message_newsgroup, time_obtained, message_ref_id) VALUES(?, ?, ?, ?, ?)",
+		RegisterArticle_2:               "UPDATE Newsgroups SET last_post = ? WHERE name = ?",
+		RegisterArticle_3:               "INSERT INTO ArticlePosts(newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		RegisterArticle_4:               "INSERT INTO ArticleThreads(root_message_id, last_bump, last_post, newsgroup) VALUES(?1, ?2, ?2, ?3)",
+		RegisterArticle_5:               "SELECT COUNT(*) FROM ArticlePosts WHERE ref_id = ?",
+		RegisterArticle_6:               "UPDATE ArticleThreads SET last_bump = ?2 WHERE root_message_id = ?1",
+		RegisterArticle_7:               "UPDATE ArticleThreads SET last_post = ?2 WHERE root_message_id = ?1",
+		RegisterArticle_8:               "INSERT INTO ArticleAttachments(message_id, sha_hash, filename, filepath) VALUES(?, ?, ?, ?)",
+		GetMessageIDByHeader:            "SELECT header_article_message_id FROM NNTPHeaders WHERE header_name = ? AND header_value = ?",
+		RegisterSigned:                  "INSERT INTO ArticleKeys(message_id, pubkey) VALUES (?, ?)",
+		GetAllArticlesInGroup:           "SELECT message_id FROM ArticlePosts WHERE newsgroup = ?",
+		GetAllArticles:                  "SELECT message_id, newsgroup FROM ArticlePosts",
+		GetMessageIDByHash:              "SELECT message_id, message_newsgroup FROM Articles WHERE message_id_hash = ? LIMIT 1",
+		CheckEncIPBanned:                "SELECT scope FROM EncIPBans WHERE encaddr = ? AND ( expires < 0 OR expires > ? )",
+		GetFirstAndLastForGroup:         "SELECT COALESCE(MAX(message_no), 1), COALESCE(MIN(message_no), 0) FROM ArticleNumbers WHERE newsgroup = ?",
+		GetMessageIDForNNTPID:           "SELECT message_id FROM ArticleNumbers WHERE newsgroup = ? AND message_no = ? LIMIT 1",
+		GetNNTPIDForMessageID:           "SELECT message_no FROM ArticleNumbers WHERE newsgroup = ? AND message_id = ? LIMIT 1",
+		GetNextNNTPID:                   "SELECT message_no, message_id FROM ArticleNumbers WHERE newsgroup = ? AND message_no > ? ORDER BY message_no ASC LIMIT 1",
+		GetPrevNNTPID:                   "SELECT message_no, message_id FROM ArticleNumbers WHERE newsgroup = ? AND message_no < ? ORDER BY message_no DESC LIMIT 1",
+		IsExpired:                       "SELECT COUNT(*) FROM ( SELECT message_id FROM Articles WHERE message_id = ?1 INTERSECT SELECT message_id FROM ArticlePosts WHERE message_id = ?1 )",
+		GetLastDaysPostsForGroup:        "SELECT COUNT(*) FROM ArticlePosts WHERE time_posted < ? AND time_posted > ? AND newsgroup = ?",
+		GetLastDaysPosts:                "SELECT COUNT(*) FROM ArticlePosts WHERE time_posted < ? AND time_posted > ?",
+		GetLastPostedPostModels:         "SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE newsgroup != 'ctl' AND NOT " + articleFlagSQL("message_id", ArticleFlagHidden) + " ORDER BY time_posted DESC LIMIT ?",
+		GetMonthlyPostHistory:           "SELECT time_posted FROM ArticlePosts WHERE time_posted > 0 ORDER BY time_posted ASC LIMIT 1",
+		CheckNNTPLogin:                  "SELECT login_hash, login_salt FROM NNTPUsers WHERE username = ?",
+		CheckNNTPUserExists:             "SELECT COUNT(username) FROM NNTPUsers WHERE username = ?",
+		GetHeadersForMessage:            "SELECT header_name, header_value FROM NNTPHeaders WHERE header_article_message_id = ?",
+		CountAllArticlesInGroup:         "SELECT COUNT(message_id) FROM ArticlePosts WHERE newsgroup = ?",
+		GetMessageIDByEncryptedIP:       "SELECT message_id FROM ArticlePosts WHERE addr = ?",
+		GetPostsBefore:                  "SELECT message_id FROM ArticlePosts WHERE time_posted < ?",
+		SearchByHash_1:                  "SELECT message_newsgroup, message_id, message_ref_id FROM Articles WHERE message_id_hash LIKE ? ORDER BY time_obtained DESC",
+		SearchByHash_2:                  "SELECT message_newsgroup, message_id, message_ref_id FROM Articles WHERE message_id_hash LIKE ? AND message_newsgroup = ? ORDER BY time_obtained DESC",
+		GetNNTPPostsInGroup:             "SELECT message_no, ArticlePosts.message_id, subject, time_posted, ref_id, name, path FROM ArticleNumbers INNER JOIN ArticlePosts ON ArticleNumbers.message_id = ArticlePosts.message_id WHERE ArticlePosts.newsgroup = ? AND ArticlePosts.message_id " + notQuarantinedQuery + " ORDER BY message_no",
+		GetCitesByPostHashLike:          "SELECT message_id, message_ref_id FROM Articles WHERE message_id_hash LIKE ?",
+		GetArticlesObtainedSince:        "SELECT message_id, message_newsgroup FROM Articles WHERE time_obtained >= ? AND message_id " + notQuarantinedQuery + " ORDER BY time_obtained",
+		GetNewsgroupsCreatedSince:       "SELECT name FROM Newsgroups WHERE time_created >= ?",
+		FeedRejectedArticle:             "SELECT COUNT(*) FROM FeedRejections WHERE feed = ? AND message_id = ?",
+		GetMessageHistory:               "SELECT disposition FROM MessageHistory WHERE message_id = ?",
+	}
+	names := make(map[string]string)
+	for name, query := range self.stmt {
+		names[query] = name
+	}
+	self.conn.names = names
+}
+
+func (self *SQLiteDatabase) CreateTables() {
+	err := self.MigrateSchema()
+	if err != nil {
+		log.Fatalf("cannot migrate database schema: %s, database was '%s'", err, self.path)
+	}
+	self.prepareStatements()
+}
+
+func (self *SQLiteDatabase) migrator() *schemaMigrator {
+	return &schemaMigrator{
+		conn: self.conn,
+		migrations: []dbMigration{
+			{1, "initial tables", self.createTablesV1},
+			{2, "article flags", execMigration(
+				// moderation flags on articles, the article might not be here yet
+				`CREATE TABLE IF NOT EXISTS ArticleFlags (
+                              message_id VARCHAR(255) NOT NULL,
+                              flag VARCHAR(16) NOT NULL,
+                              time_set INTEGER NOT NULL,
+                              PRIMARY KEY(message_id, flag)
+                            )`,
+				"CREATE INDEX IF NOT EXISTS ArticleFlags_flag ON ArticleFlags(flag)",
+			)},
+			{3, "scoped bans", execMigration(
+				// bans from before this were for everything
+				"ALTER TABLE IPBans ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT '"+ModScopeGlobal+"'",
+				"ALTER TABLE IPBans ADD COLUMN reason TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE EncIPBans ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT '"+ModScopeGlobal+"'",
+				"ALTER TABLE EncIPBans ADD COLUMN reason TEXT NOT NULL DEFAULT ''",
+			)},
+			{4, "mod log", execMigration(
+				"ALTER TABLE ModLogs ADD COLUMN scope VARCHAR(255) NOT NULL DEFAULT ''",
+				"ALTER TABLE ModLogs ADD COLUMN reason TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE ModLogs ADD COLUMN message_id VARCHAR(255) NOT NULL DEFAULT ''",
+				"ALTER TABLE ModLogs ADD COLUMN executed BOOLEAN NOT NULL DEFAULT 1",
+				"CREATE INDEX IF NOT EXISTS ModLogs_time ON ModLogs(time)",
+				"CREATE INDEX IF NOT EXISTS ModLogs_target ON ModLogs(target)",
+				"CREATE INDEX IF NOT EXISTS ModLogs_pubkey ON ModLogs(pubkey)",
+			)},
+			{5, "article reports", execMigration(
+				// reports from users, only kept here
+				`CREATE TABLE IF NOT EXISTS ArticleReports (
+                              message_id VARCHAR(255) NOT NULL,
+                              reporter VARCHAR(255) NOT NULL,
+                              reason VARCHAR(32) NOT NULL,
+                              time_reported INTEGER NOT NULL,
+                              PRIMARY KEY(message_id, reporter)
+                            )`,
+				"CREATE INDEX IF NOT EXISTS ArticleReports_reporter ON ArticleReports(reporter, time_reported)",
+			)},
+			{6, "mod delegations", execMigration(
+				// rights given to keys in ctl messages
+				`CREATE TABLE IF NOT EXISTS ModDelegations (
+                              pubkey VARCHAR(255) NOT NULL,
+                              granter VARCHAR(255) NOT NULL,
+                              scope VARCHAR(255) NOT NULL,
+                              actions TEXT NOT NULL,
+                              expires INTEGER NOT NULL,
+                              time_granted INTEGER NOT NULL,
+                              PRIMARY KEY(pubkey, granter, scope)
+                            )`,
+			)},
+			{7, "image hashes", execMigration(
+				// perceptual hashes of image attachments
+				`CREATE TABLE IF NOT EXISTS ImageHashes (
+                              attachment_filepath VARCHAR(255) PRIMARY KEY,
+                              phash VARCHAR(16) NOT NULL
+                            )`,
+				"CREATE INDEX IF NOT EXISTS ImageHashes_phash ON ImageHashes(phash)",
+				`CREATE TABLE IF NOT EXISTS BannedImageHashes (
+                              phash VARCHAR(16) PRIMARY KEY,
+                              reason TEXT NOT NULL,
+                              time_banned INTEGER NOT NULL
+                            )`,
+			)},
+			{8, "filter rules", execMigration(
+				`CREATE TABLE IF NOT EXISTS FilterRules (
+                              id INTEGER PRIMARY KEY AUTOINCREMENT,
+                              newsgroup VARCHAR(255) NOT NULL,
+                              field VARCHAR(255) NOT NULL,
+                              pattern TEXT NOT NULL,
+                              regex BOOLEAN NOT NULL,
+                              action VARCHAR(16) NOT NULL,
+                              replacement TEXT NOT NULL,
+                              time_added INTEGER NOT NULL
+                            )`,
+			)},
+			{9, "spam classifier", execMigration(
+				`CREATE TABLE IF NOT EXISTS SpamTokens (
+                              token VARCHAR(64) PRIMARY KEY,
+                              spam INTEGER NOT NULL,
+                              ham INTEGER NOT NULL
+                            )`,
+			)},
+			{10, "ban issuers", execMigration(
+				// bans from before this don't say who made them
+				"ALTER TABLE IPBans ADD COLUMN issuer VARCHAR(255) NOT NULL DEFAULT ''",
+				"ALTER TABLE EncIPBans ADD COLUMN issuer VARCHAR(255) NOT NULL DEFAULT ''",
+			)},
+			{11, "trained spam", execMigration(
+				`CREATE TABLE IF NOT EXISTS SpamTrained (
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              spam INTEGER NOT NULL,
+                              time_trained INTEGER NOT NULL
+                            )`,
+			)},
+			{12, "feed rejections by article", execMigration(
+				// we look up every feed that refused an article when we federate it
+				"CREATE INDEX IF NOT EXISTS FeedRejections_message_id ON FeedRejections(message_id)",
+			)},
+		},
+		legacyVersion: self.getDBVersion,
+	}
+}
+
+func (self *SQLiteDatabase) GetSchemaMigrations() ([]SchemaMigration, error) {
+	return self.migrator().Status()
+}
+
+func (self *SQLiteDatabase) MigrateSchema() error {
+	return self.migrator().Migrate()
+}
+
+// create all tables for database version 1
+// the same tables as the postgres backend has after all its upgrades
+func (self *SQLiteDatabase) createTablesV1(tx *sql.Tx) (err error) {
+	tables := make(map[string]string)
+
+	// table of active newsgroups
+	tables["Newsgroups"] = `(
+                            name VARCHAR(255) PRIMARY KEY,
+                            last_post INTEGER NOT NULL,
+                            restricted BOOLEAN,
+                            time_created INTEGER
+                          )`
+
+	// table for ip and their encryption key
+	tables["EncryptedAddrs"] = `(
+                                enckey VARCHAR(255) NOT NULL,
+                                addr VARCHAR(255) NOT NULL,
+                                encaddr VARCHAR(255) NOT NULL
+                              )`
+
+	// table for articles that have been banned
+	tables["BannedArticles"] = `(
+                                message_id VARCHAR(255) PRIMARY KEY,
+                                time_banned INTEGER NOT NULL,
+                                ban_reason TEXT NOT NULL
+                              )`
+
+	// table for banned newsgroups
+	tables["BannedGroups"] = `(
+                             newsgroup VARCHAR(255) PRIMARY KEY,
+                             time_banned INTEGER NOT NULL
+                           )`
+
+	// table for storing nntp article meta data
+	tables["Articles"] = `(
+                          message_id VARCHAR(255) PRIMARY KEY,
+                          message_id_hash VARCHAR(40) UNIQUE NOT NULL,
+                          message_newsgroup VARCHAR(255),
+                          message_ref_id VARCHAR(255),
+                          time_obtained INTEGER NOT NULL
+                        )`
+
+	// table for storing nntp article post content
+	tables["ArticlePosts"] = `(
+                              newsgroup VARCHAR(255),
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              ref_id VARCHAR(255),
+                              name TEXT NOT NULL,
+                              subject TEXT NOT NULL,
+                              path TEXT NOT NULL,
+                              time_posted INTEGER NOT NULL,
+                              message TEXT NOT NULL,
+                              addr VARCHAR(255)
+                            )`
+
+	// table for storing nntp article posts to pubkey mapping
+	tables["ArticleKeys"] = `(
+                             message_id VARCHAR(255) NOT NULL,
+                             pubkey VARCHAR(255) NOT NULL
+                           )`
+
+	// table for thread state
+	tables["ArticleThreads"] = `(
+                                newsgroup VARCHAR(255) NOT NULL,
+                                root_message_id VARCHAR(255) NOT NULL,
+                                last_bump INTEGER NOT NULL,
+                                last_post INTEGER NOT NULL
+                              )`
+
+	// table for storing nntp article attachment info
+	tables["ArticleAttachments"] = `(
+                                    message_id VARCHAR(255),
+                                    sha_hash VARCHAR(128) NOT NULL,
+                                    filename TEXT NOT NULL,
+                                    filepath TEXT NOT NULL
+                                  )`
+
+	// table for storing current permissions of mod pubkeys
+	tables["ModPrivs"] = `(
+                          pubkey VARCHAR(255),
+                          newsgroup VARCHAR(255),
+                          permission VARCHAR(255)
+                        )`
+
+	// table for storing moderation events
+	tables["ModLogs"] = `(
+                         pubkey VARCHAR(255),
+                         action VARCHAR(255),
+                         target VARCHAR(255),
+                         time INTEGER
+                       )`
+
+	// ip range bans, an address or a cidr
+	tables["IPBans"] = `(
+                        addr VARCHAR(255) NOT NULL,
+                        made INTEGER NOT NULL,
+                        expires INTEGER NOT NULL
+                      )`
+
+	// bans for encrypted addresses that we don't have the ip for
+	tables["EncIPBans"] = `(
+                           encaddr VARCHAR(255) NOT NULL,
+                           made INTEGER NOT NULL,
+                           expires INTEGER NOT NULL
+                         )`
+
+	tables["Settings"] = `(
+                           name VARCHAR(255) NOT NULL,
+                           value VARCHAR(255) NOT NULL
+                        )`
+
+	tables["NNTPUsers"] = `(
+                           username VARCHAR(255) PRIMARY KEY,
+                           login_hash VARCHAR(255) NOT NULL,
+                           login_salt VARCHAR(255) NOT NULL
+                         )`
+
+	tables["NNTPHeaders"] = `(
+                             header_name VARCHAR(255) NOT NULL,
+                             header_value TEXT NOT NULL,
+                             header_article_message_id VARCHAR(255) NOT NULL
+                           )`
+
+	tables["ArticleNumbers"] = `(
+                                newsgroup VARCHAR(255) NOT NULL,
+                                message_id VARCHAR(255) NOT NULL,
+                                message_no INTEGER NOT NULL
+                              )`
+
+	// public key properties, key value pair: pubkey -> status
+	tables["PubkeyProperties"] = `(
+                                  pubkey VARCHAR(255) PRIMARY KEY,
+                                  status VARCHAR(255) NOT NULL
+                                )`
+
+	// ledger of public key property modification events
+	tables["PubkeyModifyEvents"] = `(
+                                     source_pubkey VARCHAR(255) NOT NULL,
+                                     target_pubkey VARCHAR(255) NOT NULL,
+                                     event_time INTEGER NOT NULL,
+                                     status VARCHAR(255) NOT NULL,
+                                     id INTEGER PRIMARY KEY AUTOINCREMENT
+                                   )`
+
+	// table for thumbnail info
+	tables["Thumbnails"] = `(
+                            sha_hash VARCHAR(128) PRIMARY KEY,
+                            width INTEGER NOT NULL,
+                            height INTEGER NOT NULL
+                          )`
+
+	tables["Cites"] = `(
+                       post_msgid VARCHAR(255) NOT NULL,
+                       cite_msgid VARCHAR(255) NOT NULL
+                     )`
+
+	// table for the high-water timestamp of each pull sync feed
+	tables["FeedSyncTimes"] = `(
+                               feed VARCHAR(255) PRIMARY KEY,
+                               last_sync INTEGER NOT NULL
+                             )`
+
+	// table for articles a feed refused
+	tables["FeedRejections"] = `(
+                                feed VARCHAR(255) NOT NULL,
+                                message_id VARCHAR(255) NOT NULL,
+                                reason TEXT NOT NULL,
+                                time_rejected INTEGER NOT NULL,
+                                PRIMARY KEY(feed, message_id)
+                              )`
+
+	// table for every message-id we have seen
+	tables["MessageHistory"] = `(
+                                message_id VARCHAR(255) PRIMARY KEY,
+                                disposition VARCHAR(16) NOT NULL,
+                                time_seen INTEGER NOT NULL
+                              )`
+
+	table_order := []string{"Newsgroups", "BannedGroups", "BannedArticles", "IPBans", "EncIPBans", "Settings", "Articles", "ArticlePosts", "ArticleKeys", "ArticleThreads", "ArticleAttachments", "ModPrivs", "ModLogs", "EncryptedAddrs", "NNTPUsers", "NNTPHeaders", "ArticleNumbers", "PubkeyProperties", "PubkeyModifyEvents", "Thumbnails", "Cites", "FeedSyncTimes", "FeedRejections", "MessageHistory"}
+	for _, table := range table_order {
+		_, err = tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, tables[table]))
+		if err != nil {
+			return
+		}
+	}
+
+	// sqlite needs a name for every index
+	cmds := []string{
+		"CREATE INDEX IF NOT EXISTS ArticlePosts_ref_id ON ArticlePosts(ref_id)",
+		"CREATE INDEX IF NOT EXISTS ArticlePosts_newsgroup ON ArticlePosts(newsgroup)",
+		"CREATE INDEX IF NOT EXISTS ArticleKeys_message_id ON ArticleKeys(message_id)",
+		"CREATE INDEX IF NOT EXISTS ArticleThreads_root_message_id ON ArticleThreads(root_message_id)",
+		"CREATE INDEX IF NOT EXISTS ArticleThreads_newsgroup ON ArticleThreads(newsgroup, last_bump)",
+		"CREATE INDEX IF NOT EXISTS ArticleAttachments_message_id ON ArticleAttachments(message_id)",
+		"CREATE INDEX IF NOT EXISTS Articles_time_obtained ON Articles(time_obtained)",
+		"CREATE INDEX IF NOT EXISTS Newsgroups_time_created ON Newsgroups(time_created)",
+		"CREATE INDEX IF NOT EXISTS NNTPHeaders_header_name ON NNTPHeaders(header_name)",
+		"CREATE INDEX IF NOT EXISTS NNTPHeaders_message_id ON NNTPHeaders(header_article_message_id)",
+		"CREATE INDEX IF NOT EXISTS ArticleNumbers_newsgroup ON ArticleNumbers(newsgroup, message_no)",
+		"CREATE INDEX IF NOT EXISTS ArticleNumbers_message_id ON ArticleNumbers(message_id)",
+		"CREATE INDEX IF NOT EXISTS Cites_cite_msgid ON Cites(cite_msgid)",
+		"CREATE INDEX IF NOT EXISTS FeedRejections_time_rejected ON FeedRejections(time_rejected)",
+		"CREATE INDEX IF NOT EXISTS MessageHistory_time_seen ON MessageHistory(time_seen)",
+	}
+	return execMigration(cmds...)(tx)
+}
+
+// get the schema version from before we had migrations
+func (self *SQLiteDatabase) getDBVersion() (version int) {
+	var val string
+	var vers int64
+	err := self.conn.QueryRow("SELECT value FROM Settings WHERE name = ?", "version").Scan(&val)
+	if err == nil {
+		vers, err = strconv.ParseInt(val, 10, 32)
+		if err == nil {
+			version = int(vers)
+		} else {
+			log.Fatal("cannot figure out db version", err)
+		}
+	} else {
+		version = -1
+	}
+	return
+}
+
+func (self *SQLiteDatabase) BanNewsgroup(group string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO BannedGroups(newsgroup, time_banned) VALUES(?, ?)", group, timeNow())
+	return
+}
+
+func (self *SQLiteDatabase) UnbanNewsgroup(group string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BannedGroups WHERE newsgroup = ?", group)
+	return
+}
+
+func (self *SQLiteDatabase) NewsgroupBanned(group string) (banned bool, err error) {
+	var count int64
+	err = self.conn.QueryRow(self.stmt[NewsgroupBanned], group).Scan(&count)
+	banned = count > 0
+	return
+}
+
+func (self *SQLiteDatabase) NukeNewsgroup(group string, store ArticleStore) {
+	// first delete all thread presences
+	_, _ = self.conn.Exec("DELETE FROM ArticleThreads WHERE newsgroup = ?", group)
+	// get all articles in that newsgroup
+	chnl := make(chan ArticleEntry, 24)
+	go func() {
+		self.GetAllArticlesInGroup(group, chnl)
+		close(chnl)
+	}()
+	// for each article delete it fully
+	for article := range chnl {
+		msgid := article.MessageID()
+		log.Println("delete", msgid)
+		// remove article from store
+		fname := store.GetFilename(msgid)
+		os.Remove(fname)
+		// get all attachments
+		for _, att := range self.GetPostAttachments(msgid) {
+			// remove attachment
+			log.Println("delete attachment", att)
+			os.Remove(store.ThumbnailFilepath(att))
+			os.Remove(store.AttachmentFilepath(att))
+		}
+		// delete from database
+		self.DeleteArticle(msgid)
+	}
+	log.Println("nuke of", group, "done")
+}
+
+func (self *SQLiteDatabase) AddModPubkey(pubkey string) error {
+	if self.CheckModPubkey(pubkey) {
+		log.Println("did not add pubkey", pubkey, "already exists")
+		return nil
+	}
+	_, err := self.conn.Exec("INSERT INTO ModPrivs(pubkey, newsgroup, permission) VALUES ( ?, ?, ? )", pubkey, "ctl", "login")
+	return err
+}
+
+// count how many threads in a newsgroup were bumped at or after the given thread
+func (self *SQLiteDatabase) countThreadsBumpedSince(root_message_id, group string) (count int64, err error) {
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM ArticleThreads WHERE newsgroup = ? AND last_bump >= ( SELECT last_bump FROM ArticleThreads WHERE root_message_id = ? )", group, root_message_id).Scan(&count)
+	return
+}
+
+func (self *SQLiteDatabase) GetPageForRootMessage(root_message_id string) (group string, page int64, err error) {
+	err = self.conn.QueryRow("SELECT newsgroup FROM ArticleThreads WHERE root_message_id = ?", root_message_id).Scan(&group)
+	if err == nil {
+		perpage, _ := self.GetPagesPerBoard(group)
+		page, err = self.countThreadsBumpedSince(root_message_id, group)
+		return group, page / int64(perpage), err
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetInfoForMessage(msgid string) (root string, newsgroup string, page int64, err error) {
+	err = self.conn.QueryRow("SELECT newsgroup, ref_id FROM ArticlePosts WHERE message_id = ?", msgid).Scan(&newsgroup, &root)
+	if err == nil {
+		if root == "" {
+			root = msgid
+		}
+		perpage, _ := self.GetPagesPerBoard(newsgroup)
+		page, err = self.countThreadsBumpedSince(root, newsgroup)
+		page = page / int64(perpage)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) CheckModPubkeyGlobal(pubkey string) bool {
+	var result int64
+	_ = self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = ? AND newsgroup = ? AND permission = ?", pubkey, "overchan", "all").Scan(&result)
+	return result > 0
+}
+
+func (self *SQLiteDatabase) CheckModPubkeyCanModGroup(pubkey, newsgroup string) bool {
+	var result int64
+	_ = self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = ? AND newsgroup = ?", pubkey, newsgroup).Scan(&result)
+	return result > 0
+}
+
+func (self *SQLiteDatabase) CountPostsInGroup(newsgroup string, time_frame int64) (result int64) {
+	if time_frame > 0 {
+		time_frame = timeNow() - time_frame
+	} else if time_frame < 0 {
+		time_frame = 0
+	}
+	self.conn.QueryRow("SELECT COUNT(*) FROM ArticlePosts WHERE time_posted > ? AND newsgroup = ?", time_frame, newsgroup).Scan(&result)
+	return
+}
+
+func (self *SQLiteDatabase) CheckModPubkey(pubkey string) bool {
+	var result int64
+	self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = ?", pubkey).Scan(&result)
+	return result > 0
+}
+
+func (self *SQLiteDatabase) BanArticle(messageID, reason string) error {
+	if self.ArticleBanned(messageID) {
+		log.Println(messageID, "already banned")
+		return nil
+	}
+	_, err := self.conn.Exec("INSERT INTO BannedArticles(message_id, time_banned, ban_reason) VALUES(?, ?, ?)", messageID, timeNow(), reason)
+	return err
+}
+
+func (self *SQLiteDatabase) ArticleBanned(messageID string) (result bool) {
+	var count int64
+	err := self.conn.QueryRow(self.stmt[ArticleBanned], messageID).Scan(&count)
+	if err == nil {
+		result = count > 0
+	} else {
+		log.Println("error checking if article is banned", err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetEncAddress(addr string) (encaddr string, err error) {
+	err = self.conn.QueryRow("SELECT encaddr FROM EncryptedAddrs WHERE addr = ? LIMIT 1", addr).Scan(&encaddr)
+	if err == sql.ErrNoRows {
+		// needs to be inserted
+		var key string
+		key, encaddr = newAddrEnc(addr)
+		if len(encaddr) == 0 {
+			err = errors.New("failed to generate new encryption key")
+		} else {
+			_, err = self.conn.Exec("INSERT INTO EncryptedAddrs(enckey, encaddr, addr) VALUES(?, ?, ?)", key, encaddr, addr)
+		}
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetEncKey(encAddr string) (enckey string, err error) {
+	err = self.conn.QueryRow("SELECT enckey FROM EncryptedAddrs WHERE encaddr = ? LIMIT 1", encAddr).Scan(&enckey)
+	return
+}
+
+// get an address or cidr as a network, a single address is a network of just itself
+func parseAddrOrCIDR(addr string) (network *net.IPNet) {
+	if strings.Index(addr, "/") == -1 {
+		ip := net.ParseIP(addr)
+		if ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+	} else {
+		_, network, _ = net.ParseCIDR(addr)
+	}
+	return
+}
+
+// check if the network outer contains or is equal to the network inner, like postgres' >>= on cidr
+func cidrContains(outer, inner string) bool {
+	o := parseAddrOrCIDR(outer)
+	i := parseAddrOrCIDR(inner)
+	if o == nil || i == nil {
+		return false
+	}
+	osz, obits := o.Mask.Size()
+	isz, ibits := i.Mask.Size()
+	return obits == ibits && osz <= isz && o.Contains(i.IP)
+}
+
+// get the rowids and scopes of every ip ban that covers addr
+// if now is more than zero only get the ones that did not expire by then
+func (self *SQLiteDatabase) getIPBansFor(addr string, now int64) (ids []int64, scopes []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT rowid, addr, scope, expires FROM IPBans")
+	if err == nil {
+		for rows.Next() {
+			var id, expires int64
+			var ban, scope string
+			rows.Scan(&id, &ban, &scope, &expires)
+			if now > 0 && expires >= 0 && expires <= now {
+				continue
+			}
+			if cidrContains(ban, addr) {
+				ids = append(ids, id)
+				scopes = append(scopes, scope)
+			}
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) CheckIPBanned(addr, newsgroup string) (banned bool, err error) {
+	var scopes []string
+	_, scopes, err = self.getIPBansFor(addr, timeNow())
+	for _, scope := range scopes {
+		if banCovers(scope, newsgroup) {
+			banned = true
+			break
+		}
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetIPAddress(encaddr string) (addr string, err error) {
+	err = self.conn.QueryRow("SELECT addr FROM EncryptedAddrs WHERE encaddr = ? LIMIT 1", encaddr).Scan(&addr)
+	if err == sql.ErrNoRows {
+		// we don't have it
+		err = nil
+	}
+	return
+}
+
+func (self *SQLiteDatabase) MarkModPubkeyGlobal(pubkey string) (err error) {
+	if len(pubkey) != 64 {
+		err = errors.New("invalid pubkey length")
+		return
+	}
+	if self.CheckModPubkeyGlobal(pubkey) {
+		// already marked
+		log.Println("pubkey already marked as global", pubkey)
+	} else {
+		_, err = self.conn.Exec("INSERT INTO ModPrivs(pubkey, newsgroup, permission) VALUES ( ?, ?, ? )", pubkey, "overchan", "all")
+	}
+	return
+}
+
+func (self *SQLiteDatabase) MarkPubkeyAdmin(pubkey string) (err error) {
+	var admin bool
+	admin, err = self.CheckAdminPubkey(pubkey)
+	if err == nil && !admin {
+		// add as admin since it's not already there
+		_, err = self.conn.Exec("INSERT INTO ModPrivs(pubkey, newsgroup, permission) VALUES ( ?, ?, ? )", pubkey, "overchan", "admin")
+	}
+	return
+}
+
+func (self *SQLiteDatabase) UnmarkPubkeyAdmin(pubkey string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ModPrivs WHERE pubkey = ? AND permission = ?", pubkey, "admin")
+	return
+}
+
+func (self *SQLiteDatabase) CheckAdminPubkey(pubkey string) (admin bool, err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(pubkey) FROM ModPrivs WHERE pubkey = ? AND permission = ?", pubkey, "admin").Scan(&count)
+	if err == nil {
+		admin = count > 0
+	}
+	return
+}
+
+func (self *SQLiteDatabase) UnMarkModPubkeyGlobal(pubkey string) (err error) {
+	if self.CheckModPubkeyGlobal(pubkey) {
+		_, err = self.conn.Exec("DELETE FROM ModPrivs WHERE pubkey = ? AND newsgroup = ? AND permission = ?", pubkey, "overchan", "all")
+	} else {
+		err = errors.New("public key not marked as global")
+	}
+	return
+}
+
+func (self *SQLiteDatabase) CountThreadReplies(root_message_id string) (repls int64) {
+	_ = self.conn.QueryRow("SELECT COUNT(message_id) FROM ArticlePosts WHERE ref_id = ?", root_message_id).Scan(&repls)
+	return
+}
+
+func (self *SQLiteDatabase) GetRootPostsForExpiration(newsgroup string, threadcount int) (roots []string) {
+	rows, err := self.conn.Query("SELECT root_message_id FROM ArticleThreads WHERE newsgroup = ?1 AND root_message_id NOT IN ( SELECT root_message_id FROM ArticleThreads WHERE newsgroup = ?1 ORDER BY "+articleFlagSQL("root_message_id", ArticleFlagSticky)+" DESC, last_bump DESC LIMIT ?2 )", newsgroup, threadcount)
+	if err == nil {
+		for rows.Next() {
+			var root string
+			rows.Scan(&root)
+			roots = append(roots, root)
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to get root posts for expiration", err)
+	}
+	return
+}
+
+// register an article in a newsgroup with the ArticleNumbers table
+func (self *SQLiteDatabase) registerNNTPNumber(group, msgid string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO ArticleNumbers(newsgroup, message_id, message_no) SELECT ?1, ?2, COALESCE(MAX(message_no), 0) + 1 FROM ArticleNumbers WHERE newsgroup = ?1", group, msgid)
+	return
+}
+
+func (self *SQLiteDatabase) GetAllNewsgroups() (groups []string) {
+	rows, err := self.conn.Query(self.stmt[GetAllNewsgroups])
+	if err == nil {
+		for rows.Next() {
+			var group string
+			rows.Scan(&group)
+			groups = append(groups, group)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetGroupPageCount(newsgroup string) int64 {
+	var count int64
+	err := self.conn.QueryRow("SELECT COUNT(*) FROM ArticleThreads WHERE newsgroup = ?", newsgroup).Scan(&count)
+	if err != nil {
+		log.Println("failed to count pages in group", newsgroup, err)
+	}
+	// divide by threads per page
+	return int64(math.Ceil(float64(count/10)) + 1)
+}
+
+// only fetches root posts
+// does not update the thread contents
+func (self *SQLiteDatabase) GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel {
+	var threads []ThreadModel
+	pages := self.GetGroupPageCount(newsgroup)
+	var posts []*post
+	rows, err := self.conn.Query("SELECT p.newsgroup, p.message_id, p.name, p.subject, p.path, p.time_posted, p.message, p.addr FROM ArticlePosts p INNER JOIN ArticleThreads t ON ( t.root_message_id = p.message_id ) WHERE t.newsgroup = ? AND NOT "+articleFlagSQL("t.root_message_id", ArticleFlagHidden)+" ORDER BY "+articleFlagSQL("t.root_message_id", ArticleFlagSticky)+" DESC, t.last_bump DESC LIMIT ? OFFSET ?", newsgroup, perpage, pageno*perpage)
+	if err == nil {
+		for rows.Next() {
+			p := &post{
+				prefix: prefix,
+			}
+			rows.Scan(&p.board, &p.Message_id, &p.PostName, &p.PostSubject, &p.MessagePath, &p.Posted, &p.PostMessage, &p.addr)
+			posts = append(posts, p)
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to fetch board model for", newsgroup, "page", pageno, err)
+	}
+	// fill in the rest once we are done with the rows so we don't hold 2 connections per post
+	for _, p := range posts {
+		p.Parent = p.Message_id
+		p.op = true
+		_ = self.conn.QueryRow(self.stmt[GetArticlePubkey], p.Message_id).Scan(&p.Key)
+		p.sage = isSage(p.PostSubject)
+		atts := self.GetPostAttachmentModels(prefix, p.Message_id)
+		if atts != nil {
+			p.Files = append(p.Files, atts...)
+		}
+		threads = append(threads, createThreadModel(p))
+	}
+	return &boardModel{
+		prefix:   prefix,
+		frontend: frontend,
+		board:    newsgroup,
+		page:     pageno,
+		pages:    int(pages),
+		threads:  threads,
+	}
+}
+
+func (self *SQLiteDatabase) GetNNTPPostsInGroup(newsgroup string) (models []PostModel, err error) {
+	rows, err := self.conn.Query(self.stmt[GetNNTPPostsInGroup], newsgroup)
+	if err == nil {
+		for rows.Next() {
+			model := new(post)
+			model.Newsgroup = newsgroup
+			rows.Scan(&model.nntp_id, &model.Message_id, &model.PostSubject, &model.Posted, &model.Parent, &model.PostName, &model.MessagePath)
+			models = append(models, model)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetNNTPHeadersInRange(newsgroup string, lo, hi int64, names []string) (overviews []NNTPOverview, err error) {
+	// sqlite has no arrays so we put a placeholder per header name
+	args := []interface{}{newsgroup, lo}
+	var params []string
+	for _, name := range names {
+		params = append(params, "?")
+		args = append(args, strings.ToLower(name))
+	}
+	q := "SELECT message_no, message_id, header_name, header_value FROM ArticleNumbers LEFT OUTER JOIN NNTPHeaders ON ( ArticleNumbers.message_id = NNTPHeaders.header_article_message_id AND header_name IN ( " + strings.Join(params, ", ") + " ) ) WHERE newsgroup = ? AND message_no >= ? AND message_id " + notQuarantinedQuery
+	// the header names come first in the query
+	args = append(args[2:], args[:2]...)
+	if hi >= 0 {
+		q += " AND message_no <= ?"
+		args = append(args, hi)
+	}
+	q += " ORDER BY message_no"
+	var rows *sql.Rows
+	rows, err = self.conn.Query(q, args...)
+	if err == nil {
+		for rows.Next() {
+			var ov NNTPOverview
+			var k, v sql.NullString
+			err = rows.Scan(&ov.Number, &ov.MessageID, &k, &v)
+			if err != nil {
+				break
+			}
+			last := len(overviews) - 1
+			if last < 0 || overviews[last].Number != ov.Number {
+				ov.Headers = make(ArticleHeaders)
+				overviews = append(overviews, ov)
+				last++
+			}
+			if k.Valid {
+				overviews[last].Headers.Add(k.String, v.String)
+			}
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetArticlesObtainedSince(t int64) (articles []ArticleEntry, err error) {
+	rows, err := self.conn.Query(self.stmt[GetArticlesObtainedSince], t)
+	if err == nil {
+		for rows.Next() {
+			var entry ArticleEntry
+			rows.Scan(&entry[0], &entry[1])
+			articles = append(articles, entry)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetNewsgroupsCreatedSince(t int64) (groups []string, err error) {
+	rows, err := self.conn.Query(self.stmt[GetNewsgroupsCreatedSince], t)
+	if err == nil {
+		for rows.Next() {
+			var group string
+			rows.Scan(&group)
+			groups = append(groups, group)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetFeedSyncTime(feed string) (t int64, err error) {
+	err = self.conn.QueryRow("SELECT last_sync FROM FeedSyncTimes WHERE feed = ?", feed).Scan(&t)
+	if err == sql.ErrNoRows {
+		// never synced
+		err = nil
+	}
+	return
+}
+
+func (self *SQLiteDatabase) SetFeedSyncTime(feed string, t int64) (err error) {
+	_, err = self.conn.Exec("INSERT OR REPLACE INTO FeedSyncTimes(feed, last_sync) VALUES(?, ?)", feed, t)
+	return
+}
+
+func (self *SQLiteDatabase) RecordFeedRejection(feed, msgid, reason string) (err error) {
+	_, err = self.conn.Exec("INSERT OR REPLACE INTO FeedRejections(feed, message_id, reason, time_rejected) VALUES(?, ?, ?, ?)", feed, msgid, reason, timeNow())
+	return
+}
+
+func (self *SQLiteDatabase) FeedRejectedArticle(feed, msgid string) (rejected bool, err error) {
+	var count int64
+	err = self.conn.QueryRow(self.stmt[FeedRejectedArticle], feed, msgid).Scan(&count)
+	rejected = count > 0
+	return
+}
+
+func (self *SQLiteDatabase) GetArticleRejections(msgid string) (feeds []string, err error) {
+	rows, err := self.conn.Query("SELECT feed FROM FeedRejections WHERE message_id = ?", msgid)
+	if err == nil {
+		for rows.Next() {
+			var feed string
+			err = rows.Scan(&feed)
+			if err != nil {
+				break
+			}
+			feeds = append(feeds, feed)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetFeedRejections(feed string) (msgids []string, err error) {
+	rows, err := self.conn.Query("SELECT message_id FROM FeedRejections WHERE feed = ?", feed)
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) ClearFeedRejections(feed string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FeedRejections WHERE feed = ?", feed)
+	return
+}
+
+func (self *SQLiteDatabase) ExpireFeedRejections(t int64) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FeedRejections WHERE time_rejected < ?", t)
+	return
+}
+
+func (self *SQLiteDatabase) RecordMessageHistory(msgid, disposition string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO MessageHistory(message_id, disposition, time_seen) VALUES(?, ?, ?) ON CONFLICT (message_id) DO UPDATE SET disposition = excluded.disposition, time_seen = excluded.time_seen", msgid, disposition, timeNow())
+	return
+}
+
+func (self *SQLiteDatabase) GetMessageHistory(msgid string) (disposition string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetMessageHistory], msgid).Scan(&disposition)
+	if err == sql.ErrNoRows {
+		// never seen it
+		err = nil
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetAllKnownMessageIDs(send chan string) (err error) {
+	rows, err := self.conn.Query("SELECT message_id FROM Articles UNION SELECT message_id FROM BannedArticles UNION SELECT message_id FROM MessageHistory")
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			send <- msgid
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) MarkArticleFlag(msgid, flag string) (err error) {
+	_, err = self.conn.Exec("INSERT OR IGNORE INTO ArticleFlags(message_id, flag, time_set) VALUES(?, ?, ?)", msgid, flag, timeNow())
+	return
+}
+
+func (self *SQLiteDatabase) UnmarkArticleFlag(msgid, flag string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ArticleFlags WHERE message_id = ? AND flag = ?", msgid, flag)
+	return
+}
+
+func (self *SQLiteDatabase) ArticleHasFlag(msgid, flag string) bool {
+	var count int64
+	err := self.conn.QueryRow("SELECT COUNT(*) FROM ArticleFlags WHERE message_id = ? AND flag = ?", msgid, flag).Scan(&count)
+	if err != nil {
+		log.Println("failed to check article flag", flag, "for", msgid, err)
+	}
+	return count > 0
+}
+
+func (self *SQLiteDatabase) ExpireMessageHistory(t int64) (err error) {
+	_, err = self.conn.Exec("DELETE FROM MessageHistory WHERE time_seen < ?", t)
+	return
+}
+
+func (self *SQLiteDatabase) GetPostsInGroup(newsgroup string) (models []PostModel, err error) {
+	rows, err := self.conn.Query(self.stmt[GetPostsInGroup], newsgroup)
+	if err == nil {
+		for rows.Next() {
+			model := new(post)
+			rows.Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
+			models = append(models, model)
+		}
+		rows.Close()
+	}
+	return
+}
+
+// fill in the parts of a post model that don't come from ArticlePosts
+func (self *SQLiteDatabase) finishPostModel(prefix string, model *post) {
+	model.prefix = prefix
+	model.op = len(model.Parent) == 0
+	if len(model.Parent) == 0 {
+		model.Parent = model.Message_id
+	}
+	model.sage = isSage(model.PostSubject)
+	atts := self.GetPostAttachmentModels(prefix, model.Message_id)
+	if atts != nil {
+		model.Files = append(model.Files, atts...)
+	}
+	// quiet fail
+	self.conn.QueryRow(self.stmt[GetArticlePubkey], model.Message_id).Scan(&model.Key)
+}
+
+func (self *SQLiteDatabase) GetPostModel(prefix, messageID string) PostModel {
+	model := new(post)
+	err := self.conn.QueryRow(self.stmt[GetPostModel], messageID).Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
+	if err == nil {
+		self.finishPostModel(prefix, model)
+		return model
+	}
+	log.Println("failed to prepare query for geting post model for", messageID, err)
+	return nil
+}
+
+// get post models for every row of a query that selects ArticlePosts columns
+func (self *SQLiteDatabase) queryPostModels(prefix, q string, args ...interface{}) (models []*post, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(q, args...)
+	if err == nil {
+		for rows.Next() {
+			model := new(post)
+			err = rows.Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
+			if err != nil {
+				break
+			}
+			models = append(models, model)
+		}
+		rows.Close()
+	}
+	for _, model := range models {
+		self.finishPostModel(prefix, model)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetCitesByPostHashLike(like string) (cites []MessageIDTuple, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(self.stmt[GetCitesByPostHashLike], like+"%")
+	if err == nil {
+		for rows.Next() {
+			var tup MessageIDTuple
+			rows.Scan(&tup[0], &tup[1])
+			cites = append(cites, tup)
+		}
+		rows.Close()
+	} else {
+		log.Println("error getting post models like", like, err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetThreadModel(prefix, msgid string) (th ThreadModel, err error) {
+	var posts []PostModel
+	var rows *sql.Rows
+	pmap := make(map[string]*post)
+	rows, err = self.conn.Query(self.stmt[GetThreadModel], msgid)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		p := new(post)
+		p.prefix = prefix
+		p.Parent = msgid
+		rows.Scan(&p.board, &p.Message_id, &p.PostName, &p.PostSubject, &p.Posted, &p.PostMessage, &p.addr)
+		p.op = p.Message_id == msgid
+		p.sage = isSage(p.PostSubject)
+		pmap[p.Message_id] = p
+		posts = append(posts, p)
+	}
+	rows.Close()
+	rows, err = self.conn.Query(self.stmt[GetThreadModelAttachments], msgid)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		att := &attachment{
+			prefix: prefix,
+		}
+		var att_msgid string
+		rows.Scan(&att.Name, &att.Path, &att_msgid)
+		p, ok := pmap[att_msgid]
+		if ok {
+			p.Files = append(p.Files, att)
+		}
+	}
+	rows.Close()
+	rows, err = self.conn.Query(self.stmt[GetThreadModelPubkeys], msgid)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var key_msgid, key string
+		rows.Scan(&key, &key_msgid)
+		p, ok := pmap[key_msgid]
+		if ok {
+			p.Key = key
+		}
+	}
+	rows.Close()
+	if _, ok := pmap[msgid]; !ok {
+		// root post is hidden or gone
+		err = errors.New("no such thread " + msgid)
+		return
+	}
+	th = createThreadModel(posts...)
+	return
+}
+
+func (self *SQLiteDatabase) DeleteThread(msgid string) (err error) {
+	_, err = self.conn.Exec(self.stmt[DeleteThread], msgid)
+	return
+}
+
+func (self *SQLiteDatabase) DeleteArticle(msgid string) (err error) {
+	// postgres cascades the delete to the thread, we don't have foreign keys so we do it ourselves
+	for _, q := range []string{DeleteArticle_1, DeleteArticle_2, DeleteArticle_3, DeleteArticle_4, DeleteArticle_5, DeleteThread} {
+		_, err = self.conn.Exec(self.stmt[q], msgid)
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetThreadReplyPostModels(prefix, rootpost string, start, limit int) (repls []PostModel) {
+	var models []*post
+	var err error
+	if limit > 0 {
+		models, err = self.queryPostModels(prefix, self.stmt[GetThreadReplyPostModels_1], rootpost, limit)
+	} else {
+		models, err = self.queryPostModels(prefix, self.stmt[GetThreadReplyPostModels_2], rootpost)
+	}
+	if err == nil {
+		for idx, model := range models {
+			if idx >= start {
+				repls = append(repls, model)
+			}
+		}
+	} else {
+		log.Println("failed to get thread replies", rootpost, err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetThreadReplies(rootpost string, start, limit int) (repls []string) {
+	var rows *sql.Rows
+	var err error
+	if limit > 0 {
+		rows, err = self.conn.Query(self.stmt[GetThreadReplies_1], rootpost, limit)
+	} else {
+		rows, err = self.conn.Query(self.stmt[GetThreadReplies_2], rootpost)
+	}
+	offset := start
+	if err == nil {
+		for rows.Next() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			var msgid string
+			rows.Scan(&msgid)
+			repls = append(repls, msgid)
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to get thread replies", rootpost, err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) ThreadHasReplies(rootpost string) bool {
+	return self.CountThreadReplies(rootpost) > 0
+}
+
+func (self *SQLiteDatabase) GetGroupThreads(group string, recv chan ArticleEntry) {
+	rows, err := self.conn.Query(self.stmt[GetGroupThreads], group)
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			recv <- ArticleEntry{msgid, group}
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to get group threads", err)
+	}
+}
+
+func (self *SQLiteDatabase) GetLastBumpedThreads(newsgroups string, threads int) []ArticleEntry {
+	return self.GetLastBumpedThreadsPaginated(newsgroups, threads, 0)
+}
+
+func (self *SQLiteDatabase) GetLastBumpedThreadsPaginated(newsgroup string, threads, offset int) (roots []ArticleEntry) {
+	var err error
+	var rows *sql.Rows
+	if len(newsgroup) > 0 {
+		rows, err = self.conn.Query(self.stmt[GetLastBumpedThreadsPaginated_1], newsgroup, threads+offset)
+	} else {
+		rows, err = self.conn.Query(self.stmt[GetLastBumpedThreadsPaginated_2], threads+offset)
+	}
+	if err == nil {
+		for rows.Next() {
+			var ent ArticleEntry
+			rows.Scan(&ent[0], &ent[1])
+			if offset > 0 {
+				offset--
+			} else {
+				roots = append(roots, ent)
+			}
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to get last bumped", err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GroupHasPosts(group string) bool {
+	count, err := self.CountAllArticlesInGroup(group)
+	if err != nil {
+		log.Println("error counting posts in group", group, err)
+	}
+	return count > 0
+}
+
+// check if a newsgroup exists
+func (self *SQLiteDatabase) HasNewsgroup(group string) bool {
+	var count int64
+	err := self.conn.QueryRow(self.stmt[HasNewsgroup], group).Scan(&count)
+	if err != nil {
+		log.Println("failed to check for newsgroup", group, err)
+	}
+	return count > 0
+}
+
+// check if an article exists
+func (self *SQLiteDatabase) HasArticle(message_id string) bool {
+	var count int64
+	err := self.conn.QueryRow(self.stmt[HasArticle], message_id).Scan(&count)
+	if err != nil {
+		log.Println("failed to check for article", message_id, err)
+	}
+	return count > 0
+}
+
+// check if an article exists locally
+func (self *SQLiteDatabase) HasArticleLocal(message_id string) bool {
+	var count int64
+	err := self.conn.QueryRow(self.stmt[HasArticleLocal], message_id).Scan(&count)
+	if err != nil {
+		log.Println("failed to check for local article", message_id, err)
+	}
+	return count > 0
+}
+
+// count articles we have
+func (self *SQLiteDatabase) ArticleCount() (count int64) {
+	err := self.conn.QueryRow("SELECT COUNT(message_id) FROM ArticlePosts").Scan(&count)
+	if err != nil {
+		log.Println("failed to count articles", err)
+	}
+	return
+}
+
+// register a new newsgroup
+func (self *SQLiteDatabase) RegisterNewsgroup(group string) {
+	now := timeNow()
+	_, err := self.conn.Exec("INSERT INTO Newsgroups (name, last_post, time_created) VALUES(?, ?, ?)", group, now, now)
+	if err != nil {
+		log.Println("failed to register newsgroup", group, err)
+	}
+}
+
+func (self *SQLiteDatabase) GetPostAttachments(messageID string) (atts []string) {
+	rows, err := self.conn.Query(self.stmt[GetPostAttachments], messageID)
+	if err == nil {
+		for rows.Next() {
+			var val string
+			rows.Scan(&val)
+			atts = append(atts, val)
+		}
+		rows.Close()
+	} else {
+		log.Println("cannot find attachments for", messageID, err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetPostAttachmentModels(prefix, messageID string) (atts []AttachmentModel) {
+	rows, err := self.conn.Query(self.stmt[GetPostAttachmentModels], messageID)
+	if err == nil {
+		for rows.Next() {
+			var fpath, fname string
+			rows.Scan(&fpath, &fname)
+			atts = append(atts, &attachment{
+				prefix: prefix,
+				Path:   fpath,
+				Name:   fname,
+			})
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to get attachment models for", messageID, err)
+	}
+	return
+}
+
+// register a message with the database
+func (self *SQLiteDatabase) RegisterArticle(message NNTPMessage) (err error) {
+
+	msgid := message.MessageID()
+	group := message.Newsgroup()
+
+	if !self.HasNewsgroup(group) {
+		self.RegisterNewsgroup(group)
+	}
+	if self.HasArticle(msgid) {
+		return
+	}
+	now := timeNow()
+	// insert article metadata
+	_, err = self.conn.Exec(self.stmt[RegisterArticle_1], msgid, HashMessageID(msgid), group, now, message.Reference())
+	if err != nil {
+		log.Println("failed to insert article metadata", err)
+		return
+	}
+	// update newsgroup
+	_, err = self.conn.Exec(self.stmt[RegisterArticle_2], now, group)
+	if err != nil {
+		log.Println("failed to update newsgroup last post", err)
+		return
+	}
+	// insert article post
+	_, err = self.conn.Exec(self.stmt[RegisterArticle_3], group, msgid, message.Reference(), message.Name(), message.Subject(), message.Path(), message.Posted(), message.Message(), message.Addr())
+	if err != nil {
+		log.Println("cannot insert article post", err)
+		return
+	}
+
+	// set / update thread state
+	if message.OP() {
+		// insert new thread for op
+		_, err = self.conn.Exec(self.stmt[RegisterArticle_4], msgid, message.Posted(), group)
+		if err != nil {
+			log.Println("cannot register thread", msgid, err)
+			return
+		}
+	} else if !self.ArticleHasFlag(msgid, ArticleFlagQuarantined) {
+		err = self.BumpThread(message)
+		if err != nil {
+			return
+		}
+	}
+
+	// register article header key value pairs in one transaction
+	var tx *sql.Tx
+	tx, err = self.conn.Begin()
+	if err == nil {
+		var st *sql.Stmt
+		st, err = tx.Prepare("INSERT INTO NNTPHeaders(header_name, header_value, header_article_message_id) VALUES(?, ?, ?)")
+		if err == nil {
+			for k, val := range message.Headers() {
+				k = strings.ToLower(k)
+				for _, v := range val {
+					_, err = st.Exec(k, v, msgid)
+					if err != nil {
+						break
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+			st.Close()
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		log.Println("failed to register nntp article header values:", err)
+		return
+	}
+	err = self.registerNNTPNumber(group, msgid)
+	if err != nil {
+		log.Println("failed to register nntp number for", msgid, err)
+		return
+	}
+	// register all attachments
+	for _, att := range message.Attachments() {
+		h := hex.EncodeToString(att.Hash())
+		_, err = self.conn.Exec(self.stmt[RegisterArticle_8], msgid, h, att.Filename(), att.Filepath())
+		if err != nil {
+			log.Println("failed to register attachment", err)
+			continue
+		}
+	}
+	return
+}
+
+func (self *SQLiteDatabase) BumpThread(reply NNTPMessage) (err error) {
+	ref := reply.Reference()
+	if !reply.Sage() && !self.ArticleHasFlag(ref, ArticleFlagSage) {
+		var posts int64
+		err = self.conn.QueryRow(self.stmt[RegisterArticle_5], ref).Scan(&posts)
+		if err == nil && posts <= BumpLimit {
+			_, err = self.conn.Exec(self.stmt[RegisterArticle_6], ref, reply.Posted())
+		}
+		if err != nil {
+			log.Println("failed to bump thread", ref, err)
+			return
+		}
+	}
+	// update last posted
+	_, err = self.conn.Exec(self.stmt[RegisterArticle_7], ref, reply.Posted())
+	if err != nil {
+		log.Println("failed to update post time for", ref, err)
+	}
+	return
+}
+
+//
+// get message ids of articles with this header name and value
+//
+func (self *SQLiteDatabase) GetMessageIDByHeader(name, val string) (msgids []string, err error) {
+	var rows *sql.Rows
+	name = strings.ToLower(name)
+	rows, err = self.conn.Query(self.stmt[GetMessageIDByHeader], name, val)
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) RegisterSigned(message_id, pubkey string) (err error) {
+	_, err = self.conn.Exec(self.stmt[RegisterSigned], message_id, pubkey)
+	return
+}
+
+// get all articles in a newsgroup
+// send result down a channel
+func (self *SQLiteDatabase) GetAllArticlesInGroup(group string, recv chan ArticleEntry) {
+	rows, err := self.conn.Query(self.stmt[GetAllArticlesInGroup], group)
+	if err != nil {
+		log.Printf("failed to get all articles in %s: %s", group, err)
+		return
+	}
+	for rows.Next() {
+		var msgid string
+		rows.Scan(&msgid)
+		recv <- ArticleEntry{msgid, group}
+	}
+	rows.Close()
+}
+
+// get all articles
+func (self *SQLiteDatabase) GetAllArticles() (articles []ArticleEntry) {
+	rows, err := self.conn.Query(self.stmt[GetAllArticles])
+	if err == nil {
+		for rows.Next() {
+			var entry ArticleEntry
+			rows.Scan(&entry[0], &entry[1])
+			articles = append(articles, entry)
+		}
+		rows.Close()
+	} else {
+		log.Println("failed to get all articles", err)
+	}
+	return articles
+}
+
+func (self *SQLiteDatabase) GetPagesPerBoard(group string) (int, error) {
+	//XXX: hardcoded
+	return 10, nil
+}
+
+func (self *SQLiteDatabase) GetThreadsPerPage(group string) (int, error) {
+	//XXX: hardcoded
+	return 10, nil
+}
+
+func (self *SQLiteDatabase) GetMessageIDByHash(hash string) (article ArticleEntry, err error) {
+	err = self.conn.QueryRow(self.stmt[GetMessageIDByHash], hash).Scan(&article[0], &article[1])
+	return
+}
+
+func (self *SQLiteDatabase) BanAddr(addr, scope, reason, issuer string, expires int64) (err error) {
+	_, err = self.conn.Exec("INSERT INTO IPBans(addr, made, expires, scope, reason, issuer) VALUES(?, ?, ?, ?, ?, ?)", addr, timeNow(), expires, scope, reason, issuer)
+	return
+}
+
+// remove every ban that covers addr
+func (self *SQLiteDatabase) UnbanAddr(addr string) (err error) {
+	var ids []int64
+	ids, _, err = self.getIPBansFor(addr, 0)
+	for _, id := range ids {
+		_, err = self.conn.Exec("DELETE FROM IPBans WHERE rowid = ?", id)
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (self *SQLiteDatabase) CheckEncIPBanned(encaddr, newsgroup string) (banned bool, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(self.stmt[CheckEncIPBanned], encaddr, timeNow())
+	if err == nil {
+		banned = scanBansCover(rows, newsgroup)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) BanEncAddr(encaddr, scope, reason, issuer string, expires int64) (err error) {
+	_, err = self.conn.Exec("INSERT INTO EncIPBans(encaddr, made, expires, scope, reason, issuer) VALUES(?, ?, ?, ?, ?, ?)", encaddr, timeNow(), expires, scope, reason, issuer)
+	return
+}
+
+func (self *SQLiteDatabase) UnbanEncAddr(encaddr string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM EncIPBans WHERE encaddr = ?", encaddr)
+	return
+}
+
+func (self *SQLiteDatabase) GetIPBans() ([]IPBan, error) {
+	return queryIPBans(self.conn, activeIPBansQuery, activeEncIPBansQuery, timeNow())
+}
+
+func (self *SQLiteDatabase) RecordModLog(entry ModLogEntry) (err error) {
+	_, err = self.conn.Exec("INSERT INTO ModLogs(pubkey, action, target, scope, reason, message_id, executed, time) VALUES(?, ?, ?, ?, ?, ?, ?, ?)", entry.Pubkey, entry.Action, entry.Target, entry.Scope, entry.Reason, entry.MessageID, entry.Executed, entry.Time)
+	return
+}
+
+func (self *SQLiteDatabase) GetModLogs(q ModLogQuery) (entries []ModLogEntry, err error) {
+	query, args := q.sql()
+	var rows *sql.Rows
+	rows, err = self.conn.Query(query, args...)
+	if err == nil {
+		entries, err = scanModLogs(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) ReportArticle(msgid, reason, reporter string) (err error) {
+	_, err = self.conn.Exec("INSERT OR IGNORE INTO ArticleReports(message_id, reporter, reason, time_reported) VALUES(?, ?, ?, ?)", msgid, reporter, reason, timeNow())
+	return
+}
+
+func (self *SQLiteDatabase) CountReportsBy(reporter string, since int64) (count int64, err error) {
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM ArticleReports WHERE reporter = ? AND time_reported >= ?", reporter, since).Scan(&count)
+	return
+}
+
+func (self *SQLiteDatabase) GetArticleReports() (reports []ArticleReports, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT r.message_id, p.newsgroup, r.reason, COUNT(*), MIN(r.time_reported), MAX(r.time_reported) FROM ArticleReports r INNER JOIN ArticlePosts p ON p.message_id = r.message_id GROUP BY r.message_id, p.newsgroup, r.reason")
+	if err == nil {
+		reports, err = scanArticleReports(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetQuarantinedArticles() (articles []QuarantinedArticle, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(quarantinedArticlesQuery)
+	if err == nil {
+		articles, err = scanQuarantinedArticles(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) DismissReports(msgid string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ArticleReports WHERE message_id = ?", msgid)
+	return
+}
+
+func (self *SQLiteDatabase) AddModDelegation(d ModDelegation) (err error) {
+	_, err = self.conn.Exec("INSERT OR REPLACE INTO ModDelegations(pubkey, granter, scope, actions, expires, time_granted) VALUES(?, ?, ?, ?, ?, ?)", d.Pubkey, d.Granter, d.Scope, d.actionsString(), d.Expires, d.Time)
+	return
+}
+
+func (self *SQLiteDatabase) DelModDelegation(pubkey, granter, scope string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ModDelegations WHERE pubkey = ? AND granter = ? AND scope = ?", pubkey, granter, scope)
+	return
+}
+
+func (self *SQLiteDatabase) GetModDelegations(pubkey string) (delegations []ModDelegation, err error) {
+	var rows *sql.Rows
+	if pubkey == "" {
+		rows, err = self.conn.Query("SELECT pubkey, granter, scope, actions, expires, time_granted FROM ModDelegations ORDER BY time_granted")
+	} else {
+		rows, err = self.conn.Query("SELECT pubkey, granter, scope, actions, expires, time_granted FROM ModDelegations WHERE pubkey = ? ORDER BY time_granted", pubkey)
+	}
+	if err == nil {
+		delegations, err = scanModDelegations(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) RegisterImageHash(fname string, hash ImageHash) (err error) {
+	_, err = self.conn.Exec("INSERT OR IGNORE INTO ImageHashes(attachment_filepath, phash) VALUES(?, ?)", fname, hash.String())
+	return
+}
+
+func (self *SQLiteDatabase) GetImageHash(fname string) (hash ImageHash, err error) {
+	var h string
+	err = self.conn.QueryRow("SELECT phash FROM ImageHashes WHERE attachment_filepath = ?", fname).Scan(&h)
+	if err == nil {
+		hash, err = ParseImageHash(h)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) BanImageHash(hash ImageHash, reason string) (err error) {
+	_, err = self.conn.Exec("INSERT OR IGNORE INTO BannedImageHashes(phash, reason, time_banned) VALUES(?, ?, ?)", hash.String(), reason, timeNow())
+	return
+}
+
+func (self *SQLiteDatabase) UnbanImageHash(hash ImageHash) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BannedImageHashes WHERE phash = ?", hash.String())
+	return
+}
+
+func (self *SQLiteDatabase) GetBannedImageHashes() (banned []BannedImage, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT phash, reason, time_banned FROM BannedImageHashes ORDER BY time_banned")
+	if err == nil {
+		banned, err = scanBannedImages(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) AddFilterRule(rule FilterRule) (id int64, err error) {
+	var res sql.Result
+	res, err = self.conn.Exec("INSERT INTO FilterRules(newsgroup, field, pattern, regex, action, replacement, time_added) VALUES(?, ?, ?, ?, ?, ?, ?)", rule.Newsgroup, rule.Field, rule.Pattern, rule.Regex, string(rule.Action), rule.Replacement, timeNow())
+	if err == nil {
+		id, err = res.LastInsertId()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) DelFilterRule(id int64) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FilterRules WHERE id = ?", id)
+	return
+}
+
+func (self *SQLiteDatabase) GetFilterRules() (rules []FilterRule, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT id, newsgroup, field, pattern, regex, action, replacement, time_added FROM FilterRules ORDER BY id")
+	if err == nil {
+		rules, err = scanFilterRules(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) TrainSpam(msgid string, tokens []string, spam bool) (err error) {
+	err = trainSpamTx(self.conn, msgid, tokens, spam)
+	return
+}
+
+func (self *SQLiteDatabase) GetSpamCounts(tokens []string) (counts map[string]SpamCounts, totals SpamCounts, err error) {
+	var rows *sql.Rows
+	q, args := spamCountsQuery(tokens)
+	rows, err = self.conn.Query(q, args...)
+	if err == nil {
+		counts, totals, err = scanSpamCounts(rows)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) PurgeExpiredBans(now int64) (bans []IPBan, err error) {
+	bans, err = queryIPBans(self.conn, expiredIPBansQuery, expiredEncIPBansQuery, now)
+	if err == nil {
+		_, err = self.conn.Exec("DELETE FROM IPBans WHERE expires >= 0 AND expires <= ?", now)
+	}
+	if err == nil {
+		_, err = self.conn.Exec("DELETE FROM EncIPBans WHERE expires >= 0 AND expires <= ?", now)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetLastAndFirstForGroup(group string) (last, first int64, err error) {
+	err = self.conn.QueryRow(self.stmt[GetFirstAndLastForGroup], group).Scan(&last, &first)
+	return
+}
+
+func (self *SQLiteDatabase) GetMessageIDForNNTPID(group string, id int64) (msgid string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetMessageIDForNNTPID], group, id).Scan(&msgid)
+	return
+}
+
+func (self *SQLiteDatabase) GetNNTPIDForMessageID(group, msgid string) (id int64, err error) {
+	err = self.conn.QueryRow(self.stmt[GetNNTPIDForMessageID], group, msgid).Scan(&id)
+	return
+}
+
+func (self *SQLiteDatabase) GetNextNNTPID(group string, n int64) (id int64, msgid string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetNextNNTPID], group, n).Scan(&id, &msgid)
+	if err == sql.ErrNoRows {
+		// no next article
+		err = nil
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetPrevNNTPID(group string, n int64) (id int64, msgid string, err error) {
+	err = self.conn.QueryRow(self.stmt[GetPrevNNTPID], group, n).Scan(&id, &msgid)
+	if err == sql.ErrNoRows {
+		// no previous article
+		err = nil
+	}
+	return
+}
+
+func (self *SQLiteDatabase) MarkModPubkeyCanModGroup(pubkey, group string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO ModPrivs(pubkey, newsgroup, permission) VALUES(?, ?, ?)", pubkey, group, "all")
+	return
+}
+
+func (self *SQLiteDatabase) UnMarkModPubkeyCanModGroup(pubkey, group string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ModPrivs WHERE pubkey = ? AND newsgroup = ?", pubkey, group)
+	return
+}
+
+func (self *SQLiteDatabase) IsExpired(root_message_id string) bool {
+	var count int
+	err := self.conn.QueryRow(self.stmt[IsExpired], root_message_id).Scan(&count)
+	if err != nil {
+		log.Println("error checking for expired article:", err)
+	}
+	return count == 0
+}
+
+// count posts made in each of the last n days, optionally only in one newsgroup
+func (self *SQLiteDatabase) getLastDaysPosts(newsgroup string, n int64) (posts []PostEntry) {
+	day := time.Hour * 24
+	now := time.Now().UTC()
+	now = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for n > 0 {
+		var num int64
+		var err error
+		if newsgroup == "" {
+			err = self.conn.QueryRow(self.stmt[GetLastDaysPosts], now.Add(day).Unix(), now.Unix()).Scan(&num)
+		} else {
+			err = self.conn.QueryRow(self.stmt[GetLastDaysPostsForGroup], now.Add(day).Unix(), now.Unix(), newsgroup).Scan(&num)
+		}
+		if err != nil {
+			log.Println("error counting last n days posts", err)
+			return nil
+		}
+		posts = append(posts, PostEntry{now.Unix(), num})
+		now = now.Add(-day)
+		n--
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetLastDaysPostsForGroup(newsgroup string, n int64) []PostEntry {
+	return self.getLastDaysPosts(newsgroup, n)
+}
+
+func (self *SQLiteDatabase) GetLastDaysPosts(n int64) []PostEntry {
+	return self.getLastDaysPosts("", n)
+}
+
+func (self *SQLiteDatabase) GetLastPostedPostModels(prefix string, n int64) (posts []PostModel) {
+	models, err := self.queryPostModels(prefix, self.stmt[GetLastPostedPostModels], n)
+	if err != nil {
+		log.Println("failed to prepare query for geting last post models", err)
+		return nil
+	}
+	for _, model := range models {
+		posts = append(posts, model)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetMonthlyPostHistory() (posts []PostEntry) {
+	var oldest int64
+	now := time.Now().UTC()
+	err := self.conn.QueryRow(self.stmt[GetMonthlyPostHistory]).Scan(&oldest)
+	if err == sql.ErrNoRows {
+		// no posts
+		return
+	}
+	if err == nil {
+		old := time.Unix(oldest, 0).UTC()
+		old = time.Date(old.Year(), old.Month(), 1, 0, 0, 0, 0, time.UTC)
+		// count up from oldest month to this one
+		for !old.After(now) {
+			next_month := old.AddDate(0, 1, 0)
+			var count int64
+			err = self.conn.QueryRow("SELECT COUNT(*) FROM ArticlePosts WHERE time_posted >= ? AND time_posted < ?", old.Unix(), next_month.Unix()).Scan(&count)
+			if err != nil {
+				posts = nil
+				break
+			}
+			posts = append(posts, PostEntry{old.Unix(), count})
+			old = next_month
+		}
+	}
+	if err != nil {
+		log.Println("failed getting monthly post history", err)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) CheckNNTPLogin(username, passwd string) (valid bool, err error) {
+	var login_hash, login_salt string
+	err = self.conn.QueryRow(self.stmt[CheckNNTPLogin], username).Scan(&login_hash, &login_salt)
+	if err == nil {
+		if len(login_hash) > 0 && len(login_salt) > 0 {
+			valid = nntpLoginCredHash(passwd, login_salt) == login_hash
+		}
+	}
+	return
+}
+
+func (self *SQLiteDatabase) AddNNTPLogin(username, passwd string) (err error) {
+	login_salt := genLoginCredSalt()
+	login_hash := nntpLoginCredHash(passwd, login_salt)
+	_, err = self.conn.Exec("INSERT INTO NNTPUsers(username, login_hash, login_salt) VALUES(?, ?, ?)", username, login_hash, login_salt)
+	return
+}
+
+func (self *SQLiteDatabase) RemoveNNTPLogin(username string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM NNTPUsers WHERE username = ?", username)
+	return
+}
+
+func (self *SQLiteDatabase) CheckNNTPUserExists(username string) (exists bool, err error) {
+	var count int64
+	err = self.conn.QueryRow(self.stmt[CheckNNTPUserExists], username).Scan(&count)
+	exists = count > 0
+	return
+}
+
+func (self *SQLiteDatabase) GetHeadersForMessage(msgid string) (hdr ArticleHeaders, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(self.stmt[GetHeadersForMessage], msgid)
+	if err == nil {
+		hdr = make(ArticleHeaders)
+		for rows.Next() {
+			var k, v string
+			rows.Scan(&k, &v)
+			hdr.Add(k, v)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) CountAllArticlesInGroup(group string) (count int64, err error) {
+	err = self.conn.QueryRow(self.stmt[CountAllArticlesInGroup], group).Scan(&count)
+	return
+}
+
+// sqlite doesn't know about cidr so we check every address we have
+func (self *SQLiteDatabase) GetMessageIDByCIDR(cidr *net.IPNet) (msgids []string, err error) {
+	var encaddrs []string
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT addr, encaddr FROM EncryptedAddrs")
+	if err == nil {
+		for rows.Next() {
+			var addr, encaddr string
+			rows.Scan(&addr, &encaddr)
+			ip := net.ParseIP(addr)
+			if ip != nil && cidr.Contains(ip) {
+				encaddrs = append(encaddrs, encaddr)
+			}
+		}
+		rows.Close()
+	}
+	for _, encaddr := range encaddrs {
+		var ids []string
+		ids, err = self.GetMessageIDByEncryptedIP(encaddr)
+		if err != nil {
+			break
+		}
+		msgids = append(msgids, ids...)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetMessageIDByEncryptedIP(encaddr string) (msgids []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(self.stmt[GetMessageIDByEncryptedIP], encaddr)
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) BanPubkey(pubkey string) (err error) {
+	// TODO: implement
+	err = errors.New("ban pubkey not implemented")
+	return
+}
+
+func (self *SQLiteDatabase) PubkeyIsBanned(pubkey string) (bool, error) {
+	// TODO: implement
+	return false, nil
+}
+
+func (self *SQLiteDatabase) GetPostsBefore(t time.Time) (msgids []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(self.stmt[GetPostsBefore], t.Unix())
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *SQLiteDatabase) GetPostingStats(gran, begin, end int64) (st PostingStats, err error) {
+	return makePostingStats(gran, begin, end, func(lo, hi int64) (counts map[string]int64, err error) {
+		var rows *sql.Rows
+		rows, err = self.conn.Query("SELECT newsgroup, COUNT(*) FROM ArticlePosts WHERE time_posted >= ? AND time_posted < ? GROUP BY newsgroup", lo, hi)
+		if err == nil {
+			counts = make(map[string]int64)
+			for rows.Next() {
+				var group string
+				var posted int64
+				rows.Scan(&group, &posted)
+				counts[group] = posted
+			}
+			rows.Close()
+		}
+		return
+	})
+}
+
+// sqlite has no tsvector so each term is a LIKE and results are newest first
+func (self *SQLiteDatabase) SearchPosts(prefix string, req SearchRequest) (posts []PostModel, more bool, err error) {
+	terms := parseSearchText(req.Text)
+	if len(terms) == 0 {
+		err = errors.New("nothing to search for")
+		return
+	}
+	var args []interface{}
+	var conds []string
+	for _, term := range terms {
+		// only letters and numbers are in words so there is nothing to escape
+		args = append(args, "%"+strings.Join(term.words, " ")+"%")
+		cond := fmt.Sprintf("(subject || ' ' || name || ' ' || message) LIKE $%d", len(args))
+		if term.negate {
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+	}
+	var filters []string
+	filters, args = req.filters(args)
+	conds = append(conds, filters...)
+	offset, limit := req.limits()
+	// get one more than we need to know if there is another page
+	args = append(args, limit+1, offset)
+	q := fmt.Sprintf("SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE %s ORDER BY time_posted DESC LIMIT $%d OFFSET $%d", strings.Join(conds, " AND "), len(args)-1, len(args))
+	var models []*post
+	models, err = self.queryPostModels(prefix, q, args...)
+	if len(models) > limit {
+		more = true
+		models = models[:limit]
+	}
+	for _, model := range models {
+		posts = append(posts, model)
+	}
+	return
+}
+
+func (self *SQLiteDatabase) SearchByHash(prefix, group, text string, chnl chan PostModel) (err error) {
+	if text != "" && strings.Count(text, "%") == 0 {
+		text = "%" + text + "%"
+		var rows *sql.Rows
+		if group == "" {
+			rows, err = self.conn.Query(self.stmt[SearchByHash_1], text)
+		} else {
+			rows, err = self.conn.Query(self.stmt[SearchByHash_2], text, group)
+		}
+		if err == nil {
+			for rows.Next() {
+				p := new(post)
+				rows.Scan(&p.board, &p.Message_id, &p.Parent)
+				chnl <- p
+			}
+			rows.Close()
+		}
+	}
+	close(chnl)
+	return
+}
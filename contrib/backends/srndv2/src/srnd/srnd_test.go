@@ -1,6 +1,9 @@
 package srnd
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestGenFeedsConfig(t *testing.T) {
 
@@ -29,3 +32,77 @@ func TestOpenFileWriter(t *testing.T) {
 	}
 
 }
+
+func TestParseNNTPRange(t *testing.T) {
+
+	tests := map[string][2]int64{
+		"5":    {5, 5},
+		"5-":   {5, -1},
+		"5-10": {5, 10},
+	}
+	for str, expect := range tests {
+		lo, hi, err := parseNNTPRange(str)
+		if err != nil || lo != expect[0] || hi != expect[1] {
+			t.Error("bad range parse of", str, lo, hi, err)
+		}
+	}
+	_, _, err := parseNNTPRange("x-")
+	if err == nil {
+		t.Error("parsed invalid range")
+	}
+
+}
+
+func TestWildmatMatch(t *testing.T) {
+
+	if !wildmatMatch("*", "overchan.test") {
+		t.Error("* did not match")
+	}
+	if wildmatMatch("*,!overchan.*", "overchan.test") {
+		t.Error("negated pattern matched")
+	}
+	if !wildmatMatch("!overchan.*,overchan.test", "overchan.test") {
+		t.Error("last matching pattern did not win")
+	}
+
+}
+
+func TestMetricsFormat(t *testing.T) {
+
+	h := newHistogram("test_seconds", "test", []float64{0.1, 1}, "kind")
+	h.Observe(0.5, "a\"b")
+	var buff bytes.Buffer
+	h.Write(&buff)
+	expect := `# HELP test_seconds test
+# TYPE test_seconds histogram
+test_seconds_bucket{kind="a\"b",le="0.1"} 0
+test_seconds_bucket{kind="a\"b",le="1"} 1
+test_seconds_bucket{kind="a\"b",le="+Inf"} 1
+test_seconds_sum{kind="a\"b"} 0.5
+test_seconds_count{kind="a\"b"} 1
+`
+	if buff.String() != expect {
+		t.Error("bad histogram output", buff.String())
+	}
+	if metricReason("invalid newsgroup: overchan.test") != "invalid newsgroup" {
+		t.Error("rejection reason not trimmed")
+	}
+
+}
+
+func TestParseSearchText(t *testing.T) {
+
+	terms := parseSearchText(`Hello "big  World" -spam -"bad thing" "unterminated`)
+	if len(terms) != 5 {
+		t.Fatal("wrong number of terms", terms)
+	}
+	q := searchTSQuery(terms)
+	expect := "hello & (big <-> world) & !spam & !(bad <-> thing) & unterminated"
+	if q != expect {
+		t.Errorf("bad tsquery %q, expected %q", q, expect)
+	}
+	if len(parseSearchText(`"" - '; --`)) != 0 {
+		t.Error("made terms from punctuation")
+	}
+
+}
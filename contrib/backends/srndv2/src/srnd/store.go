@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var ErrOversizedMessage = errors.New("oversized message")
@@ -65,7 +66,8 @@ type ArticleStore interface {
 	// process body of nntp message, register attachments and the article
 	// write the body into writer as we go through the body
 	// does NOT write mime header
-	ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body *io.LimitedReader) error
+	// puts the article in the quarantine queue before registering it if quarantine is set
+	ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body *io.LimitedReader, quarantine bool) error
 	// register this post with the daemon
 	RegisterPost(nntp NNTPMessage) error
 	// register signed message
@@ -79,6 +81,12 @@ type ArticleStore interface {
 	// get thumbnail info of file by path
 	ThumbInfo(fpath string) (ThumbInfo, error)
 
+	// get the perceptual hash of an image attachment, computing and remembering it if we don't have it
+	ImageHash(fname string) (ImageHash, error)
+
+	// check if an attachment is an image close enough to a banned one
+	ImageBanned(fname string) bool
+
 	// delete message by message-id
 	Remove(msgid string) error
 }
@@ -95,6 +103,11 @@ type articleStore struct {
 	placeholder   string
 	compression   bool
 	compWriter    *gzip.Writer
+	// how many bits an image hash can be from a banned one and still be banned
+	imageBanDistance int
+	// how likely to be spam an inbound article has to be for us to act and what we do
+	spamThreshold float64
+	spamAction    string
 }
 
 func createArticleStore(config map[string]string, database Database) ArticleStore {
@@ -110,6 +123,13 @@ func createArticleStore(config map[string]string, database Database) ArticleStor
 		placeholder:   config["placeholder_thumbnail"],
 		database:      database,
 		compression:   config["compression"] == "1",
+
+		imageBanDistance: mapGetInt(config, "image_ban_distance", DefaultImageBanDistance),
+		spamThreshold:    mapGetFloat64(config, "spam_threshold", DefaultSpamThreshold),
+		spamAction:       config["spam_action"],
+	}
+	if store.spamAction != SpamHold {
+		store.spamAction = SpamReject
 	}
 	store.Init()
 	return store
@@ -232,13 +252,20 @@ func (self *articleStore) GenerateThumbnail(fname string) (info ThumbInfo, err e
 	outfname := self.ThumbnailFilepath(fname)
 	infname := self.AttachmentFilepath(fname)
 	tmpfname := ""
+	kind := "placeholder"
+	start := time.Now()
+	defer func() {
+		metrics.thumbnailSeconds.ObserveSince(start, kind)
+	}()
 	var cmd *exec.Cmd
 	if self.isImage(fname) {
+		kind = "image"
 		if strings.HasSuffix(infname, ".gif") {
 			infname += "[0]"
 		}
 		cmd = exec.Command(self.convert_path, "-thumbnail", "200", infname, outfname)
 	} else if self.isAudio(fname) {
+		kind = "audio"
 		tmpfname = infname + ".wav"
 		cmd = exec.Command(self.ffmpeg_path, "-i", infname, tmpfname)
 		var out []byte
@@ -252,6 +279,7 @@ func (self *articleStore) GenerateThumbnail(fname string) (info ThumbInfo, err e
 		}
 
 	} else if self.isVideo(fname) || strings.HasSuffix(fname, ".txt") {
+		kind = "video"
 		cmd = exec.Command(self.ffmpeg_path, "-i", infname, "-vf", "scale=300:200", "-vframes", "1", outfname)
 	}
 	if cmd == nil {
@@ -335,6 +363,54 @@ func (self *articleStore) saveAttachment(att NNTPAttachment) {
 	}
 	att.Reset()
 	self.thumbnailAttachment(fpath)
+	self.hashAttachment(fpath)
+}
+
+// remember the perceptual hash of an image attachment
+func (self *articleStore) hashAttachment(fpath string) {
+	if canHashImage(fpath) {
+		_, err := self.ImageHash(fpath)
+		if err != nil {
+			log.Println("failed to hash image", fpath, err)
+		}
+	}
+}
+
+func (self *articleStore) ImageHash(fname string) (hash ImageHash, err error) {
+	hash, err = self.database.GetImageHash(fname)
+	if err == nil {
+		return
+	}
+	hash, err = hashImageFile(self.AttachmentFilepath(fname))
+	if err == nil {
+		err = self.database.RegisterImageHash(fname, hash)
+	}
+	return
+}
+
+func (self *articleStore) ImageBanned(fname string) bool {
+	if !canHashImage(fname) {
+		return false
+	}
+	banned, err := self.database.GetBannedImageHashes()
+	if err != nil {
+		log.Println("failed to get banned image hashes", err)
+		return false
+	}
+	if len(banned) == 0 {
+		return false
+	}
+	hash, err := self.ImageHash(fname)
+	if err != nil {
+		log.Println("failed to hash image", fname, err)
+		return false
+	}
+	ban := findBannedImage(banned, hash, self.imageBanDistance)
+	if ban != nil {
+		log.Println(fname, "is banned image", ban.Hash, ban.Reason)
+		return true
+	}
+	return false
 }
 
 // generate attachment thumbnail
@@ -437,8 +513,56 @@ func (self *articleStore) getMIMEHeader(messageID string) (hdr textproto.MIMEHea
 	return hdr
 }
 
-func (self *articleStore) ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body *io.LimitedReader) (err error) {
+func (self *articleStore) ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body *io.LimitedReader, quarantine bool) (err error) {
+	var bannedImage, filtered, rejectedSpam bool
 	err = read_message_body(body, hdr, self, wr, false, func(nntp NNTPMessage) {
+		// we don't keep the files of articles we reject
+		defer func() {
+			if bannedImage || filtered || rejectedSpam {
+				for _, att := range nntp.Attachments() {
+					DelFile(self.AttachmentFilepath(att.Filepath()))
+					DelFile(self.ThumbnailFilepath(att.Filepath()))
+				}
+			}
+		}()
+		for _, att := range nntp.Attachments() {
+			if self.ImageBanned(att.Filepath()) {
+				bannedImage = true
+			}
+		}
+		if bannedImage {
+			return
+		}
+		var held *FilterRule
+		if article, ok := nntp.(*nntpArticle); ok {
+			rules, ferr := self.database.GetFilterRules()
+			if ferr != nil {
+				log.Println("failed to get filter rules", ferr)
+			}
+			// replacing only changes what we show, we pass on what we got
+			result := filterArticle(rules, article)
+			if result.Rejected != nil {
+				log.Println(nntp.MessageID(), "rejected by", result.Rejected)
+				filtered = true
+				return
+			}
+			held = result.Held
+		}
+		spam, p := classifySpam(self.database, nntp, self.spamThreshold)
+		if spam && self.spamAction == SpamReject {
+			log.Printf("%s rejected as spam p=%.4f", nntp.MessageID(), p)
+			rejectedSpam = true
+			return
+		}
+		// held articles are quarantined before they are registered
+		if held != nil {
+			holdFilteredArticle(self.database, nntp.MessageID(), held)
+		}
+		if spam {
+			holdSpamArticle(self.database, nntp.MessageID(), p)
+		} else if quarantine {
+			quarantineArticle(self.database, nntp.MessageID())
+		}
 		err = self.RegisterPost(nntp)
 		if err == nil {
 			pk := hdr.Get("X-PubKey-Ed25519")
@@ -453,6 +577,13 @@ func (self *articleStore) ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHea
 			log.Println("error procesing message body", err)
 		}
 	})
+	if err == nil && bannedImage {
+		err = ErrBannedImage
+	} else if err == nil && filtered {
+		err = ErrFilteredArticle
+	} else if err == nil && rejectedSpam {
+		err = ErrSpamArticle
+	}
 	return
 }
 
@@ -463,24 +594,20 @@ func (self *articleStore) GetMessage(msgid string) (nntp NNTPMessage) {
 		br := bufio.NewReader(r)
 		msg, err := readMIMEHeader(br)
 		if err == nil {
-			chnl := make(chan NNTPMessage)
 			hdr := textproto.MIMEHeader(msg.Header)
 			body := &io.LimitedReader{
 				R: msg.Body,
 				N: MaxMessageSize,
 			}
+			// the callback is called before read_message_body returns, for signed messages too
 			err = read_message_body(body, hdr, nil, nil, true, func(n NNTPMessage) {
-				c := chnl
 				// inject pubkey for mod
 				n.Headers().Set("X-PubKey-Ed25519", hdr.Get("X-PubKey-Ed25519"))
-				c <- n
-				close(c)
+				nntp = n
 			})
-			if err == nil {
-				nntp = <-chnl
-			} else {
+			if err != nil {
 				log.Println("GetMessage() failed to load", msgid, err)
-				close(chnl)
+				nntp = nil
 			}
 		}
 	}
@@ -533,7 +660,13 @@ func read_message_body(body *io.LimitedReader, hdr map[string][]string, store Ar
 				media_type, _, err = mime.ParseMediaType(part_type)
 				if err == nil {
 					if media_type == "text/plain" {
-						att := readAttachmentFromMimePartAndStore(part, store)
+						var att NNTPAttachment
+						if part.FileName() == "" {
+							// message part, it goes in the article so we don't store it as a file
+							att = readAttachmentFromMimePartAndStore(part, nil)
+						} else {
+							att = readAttachmentFromMimePartAndStore(part, store)
+						}
 						if att == nil {
 							log.Println("failed to load plaintext attachment")
 						} else {
@@ -576,12 +709,15 @@ func read_message_body(body *io.LimitedReader, hdr map[string][]string, store Ar
 			return errors.New("invalid headers")
 		}
 		// process inner body
+		// the inner message can't be bigger than what is left of this one
+		// verifying reads body while we handle the inner message so we take the limit now
+		limit := body.N
 		// verify message
 		err = verifyMessage(pk, sig, body, func(h map[string][]string, innerBody io.Reader) {
 			// handle inner message
 			ir := &io.LimitedReader{
 				R: innerBody,
-				N: body.N,
+				N: limit,
 			}
 			err := read_message_body(ir, h, store, nil, true, callback)
 			if err != nil {
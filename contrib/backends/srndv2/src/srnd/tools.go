@@ -4,8 +4,10 @@
 package srnd
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
 // worker for thumbnailer tool
@@ -56,6 +58,34 @@ func RegenTool() {
 	}
 }
 
+// run database schema tool, action is migrate or status
+func DatabaseTool(action string) {
+	conf := ReadConfig()
+	if conf == nil {
+		log.Println("cannot load config, ReadConfig() returned nil")
+		return
+	}
+	db := NewDatabase(conf.database["type"], conf.database["schema"], conf.database["host"], conf.database["port"], conf.database["user"], conf.database["password"])
+	defer db.Close()
+	if action == "migrate" {
+		err := db.MigrateSchema()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	migrations, err := db.GetSchemaMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range migrations {
+		applied := "pending"
+		if m.Applied > 0 {
+			applied = time.Unix(m.Applied, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-25s  %s\n", m.Version, applied, m.Name)
+	}
+}
+
 func regenGroup(name string, db Database) {
 	log.Println("regenerating", name)
 }
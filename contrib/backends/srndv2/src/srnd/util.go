@@ -112,6 +112,36 @@ func timeNow() int64 {
 	return time.Now().UTC().Unix()
 }
 
+// parse the date and time arguments of NEWNEWS and NEWGROUPS, RFC 3977 section 7.3
+// date is yymmdd or yyyymmdd, tm is hhmmss, if gmt is false they are in local time
+func parseNNTPDate(date, tm string, gmt bool) (t time.Time, err error) {
+	layout := "20060102 150405"
+	if len(date) == 6 {
+		layout = "060102 150405"
+	}
+	loc := time.Local
+	if gmt {
+		loc = time.UTC
+	}
+	t, err = time.ParseInLocation(layout, date+" "+tm, loc)
+	return
+}
+
+// check if a newsgroup matches a wildmat, RFC 3977 section 4
+// the last pattern in the list that matches decides
+func wildmatMatch(wildmat, group string) (matches bool) {
+	for _, pattern := range strings.Split(wildmat, ",") {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		if ok, _ := filepath.Match(pattern, group); ok {
+			matches = !negate
+		}
+	}
+	return
+}
+
 // sanitize data for nntp
 func nntpSanitize(data string) (ret string) {
 	parts := strings.Split(data, "\n")
@@ -363,6 +393,18 @@ func mapGetInt64(m map[string]string, key string, fallback int64) int64 {
 	return fallback
 }
 
+// get from a map a float64 given a key or fall back to a default value
+func mapGetFloat64(m map[string]string, key string, fallback float64) float64 {
+	val, ok := m[key]
+	if ok {
+		f, err := strconv.ParseFloat(val, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
 func isSage(str string) bool {
 	str = strings.ToLower(str)
 	return str == "sage" || strings.HasPrefix(str, "sage ")
@@ -614,6 +656,18 @@ func extractParam(param map[string]interface{}, k string) string {
 	return extractParamFallback(param, k, "")
 }
 
+// get a number from json parameters, 0 if it's not there
+func extractIntParam(param map[string]interface{}, k string) int64 {
+	switch v := param[k].(type) {
+	case float64:
+		return int64(v)
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
+
 // get real ip addresss from an http request
 func extractRealIP(r *http.Request) (ip string, err error) {
 	ip, _, err = net.SplitHostPort(r.RemoteAddr)
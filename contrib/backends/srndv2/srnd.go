@@ -66,7 +66,10 @@ func main() {
 									return
 								} else {
 									ev := srnd.ParseModEvent(line)
-									eng.Do(ev)
+									err = eng.Do(ev, "")
+									if err != nil {
+										fmt.Println("not done:", err)
+									}
 								}
 							}
 						}
@@ -108,6 +111,12 @@ func main() {
 						}
 					}
 					fmt.Fprintf(os.Stdout, "usage: %s tool rethumb [missing|all]\n", os.Args[0])
+				} else if tool == "db" {
+					if len(os.Args) == 4 && (os.Args[3] == "migrate" || os.Args[3] == "status") {
+						srnd.DatabaseTool(os.Args[3])
+					} else {
+						fmt.Fprintf(os.Stdout, "usage: %s tool db [migrate|status]\n", os.Args[0])
+					}
 				} else if tool == "keygen" {
 					srnd.KeygenTool()
 				} else if tool == "nntp" {
@@ -132,10 +141,10 @@ func main() {
 						fmt.Fprintf(os.Stdout, "Usage: %s tool nntp [add-login|del-login]\n", os.Args[0])
 					}
 				} else {
-					fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|keygen|nntp|mod]\n", os.Args[0])
+					fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|keygen|nntp|mod|db]\n", os.Args[0])
 				}
 			} else {
-				fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|keygen|nntp|mod]\n", os.Args[0])
+				fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|keygen|nntp|mod|db]\n", os.Args[0])
 			}
 		} else {
 			log.Println("Invalid action:", action)
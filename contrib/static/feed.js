@@ -47,6 +47,10 @@ function inject_nntp_feed_element(feed, elem) {
   name_elem = document.createTextNode("Name: "+feed.State.Config.Name);
   name.appendChild(name_elem);
   elem.appendChild(name);
+  var spool = document.createElement("div");
+  spool.setAttribute("class", "feeds_spool");
+  spool.appendChild(document.createTextNode("Spooled articles: "+feed.SpoolDepth));
+  elem.appendChild(spool);
   var conns = document.createElement("div");
   conns.setAttribute("class", "connections");
   for ( var idx = 0 ; idx < feed.Conns.length; idx ++ ) {
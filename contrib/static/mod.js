@@ -31,6 +31,19 @@ function nntpchan_ban() {
   });
 }
 
+// handle ban images command
+function nntpchan_ban_images() {
+  nntpchan_mod({
+    parser: get_longhash,
+    name: "imageban",
+    handle: function(j) {
+      if (j.banned) {
+        return document.createTextNode("banned images " + j.banned.join(", "));
+      }
+    }
+  });
+}
+
 function nntpchan_unban() {
   nntpchan_mod({
     name: "unban",
@@ -42,6 +55,21 @@ function nntpchan_unban() {
   })
 }
 
+// lift a ban from the ban list, result goes in result_elem
+function nntpchan_unban_addr(addr, result_elem) {
+  nntpchan_mod({
+    name: "unban",
+    parser: function(target) {
+      return encodeURIComponent(addr);
+    },
+    handle: function(j) {
+      if (j.result) {
+        return document.createTextNode(j.result);
+      }
+    }
+  }, result_elem);
+}
+
 function get_board_target() {
   var e = document.getElementById("nntpchan_board_target");
   return e.value;
@@ -222,3 +250,28 @@ function nntpchan_mod(mod_action, result_elem) {
   csrf_ajax.open("GET", "");
   csrf_ajax.send();
 }
+
+// delete, ban or dismiss a post from the report queue
+function nntpchan_report_action(action, longhash) {
+  nntpchan_mod({
+    name: action,
+    parser: function(target) {
+      return longhash;
+    },
+    handle: function(j) {
+      if (j.deleted) {
+        return document.createTextNode("deleted");
+      } else if (j.banned) {
+        return document.createTextNode(j.banned);
+      } else if (j.approved) {
+        return document.createTextNode("approved");
+      } else if (j.rejected) {
+        return document.createTextNode("rejected");
+      } else if (j.ham) {
+        return document.createTextNode("not spam");
+      } else if (j.dismissed) {
+        return document.createTextNode("dismissed");
+      }
+    }
+  }, document.getElementById("nntpchan_report_result_"+longhash));
+}
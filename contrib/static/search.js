@@ -44,13 +44,14 @@ function inject_search(elem) {
                 if(ajax.status == 200) {
                     // good
                     var result = JSON.parse(ajax.responseText);
-                    var num = result.length - 1;
-                    if (num <= 0) {
+                    if (result.error) {
+                        status.innerHTML = result.error;
+                    } else if (result.posts.length == 0) {
                         status.innerHTML = "no results";
                     } else {
-                        status.innerHTML = "found "+num+" results";
-                        for (var idx = 0 ; idx < result.length; idx++ ) {
-                            inject_search_result(result[idx]);
+                        status.innerHTML = "found "+result.posts.length+" results";
+                        for (var idx = 0 ; idx < result.posts.length; idx++ ) {
+                            inject_search_result(result.posts[idx]);
                         }
                     }
                 } else {
@@ -65,7 +66,7 @@ function inject_search(elem) {
             }
         };
         if (!h) {
-            ajax.open("GET", "/api/find?text="+text+"&group="+group);
+            ajax.open("GET", "/api/find?text="+encodeURIComponent(text)+"&group="+encodeURIComponent(group));
         } else {
             ajax.open("GET", "/api/find?hash="+h);
         }